@@ -0,0 +1,220 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAdaptiveInterval     = 30 * time.Second
+	defaultAdaptiveMaxErrorRate = 0.1
+
+	// intervals without probing for more slots after a slot didn't add throughput
+	adaptiveHoldIntervals = 4
+)
+
+// AdaptiveConfig scales the number of tags mirrored at the same time, up to `workers`
+type AdaptiveConfig struct {
+	Min          int       `yaml:"min,omitempty"`            // slots at the start, and the floor (default: 1)
+	Interval     *Duration `yaml:"interval,omitempty"`       // between two adjustments (default: 30s)
+	MaxErrorRate float64   `yaml:"max_error_rate,omitempty"` // retries per completed tag backing off (default: 0.1)
+}
+
+// adaptive scales the tag slots of the run with `adaptive_workers`, nil otherwise
+var adaptive *adaptiveLimiter
+
+// adaptiveLimiter scales the number of tags mirrored at the same time between the `min` of
+// `adaptive_workers` and `workers`: a slot is added while it adds throughput, and the slots
+// are halved when the retries (429s, 5xx, timeouts) rise. The limit is lowered by holding
+// slots of tagSlots, so the tags waiting for a slot don't know about the limiter
+type adaptiveLimiter struct {
+	mu           sync.Mutex
+	slots        chan struct{} // the tagSlots it scales
+	min          int
+	interval     time.Duration
+	maxErrorRate float64
+	limit        int // wanted number of slots
+	held         int // slots of tagSlots held to lower the limit to `limit`
+	bytes        int64
+	tags         int
+	retries      int
+	lastRate     float64 // bytes per second of the previous interval
+	grew         bool    // the previous adjustment added a slot
+	hold         int     // intervals left without adding a slot
+	stop         chan struct{}
+}
+
+func newAdaptiveLimiter(slots chan struct{}, c AdaptiveConfig) *adaptiveLimiter {
+	a := &adaptiveLimiter{
+		slots:        slots,
+		min:          c.Min,
+		interval:     defaultAdaptiveInterval,
+		maxErrorRate: c.MaxErrorRate,
+		stop:         make(chan struct{}),
+	}
+	if a.min < 1 {
+		a.min = 1
+	}
+	if a.min > cap(slots) {
+		a.min = cap(slots)
+	}
+	if c.Interval != nil {
+		a.interval = time.Duration(*c.Interval)
+	}
+	if a.maxErrorRate <= 0 {
+		a.maxErrorRate = defaultAdaptiveMaxErrorRate
+	}
+
+	// start slow, the slots are added while they add throughput
+	a.limit = a.min
+	a.balance()
+
+	return a
+}
+
+// setupTagSlots sizes the tag slots shared by the repositories to `workers`, scaled by an
+// adaptive limiter with `adaptive_workers`
+func setupTagSlots() {
+	adaptive.close()
+	adaptive = nil
+
+	tagSlots = make(chan struct{}, config.Workers)
+	if config.AdaptiveWorkers != nil {
+		adaptive = newAdaptiveLimiter(tagSlots, *config.AdaptiveWorkers)
+		log.Infof("Adaptive workers: starting with %d of %d tag slots", adaptive.limit, config.Workers)
+		go adaptive.run()
+	}
+}
+
+// run adjusts the limit every interval, until the limiter is closed
+func (a *adaptiveLimiter) run() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.adjust()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// close stops adjusting the limit, and gives the held slots back
+func (a *adaptiveLimiter) close() {
+	if a == nil {
+		return
+	}
+
+	close(a.stop)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.limit = cap(a.slots)
+	a.balance()
+}
+
+// completed records a mirrored tag and its bytes
+func (a *adaptiveLimiter) completed(bytes int64) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tags++
+	a.bytes += bytes
+}
+
+// retried records a retried request, i.e. a 429 or a timeout
+func (a *adaptiveLimiter) retried() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.retries++
+}
+
+// adjust halves the limit when the retries rise, and otherwise adds a slot while the last
+// added slot increased the throughput. A slot that didn't is taken back, and no slot is
+// added for a few intervals
+func (a *adaptiveLimiter) adjust() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rate := float64(a.bytes) / a.interval.Seconds()
+	errorRate := float64(a.retries) / float64(maxInt(a.tags, 1))
+	previous := a.limit
+
+	switch {
+	case errorRate > a.maxErrorRate:
+		a.limit = maxInt(a.min, a.limit/2)
+		a.grew, a.hold = false, adaptiveHoldIntervals
+	case a.tags == 0:
+		// idle, nothing to learn from
+	case a.grew && rate < a.lastRate*1.05:
+		a.limit = maxInt(a.min, a.limit-1)
+		a.grew, a.hold = false, adaptiveHoldIntervals
+	case a.hold > 0:
+		a.hold--
+	case a.limit < cap(a.slots):
+		a.limit++
+		a.grew = true
+	}
+
+	if a.limit != previous {
+		log.Infof("Adaptive workers: %d -> %d tag slots (%.0f bytes/s, %d retries for %d tags)", previous, a.limit, rate, a.retries, a.tags)
+	}
+
+	if a.tags > 0 {
+		a.lastRate = rate
+	}
+	a.bytes, a.tags, a.retries = 0, 0, 0
+	a.balance()
+}
+
+// balance holds or gives back slots of tagSlots to match the limit. Busy slots can't be
+// taken, the rest is taken at the next adjustment
+func (a *adaptiveLimiter) balance() {
+	for a.held > cap(a.slots)-a.limit {
+		<-a.slots
+		a.held--
+	}
+
+	for a.held < cap(a.slots)-a.limit {
+		select {
+		case a.slots <- struct{}{}:
+			a.held++
+		default:
+			return
+		}
+	}
+}
+
+// current returns the wanted number of tag slots
+func (a *adaptiveLimiter) current() int {
+	if a == nil {
+		return cap(tagSlots)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.limit
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
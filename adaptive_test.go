@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	interval := Duration(time.Second)
+	slots := make(chan struct{}, 8)
+	a := newAdaptiveLimiter(slots, AdaptiveConfig{Min: 2, Interval: &interval})
+
+	// it starts from min, holding the other slots
+	if a.current() != 2 || len(slots) != 6 {
+		t.Fatalf("Expected 2 free slots, got a limit of %d and %d used", a.current(), len(slots))
+	}
+
+	observe := func(bytes int64, tags, retries int) int {
+		for i := 0; i < tags; i++ {
+			a.completed(bytes / int64(tags))
+		}
+		for i := 0; i < retries; i++ {
+			a.retried()
+		}
+		a.adjust()
+		return a.current()
+	}
+
+	// a slot is added while the throughput scales
+	if got := observe(100, 4, 0); got != 3 {
+		t.Errorf("Expected a slot to be added, got %d", got)
+	}
+	if got := observe(200, 4, 0); got != 4 {
+		t.Errorf("Expected another slot to be added, got %d", got)
+	}
+
+	// a slot without more throughput is taken back, and no slot is added for a while
+	if got := observe(200, 4, 0); got != 3 {
+		t.Errorf("Expected the last slot to be taken back, got %d", got)
+	}
+	for i := 0; i < adaptiveHoldIntervals; i++ {
+		if got := observe(200, 4, 0); got != 3 {
+			t.Errorf("Expected the limit to hold, got %d", got)
+		}
+	}
+	if got := observe(200, 4, 0); got != 4 {
+		t.Errorf("Expected a slot to be added after holding, got %d", got)
+	}
+	if len(slots) != 4 {
+		t.Errorf("Expected 4 held slots, got %d", len(slots))
+	}
+
+	// retries halve the slots, down to min
+	if got := observe(300, 4, 2); got != 2 {
+		t.Errorf("Expected the slots to be halved, got %d", got)
+	}
+	if got := observe(300, 4, 4); got != 2 {
+		t.Errorf("Expected min slots, got %d", got)
+	}
+
+	// the held slots are given back
+	a.close()
+	if len(slots) != 0 {
+		t.Errorf("Expected all the slots to be given back, got %d used", len(slots))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseStatus is the response of the pause and resume endpoints
+type pauseStatus struct {
+	Paused bool       `json:"paused"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// daemonStatus is the response of the status endpoint
+type daemonStatus struct {
+	Paused  bool                 `json:"paused"`
+	Stopped string               `json:"stopped,omitempty"` // why the kill switch stopped the daemon
+	Hosts   map[string]hostQuota `json:"hosts"`             // upstream usage per host
+}
+
+// startAdminServer serves the admin endpoints in the background
+func startAdminServer(addr string) {
+	log.Infof("Starting admin server on %s", addr)
+
+	go func() {
+		if err := http.ListenAndServe(addr, newAdminMux()); err != nil {
+			log.Fatalf("Admin server failed: %s", err)
+		}
+	}()
+}
+
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		scheduler.pause()
+		writePauseStatus(w)
+	}))
+	mux.HandleFunc("/resume", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		scheduler.resume()
+		writePauseStatus(w)
+	}))
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		paused, _ := scheduler.status()
+		writeJSON(w, daemonStatus{
+			Paused:  paused,
+			Stopped: killSwitch.stopped(),
+			Hosts:   quotas.usage(),
+		})
+	})
+
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/debug/state", stateHandler)
+
+	mux.HandleFunc("/webhook", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		if webhooks == nil {
+			http.Error(w, "Webhooks are not enabled, set a `webhook -> token` in the config", http.StatusNotFound)
+			return
+		}
+		webhooks.ServeHTTP(w, r)
+	}))
+
+	if pprofEnabled() {
+		registerPprof(mux)
+	}
+
+	return mux
+}
+
+// postOnly rejects requests not using the POST method
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+func writePauseStatus(w http.ResponseWriter) {
+	paused, since := scheduler.status()
+
+	status := pauseStatus{Paused: paused}
+	if paused {
+		status.Since = &since
+	}
+
+	writeJSON(w, status)
+}
+
+// writeJSON writes the value as the JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Failed to write admin response: %s", err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAdminPauseResume(t *testing.T) {
+	defer func(p *pauser) { scheduler = p }(scheduler)
+	scheduler = &pauser{}
+
+	server := httptest.NewServer(newAdminMux())
+	defer server.Close()
+
+	post := func(path string) pauseStatus {
+		res, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		defer res.Body.Close()
+
+		var status pauseStatus
+		if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return status
+	}
+
+	if status := post("/pause"); !status.Paused || status.Since == nil {
+		t.Errorf("Expected to be paused, got %+v", status)
+	}
+
+	if status := post("/resume"); status.Paused {
+		t.Errorf("Expected to be resumed, got %+v", status)
+	}
+
+	res, err := http.Get(server.URL + "/pause")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /pause to be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestPauserWait(t *testing.T) {
+	defer func(d time.Duration) { pauseCheckInterval = d }(pauseCheckInterval)
+	pauseCheckInterval = 5 * time.Millisecond
+
+	p := &pauser{}
+	p.pause()
+
+	done := make(chan struct{})
+	go func() {
+		p.wait(log.WithField("repo", "redis"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected wait to block while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.resume()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected wait to return once resumed")
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	defer func(q *quotaTracker) { quotas = q }(quotas)
+	quotas = newQuotaTracker()
+	quotas.record(dockerHub, quotaPull)
+
+	server := httptest.NewServer(newAdminMux())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+
+	var status daemonStatus
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if got := status.Hosts[dockerHub].Recent[quotaPull]; got != 1 {
+		t.Errorf("Expected 1 recent %s pull, got %d", dockerHub, got)
+	}
+}
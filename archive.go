@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// date layout of the archive snapshot tag suffix, e.g. 7.0-20260115
+const archiveDateLayout = "20060102"
+
+// matches the date suffix of an archive snapshot tag
+var archiveSuffixRE = regexp.MustCompile(`-([0-9]{8})$`)
+
+// tagName returns the tag the image is pushed as, archive targets push a dated
+// snapshot (<tag>-<yyyymmdd>) that is never overwritten by a later upstream change
+func (t *target) tagName(tag string, day time.Time) string {
+	if !t.config.Archive {
+		return tag
+	}
+
+	return tag + "-" + day.UTC().Format(archiveDateLayout)
+}
+
+// expiredSnapshots returns the archive snapshots of the tag that exceed the retention
+// of the target, by age (archive_max_age) and by number of snapshots (archive_max_tags)
+func (t *target) expiredSnapshots(tag string, tags map[string]string, now time.Time) []string {
+	type snapshot struct {
+		tag string
+		day time.Time
+	}
+
+	var snapshots []snapshot
+	for candidate := range tags {
+		matches := archiveSuffixRE.FindStringSubmatch(candidate)
+		if matches == nil || candidate[:len(candidate)-len(matches[0])] != tag {
+			continue
+		}
+
+		day, err := time.Parse(archiveDateLayout, matches[1])
+		if err != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, snapshot{tag: candidate, day: day})
+	}
+
+	// newest first
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].day.After(snapshots[j].day)
+	})
+
+	var expired []string
+	for i, s := range snapshots {
+		tooMany := t.config.ArchiveMaxTags > 0 && i >= t.config.ArchiveMaxTags
+		tooOld := t.config.ArchiveMaxAge != nil && now.Sub(s.day) > time.Duration(*t.config.ArchiveMaxAge)
+		if tooMany || tooOld {
+			expired = append(expired, s.tag)
+		}
+	}
+
+	return expired
+}
+
+// pruneArchive deletes the snapshots of the tag exceeding the retention of the archive target
+func (m *mirror) pruneArchive(t *target, tag string, now time.Time) error {
+	if !t.config.Archive || (t.config.ArchiveMaxAge == nil && t.config.ArchiveMaxTags == 0) {
+		return nil
+	}
+
+	repository := m.targetRepositoryName(t)
+	tags, err := t.ecrManager.listTags(repository)
+	if err != nil {
+		return err
+	}
+
+	expired := t.expiredSnapshots(tag, tags, now)
+	if len(expired) == 0 {
+		return nil
+	}
+
+	m.log.Infof("Deleting %d expired archive snapshots from %s/%s: %v", len(expired), t.registry, repository, expired)
+	return deletions.deleteTags(t, repository, expired, "archive retention", nil)
+}
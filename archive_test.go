@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchiveTagName(t *testing.T) {
+	day := time.Date(2026, 1, 15, 23, 30, 0, 0, time.UTC)
+
+	if got := (&target{}).tagName("7.0", day); got != "7.0" {
+		t.Errorf("Expected a regular target to keep the tag, got %q", got)
+	}
+
+	archive := &target{config: TargetConfig{Archive: true}}
+	if got := archive.tagName("7.0", day); got != "7.0-20260115" {
+		t.Errorf("Expected a dated snapshot tag, got %q", got)
+	}
+}
+
+func TestExpiredSnapshots(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	tags := make(map[string]string)
+	for _, tag := range []string{"7.0", "7.0-20260301", "7.0-20260220", "7.0-20260101", "7.0-rc-20250101", "7.0-20251201", "latest-20250101"} {
+		tags[tag] = "sha256:" + tag
+	}
+
+	maxAge := Duration(30 * 24 * time.Hour)
+	tests := map[string]struct {
+		config TargetConfig
+		want   []string
+	}{
+		"no retention": {TargetConfig{Archive: true}, nil},
+		"max age":      {TargetConfig{Archive: true, ArchiveMaxAge: &maxAge}, []string{"7.0-20260101", "7.0-20251201"}},
+		"max tags":     {TargetConfig{Archive: true, ArchiveMaxTags: 3}, []string{"7.0-20251201"}},
+		"both":         {TargetConfig{Archive: true, ArchiveMaxAge: &maxAge, ArchiveMaxTags: 1}, []string{"7.0-20260220", "7.0-20260101", "7.0-20251201"}},
+	}
+
+	for name, tt := range tests {
+		tgt := &target{config: tt.config}
+		if got := tgt.expiredSnapshots("7.0", tags, now); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v to expire, got %v", name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	mediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+
+	// OCI annotation of the creation time of an artifact, artifacts have no image config
+	annotationCreated = "org.opencontainers.image.created"
+)
+
+// artifactType returns the type of the OCI artifact of the manifest (i.e. the Helm config
+// media type of a chart), empty for a container image or an index
+func artifactType(m manifest) string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
+	}
+
+	if m.Config == nil {
+		return ""
+	}
+
+	switch m.Config.MediaType {
+	case "", mediaTypeDockerConfig, mediaTypeOCIConfig:
+		return ""
+	}
+
+	return m.Config.MediaType
+}
+
+// artifactMetadata returns the metadata of an OCI artifact from the annotations of its
+// manifest, its config isn't an image config
+func artifactMetadata(body []byte, artifact string) *imageMetadata {
+	var m struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	json.Unmarshal(body, &m)
+
+	var created time.Time
+	if t, err := time.Parse(time.RFC3339, m.Annotations[annotationCreated]); err == nil {
+		created = t
+	}
+
+	md := newImageMetadata(m.Annotations, created, "")
+	md.artifactType = artifact
+	return md
+}
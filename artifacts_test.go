@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestArtifactType(t *testing.T) {
+	tests := map[string]manifest{
+		"": {Config: &descriptor{MediaType: mediaTypeDockerConfig}},
+		"application/vnd.cncf.helm.config.v1+json": {Config: &descriptor{MediaType: "application/vnd.cncf.helm.config.v1+json"}},
+		"application/vnd.example.sbom":             {ArtifactType: "application/vnd.example.sbom", Config: &descriptor{MediaType: "application/vnd.oci.empty.v1+json"}},
+	}
+
+	for want, m := range tests {
+		if got := artifactType(m); got != want {
+			t.Errorf("Expected the artifact type %q, got %q", want, got)
+		}
+	}
+}
+
+func TestCopyHelmChart(t *testing.T) {
+	source := newFakeRegistry()
+	config := []byte(`{"name":"foo","version":"1.2.0"}`)
+	chart := []byte("chart tarball")
+	source.blobs[sha256Digest(config)] = config
+	source.blobs[sha256Digest(chart)] = chart
+	body, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        descriptor{MediaType: "application/vnd.cncf.helm.config.v1+json", Digest: sha256Digest(config), Size: int64(len(config))},
+		"layers":        []descriptor{{MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip", Digest: sha256Digest(chart), Size: int64(len(chart))}},
+		"annotations":   map[string]string{annotationCreated: "2024-05-01T10:00:00Z", "org.opencontainers.image.title": "foo"},
+	})
+	source.manifests["org/charts/foo:1.2.0"] = body
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	md, err := fetchImageMetadata(src, "org/charts/foo", "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.artifactType != "application/vnd.cncf.helm.config.v1+json" || md.Labels["org.opencontainers.image.title"] != "foo" || md.Created == nil || !md.Created.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the metadata of the chart from its annotations, got %+v", md)
+	}
+
+	if _, _, err := checkMediaTypes(src, "org/charts/foo", "1.2.0"); err != nil {
+		t.Errorf("Expected ECR to accept the chart, got %s", err)
+	}
+
+	dest := newFakeRegistry()
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+	dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	digest, _, err := copyImage(src, "org/charts/foo", "1.2.0", dst, "mirror/foo", "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != sha256Digest(body) || string(dest.blobs[sha256Digest(chart)]) != string(chart) {
+		t.Errorf("Expected the chart to be copied as is, got %s", digest)
+	}
+}
+
+func TestSourceAuthOtherRegistry(t *testing.T) {
+	t.Setenv("DOCKERHUB_USER", "user")
+	t.Setenv("DOCKERHUB_PASSWORD", "secret")
+
+	m := mirror{repo: Repository{Name: "ghcr.io/org/charts/foo", Host: dockerHub}}
+	m.log = log.WithField("test", t.Name())
+	if auth := m.sourceAuth(); auth.Username != "" {
+		t.Errorf("Expected no Docker Hub credentials for ghcr.io, got %s", auth.Username)
+	}
+
+	m.repo.Name = "library/redis"
+	if auth := m.sourceAuth(); auth.Username != "user" {
+		t.Errorf("Expected the Docker Hub credentials, got %q", auth.Username)
+	}
+}
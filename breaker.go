@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how long the repositories of a host are deferred once its breaker opens, by default
+const defaultBreakerCooldown = 15 * time.Minute
+
+// BreakerConfig configures the circuit breaker of the upstream hosts
+type BreakerConfig struct {
+	Failures int       `yaml:"failures,omitempty"` // consecutive repositories failing to list their tags, 0 disables the breaker
+	Cooldown *Duration `yaml:"cooldown,omitempty"` // how long the repositories of the host are deferred (default: 15m)
+}
+
+// breakers are the circuit breakers of the upstream hosts, kept across the runs of a daemon
+var breakers = newCircuitBreakers()
+
+// circuitBreakers count the consecutive repositories of each host failing to list their
+// tags. Once a host reaches the `failures` of the config, its breaker opens and the remaining
+// repositories of the host are deferred for the cooldown, instead of retrying a known-down
+// registry for every repository. After the cooldown a single failure opens it again
+type circuitBreakers struct {
+	mu       sync.Mutex
+	failures map[string]int
+	openedAt map[string]time.Time
+}
+
+func newCircuitBreakers() *circuitBreakers {
+	return &circuitBreakers{failures: make(map[string]int), openedAt: make(map[string]time.Time)}
+}
+
+// tagSourceHost returns the host the tags of the repository are listed from, the key of its
+// breaker
+func tagSourceHost(repo Repository) string {
+	switch repo.RemoteTagSource {
+	case remoteTagSourceGitHub, remoteTagSourceGitLab:
+		return repo.RemoteTagSource
+	}
+
+	return repo.Host
+}
+
+// cooldown returns the cooldown of the `circuit_breaker` config
+func (c BreakerConfig) cooldown() time.Duration {
+	if c.Cooldown != nil {
+		return time.Duration(*c.Cooldown)
+	}
+
+	return defaultBreakerCooldown
+}
+
+// open returns why the repositories of the host are deferred, empty when its breaker is
+// closed
+func (b *circuitBreakers) open(host string, now time.Time, cfg BreakerConfig) string {
+	if cfg.Failures == 0 {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	openedAt, ok := b.openedAt[host]
+	if !ok {
+		return ""
+	}
+
+	until := openedAt.Add(cfg.cooldown())
+	if now.After(until) {
+		// half-open, the next failure opens the breaker again
+		delete(b.openedAt, host)
+		b.failures[host] = cfg.Failures - 1
+		return ""
+	}
+
+	return fmt.Sprintf("%s is failing, deferred until %s", host, until.Format(time.RFC3339))
+}
+
+// failure counts a repository of the host failing to list its tags, and opens the breaker of
+// the host once it reached the `failures` of the config
+func (b *circuitBreakers) failure(host string, now time.Time, cfg BreakerConfig) {
+	if cfg.Failures == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures[host]++
+	if _, ok := b.openedAt[host]; ok || b.failures[host] < cfg.Failures {
+		return
+	}
+
+	b.openedAt[host] = now
+	log.WithField("host", host).Warnf("%d consecutive repositories failed to list their tags from %s, deferring its repositories for %s", b.failures[host], host, cfg.cooldown())
+}
+
+// success closes the breaker of the host
+func (b *circuitBreakers) success(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.failures, host)
+	delete(b.openedAt, host)
+}
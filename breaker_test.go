@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	cooldown := Duration(10 * time.Minute)
+	cfg := BreakerConfig{Failures: 2, Cooldown: &cooldown}
+	b := newCircuitBreakers()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b.failure(quay, now, cfg)
+	if reason := b.open(quay, now, cfg); reason != "" {
+		t.Errorf("Expected the breaker to be closed after a failure, got %q", reason)
+	}
+
+	// a success resets the count
+	b.success(quay)
+	b.failure(quay, now, cfg)
+	if reason := b.open(quay, now, cfg); reason != "" {
+		t.Errorf("Expected the breaker to be closed after a success, got %q", reason)
+	}
+
+	b.failure(quay, now, cfg)
+	if reason := b.open(quay, now.Add(time.Minute), cfg); reason != "quay.io is failing, deferred until 2024-01-01T00:10:00Z" {
+		t.Errorf("Expected the breaker to be open, got %q", reason)
+	}
+	if reason := b.open(gcr, now, cfg); reason != "" {
+		t.Errorf("Expected the breaker of another host to be closed, got %q", reason)
+	}
+
+	// after the cooldown a single failure opens it again
+	later := now.Add(11 * time.Minute)
+	if reason := b.open(quay, later, cfg); reason != "" {
+		t.Errorf("Expected the breaker to be half-open after the cooldown, got %q", reason)
+	}
+	b.failure(quay, later, cfg)
+	if reason := b.open(quay, later, cfg); reason == "" {
+		t.Error("Expected the breaker to open again")
+	}
+
+	// disabled without failures
+	b.failure(dockerHub, now, BreakerConfig{})
+	b.failure(dockerHub, now, BreakerConfig{})
+	if reason := b.open(dockerHub, now, BreakerConfig{}); reason != "" {
+		t.Errorf("Expected the breaker to be disabled, got %q", reason)
+	}
+}
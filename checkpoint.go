@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpoint records the tags completed in the current run, nil when checkpointing is disabled
+var checkpoint *checkpointFile
+
+// checkpointFile persists the progress of a run to a local file, so an interrupted
+// run can be resumed instead of re-mirroring everything
+type checkpointFile struct {
+	mu   sync.Mutex
+	file string
+	Tags map[string]*mirroredTag `json:"tags"` // completed tags, by source image reference
+}
+
+// openCheckpoint creates the checkpoint file, when resuming the progress of the
+// previous run is loaded from the file
+func openCheckpoint(file string, resume bool) (*checkpointFile, error) {
+	c := &checkpointFile{file: file, Tags: make(map[string]*mirroredTag)}
+	if !resume {
+		return c, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, err
+	}
+
+	if c.Tags == nil {
+		c.Tags = make(map[string]*mirroredTag)
+	}
+
+	return c, nil
+}
+
+// completed returns true if the source image was mirrored to all the target images
+func (c *checkpointFile) completed(source string, targets []string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Tags[source]
+	return ok && entry.covers(targets)
+}
+
+// complete records the mirrored tag and persists the checkpoint
+func (c *checkpointFile) complete(source, sourceDigest string, targets map[string]string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Tags[source] = &mirroredTag{
+		SourceDigest: sourceDigest,
+		Targets:      targets,
+		MirroredAt:   time.Now(),
+	}
+
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file first, so a crash never leaves
+// a truncated checkpoint behind
+func (c *checkpointFile) save() error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.file), filepath.Base(c.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// remove deletes the checkpoint once the run completed, the next run starts from scratch
+func (c *checkpointFile) remove() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Tags = make(map[string]*mirroredTag)
+	if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCheckpoint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c, err := openCheckpoint(file, true)
+	if err != nil {
+		t.Fatalf("Expected a missing checkpoint to be ignored, got %s", err)
+	}
+
+	err = c.complete("redis:7", "sha256:aaa", map[string]string{
+		"registry.example.com/hub/redis:7": "sha256:aaa",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// without resume the previous progress is ignored
+	if c, _ := openCheckpoint(file, false); c.completed("redis:7", []string{"registry.example.com/hub/redis:7"}) {
+		t.Error("Expected the checkpoint to be ignored without resume")
+	}
+
+	resumed, err := openCheckpoint(file, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !resumed.completed("redis:7", []string{"registry.example.com/hub/redis:7"}) {
+		t.Error("Expected redis:7 to be completed")
+	}
+
+	if resumed.completed("redis:7", []string{"registry.example.com/hub/redis:7", "harbor.example.com/redis:7"}) {
+		t.Error("Expected redis:7 not to be completed for a new target")
+	}
+
+	if resumed.completed("redis:6", []string{"registry.example.com/hub/redis:6"}) {
+		t.Error("Expected redis:6 not to be completed")
+	}
+
+	if err := resumed.remove(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkpoint to be removed, got %v", err)
+	}
+}
+
+func TestCheckpointSkipsCompletedTags(t *testing.T) {
+	defer func(c *checkpointFile) { checkpoint = c }(checkpoint)
+
+	var err error
+	checkpoint, err = openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	checkpoint.complete("redis:7", "", map[string]string{"registry.example.com/hub/redis:7": ""})
+
+	responseContainer := &ResponseContainer{}
+	var client DockerClient
+	client = CreateTestDockerClient(responseContainer)
+	tc := TargetConfig{Registry: "registry.example.com", Prefix: "hub/", Username: "user"}
+
+	m := mirror{
+		dockerClient: &client,
+		targets:      []*target{{registry: tc.Registry, config: tc, ecrManager: newRegistryManager(tc)}},
+		report:       newRunReport().repository("redis", dockerHub),
+		log:          log.WithField("repo", "redis"),
+		repo:         Repository{Name: "redis", Host: dockerHub},
+		remoteTags:   []RepositoryTag{{Name: "7"}, {Name: "6"}},
+	}
+	m.work()
+
+	if got := responseContainer.PullImageOptions.Tag; got != "6" {
+		t.Errorf("Expected only tag 6 to be pulled, got %q", got)
+	}
+
+	if !checkpoint.completed("redis:6", []string{"registry.example.com/hub/redis:6"}) {
+		t.Error("Expected tag 6 to be saved in the checkpoint")
+	}
+
+	for _, tr := range m.report.Tags {
+		if tr.Tag == "7" && (tr.Result != resultSkipped || tr.Reason != "completed in checkpoint") {
+			t.Errorf("Expected tag 7 to be skipped, got %+v", tr)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	cleanupScopeSource      = "source"       // only remove the pulled source image
+	cleanupScopeTargetLocal = "target_local" // only remove the (re)tagged target images
+	cleanupScopeBoth        = "both"         // remove both, the default
+)
+
+// cleanupBatch is a set of local images that belong to a single mirrored tag
+type cleanupBatch struct {
+	log    *log.Entry // logrus logger with the relevant custom fields
+	images []string   // image references to remove
+	span   *span      // trace span of the mirrored tag
+}
+
+// cleaner removes local images on background goroutines, so cleanup
+// doesn't serialize the mirror pipeline
+type cleaner struct {
+	dockerClient *DockerClient     // docker client used to remove images
+	queue        chan cleanupBatch // batches waiting to be removed
+	retryDelay   time.Duration     // initial delay between retries of a failed removal
+	pending      sync.WaitGroup    // batches queued but not removed yet
+	wg           sync.WaitGroup
+}
+
+func newCleaner(dc *DockerClient, workers int) *cleaner {
+	c := &cleaner{
+		dockerClient: dc,
+		queue:        make(chan cleanupBatch, 100),
+		retryDelay:   1 * time.Second,
+	}
+
+	for i := 0; i < workers; i++ {
+		c.wg.Add(1)
+		go c.work()
+	}
+
+	return c
+}
+
+// remove queues the images for removal
+func (c *cleaner) remove(logger *log.Entry, images []string, parent *span) {
+	if len(images) == 0 {
+		return
+	}
+
+	c.pending.Add(1)
+	c.queue <- cleanupBatch{log: logger, images: images, span: parent}
+}
+
+// flush blocks until all queued batches are removed, the cleaner keeps accepting new batches
+func (c *cleaner) flush() {
+	c.pending.Wait()
+}
+
+// wait stops accepting new batches and blocks until all queued batches are removed
+func (c *cleaner) wait() {
+	close(c.queue)
+	c.wg.Wait()
+}
+
+func (c *cleaner) work() {
+	defer c.wg.Done()
+
+	for batch := range c.queue {
+		s := batch.span.child("cleanup", "images", strconv.Itoa(len(batch.images)))
+
+		// the images in a batch are removed in parallel, they are independent references
+		var wg sync.WaitGroup
+		for _, image := range batch.images {
+			wg.Add(1)
+			go func(image string) {
+				defer wg.Done()
+
+				if err := c.removeImage(batch.log, image); err != nil {
+					batch.log.Errorf("Failed to clean image %s: %s", image, err)
+				}
+			}(image)
+		}
+		wg.Wait()
+		s.finish(nil)
+		c.pending.Done()
+	}
+}
+
+// removeImage removes a single image, retrying on transient errors
+func (c *cleaner) removeImage(logger *log.Entry, image string) error {
+	logger.Info("Cleaning images: " + image)
+
+	backoffSettings := backoff.NewExponentialBackOff()
+	backoffSettings.InitialInterval = c.retryDelay
+	backoffSettings.MaxElapsedTime = 30 * c.retryDelay
+
+	operation := func() error {
+		err := (*c.dockerClient).RemoveImage(image)
+		if err == docker.ErrNoSuchImage {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}
+
+	notifyError := func(err error, d time.Duration) {
+		logger.Warnf("Failed to clean image %s, retrying: %v (%s)", image, err, d.String())
+	}
+
+	return backoff.RetryNotify(operation, backoffSettings, notifyError)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// CleanupDockerClient records every RemoveImage call, and fails the
+// configured images a number of times before succeeding
+type CleanupDockerClient struct {
+	TestDockerClient
+	mu       sync.Mutex
+	calls    map[string]int
+	failures map[string]error
+	failFor  int
+	removed  []string
+}
+
+func (c *CleanupDockerClient) RemoveImage(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls[name]++
+	if err, ok := c.failures[name]; ok && (err == docker.ErrNoSuchImage || c.calls[name] <= c.failFor) {
+		return err
+	}
+
+	c.removed = append(c.removed, name)
+	return nil
+}
+
+func TestCleaner(t *testing.T) {
+	client := &CleanupDockerClient{
+		calls: make(map[string]int),
+		failures: map[string]error{
+			"redis:7":   errors.New("transient error"),
+			"missing:1": docker.ErrNoSuchImage,
+		},
+		failFor: 2,
+	}
+
+	var dc DockerClient = client
+	c := newCleaner(&dc, 2)
+	c.retryDelay = 1 * time.Millisecond
+
+	logger := log.WithField("test", "cleaner")
+	c.remove(logger, []string{"redis:7", "registry.example.com/hub/redis:7", "registry.example.com/mirror/redis:7"}, nil)
+	c.remove(logger, []string{"missing:1"}, nil)
+	c.remove(logger, nil, nil)
+	c.wait()
+
+	sort.Strings(client.removed)
+	want := []string{"redis:7", "registry.example.com/hub/redis:7", "registry.example.com/mirror/redis:7"}
+	if len(client.removed) != len(want) {
+		t.Fatalf("Expected %v to be removed, got %v", want, client.removed)
+	}
+	for i := range want {
+		if client.removed[i] != want[i] {
+			t.Errorf("Expected %v to be removed, got %v", want, client.removed)
+		}
+	}
+
+	if got := client.calls["redis:7"]; got != 3 {
+		t.Errorf("Expected the transient error to be retried until success (3 calls), got %d calls", got)
+	}
+
+	if got := client.calls["missing:1"]; got != 1 {
+		t.Errorf("Expected ErrNoSuchImage to not be retried, got %d calls", got)
+	}
+}
+
+func TestCleanerFlush(t *testing.T) {
+	client := &CleanupDockerClient{calls: make(map[string]int)}
+
+	var dc DockerClient = client
+	c := newCleaner(&dc, 2)
+
+	// in daemon mode every run waits for its own batches, the cleaner keeps running
+	for run := 1; run <= 2; run++ {
+		c.remove(log.WithField("test", "cleaner"), []string{fmt.Sprintf("redis:%d", run)}, nil)
+		c.flush()
+
+		client.mu.Lock()
+		removed := len(client.removed)
+		client.mu.Unlock()
+
+		if removed != run {
+			t.Errorf("Expected %d images to be removed after run %d, got %d", run, run, removed)
+		}
+	}
+
+	c.wait()
+}
+
+func TestCleanupScope(t *testing.T) {
+	defer func(scope string) { config.CleanupScope = scope }(config.CleanupScope)
+
+	tc := TargetConfig{Registry: "registry.example.com", Prefix: "hub/"}
+	targets := []*target{{registry: tc.Registry, config: tc}}
+	m := mirror{repo: Repository{Name: "redis", Host: dockerHub}}
+
+	tests := map[string][]string{
+		"":                      {"redis:7", "registry.example.com/hub/redis:7"},
+		cleanupScopeBoth:        {"redis:7", "registry.example.com/hub/redis:7"},
+		cleanupScopeSource:      {"redis:7"},
+		cleanupScopeTargetLocal: {"registry.example.com/hub/redis:7"},
+	}
+
+	for scope, want := range tests {
+		config.CleanupScope = scope
+		if got := m.cleanupImages("7", targets, time.Now()); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected cleanup_scope %q to remove %v, got %v", scope, want, got)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// version of docker-mirror, set at build time with `-ldflags "-X main.version=..."`
+var version = "dev"
+
+// usage prints the list of subcommands
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: docker-mirror <command> [flags]
+
+Commands:
+  run           mirror the configured repositories (default)
+  validate      check the config file and exit
+  plan          show what the next run would create, add and update in the targets
+  diff          list the tags the next run would add or update, with --output json for bots
+  verify        compare the digests of the mirrored tags across targets
+  sync-targets  copy the tags missing from a target from another target
+  copy          mirror a single image to the targets right away, i.e. copy redis:7.2
+  version       print the version
+  import        convert a skopeo sync or regsync config into a docker-mirror config
+  export        convert the docker-mirror config into a skopeo sync config
+  dashboards    export a Grafana dashboard or prometheus alert rules of the metrics
+  discover      generate the repositories of the images in Helm charts and manifests
+  scan-cluster  mirror the images of the pods running in a Kubernetes cluster
+  operator      mirror the MirrorRepository resources of a Kubernetes cluster
+  queue         mirror the jobs of an SQS queue, i.e. {"name": "library/redis", "tag": "7.2"}
+  login         log into Docker Hub in a browser, instead of DOCKERHUB_PASSWORD
+
+Run 'docker-mirror <command> -h' for the flags of a command.
+`)
+}
+
+// configFlag adds the --config flag, the CONFIG_FILE env var is the default
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", configFilePath(), "config file or directory to read (env: CONFIG_FILE)")
+}
+
+// validateConfig checks the parts of the config the yaml parser can't
+func validateConfig(cfg Config) error {
+	if err := validateHosts(cfg.Hosts); err != nil {
+		return err
+	}
+
+	switch cfg.CleanupScope {
+	case "", cleanupScopeSource, cleanupScopeTargetLocal, cleanupScopeBoth:
+	default:
+		return fmt.Errorf("Unknown cleanup_scope %q, we support %s, %s and %s", cfg.CleanupScope, cleanupScopeSource, cleanupScopeTargetLocal, cleanupScopeBoth)
+	}
+
+	switch cfg.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("Unknown log format %q, we support text and json", cfg.LogFormat)
+	}
+
+	if err := validateTenants(cfg.Tenants); err != nil {
+		return err
+	}
+
+	for _, repo := range cfg.allRepositories() {
+		if repo.TrackDeletions && cfg.State.Type == "" {
+			return fmt.Errorf("The `track_deletions` of repository %s needs a `state` backend to compare with the previous run", repo.Name)
+		}
+		if len(repo.Platforms) > 1 && !cfg.Daemonless {
+			return fmt.Errorf("Repository %s sets %d platforms, the docker daemon pulls a single platform, set `daemonless: true` to copy several", repo.Name, len(repo.Platforms))
+		}
+		if repo.ForeignLayers == foreignLayersCopy && !cfg.Daemonless {
+			return fmt.Errorf("The `foreign_layers: copy` of repository %s needs `daemonless: true`, the docker daemon decides itself whether it pushes foreign layers", repo.Name)
+		}
+		if len(repo.AddLabels) > 0 && cfg.Daemonless {
+			return fmt.Errorf("The `add_labels` of repository %s rebuild the image with the docker daemon, they can't be used with `daemonless: true`", repo.Name)
+		}
+		if err := validateSource(repo); err != nil {
+			return err
+		}
+		if err := validatePullHost(repo); err != nil {
+			return err
+		}
+		if err := validateSourceAuth(repo); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.AddLabels) > 0 && cfg.Daemonless {
+		return fmt.Errorf("The `add_labels` rebuild the images with the docker daemon, they can't be used with `daemonless: true`")
+	}
+
+	if cfg.Retries < 0 {
+		return fmt.Errorf("The `retries` can't be negative")
+	}
+
+	if cfg.HubRateLimit.Reserve < 0 {
+		return fmt.Errorf("The `hub_rate_limit -> reserve` can't be negative")
+	}
+
+	if cfg.Disk != (DiskConfig{}) && cfg.Daemonless {
+		return fmt.Errorf("The `disk` settings manage the images of the docker daemon, they can't be used with `daemonless: true`")
+	}
+
+	if err := validateScanConfig(cfg.Scan); err != nil {
+		return err
+	}
+
+	if err := validateHistoryConfig(cfg.History); err != nil {
+		return err
+	}
+
+	if cfg.WarmUp.File != "" && cfg.WarmUp.URL != "" {
+		return fmt.Errorf("Set either `warm_up -> file` or `warm_up -> url`, not both")
+	}
+
+	primaries := map[string]bool{}
+	for _, tc := range cfg.Targets {
+		if tc.StandbyFor == "" {
+			primaries[tc.Registry] = true
+		}
+	}
+
+	for _, tc := range append([]TargetConfig{cfg.Target}, cfg.Targets...) {
+		if tc.StandbyFor != "" && !primaries[tc.StandbyFor] {
+			return fmt.Errorf("Target %s is a standby for %s, which is not a target without `standby_for`", tc.Registry, tc.StandbyFor)
+		}
+
+		if tc.StandbyFor != "" && tc.Archive {
+			return fmt.Errorf("Target %s can't be both a standby and an archive target", tc.Registry)
+		}
+
+		if !tc.Archive && (tc.ArchiveMaxAge != nil || tc.ArchiveMaxTags > 0) {
+			return fmt.Errorf("Target %s sets an archive retention without `archive: true`", tc.Registry)
+		}
+
+		if _, err := parseNameTemplate(tc); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Target.Registry == "" && len(cfg.Targets) == 0 {
+		return fmt.Errorf("Missing `target -> registry` or `targets` yaml config")
+	}
+
+	return nil
+}
+
+// setupConfig loads and validates the config file, and applies its defaults
+func setupConfig(configFile string) {
+	if err := loadConfig(configFile); err != nil {
+		log.Fatal(err)
+	}
+
+	if os.Getenv("LOG_FORMAT") == "" {
+		if err := setLogFormat(config.LogFormat); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := validateConfig(config); err != nil {
+		log.Fatal(err)
+	}
+
+	if config.Workers == 0 {
+		config.Workers = runtime.NumCPU()
+	}
+}
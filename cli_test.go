@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	target := TargetConfig{Registry: "registry.example.com"}
+
+	tests := map[string]struct {
+		cfg   Config
+		valid bool
+	}{
+		"valid":                {Config{Target: target}, true},
+		"targets list":         {Config{Targets: []TargetConfig{target}}, true},
+		"missing target":       {Config{}, false},
+		"unknown scope":        {Config{Target: target, CleanupScope: "everything"}, false},
+		"unknown log format":   {Config{Target: target, LogFormat: "xml"}, false},
+		"built-in custom host": {Config{Target: target, Hosts: []HostConfig{{Name: quay, Type: hostTypeArtifactory}}}, false},
+		"standby":              {Config{Targets: []TargetConfig{target, {Registry: "standby.example.com", StandbyFor: target.Registry}}}, true},
+		"unknown primary":      {Config{Targets: []TargetConfig{target, {Registry: "standby.example.com", StandbyFor: "other.example.com"}}}, false},
+		"standby archive":      {Config{Targets: []TargetConfig{target, {Registry: "standby.example.com", StandbyFor: target.Registry, Archive: true}}}, false},
+		"deletions":            {Config{Target: target, Repositories: []Repository{{Name: "redis", TrackDeletions: true}}, State: StateConfig{Type: stateTypeS3}}, true},
+		"deletions no state":   {Config{Target: target, Repositories: []Repository{{Name: "redis", TrackDeletions: true}}}, false},
+		"platform":             {Config{Target: target, Repositories: []Repository{{Name: "redis", Platforms: []string{"linux/arm64"}}}}, true},
+		"platforms daemon":     {Config{Target: target, Repositories: []Repository{{Name: "redis", Platforms: []string{"linux/amd64", "linux/arm64"}}}}, false},
+		"labels":               {Config{Target: target, AddLabels: map[string]string{"approved": "true"}}, true},
+		"labels daemonless":    {Config{Target: target, Daemonless: true, Repositories: []Repository{{Name: "redis", AddLabels: map[string]string{"approved": "true"}}}}, false},
+		"foreign layers copy":  {Config{Target: target, Repositories: []Repository{{Name: "servercore", ForeignLayers: foreignLayersCopy}}}, false},
+		"platforms daemonless": {Config{Target: target, Daemonless: true, Repositories: []Repository{{Name: "redis", Platforms: []string{"linux/amd64", "linux/arm64"}}}}, true},
+		"source":               {Config{Target: target, Repositories: []Repository{{Name: "redis", Source: "ghcr.io/acme/redis"}}}, true},
+		"source with host":     {Config{Target: target, Repositories: []Repository{{Name: "redis", Host: quay, Source: "ghcr.io/acme/redis"}}}, false},
+		"source with tag":      {Config{Target: target, Repositories: []Repository{{Name: "redis", Source: "ghcr.io/acme/redis:7"}}}, false},
+		"pull host":            {Config{Target: target, Repositories: []Repository{{Name: "redis", PullHost: "mirror.gcr.io"}}}, true},
+		"pull host of quay":    {Config{Target: target, Repositories: []Repository{{Name: "coreos/etcd", Host: quay, PullHost: "mirror.gcr.io"}}}, false},
+		"pull host of ghcr":    {Config{Target: target, Repositories: []Repository{{Name: "redis", Source: "ghcr.io/acme/redis", PullHost: "mirror.gcr.io"}}}, false},
+		"pull host with trust": {Config{Target: target, Repositories: []Repository{{Name: "redis", ContentTrust: true, PullHost: "mirror.gcr.io"}}}, false},
+		"disk daemonless":      {Config{Target: target, Daemonless: true, Disk: DiskConfig{MinFree: 5 << 30}}, false},
+	}
+
+	for name, tt := range tests {
+		err := validateConfig(tt.cfg)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
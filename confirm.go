@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// deletionGate holds the deletions of target tags (prune_target, the target retention and
+// the archive retention) with --confirm, until they are confirmed at the end of the run, so
+// a config mistake can't wipe the mirrored history unseen
+type deletionGate struct {
+	mu      sync.Mutex
+	enabled bool      // --confirm or --yes
+	yes     bool      // --yes, the deletions are confirmed without asking
+	input   io.Reader // the answer to the confirmation, replaced in tests
+	output  io.Writer // the summary and the question, replaced in tests
+	pending []*pendingDeletion
+}
+
+// pendingDeletion is a deletion of tags held until the confirmation
+type pendingDeletion struct {
+	target     *target
+	repository string
+	tags       []string
+	reason     string
+	report     *prunedTags // nil for the archive snapshots, they aren't reported
+}
+
+var deletions = &deletionGate{input: os.Stdin, output: os.Stdout}
+
+// deleteTags deletes the tags of the repository of the target, or holds the deletion until
+// the confirmation with --confirm
+func (g *deletionGate) deleteTags(t *target, repository string, tags []string, reason string, p *prunedTags) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.enabled {
+		targetTagsCache.forget(t, repository)
+		return t.ecrManager.deleteTags(repository, tags)
+	}
+
+	log.Infof("Holding the deletion of %d tags from %s/%s until the end of the run (--confirm)", len(tags), t.registry, repository)
+	g.pending = append(g.pending, &pendingDeletion{target: t, repository: repository, tags: tags, reason: reason, report: p})
+	return nil
+}
+
+// confirm prints a summary of the deletions held during the run, and deletes them once
+// confirmed. Without --yes the question is answered on the standard input, anything but
+// y or yes (i.e. no terminal) keeps the tags, and the deletions are `declined` in the report
+func (g *deletionGate) confirm() {
+	g.mu.Lock()
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].target.registry+"/"+pending[i].repository < pending[j].target.registry+"/"+pending[j].repository
+	})
+
+	count := 0
+	fmt.Fprintf(g.output, "The run would delete these tags:\n")
+	for _, d := range pending {
+		fmt.Fprintf(g.output, "  %s/%s (%s): %s\n", d.target.registry, d.repository, d.reason, strings.Join(d.tags, ", "))
+		count += len(d.tags)
+	}
+
+	confirmed := g.yes
+	if !confirmed {
+		fmt.Fprintf(g.output, "Delete these %d tags? [y/N] ", count)
+		answer, _ := bufio.NewReader(g.input).ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			confirmed = true
+		}
+	}
+
+	if !confirmed {
+		log.Warnf("Not deleting the %d tags, the deletion wasn't confirmed", count)
+		for _, d := range pending {
+			if d.report != nil {
+				d.report.Declined = true
+			}
+		}
+		return
+	}
+
+	log.Infof("Deleting %d confirmed tags", count)
+	for _, d := range pending {
+		targetTagsCache.forget(d.target, d.repository)
+		if err := d.target.ecrManager.deleteTags(d.repository, d.tags); err != nil {
+			log.Errorf("Could not delete the tags of %s/%s: %s", d.target.registry, d.repository, err)
+			if d.report != nil {
+				d.report.Error = err.Error()
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDeletionGate(t *testing.T) {
+	newTarget := func() *target {
+		return &target{registry: "registry.example.com", ecrManager: &fakeManager{tags: map[string]map[string]string{
+			"hub/nginx": {"1.24": "sha256:a", "1.25": "sha256:b"},
+		}}}
+	}
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		yes      bool
+		answer   string
+		deleted  []string
+		declined bool
+	}{
+		{name: "disabled", deleted: []string{"hub/nginx:1.24"}},
+		{name: "confirmed", enabled: true, answer: "y\n", deleted: []string{"hub/nginx:1.24"}},
+		{name: "yes", enabled: true, yes: true, deleted: []string{"hub/nginx:1.24"}},
+		{name: "declined", enabled: true, answer: "n\n", declined: true},
+		{name: "no answer", enabled: true, declined: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			g := &deletionGate{enabled: tt.enabled, yes: tt.yes, input: strings.NewReader(tt.answer), output: &out}
+			target := newTarget()
+			p := &prunedTags{}
+
+			if err := g.deleteTags(target, "hub/nginx", []string{"1.24"}, pruneNotUpstream, p); err != nil {
+				t.Fatal(err)
+			}
+			g.confirm()
+
+			deleted := target.ecrManager.(*fakeManager).deleted
+			if strings.Join(deleted, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted %v, want %v", deleted, tt.deleted)
+			}
+			if p.Declined != tt.declined {
+				t.Errorf("declined %v, want %v", p.Declined, tt.declined)
+			}
+			if tt.enabled && !strings.Contains(out.String(), "registry.example.com/hub/nginx (not_upstream): 1.24") {
+				t.Errorf("summary %q is missing the deletion", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notary server of the Docker Hub content trust
+const dockerHubNotary = "https://notary.docker.io"
+
+// the roles signing the tags, the releases delegation of `docker trust sign` is preferred
+// over the targets key of the repository, like the docker CLI
+var trustRoles = []string{"targets/releases", "targets"}
+
+// tufSigned is a signed TUF metadata file
+type tufSigned struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []struct {
+		KeyID  string `json:"keyid"`
+		Method string `json:"method"`
+		Sig    string `json:"sig"`
+	} `json:"signatures"`
+}
+
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type tufRole struct {
+	Name      string   `json:"name"`
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+type tufRoot struct {
+	Expires time.Time          `json:"expires"`
+	Keys    map[string]tufKey  `json:"keys"`
+	Roles   map[string]tufRole `json:"roles"`
+}
+
+type tufTargets struct {
+	Expires time.Time `json:"expires"`
+	Targets map[string]struct {
+		Hashes map[string]string `json:"hashes"`
+	} `json:"targets"`
+	Delegations struct {
+		Keys  map[string]tufKey `json:"keys"`
+		Roles []tufRole         `json:"roles"`
+	} `json:"delegations"`
+}
+
+// trustData is the signed digest of every tag of a repository, loaded once per run
+type trustData struct {
+	once    sync.Once
+	digests map[string]string
+	err     error
+}
+
+// trustedDigest returns the digest the tag is signed with, an error when it isn't signed
+func (m *mirror) trustedDigest(tag string) (string, error) {
+	m.trust.once.Do(func() {
+		m.trust.digests, m.trust.err = m.loadTrustData()
+	})
+
+	if m.trust.err != nil {
+		return "", fmt.Errorf("Could not load the content trust data: %s", m.trust.err)
+	}
+
+	digest, ok := m.trust.digests[tag]
+	if !ok {
+		return "", fmt.Errorf("Tag %s isn't signed, content_trust refuses to mirror it", tag)
+	}
+
+	return digest, nil
+}
+
+// loadTrustData fetches the Notary v1 (TUF) trust data of the repository, and verifies the
+// signatures of the targets and their delegations up to the root of the repository
+func (m *mirror) loadTrustData() (map[string]string, error) {
+	server := m.repo.TrustServer
+	if server == "" {
+		server = dockerHubNotary
+	}
+
+	host, repository := splitReference(m.sourceRepository())
+	gun := host + "/" + repository
+	if host == dockerHubRegistry {
+		gun = "docker.io/" + repository
+	}
+
+	client := newRegistryClient(strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://"), m.sourceAuth(), strings.HasPrefix(server, "http://"))
+
+	var root tufRoot
+	rootFile, err := fetchTUF(client, gun, "root", &root)
+	if err != nil {
+		return nil, err
+	}
+
+	// the root must be signed by its own root keys, and by one of the pinned root keys when
+	// set. Without pinned keys the root is only checked against itself, so a compromised
+	// notary server can serve any root
+	if err := verifyTUF(rootFile, root.Keys, root.Roles["root"], root.Expires); err != nil {
+		return nil, fmt.Errorf("Invalid root of %s: %s", gun, err)
+	}
+	if len(m.repo.TrustRootKeys) > 0 {
+		if err := verifyTUF(rootFile, pinnedKeys(root.Keys, m.repo.TrustRootKeys), tufRole{KeyIDs: m.repo.TrustRootKeys, Threshold: 1}, root.Expires); err != nil {
+			return nil, fmt.Errorf("Root of %s isn't signed by a content_trust_root_keys key: %s", gun, err)
+		}
+	}
+
+	var targets tufTargets
+	targetsFile, err := fetchTUF(client, gun, "targets", &targets)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyTUF(targetsFile, root.Keys, root.Roles["targets"], targets.Expires); err != nil {
+		return nil, fmt.Errorf("Invalid targets of %s: %s", gun, err)
+	}
+
+	digests := make(map[string]string)
+	for _, role := range trustRoles {
+		signed := targets
+		if role != "targets" {
+			var delegation *tufRole
+			for i := range targets.Delegations.Roles {
+				if targets.Delegations.Roles[i].Name == role {
+					delegation = &targets.Delegations.Roles[i]
+				}
+			}
+			if delegation == nil {
+				continue
+			}
+
+			signed = tufTargets{}
+			file, err := fetchTUF(client, gun, role, &signed)
+			if err != nil {
+				return nil, err
+			}
+			if err := verifyTUF(file, targets.Delegations.Keys, *delegation, signed.Expires); err != nil {
+				return nil, fmt.Errorf("Invalid %s of %s: %s", role, gun, err)
+			}
+		}
+
+		for tag, target := range signed.Targets {
+			if _, ok := digests[tag]; ok {
+				continue
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(target.Hashes["sha256"])
+			if err != nil || len(sum) != sha256.Size {
+				continue
+			}
+			digests[tag] = "sha256:" + hex.EncodeToString(sum)
+		}
+	}
+
+	return digests, nil
+}
+
+// fetchTUF fetches the metadata of the role, and decodes its signed part
+func fetchTUF(client *registryClient, gun, role string, signed interface{}) (*tufSigned, error) {
+	res, err := client.do("GET", client.url(gun, "/_trust/tuf/%s.json", role), pullScope(gun), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s has no trust data", gun)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Getting %s trust data of %s returned %d", role, gun, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var file tufSigned
+	if err := json.Unmarshal(body, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, json.Unmarshal(file.Signed, signed)
+}
+
+// verifyTUF checks the metadata isn't expired and is signed by the threshold of the keys
+// of its role
+func verifyTUF(file *tufSigned, keys map[string]tufKey, role tufRole, expires time.Time) error {
+	if time.Now().After(expires) {
+		return fmt.Errorf("expired at %s", expires.Format(time.RFC3339))
+	}
+
+	content, err := canonicalJSON(file.Signed)
+	if err != nil {
+		return err
+	}
+
+	allowed := make(map[string]bool)
+	for _, id := range role.KeyIDs {
+		allowed[id] = true
+	}
+
+	valid := make(map[string]bool)
+	for _, sig := range file.Signatures {
+		key, ok := keys[sig.KeyID]
+		if !ok || !allowed[sig.KeyID] {
+			continue
+		}
+
+		if err := verifySignature(key, sig.Method, sig.Sig, content); err == nil {
+			valid[sig.KeyID] = true
+		}
+	}
+
+	threshold := role.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	if len(valid) < threshold {
+		return fmt.Errorf("%d valid signatures, %d required", len(valid), threshold)
+	}
+
+	return nil
+}
+
+// pinnedKeys returns the keys with a pinned ID, the ID of a key being the sha256 of its
+// canonical JSON like notary computes it, so a key can't be served under a pinned ID
+func pinnedKeys(keys map[string]tufKey, pinned []string) map[string]tufKey {
+	found := make(map[string]tufKey)
+	for _, id := range pinned {
+		if key, ok := keys[id]; ok && tufKeyID(key) == id {
+			found[id] = key
+		}
+	}
+
+	return found
+}
+
+// tufKeyID returns the ID of the public key, the hex sha256 of its canonical JSON
+func tufKeyID(key tufKey) string {
+	raw, _ := json.Marshal(map[string]interface{}{
+		"keytype": key.KeyType,
+		"keyval":  map[string]interface{}{"public": key.KeyVal.Public, "private": nil},
+	})
+
+	content, err := canonicalJSON(raw)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySignature verifies an ecdsa, rsapss or ed25519 signature of the content
+func verifySignature(key tufKey, method, signature string, content []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	public, err := base64.StdEncoding.DecodeString(key.KeyVal.Public)
+	if err != nil {
+		return err
+	}
+
+	var pub crypto.PublicKey
+	switch key.KeyType {
+	case "ed25519":
+		pub = ed25519.PublicKey(public)
+	case "ecdsa", "rsa":
+		if pub, err = x509.ParsePKIXPublicKey(public); err != nil {
+			return err
+		}
+	case "ecdsa-x509", "rsa-x509":
+		block, _ := pem.Decode(public)
+		if block == nil {
+			return fmt.Errorf("invalid certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		pub = cert.PublicKey
+	default:
+		return fmt.Errorf("unsupported key type %s", key.KeyType)
+	}
+
+	sum := sha256.Sum256(content)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if method != "ecdsa" || len(sig) != 2*size {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+		if !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+	case *rsa.PublicKey:
+		if method != "rsapss" {
+			return fmt.Errorf("unsupported rsa signature method %s", method)
+		}
+		return rsa.VerifyPSS(pub, crypto.SHA256, sum[:], sig, &rsa.PSSOptions{SaltLength: sha256.Size, Hash: crypto.SHA256})
+	case ed25519.PublicKey:
+		if method != "ed25519" || !ed25519.Verify(pub, content, sig) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key")
+	}
+
+	return nil
+}
+
+// canonicalJSON re-encodes the JSON with sorted keys and without insignificant whitespace,
+// as it was signed
+func canonicalJSON(content []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// checkTrustedDigest fails when the pulled image isn't the signed digest
+func checkTrustedDigest(signed, pulled string) error {
+	if signed != "" && pulled != signed {
+		return fmt.Errorf("Pulled digest %s isn't the signed digest %s", pulled, signed)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// signTUF signs the metadata with the key, as a notary server would serve it
+func signTUF(t *testing.T, signed interface{}, keyID string, key *ecdsa.PrivateKey) []byte {
+	raw, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := canonicalJSON(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	file, _ := json.Marshal(map[string]interface{}{
+		"signed":     json.RawMessage(raw),
+		"signatures": []map[string]string{{"keyid": keyID, "method": "ecdsa", "sig": base64.StdEncoding.EncodeToString(sig)}},
+	})
+	return file
+}
+
+func publicTUFKey(t *testing.T, key *ecdsa.PrivateKey) map[string]interface{} {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]interface{}{"keytype": "ecdsa", "keyval": map[string]string{"public": base64.StdEncoding.EncodeToString(der)}}
+}
+
+func TestContentTrust(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	targetsKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	releasesKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	expires := time.Now().Add(time.Hour)
+
+	hash := func(b byte) map[string]interface{} {
+		sum := make([]byte, 32)
+		sum[31] = b
+		return map[string]interface{}{"hashes": map[string]string{"sha256": base64.StdEncoding.EncodeToString(sum)}, "length": 1024}
+	}
+
+	files := map[string][]byte{
+		"root": signTUF(t, map[string]interface{}{
+			"_type":   "Root",
+			"expires": expires,
+			"keys":    map[string]interface{}{"root": publicTUFKey(t, rootKey), "targets": publicTUFKey(t, targetsKey)},
+			"roles": map[string]interface{}{
+				"root":    map[string]interface{}{"keyids": []string{"root"}, "threshold": 1},
+				"targets": map[string]interface{}{"keyids": []string{"targets"}, "threshold": 1},
+			},
+		}, "root", rootKey),
+		"targets": signTUF(t, map[string]interface{}{
+			"_type":   "Targets",
+			"expires": expires,
+			"targets": map[string]interface{}{"6": hash(6), "7": hash(1)},
+			"delegations": map[string]interface{}{
+				"keys":  map[string]interface{}{"releases": publicTUFKey(t, releasesKey)},
+				"roles": []map[string]interface{}{{"name": "targets/releases", "keyids": []string{"releases"}, "threshold": 1}},
+			},
+		}, "targets", targetsKey),
+		// the releases delegation wins over the targets key
+		"targets/releases": signTUF(t, map[string]interface{}{
+			"_type":   "Targets",
+			"expires": expires,
+			"targets": map[string]interface{}{"7": hash(7)},
+		}, "releases", releasesKey),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/docker.io/library/redis/_trust/tuf/"), ".json")
+		file, ok := files[role]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(file)
+	}))
+	defer server.Close()
+
+	m := mirror{
+		log:   log.WithField("test", "content_trust"),
+		repo:  Repository{Name: "redis", Host: dockerHub, ContentTrust: true, TrustServer: server.URL},
+		trust: &trustData{},
+	}
+
+	for tag, want := range map[string]string{"6": "sha256:" + strings.Repeat("0", 62) + "06", "7": "sha256:" + strings.Repeat("0", 62) + "07"} {
+		if got, err := m.trustedDigest(tag); err != nil || got != want {
+			t.Errorf("Expected tag %s to be signed with %s, got %s (%v)", tag, want, got, err)
+		}
+	}
+
+	if _, err := m.trustedDigest("8"); err == nil || !strings.Contains(err.Error(), "isn't signed") {
+		t.Errorf("Expected an unsigned tag to be refused, got %v", err)
+	}
+
+	// targets signed by a key the root doesn't trust
+	files["targets"] = signTUF(t, map[string]interface{}{"_type": "Targets", "expires": expires, "targets": map[string]interface{}{"8": hash(8)}}, "targets", releasesKey)
+	m.trust = &trustData{}
+	if _, err := m.trustedDigest("8"); err == nil || !strings.Contains(err.Error(), "Invalid targets") {
+		t.Errorf("Expected targets with an invalid signature to be refused, got %v", err)
+	}
+
+	if err := checkTrustedDigest("sha256:signed", "sha256:other"); err == nil {
+		t.Errorf("Expected a pulled digest other than the signed digest to be refused")
+	}
+}
+
+func TestContentTrustRootKeys(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	expires := time.Now().Add(time.Hour)
+
+	keyID := func(key *ecdsa.PrivateKey) string {
+		raw, _ := json.Marshal(publicTUFKey(t, key))
+		var k tufKey
+		if err := json.Unmarshal(raw, &k); err != nil {
+			t.Fatal(err)
+		}
+		return tufKeyID(k)
+	}
+	rootID, otherID := keyID(rootKey), keyID(otherKey)
+
+	rootOf := func(id string, key *ecdsa.PrivateKey) []byte {
+		return signTUF(t, map[string]interface{}{
+			"_type":   "Root",
+			"expires": expires,
+			"keys":    map[string]interface{}{id: publicTUFKey(t, key)},
+			"roles": map[string]interface{}{
+				"root":    map[string]interface{}{"keyids": []string{id}, "threshold": 1},
+				"targets": map[string]interface{}{"keyids": []string{id}, "threshold": 1},
+			},
+		}, id, key)
+	}
+	files := map[string][]byte{
+		"root":    rootOf(rootID, rootKey),
+		"targets": signTUF(t, map[string]interface{}{"_type": "Targets", "expires": expires, "targets": map[string]interface{}{}}, rootID, rootKey),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v2/docker.io/library/redis/_trust/tuf/"), ".json")])
+	}))
+	defer server.Close()
+
+	m := mirror{
+		log:  log.WithField("test", "content_trust"),
+		repo: Repository{Name: "redis", Host: dockerHub, ContentTrust: true, TrustServer: server.URL, TrustRootKeys: []string{otherID, rootID}},
+	}
+	if _, err := m.loadTrustData(); err != nil {
+		t.Errorf("Expected the root signed by a pinned key to be trusted, got %v", err)
+	}
+
+	// a root of another key, and a root serving another key under the pinned ID
+	for name, root := range map[string][]byte{"other key": rootOf(otherID, otherKey), "pinned ID": rootOf(rootID, otherKey)} {
+		files["root"] = root
+		m.repo.TrustRootKeys = []string{rootID}
+		if _, err := m.loadTrustData(); err == nil || !strings.Contains(err.Error(), "content_trust_root_keys") {
+			t.Errorf("Expected the root of the %s to be refused, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// copyCommand mirrors a single image to the targets of the config right away, without
+// adding it to the config, i.e. `docker-mirror copy redis:7.2` during an incident
+func copyCommand(args []string) {
+	flags := flag.NewFlagSet("copy", flag.ExitOnError)
+	configFile := configFlag(flags)
+	targetPrefix := flags.String("target-prefix", "", "prefix of the image in the targets, instead of the prefix of the targets")
+	reportFile := flags.String("report-file", os.Getenv("REPORT_FILE"), "write a JSON report of the copy to this file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror copy [flags] <source-image[:tag|@digest]>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// the flags can follow the image, i.e. `copy redis:7.2 --target-prefix incident/`
+	var image string
+	if flags.NArg() > 0 {
+		image = flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+	}
+	if image == "" || flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	setupConfig(*configFile)
+
+	repo, err := copyRepository(image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the prefix of the targets (and of the tenant) replaces the `target_prefix` of the repository
+	if *targetPrefix != "" {
+		repo.TargetPrefix = nil
+		config.Target.Prefix = *targetPrefix
+		for i := range config.Targets {
+			config.Targets[i].Prefix = *targetPrefix
+		}
+		for i := range config.Tenants {
+			config.Tenants[i].Prefix = *targetPrefix
+		}
+	}
+
+	// only the image is mirrored, with the settings of its repository in the config
+	config.Repositories = nil
+	var tenants []TenantConfig
+	for _, tenant := range config.Tenants {
+		if tenant.Name == repo.tenant {
+			tenant.Repositories = []Repository{repo}
+			tenants = append(tenants, tenant)
+		}
+	}
+	config.Tenants = tenants
+	if repo.tenant == "" {
+		config.Repositories = []Repository{repo}
+	}
+
+	log.Infof("Copying %s", image)
+	startRun(runOptions{
+		configFile: *configFile,
+		reportFile: *reportFile,
+	})
+
+	// where the image landed, and a non-zero exit when it failed for scripts
+	failed := false
+	for _, rr := range report.Repositories {
+		for _, tr := range rr.Tags {
+			for _, t := range tr.Targets {
+				fmt.Printf("%s => %s/%s:%s %s\n", image, t.Registry, t.Repository, t.Tag, t.Result)
+			}
+			if tr.Result == resultSkipped {
+				fmt.Printf("%s skipped: %s\n", image, tr.Reason)
+			}
+		}
+		failed = failed || rr.Result == resultFailed
+	}
+	if failed || len(report.Repositories) == 0 {
+		log.Fatalf("Could not copy %s, see the errors above", image)
+	}
+}
+
+// copyRepository returns the repository mirroring the image: the repository of the config
+// with the same name and host when there is one, without its tag filters nor the deletions
+// of its targets, otherwise a repository with its `source` (i.e. ghcr.io/acme/app)
+func copyRepository(image string) (Repository, error) {
+	ref, digest := image, ""
+	if i := strings.Index(image, "@"); i > 0 {
+		ref, digest = image[:i], image[i+1:]
+	}
+	if digest != "" && !isDigest(digest) {
+		return Repository{}, fmt.Errorf("Invalid digest %s", digest)
+	}
+
+	registry, name, tag := splitImageReference(ref)
+	if name == "" {
+		return Repository{}, fmt.Errorf("Invalid image %s", image)
+	}
+
+	host, ok := importHost(registry)
+	if h := customHost(registry); h != nil {
+		host, name, ok = registry, strings.TrimPrefix(name, h.PathPrefix), true
+	}
+
+	var repo Repository
+	if ok {
+		// an official image is configured with or without its library/
+		names := []string{name}
+		if host == dockerHub {
+			name = strings.TrimPrefix(name, "library/")
+			names = []string{name, "library/" + name}
+		}
+
+		var err error
+		for _, n := range names {
+			if repo, err = jobRepository(queueJob{Name: n, Host: host, Tag: "latest"}, false); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			if repo, err = jobRepository(queueJob{Name: name, Host: host, Tag: "latest"}, true); err != nil {
+				return repo, err
+			}
+		}
+	} else {
+		repo = Repository{Name: name, Source: registry + "/" + name, Tags: []string{"latest"}}
+	}
+
+	switch {
+	case digest != "":
+		// pushed as its tag, or as sha256-<hex> without
+		repo.Tags, repo.Digests = nil, []PinnedDigest{{Digest: digest, Tag: tag}}
+	case tag != "":
+		repo.Tags = []string{tag}
+	}
+
+	repo.PruneTarget, repo.TargetMaxTags, repo.TargetMaxTagAge, repo.TrackDeletions = false, 0, nil, false
+
+	return repo, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCopyRepository(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{
+		Hosts: []HostConfig{{Name: "artifactory.example.com", PathPrefix: "docker-virtual/"}},
+		Repositories: []Repository{
+			{Name: "library/nginx", MatchTags: []string{"1.*"}, Platforms: []string{"linux/arm64"}, PruneTarget: true, TargetMaxTags: 10},
+		},
+		Tenants: []TenantConfig{{Name: "search", Repositories: []Repository{{Name: "elastic/elasticsearch", Host: quay}}}},
+	}
+
+	tests := []struct {
+		image string
+		repo  Repository
+	}{
+		{"redis", Repository{Name: "redis", Host: dockerHub, Tags: []string{"latest"}}},
+		{"docker.io/library/redis:7.2", Repository{Name: "redis", Host: dockerHub, Tags: []string{"7.2"}}},
+		// the settings of the configured repository, without its filters and target deletions
+		{"nginx:1.25", Repository{Name: "library/nginx", Host: dockerHub, Tags: []string{"1.25"}, Platforms: []string{"linux/arm64"}}},
+		{"quay.io/elastic/elasticsearch:8.11.0", Repository{Name: "elastic/elasticsearch", Host: quay, Tags: []string{"8.11.0"}, tenant: "search"}},
+		{"artifactory.example.com/docker-virtual/acme/app:1.0", Repository{Name: "acme/app", Host: "artifactory.example.com", Tags: []string{"1.0"}}},
+		// another registry is the `source`
+		{"ghcr.io/acme/app:v2", Repository{Name: "acme/app", Source: "ghcr.io/acme/app", Tags: []string{"v2"}}},
+		{"ghcr.io/acme/app@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", Repository{Name: "acme/app", Source: "ghcr.io/acme/app", Digests: []PinnedDigest{{Digest: "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"}}}},
+		{"redis:7.2@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", Repository{Name: "redis", Host: dockerHub, Digests: []PinnedDigest{{Digest: "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", Tag: "7.2"}}}},
+	}
+
+	for _, tt := range tests {
+		repo, err := copyRepository(tt.image)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.image, err)
+			continue
+		}
+		if !reflect.DeepEqual(repo, tt.repo) {
+			t.Errorf("%s: expected %+v, got %+v", tt.image, tt.repo, repo)
+		}
+	}
+
+	for _, image := range []string{"redis@latest", "ghcr.io/"} {
+		if _, err := copyRepository(image); err == nil {
+			t.Errorf("Expected %s to be invalid", image)
+		}
+	}
+}
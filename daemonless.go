@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	registryClientsMu sync.Mutex
+
+	// registry clients by host and user, so bearer tokens are reused across tags
+	registryClients = make(map[string]*registryClient)
+)
+
+// registryClientFor returns the shared registry client of the host and credentials
+func registryClientFor(host string, auth docker.AuthConfiguration, insecure bool) *registryClient {
+	registryClientsMu.Lock()
+	defer registryClientsMu.Unlock()
+
+	key := fmt.Sprintf("%s|%s|%t", host, auth.Username, insecure)
+	// a new password (i.e. a refreshed access token) replaces the client
+	if c, ok := registryClients[key]; ok && c.password == auth.Password {
+		return c
+	}
+
+	c := newRegistryClient(host, auth, insecure)
+	registryClients[key] = c
+	return c
+}
+
+// registryPath returns the host of the target registry, and the path of the repository in it
+func (t *target) registryPath(repository string) (string, string) {
+	chunk := strings.SplitN(t.registry, "/", 2)
+	if len(chunk) == 2 {
+		return chunk[0], chunk[1] + "/" + repository
+	}
+
+	return chunk[0], repository
+}
+
+// copyTag copies the tag from the source registry to every target with the registry API,
+// without a docker daemon. Blobs the target already has are not copied again. Returns the
+// metadata of the source image
+func (m *mirror) copyTag(ts *span, tr *tagReport, tagTargets []*target, tag string, start time.Time) (*imageMetadata, error) {
+	srcHost, srcRepository := splitReference(m.sourceRepository())
+	src := registryClientFor(srcHost, m.sourceAuth(), false)
+	if err := m.waitPullQuota(); err != nil {
+		return nil, err
+	}
+	quotas.record(m.pullHost(), quotaPull)
+
+	// with content trust, the signed digest is copied rather than the current tag
+	reference := tag
+	if m.signedDigest != "" {
+		reference = m.signedDigest
+	}
+
+	// a schema1 tag is skipped before anything is pushed, unless it is converted
+	metadata, err := fetchImageMetadata(src, srcRepository, reference)
+	var s1 *schema1Error
+	if errors.As(err, &s1) {
+		if m.schema1 == "" {
+			return nil, err
+		}
+	} else if err != nil {
+		m.log.Warnf("Failed to read the image metadata: %s", err)
+	}
+
+	// the daemonless mode has no pulled image, the scanner reads it from the source registry.
+	// The scanners only know container images
+	if config.Scan.Scanner != "" && metadata != nil && metadata.artifactType != "" {
+		m.log.Infof("Not scanning the %s artifact", metadata.artifactType)
+	} else if config.Scan.Scanner != "" {
+		image := m.sourceImage(tag)
+		if m.signedDigest != "" {
+			image = m.sourceRepository() + "@" + m.signedDigest
+		}
+
+		s := ts.child("scan", "scanner", config.Scan.Scanner, "image", image)
+		err := scanImage(config.Scan, image, false)
+		s.finish(err)
+		if err != nil {
+			return metadata, err
+		}
+	}
+
+	// with `platforms`, the index is rebuilt once for all the targets
+	var index *platformIndex
+	if len(m.repo.Platforms) > 0 && m.schema1 == "" {
+		if index, err = selectPlatforms(src, srcRepository, reference, m.repo.Platforms); err != nil {
+			return metadata, err
+		}
+	}
+
+	var failed error
+	for _, t := range tagTargets {
+		targetTag := m.targetTag(t, tag, start)
+		result := &targetReport{Registry: t.registry, Repository: m.targetRepositoryName(t), Tag: targetTag, Result: resultMirrored, target: t}
+		tr.Targets = append(tr.Targets, result)
+
+		creds, err := t.credentials()
+		if err != nil {
+			m.log.Errorf("Failed to get credentials for %s: %s", t.registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+			continue
+		}
+
+		host, repository := t.registryPath(m.targetRepositoryName(t))
+		dst := registryClientFor(host, *creds, t.config.Insecure)
+
+		copyStart := time.Now()
+		s := ts.child("registry copy", "registry", t.registry, "image", fmt.Sprintf("%s/%s:%s", t.registry, m.targetRepositoryName(t), targetTag))
+		copy := copyImage
+		if m.schema1 != "" {
+			copy = copySchema1Image
+		} else if index != nil {
+			copy = index.copy
+		}
+		var digest string
+		var transferred int64
+		err = m.retryStep(tr, "copy", func() error {
+			n, err := m.copyForeignLayers(src, srcRepository, reference, index, dst, repository)
+			transferred += n
+			if err != nil {
+				return err
+			}
+
+			digest, n, err = copy(src, srcRepository, reference, dst, repository, targetTag)
+			transferred += n
+			return err
+		})
+		s.finish(err)
+		result.PushDuration = time.Since(copyStart).Seconds()
+		tr.BytesTransferred += transferred
+		if err != nil {
+			m.log.Errorf("Failed to copy image to %s: %s", t.registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+			continue
+		}
+
+		result.Digest = digest
+		tr.SourceDigest = digest
+		if m.schema1 != "" {
+			// the converted manifest has its own digest
+			tr.SourceDigest = m.schema1
+		} else if index != nil {
+			// so does the rebuilt index
+			tr.SourceDigest = index.source
+		}
+
+		if err := m.pruneArchive(t, m.mapTag(tag), start); err != nil {
+			m.log.Warnf("Failed to prune archive snapshots in %s: %s", t.registry, err)
+		}
+	}
+
+	return metadata, failed
+}
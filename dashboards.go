@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	formatGrafana         = "grafana"
+	formatPrometheusRules = "prometheus-rules"
+)
+
+// grafanaPanel is a panel of the generated Grafana dashboard
+type grafanaPanel struct {
+	ID          int                    `json:"id"`
+	Title       string                 `json:"title"`
+	Type        string                 `json:"type"`
+	Datasource  string                 `json:"datasource"`
+	GridPos     map[string]int         `json:"gridPos"`
+	Targets     []grafanaTarget        `json:"targets"`
+	FieldConfig map[string]interface{} `json:"fieldConfig"`
+}
+
+// grafanaTarget is a prometheus query of a panel
+type grafanaTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+// prometheusRules is a prometheus alerting rules file
+type prometheusRules struct {
+	Groups []prometheusRuleGroup `yaml:"groups"`
+}
+
+type prometheusRuleGroup struct {
+	Name  string           `yaml:"name"`
+	Rules []prometheusRule `yaml:"rules"`
+}
+
+type prometheusRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// dashboardsCommand generates the Grafana dashboard and prometheus alert rules of the
+// metrics served on the /metrics endpoint of the admin server
+func dashboardsCommand(args []string) {
+	flags := flag.NewFlagSet("dashboards", flag.ExitOnError)
+	format := flags.String("format", formatGrafana, "format to export (grafana or prometheus-rules)")
+	staleAfter := flags.Duration("stale-after", 6*time.Hour, "alert when no run completed for this long")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror dashboards export [--format grafana|prometheus-rules]\n")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "export" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+
+	var (
+		out []byte
+		err error
+	)
+	switch *format {
+	case formatGrafana:
+		out, err = json.MarshalIndent(grafanaDashboard(), "", "  ")
+		out = append(out, '\n')
+	case formatPrometheusRules:
+		out, err = yaml.Marshal(alertRules(*staleAfter))
+	default:
+		log.Fatalf("Unknown dashboards format %q, we support %s and %s", *format, formatGrafana, formatPrometheusRules)
+	}
+	if err != nil {
+		log.Fatalf("Could not render %s: %s", *format, err)
+	}
+
+	fmt.Print(string(out))
+}
+
+// grafanaDashboard returns the Grafana dashboard model, to import in Grafana
+func grafanaDashboard() map[string]interface{} {
+	panels := []struct {
+		title  string
+		kind   string
+		unit   string
+		expr   string
+		legend string
+	}{
+		{"Time since last run", "stat", "s", fmt.Sprintf("time() - %s", metricLastRunTimestamp.name), ""},
+		{"Last run duration", "stat", "s", metricLastRunDuration.name, ""},
+		{"Runs (24h)", "stat", "short", fmt.Sprintf("increase(%s[24h])", metricRuns.name), ""},
+		{"Failed repositories (last run)", "stat", "short", fmt.Sprintf(`%s{result="failed"}`, metricLastRunRepos.name), ""},
+		{"Failed tags (last run)", "stat", "short", fmt.Sprintf(`%s{result="failed"}`, metricLastRunTags.name), ""},
+		{"Paused", "stat", "short", metricPaused.name, ""},
+		{"Tags by result", "timeseries", "short", fmt.Sprintf("sum by (result) (increase(%s[1h]))", metricTags.name), "{{result}}"},
+		{"Bytes mirrored", "timeseries", "bytes", fmt.Sprintf("increase(%s[1h])", metricBytes.name), ""},
+		{"Freshness SLA violations", "timeseries", "short", fmt.Sprintf("increase(%s[1h])", metricSLAViolations.name), ""},
+		{"Upstream requests", "timeseries", "short", fmt.Sprintf("sum by (host, kind) (increase(%s[1h]))", metricUpstreamRequests.name), "{{host}} {{kind}}"},
+		{"Tag slots", "timeseries", "short", metricTagSlots.name, ""},
+		{"Docker transfer rate", "timeseries", "Bps", fmt.Sprintf("sum by (action) (rate(%s[5m]))", metricTransfers.name), "{{action}}"},
+		{"Docker layers", "timeseries", "short", fmt.Sprintf("sum by (action) (increase(%s[1h]))", metricLayers.name), "{{action}}"},
+	}
+
+	var res []grafanaPanel
+	stats, series := 0, 0
+	for i, p := range panels {
+		// stats are a row of 6 small panels, time series two per row below them
+		var pos map[string]int
+		if p.kind == "stat" {
+			pos = map[string]int{"w": 4, "h": 4, "x": stats * 4, "y": 0}
+			stats++
+		} else {
+			pos = map[string]int{"w": 12, "h": 8, "x": (series % 2) * 12, "y": 4 + (series/2)*8}
+			series++
+		}
+
+		res = append(res, grafanaPanel{
+			ID:          i + 1,
+			Title:       p.title,
+			Type:        p.kind,
+			Datasource:  "${datasource}",
+			GridPos:     pos,
+			Targets:     []grafanaTarget{{Expr: p.expr, LegendFormat: p.legend, RefID: "A"}},
+			FieldConfig: map[string]interface{}{"defaults": map[string]string{"unit": p.unit}},
+		})
+	}
+
+	return map[string]interface{}{
+		"title":         "docker-mirror",
+		"uid":           "docker-mirror",
+		"schemaVersion": 36,
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{"name": "datasource", "type": "datasource", "query": "prometheus"},
+			},
+		},
+		"panels": res,
+	}
+}
+
+// alertRules returns the prometheus alerting rules of the metrics
+func alertRules(staleAfter time.Duration) prometheusRules {
+	warning := map[string]string{"severity": "warning"}
+
+	return prometheusRules{Groups: []prometheusRuleGroup{{
+		Name: "docker-mirror",
+		Rules: []prometheusRule{
+			{
+				Alert:       "DockerMirrorRunStale",
+				Expr:        fmt.Sprintf("time() - %s > %d", metricLastRunTimestamp.name, int(staleAfter.Seconds())),
+				Labels:      warning,
+				Annotations: map[string]string{"summary": fmt.Sprintf("No docker-mirror run completed in the last %s", staleAfter)},
+			},
+			{
+				Alert:       "DockerMirrorRepositoriesFailing",
+				Expr:        fmt.Sprintf(`%s{result="failed"} > 0`, metricLastRunRepos.name),
+				Labels:      warning,
+				Annotations: map[string]string{"summary": "{{ $value }} repositories failed to mirror in the last run"},
+			},
+			{
+				Alert:       "DockerMirrorTagsFailing",
+				Expr:        fmt.Sprintf(`increase(%s{result="failed"}[1h]) > 0`, metricTags.name),
+				Labels:      warning,
+				Annotations: map[string]string{"summary": "Tags failed to mirror in the last hour"},
+			},
+			{
+				Alert:       "DockerMirrorFreshnessSLAViolated",
+				Expr:        fmt.Sprintf("increase(%s[1h]) > 0", metricSLAViolations.name),
+				Labels:      warning,
+				Annotations: map[string]string{"summary": "Tags landed after their freshness SLA in the last hour"},
+			},
+			{
+				Alert:       "DockerMirrorPaused",
+				Expr:        fmt.Sprintf("%s == 1", metricPaused.name),
+				For:         "1h",
+				Labels:      warning,
+				Annotations: map[string]string{"summary": "docker-mirror has been paused for over an hour"},
+			},
+		},
+	}}}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDashboardsUseExposedMetrics(t *testing.T) {
+	dashboard, err := json.Marshal(grafanaDashboard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := yaml.Marshal(alertRules(6 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exposed := make(map[string]bool)
+	for _, d := range metricDefinitions {
+		exposed[d.name] = true
+	}
+
+	used := make(map[string]bool)
+	for _, name := range regexp.MustCompile(`docker_mirror_[a-z_]+`).FindAllString(string(dashboard)+string(rules), -1) {
+		used[name] = true
+		if !exposed[name] {
+			t.Errorf("Expected %s to be an exposed metric", name)
+		}
+	}
+
+	for name := range exposed {
+		if !used[name] {
+			t.Errorf("Expected %s to be in the dashboard or alert rules", name)
+		}
+	}
+}
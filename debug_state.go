@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runState tracks the scheduling decisions of the run, to diagnose a run that appears
+// stalled: dumped as JSON on SIGUSR2 and served on /debug/state
+var runState = newStateTracker()
+
+// stateTracker is the queue, the task of every worker, the retries and the waits of the run
+type stateTracker struct {
+	mu      sync.Mutex
+	queue   []string                        // repositories not picked by a worker yet
+	workers map[int]*workerState            // current repository of each worker
+	tags    map[string]map[string]time.Time // tags in progress and their start, by host/repository
+	retries map[string]int                  // retried requests since start, by host
+	waits   map[*stateWait]bool             // retry and rate limit sleeps in progress
+	layers  map[string]*transferProgress    // docker pull or push of the tags in progress, by host/repository/tag
+	active  time.Time                       // last progress of the run, for the systemd watchdog
+}
+
+type workerState struct {
+	Repository string    `json:"repository"`
+	Host       string    `json:"host"`
+	Since      time.Time `json:"since"`
+}
+
+type stateWait struct {
+	Host   string    `json:"host"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// stateSnapshot is the JSON dump of the state
+type stateSnapshot struct {
+	Time     time.Time        `json:"time"`
+	Paused   bool             `json:"paused"`
+	Stopped  string           `json:"stopped,omitempty"`
+	Queue    []string         `json:"queue"`
+	TagSlots tagSlotsState    `json:"tag_slots"`
+	Workers  []workerSnapshot `json:"workers"`
+	Retries  map[string]int   `json:"retries"`
+	Waits    []stateWait      `json:"waits"`
+}
+
+type tagSlotsState struct {
+	Used  int `json:"used"` // including the slots held by the adaptive workers
+	Size  int `json:"size"`
+	Limit int `json:"limit"` // the slots the adaptive workers allow, the size otherwise
+}
+
+type workerSnapshot struct {
+	ID int `json:"id"`
+	workerState
+	Tags []tagState `json:"tags"`
+}
+
+type tagState struct {
+	Tag      string            `json:"tag"`
+	Since    time.Time         `json:"since"`
+	Progress *transferProgress `json:"progress,omitempty"` // of its docker pull or push
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{
+		workers: make(map[int]*workerState),
+		tags:    make(map[string]map[string]time.Time),
+		retries: make(map[string]int),
+		waits:   make(map[*stateWait]bool),
+		layers:  make(map[string]*transferProgress),
+	}
+}
+
+// queued sets the repositories the run is about to schedule, in their order
+func (s *stateTracker) queued(repos []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue = append([]string{}, repos...)
+	s.active = time.Now()
+}
+
+// dequeue removes the repository from the queue
+func (s *stateTracker) dequeue(repo string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, name := range s.queue {
+		if name == repo {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// working records the repository of the worker, the worker is idle when repo is empty
+func (s *stateTracker) working(worker int, repo, host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = time.Now()
+	if repo == "" {
+		delete(s.workers, worker)
+		return
+	}
+
+	s.workers[worker] = &workerState{Repository: repo, Host: host, Since: time.Now()}
+}
+
+// tagStarted records the tag in progress, until the returned func is called
+func (s *stateTracker) tagStarted(host, repo, tag string) func() {
+	key := host + "/" + repo
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tags[key] == nil {
+		s.tags[key] = make(map[string]time.Time)
+	}
+	s.tags[key][tag] = time.Now()
+	s.active = time.Now()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.active = time.Now()
+		delete(s.layers, key+"/"+tag)
+		delete(s.tags[key], tag)
+		if len(s.tags[key]) == 0 {
+			delete(s.tags, key)
+		}
+	}
+}
+
+// transferring records the progress of the docker pull or push of the tag in progress
+func (s *stateTracker) transferring(host, repo, tag string, p transferProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the tag ended, i.e. after its repository timed out
+	if _, ok := s.tags[host+"/"+repo][tag]; !ok {
+		return
+	}
+	s.layers[host+"/"+repo+"/"+tag] = &p
+}
+
+// retrySleep sleeps before retrying a request to the host, recording the retry and the wait
+func (s *stateTracker) retrySleep(host, reason string, delay time.Duration) {
+	adaptive.retried()
+
+	s.mu.Lock()
+	s.retries[host]++
+	s.mu.Unlock()
+
+	s.pause(host, reason, delay)
+}
+
+// pause sleeps for the delay, listed in the waits of the state
+func (s *stateTracker) pause(host, reason string, delay time.Duration) {
+	w := &stateWait{Host: host, Reason: reason, Until: time.Now().Add(delay)}
+
+	s.mu.Lock()
+	s.waits[w] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.waits, w)
+		s.active = time.Now()
+		s.mu.Unlock()
+	}()
+
+	sleep(delay)
+}
+
+// progressed records that the run made progress, i.e. a layer transferred some bytes
+func (s *stateTracker) progressed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.active = time.Now()
+}
+
+// stalled returns for how long a run made no progress, when it is longer than stall. The
+// scheduler idle between runs, or waiting for a retry or a rate limit, isn't stalled
+func (s *stateTracker) stalled(now time.Time, stall time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.workers) == 0 || len(s.waits) > 0 {
+		return 0
+	}
+
+	if idle := now.Sub(s.active); idle > stall {
+		return idle
+	}
+
+	return 0
+}
+
+func (s *stateTracker) snapshot() stateSnapshot {
+	paused, _ := scheduler.status()
+	snap := stateSnapshot{
+		Time:     time.Now(),
+		Paused:   paused,
+		Stopped:  killSwitch.stopped(),
+		TagSlots: tagSlotsState{Used: len(tagSlots), Size: cap(tagSlots), Limit: adaptive.current()},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap.Queue = append([]string{}, s.queue...)
+
+	snap.Workers = []workerSnapshot{}
+	for id, w := range s.workers {
+		ws := workerSnapshot{ID: id, workerState: *w, Tags: []tagState{}}
+		for tag, since := range s.tags[w.Host+"/"+w.Repository] {
+			ws.Tags = append(ws.Tags, tagState{Tag: tag, Since: since, Progress: s.layers[w.Host+"/"+w.Repository+"/"+tag]})
+		}
+		sort.Slice(ws.Tags, func(i, j int) bool { return ws.Tags[i].Since.Before(ws.Tags[j].Since) })
+		snap.Workers = append(snap.Workers, ws)
+	}
+	sort.Slice(snap.Workers, func(i, j int) bool { return snap.Workers[i].ID < snap.Workers[j].ID })
+
+	snap.Retries = make(map[string]int)
+	for host, n := range s.retries {
+		snap.Retries[host] = n
+	}
+
+	snap.Waits = []stateWait{}
+	for w := range s.waits {
+		snap.Waits = append(snap.Waits, *w)
+	}
+	sort.Slice(snap.Waits, func(i, j int) bool { return snap.Waits[i].Until.Before(snap.Waits[j].Until) })
+
+	return snap
+}
+
+// watchStateSignal dumps the state as JSON to stderr on every SIGUSR2
+func watchStateSignal() {
+	ch := make(chan os.Signal, 1)
+	notifyStateSignal(ch)
+	go func() {
+		for range ch {
+			content, err := json.MarshalIndent(runState.snapshot(), "", "  ")
+			if err != nil {
+				log.Errorf("Could not dump the state: %s", err)
+				continue
+			}
+			os.Stderr.Write(append(content, '\n'))
+		}
+	}()
+}
+
+// stateHandler serves the state on /debug/state
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, runState.snapshot())
+}
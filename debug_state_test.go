@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateTracker(t *testing.T) {
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	defer func(s *stateTracker) { runState = s }(runState)
+	runState = newStateTracker()
+
+	runState.queued([]string{"library/redis", "library/nginx", "library/postgres"})
+	runState.dequeue("library/redis")
+	runState.working(1, "library/redis", dockerHub)
+	done := runState.tagStarted(dockerHub, "library/redis", "7.2")
+	runState.tagStarted(dockerHub, "library/redis", "7.0")
+	runState.transferring(dockerHub, "library/redis", "7.0", transferProgress{Action: "pull", LayersDone: 2, Layers: 5, Bytes: 1024, Total: 4096})
+	runState.transferring(dockerHub, "library/redis", "6.2", transferProgress{Action: "pull"})
+
+	// the wait is in the snapshot while sleeping
+	var during stateSnapshot
+	sleep = func(time.Duration) { during = runState.snapshot() }
+	runState.retrySleep(dockerHub, "Get https://hub.docker.com failed with 429", time.Minute)
+	done()
+
+	if len(during.Waits) != 1 || during.Waits[0].Host != dockerHub || during.Waits[0].Until.Before(time.Now().Add(50*time.Second)) {
+		t.Errorf("Expected the rate limit wait, got %+v", during.Waits)
+	}
+
+	server := httptest.NewServer(newAdminMux())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/debug/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var snap stateSnapshot
+	if err := json.NewDecoder(res.Body).Decode(&snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(snap.Queue, []string{"library/nginx", "library/postgres"}) {
+		t.Errorf("Unexpected queue %v", snap.Queue)
+	}
+	if len(snap.Workers) != 1 || snap.Workers[0].ID != 1 || snap.Workers[0].Repository != "library/redis" || len(snap.Workers[0].Tags) != 1 || snap.Workers[0].Tags[0].Tag != "7.0" {
+		t.Errorf("Unexpected workers %+v", snap.Workers)
+	}
+	if p := snap.Workers[0].Tags[0].Progress; p == nil || p.LayersDone != 2 || p.Bytes != 1024 {
+		t.Errorf("Expected the progress of the pull of 7.0, got %+v", p)
+	}
+	if snap.Retries[dockerHub] != 1 || len(snap.Waits) != 0 {
+		t.Errorf("Expected a retry and no wait in progress, got %v %v", snap.Retries, snap.Waits)
+	}
+
+	runState.working(1, "", "")
+	if snap := runState.snapshot(); len(snap.Workers) != 0 {
+		t.Errorf("Expected the worker to be idle, got %+v", snap.Workers)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyStateSignal relays SIGUSR2 to the channel
+func notifyStateSignal(ch chan os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR2)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifyStateSignal does nothing, Windows has no SIGUSR2: the state is served on the
+// /debug/state of the admin server
+func notifyStateSignal(ch chan os.Signal) {}
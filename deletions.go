@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// trackDeletions compares the upstream tags with the listing of the previous run recorded in
+// the state, and reports the tags that disappeared upstream (a retraction, or a compromised
+// upstream covering its tracks). With `quarantine_suffix` their mirrored copies are also
+// tagged <tag><suffix>, so they stay findable once pruned. The listing is then saved for the
+// next run, a deleted tag is only reported once
+func (m *mirror) trackDeletions(targets []*target, now time.Time) {
+	source := m.sourceRepository()
+	previous, err := state.loadUpstream(source)
+	if err != nil {
+		m.log.Warnf("Failed to load the upstream tags of the previous run: %s", err)
+		return
+	}
+
+	if previous != nil {
+		for _, tag := range sortedTags(previous.Tags) {
+			if _, ok := m.upstream[tag]; ok {
+				continue
+			}
+
+			d := &deletedTag{Tag: tag, Digest: previous.Tags[tag], LastSeen: previous.ListedAt}
+			m.log.Warnf("Tag %s was deleted upstream, it was last listed at %s", tag, previous.ListedAt.Format(time.RFC3339))
+			if m.repo.Quarantine != "" {
+				d.Quarantined, err = m.quarantine(targets, tag)
+				if err != nil {
+					m.log.Errorf("Failed to quarantine the mirrored copies of %s: %s", tag, err)
+					d.Error = err.Error()
+				}
+			}
+			m.report.deleted(d)
+		}
+	}
+
+	if err := state.saveUpstream(source, &upstreamListing{Tags: m.upstream, ListedAt: now}); err != nil {
+		m.log.Warnf("Failed to save the upstream tags: %s", err)
+	}
+}
+
+// quarantine tags the mirrored copies of the tag deleted upstream with the `quarantine_suffix`
+// in every target that has it, and returns the quarantined images
+func (m *mirror) quarantine(targets []*target, tag string) ([]string, error) {
+	mapped := m.mapTag(tag)
+	quarantined := mapped + m.repo.Quarantine
+
+	var res []string
+	var failed error
+	for _, t := range targets {
+		// archive snapshots are immutable copies already
+		if t.config.Archive || !t.wantsTag(mapped) {
+			continue
+		}
+
+		client, repository, err := targetRegistryClient(t, m.targetRepositoryName(t))
+		if err != nil {
+			failed = err
+			continue
+		}
+
+		exists, err := client.manifestExists(repository, mapped)
+		if err != nil {
+			failed = fmt.Errorf("Could not look up %s in %s: %s", mapped, t.registry, err)
+			continue
+		}
+		if !exists {
+			continue
+		}
+
+		if _, _, err := copyImage(client, repository, mapped, client, repository, quarantined); err != nil {
+			failed = fmt.Errorf("Could not tag %s as %s in %s: %s", mapped, quarantined, t.registry, err)
+			continue
+		}
+
+		res = append(res, fmt.Sprintf("%s/%s:%s", t.registry, m.targetRepositoryName(t), quarantined))
+	}
+
+	return res, failed
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestTrackDeletions(t *testing.T) {
+	defer func(s stateBackend) { state = s }(state)
+	state = &dynamoDBState{client: &fakeDynamoDB{items: make(map[string]map[string]dynamodbtypes.AttributeValue)}, table: "docker-mirror"}
+
+	lastRun := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := state.saveUpstream("redis", &upstreamListing{Tags: map[string]string{"7": "sha256:seven", "6": "sha256:six", "5": ""}, ListedAt: lastRun}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	dest := newFakeRegistry()
+	six := dest.image("hub/redis", "6", []byte("six"))
+	server := httptest.NewServer(dest)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	targets := []*target{{registry: host, config: TargetConfig{Prefix: "hub/", Username: "mirror", Insecure: true}}}
+
+	m := mirror{
+		log:      log.WithField("test", "deletions"),
+		repo:     Repository{Name: "redis", TrackDeletions: true, Quarantine: "-deleted-upstream"},
+		report:   newRunReport().repository("redis", ""),
+		upstream: map[string]string{"7": "sha256:seven", "8": ""},
+	}
+	now := lastRun.Add(24 * time.Hour)
+	m.trackDeletions(targets, now)
+
+	want := []*deletedTag{
+		{Tag: "5", LastSeen: lastRun},
+		{Tag: "6", Digest: "sha256:six", LastSeen: lastRun, Quarantined: []string{host + "/hub/redis:6-deleted-upstream"}},
+	}
+	if !reflect.DeepEqual(m.report.Deleted, want) {
+		t.Errorf("Expected %+v, got %+v", want, m.report.Deleted)
+	}
+	if m.report.run.Deletions != 2 {
+		t.Errorf("Expected 2 deletions, got %d", m.report.run.Deletions)
+	}
+	if string(dest.manifests["hub/redis:6-deleted-upstream"]) != string(six) {
+		t.Error("Expected the mirrored copy of 6 to be quarantined")
+	}
+	if !m.pruneProtected("6-deleted-upstream") {
+		t.Error("Expected the quarantined copy not to be pruned")
+	}
+
+	// the deletions are only reported once
+	m.report = newRunReport().repository("redis", "")
+	m.trackDeletions(targets, now.Add(24*time.Hour))
+	if len(m.report.Deleted) != 0 {
+		t.Errorf("Expected no new deletion, got %+v", m.report.Deleted)
+	}
+
+	if listing, _ := state.loadUpstream("redis"); !reflect.DeepEqual(listing.Tags, m.upstream) {
+		t.Errorf("Expected the upstream tags to be saved, got %+v", listing)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/github"
+)
+
+// Docker Hub repository API, replaced in tests
+var dockerHubRepositoryURL = "https://hub.docker.com/v2/repositories/%s/"
+
+// DeprecationConfig configures the check of the upstream repositories being deprecated
+// or archived, and disabling them
+type DeprecationConfig struct {
+	Check        bool `yaml:"check,omitempty"`
+	DisableAfter int  `yaml:"disable_after,omitempty"`
+}
+
+// deprecations counts the consecutive runs an upstream repository was seen deprecated
+var deprecations = &deprecationTracker{seen: make(map[string]*deprecation)}
+
+// deprecation is the last signal of a deprecated repository, and the number of
+// consecutive runs of the process it was seen in
+type deprecation struct {
+	signal string
+	runs   int
+}
+
+type deprecationTracker struct {
+	mu   sync.Mutex
+	seen map[string]*deprecation
+}
+
+// observe records the deprecation signal of the repository in this run, an empty signal
+// resets the count
+func (d *deprecationTracker) observe(repo, signal string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if signal == "" {
+		delete(d.seen, repo)
+		return 0
+	}
+
+	dep, ok := d.seen[repo]
+	if !ok {
+		dep = &deprecation{}
+		d.seen[repo] = dep
+	}
+	dep.signal = signal
+	dep.runs++
+
+	return dep.runs
+}
+
+// disabled returns the signal of the repository when it was deprecated for `disable_after`
+// runs, empty when the repository is still mirrored
+func (d *deprecationTracker) disabled(repo string, cfg DeprecationConfig) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if dep, ok := d.seen[repo]; ok && cfg.DisableAfter > 0 && dep.runs >= cfg.DisableAfter {
+		return dep.signal
+	}
+
+	return ""
+}
+
+// deprecationSignal returns why the upstream repository is deprecated, empty when it
+// isn't: a Docker Hub repository status other than active, or an archived GitHub
+// repository of the github remote_tags_source
+func (m *mirror) deprecationSignal() (string, error) {
+	if m.repo.RemoteTagSource == remoteTagSourceGitHub {
+		client, err := m.gitHubClient()
+		if err != nil {
+			return "", err
+		}
+
+		owner, name := m.repo.RemoteTagConfig["owner"], m.repo.RemoteTagConfig["repo"]
+
+		var repo *github.Repository
+		err = m.gitHubRetry(func() (res *github.Response, err error) {
+			repo, res, err = client.Repositories.Get(context.Background(), owner, name)
+			return res, err
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if repo.GetArchived() {
+			return fmt.Sprintf("GitHub repository %s/%s is archived", owner, name), nil
+		}
+		return "", nil
+	}
+
+	if m.repo.Host != dockerHub || m.repo.RemoteTagSource != "" {
+		return "", nil
+	}
+
+	name := m.upstreamName()
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	quotas.record(m.repo.Host, quotaTagAPI)
+	res, err := httpClient.Get(fmt.Sprintf(dockerHubRepositoryURL, name))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Get Docker Hub repository %s failed with %d", name, res.StatusCode)
+	}
+
+	var repo struct {
+		StatusDescription string `json:"status_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&repo); err != nil {
+		return "", err
+	}
+
+	if repo.StatusDescription != "" && repo.StatusDescription != "active" {
+		return fmt.Sprintf("Docker Hub repository %s is %s", name, repo.StatusDescription), nil
+	}
+
+	return "", nil
+}
+
+// deprecationKey identifies the repository entry in the deprecation tracker
+func deprecationKey(repo Repository) string {
+	return repo.tenant + "/" + repo.Host + "/" + repo.Name
+}
+
+// checkDeprecation records the deprecation signal of the repository in the report, and
+// counts the runs it was seen in
+func (m *mirror) checkDeprecation(key string) {
+	signal, err := m.deprecationSignal()
+	if err != nil {
+		m.log.Warnf("Could not check if the upstream repository is deprecated: %s", err)
+		return
+	}
+
+	runs := deprecations.observe(key, signal)
+	if signal == "" {
+		return
+	}
+
+	m.report.deprecated(signal)
+	if after := config.Deprecation.DisableAfter; after > 0 {
+		m.log.Warnf("%s (%d of %d runs before the repository is disabled)", signal, runs, after)
+	} else {
+		m.log.Warn(signal)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDeprecationSignal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/repositories/library/centos/":
+			w.Write([]byte(`{"name":"centos","status_description":"inactive"}`))
+		case "/v2/repositories/library/redis/":
+			w.Write([]byte(`{"name":"redis","status_description":"active"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer func(u string) { dockerHubRepositoryURL = u }(dockerHubRepositoryURL)
+	dockerHubRepositoryURL = server.URL + "/v2/repositories/%s/"
+
+	m := mirror{log: log.WithField("test", "deprecation"), repo: Repository{Name: "centos", Host: dockerHub}}
+	if signal, err := m.deprecationSignal(); err != nil || signal != "Docker Hub repository library/centos is inactive" {
+		t.Errorf("Expected centos to be deprecated, got %q (%v)", signal, err)
+	}
+
+	m.repo.Name = "redis"
+	if signal, err := m.deprecationSignal(); err != nil || signal != "" {
+		t.Errorf("Expected redis to be active, got %q (%v)", signal, err)
+	}
+}
+
+func TestDeprecationTracker(t *testing.T) {
+	d := &deprecationTracker{seen: make(map[string]*deprecation)}
+	cfg := DeprecationConfig{Check: true, DisableAfter: 2}
+
+	d.observe("centos", "Docker Hub repository library/centos is inactive")
+	if d.disabled("centos", cfg) != "" {
+		t.Errorf("Expected centos to be mirrored after a single run")
+	}
+
+	// the count resets when the signal disappears
+	d.observe("centos", "")
+	d.observe("centos", "Docker Hub repository library/centos is inactive")
+	if d.disabled("centos", cfg) != "" {
+		t.Errorf("Expected the count to reset")
+	}
+
+	d.observe("centos", "Docker Hub repository library/centos is inactive")
+	if d.disabled("centos", cfg) == "" {
+		t.Errorf("Expected centos to be disabled after 2 runs")
+	}
+
+	if d.disabled("centos", DeprecationConfig{Check: true}) != "" {
+		t.Errorf("Expected a repository to never be disabled without disable_after")
+	}
+}
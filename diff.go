@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// diffOutput is the JSON document of `diff --output json`
+type diffOutput struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Changes     []*diffChange `json:"changes"`
+	Failed      bool          `json:"failed,omitempty"`
+}
+
+// diffChange is a tag the next run would add to, or update in, a target
+type diffChange struct {
+	Action      string     `json:"action"` // add or update
+	Repository  string     `json:"repository"`
+	Host        string     `json:"host"`
+	UpstreamTag string     `json:"upstream_tag"`
+	Source      string     `json:"source"`
+	Target      string     `json:"target"`
+	Tag         string     `json:"tag"`
+	Reason      string     `json:"reason"`
+	OldDigest   string     `json:"old_digest,omitempty"`   // digest of the tag in the target
+	NewDigest   string     `json:"new_digest,omitempty"`   // upstream digest, if the tag API exposes it
+	LastUpdated *time.Time `json:"last_updated,omitempty"` // upstream update of the tag
+}
+
+// diffCommand prints the tags the next run would add or update in the targets, with their
+// old and new digests. `--output json` is a compact document for bots opening pull
+// requests, i.e. to bump the image tags of deployment manifests
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	configFile := configFlag(flags)
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "only diff the repositories starting with this prefix")
+	output := flags.String("output", "text", "output format, text or json")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror diff [--output json] [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *output != "text" && *output != "json" {
+		log.Fatalf("Unknown output %q, we support text and json", *output)
+	}
+
+	setupConfig(*configFile)
+
+	mirrors, failed := planRepositories(*prefix)
+
+	now := time.Now()
+	changes := []*diffChange{}
+	for _, m := range mirrors {
+		found, ok := m.diff(now)
+		if !ok {
+			failed = true
+		}
+		changes = append(changes, found...)
+	}
+
+	if *output == "json" {
+		out, err := json.Marshal(diffOutput{GeneratedAt: now.UTC(), Changes: changes, Failed: failed})
+		if err != nil {
+			log.Fatalf("Could not render diff: %s", err)
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, c := range changes {
+			if c.OldDigest != "" {
+				fmt.Printf("~ %s => %s (%s, %s -> %s)\n", c.Source, c.Target, c.Reason, c.OldDigest, c.NewDigest)
+			} else {
+				fmt.Printf("+ %s => %s (%s)\n", c.Source, c.Target, c.Reason)
+			}
+		}
+		fmt.Printf("\nDiff: %d tags to add or update.\n", len(changes))
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// diff returns the tags the next run would add or update in each target, ok is false if
+// the tags of a target could not be listed
+func (m *mirror) diff(now time.Time) (changes []*diffChange, ok bool) {
+	ok = true
+	for _, t := range m.targets {
+		if !t.wantsRepository(m.repo.Name) {
+			continue
+		}
+
+		pt, existing := m.planTarget(t, now)
+		if pt.Tags == nil {
+			log.Errorf("Failed to list tags of %s/%s", pt.Registry, pt.Repository)
+			ok = false
+			continue
+		}
+
+		// the plan has a tag per remote tag, in the same order
+		for i, tag := range pt.Tags {
+			if tag.Action != planAdd && tag.Action != planUpdate {
+				continue
+			}
+
+			remoteTag := m.remoteTags[i]
+			c := &diffChange{
+				Action:      tag.Action,
+				Repository:  m.repo.Name,
+				Host:        m.repo.Host,
+				UpstreamTag: remoteTag.Name,
+				Source:      m.sourceImage(remoteTag.Name),
+				Target:      fmt.Sprintf("%s/%s:%s", pt.Registry, pt.Repository, tag.Tag),
+				Tag:         tag.Tag,
+				Reason:      tag.Reason,
+				OldDigest:   existing[tag.Tag],
+				NewDigest:   remoteTag.digest(),
+			}
+			if !remoteTag.LastUpdated.IsZero() {
+				lastUpdated := remoteTag.LastUpdated
+				c.LastUpdated = &lastUpdated
+			}
+
+			changes = append(changes, c)
+		}
+	}
+
+	return changes, ok
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDiff(t *testing.T) {
+	defer func(s stateBackend) { state = s }(state)
+	state = nil
+
+	now := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2026, 1, 14, 0, 0, 0, 0, time.UTC)
+	ecr := &target{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", primary: true, config: TargetConfig{Prefix: "hub/"}, ecrManager: &fakeManager{tags: map[string]map[string]string{
+		"hub/redis": {"6": "sha256:six", "7": "sha256:old"},
+	}}}
+
+	m := mirror{
+		log:        log.WithField("test", "diff"),
+		targets:    []*target{ecr},
+		repo:       Repository{Name: "redis", Host: dockerHub},
+		remoteTags: []RepositoryTag{{Name: "6", Digest: "sha256:six"}, {Name: "7", Digest: "sha256:seven", LastUpdated: updated}, {Name: "8"}},
+	}
+
+	changes, ok := m.diff(now)
+	if !ok || len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d (%t)", len(changes), ok)
+	}
+
+	update := changes[0]
+	if update.Action != planUpdate || update.Target != ecr.registry+"/hub/redis:7" || update.Source != "redis:7" {
+		t.Errorf("Expected an update of redis:7, got %+v", update)
+	}
+	if update.OldDigest != "sha256:old" || update.NewDigest != "sha256:seven" || update.LastUpdated == nil || !update.LastUpdated.Equal(updated) {
+		t.Errorf("Expected the old and new digests of redis:7, got %+v", update)
+	}
+
+	if add := changes[1]; add.Action != planAdd || add.Tag != "8" || add.OldDigest != "" || add.LastUpdated != nil {
+		t.Errorf("Expected redis:8 to be added, got %+v", add)
+	}
+}
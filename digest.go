@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// matches a pinned image digest
+	pinnedDigestRE = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	// matches the tag of a digest, sha256-<hex>
+	digestTagRE = regexp.MustCompile(`^sha256-[a-f0-9]{64}$`)
+)
+
+// PinnedDigest is an image pinned by digest, pushed to the targets as `tag`
+type PinnedDigest struct {
+	Digest string `yaml:"digest,omitempty"`
+	Tag    string `yaml:"tag,omitempty"`
+}
+
+// targetTag returns the tag the digest is pushed as, sha256-<hex> unless the tag is set
+func (d PinnedDigest) targetTag() string {
+	if d.Tag != "" {
+		return d.Tag
+	}
+
+	return strings.Replace(d.Digest, ":", "-", 1)
+}
+
+// isDigest returns true when the reference is a digest rather than a tag
+func isDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:")
+}
+
+// pinnedDigests returns the repository name without its `@sha256:...` digest, and the
+// pinned digests of the repository, from the name and the `digests` list
+func (r Repository) pinnedDigests() (string, []PinnedDigest) {
+	chunk := strings.SplitN(r.Name, "@", 2)
+	if len(chunk) == 1 {
+		return r.Name, r.Digests
+	}
+
+	return chunk[0], append([]PinnedDigest{{Digest: chunk[1], Tag: r.DigestTag}}, r.Digests...)
+}
+
+// sourceImage returns the reference of the tag, or digest, in the source repository
+func (m *mirror) sourceImage(tag string) string {
+	if isDigest(tag) {
+		return fmt.Sprintf("%s@%s", m.sourceRepository(), tag)
+	}
+
+	return fmt.Sprintf("%s:%s", m.sourceRepository(), tag)
+}
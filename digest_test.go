@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestSetupPinnedDigests(t *testing.T) {
+	pinned := "sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"
+	other := "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+
+	m := mirror{}
+	if err := m.setup(Repository{Name: "library/nginx@" + pinned, Host: quay, DigestTag: "1.25-pinned", Digests: []PinnedDigest{{Digest: other}}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if m.repo.Name != "library/nginx" || len(m.remoteTags) != 2 || m.remoteTags[0].digest() != pinned || m.remoteTags[1].Name != other {
+		t.Errorf("Expected the pinned digests to be mirrored as is, got %s %+v", m.repo.Name, m.remoteTags)
+	}
+
+	if got, want := m.sourceImage(pinned), "quay.io/library/nginx@"+pinned; got != want {
+		t.Errorf("Expected source image %s, got %s", want, got)
+	}
+
+	for digest, want := range map[string]string{pinned: "1.25-pinned", other: "sha256-a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"} {
+		if got := m.mapTag(digest); got != want {
+			t.Errorf("Expected %s to be pushed as %s, got %s", digest, want, got)
+		}
+	}
+}
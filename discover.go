@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// the pod spec keys listing containers, in any workload manifest
+var containerKeys = map[string]bool{"containers": true, "initContainers": true, "ephemeralContainers": true}
+
+// listFlag is a repeatable string flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// discoverCommand renders Helm charts, kustomizations and plain manifests, and prints a
+// docker-mirror config of the images they reference
+func discoverCommand(args []string) {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	var charts, values, kustomizations listFlag
+	flags.Var(&charts, "helm", "Helm chart to render with `helm template` (repeatable)")
+	flags.Var(&values, "values", "values file of the Helm charts (repeatable)")
+	flags.Var(&kustomizations, "kustomize", "kustomization directory to render with `kustomize build` (repeatable)")
+	registry := flags.String("target", "ACCOUNT_ID.dkr.ecr.REGION.amazonaws.com", "target registry of the generated config")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror discover [--helm chart] [--values file] [--kustomize dir] [manifest file or directory...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if len(charts) == 0 && len(kustomizations) == 0 && flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var manifests [][]byte
+	for _, chart := range charts {
+		cmd := []string{"helm", "template", "docker-mirror-discover", chart}
+		for _, v := range values {
+			cmd = append(cmd, "--values", v)
+		}
+
+		out, err := commandOutput(cmd...)
+		if err != nil {
+			log.Fatalf("Could not render Helm chart %s: %s", chart, err)
+		}
+		manifests = append(manifests, out)
+	}
+
+	for _, dir := range kustomizations {
+		out, err := commandOutput("kustomize", "build", dir)
+		if err != nil {
+			log.Fatalf("Could not render kustomization %s: %s", dir, err)
+		}
+		manifests = append(manifests, out)
+	}
+
+	for _, path := range flags.Args() {
+		files, err := manifestFiles(path)
+		if err != nil {
+			log.Fatalf("Could not read manifests %s: %s", path, err)
+		}
+
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				log.Fatalf("Could not read manifest %s: %s", file, err)
+			}
+			manifests = append(manifests, content)
+		}
+	}
+
+	var images []string
+	for _, content := range manifests {
+		found, err := discoverImages(content)
+		if err != nil {
+			log.Fatalf("Could not parse manifests: %s", err)
+		}
+		images = append(images, found...)
+	}
+
+	discovered := Config{Target: TargetConfig{Registry: *registry}, Repositories: discoveredRepositories(images)}
+	log.Infof("Discovered %d images in %d repositories", len(images), len(discovered.Repositories))
+
+	out, err := yaml.Marshal(discovered)
+	if err != nil {
+		log.Fatalf("Could not render config: %s", err)
+	}
+
+	fmt.Print("---\n" + string(out))
+}
+
+// commandOutput runs the command (i.e. helm template) and returns its output
+func commandOutput(command ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// manifestFiles returns the file, or the YAML files of the directory and its subdirectories
+func manifestFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ext := filepath.Ext(file); !info.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, file)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// discoverImages returns the image of every container of the multi-document manifests
+func discoverImages(content []byte) ([]string, error) {
+	var images []string
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		images = append(images, containerImages(doc, false)...)
+	}
+
+	return images, nil
+}
+
+// containerImages walks a manifest, and returns the `image` of the entries of the
+// container lists (containers, initContainers and ephemeralContainers)
+func containerImages(node interface{}, containers bool) []string {
+	var images []string
+
+	switch node := node.(type) {
+	case map[interface{}]interface{}:
+		if image, ok := node["image"].(string); ok && containers && image != "" {
+			images = append(images, image)
+		}
+
+		for key, value := range node {
+			k, _ := key.(string)
+			images = append(images, containerImages(value, containerKeys[k])...)
+		}
+	case []interface{}:
+		for _, item := range node {
+			images = append(images, containerImages(item, containers)...)
+		}
+	}
+
+	return images
+}
+
+// parseSourceImage splits an image reference into the host docker-mirror mirrors it from,
+// the repository name, and the tag (latest by default) or digest. ok is false for the
+// hosts docker-mirror doesn't support
+func parseSourceImage(image string) (host, name, tag, digest string, ok bool) {
+	ref := image
+	if i := strings.Index(image, "@"); i > 0 {
+		ref, digest = image[:i], image[i+1:]
+	}
+
+	registry, name, tag := splitImageReference(ref)
+	if host, ok = importHost(registry); !ok {
+		return "", "", "", "", false
+	}
+
+	if host == dockerHub {
+		name = strings.TrimPrefix(name, "library/")
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+
+	return host, name, tag, digest, true
+}
+
+// discoveredRepositories groups the images by repository, with their tags as the static
+// `tags` and their digests as `digests`. Images of hosts docker-mirror can't mirror
+// from are logged as a warning
+func discoveredRepositories(images []string) []Repository {
+	repos := make(map[string]*Repository)
+	tags := make(map[string]map[string]bool)
+	for _, image := range images {
+		host, name, tag, digest, ok := parseSourceImage(image)
+		if !ok {
+			log.Warnf("Skipping image %s, we support %s, %s, %s and %s", image, dockerHub, quay, gcr, k8s)
+			continue
+		}
+
+		key := host + "/" + name
+		repo, ok := repos[key]
+		if !ok {
+			repo = &Repository{Name: name}
+			if host != dockerHub {
+				repo.Host = host
+			}
+			repos[key] = repo
+			tags[key] = make(map[string]bool)
+		}
+
+		// a digest is pinned as is, the tag it was referenced with is only informative
+		if digest != "" {
+			if !tags[key][digest] {
+				repo.Digests = append(repo.Digests, PinnedDigest{Digest: digest})
+			}
+			tags[key][digest] = true
+			continue
+		}
+
+		if !tags[key][tag] {
+			repo.Tags = append(repo.Tags, tag)
+		}
+		tags[key][tag] = true
+	}
+
+	keys := make([]string, 0, len(repos))
+	for key := range repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]Repository, 0, len(keys))
+	for _, key := range keys {
+		repo := repos[key]
+		sort.Strings(repo.Tags)
+		res = append(res, *repo)
+	}
+
+	return res
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiscoverImages(t *testing.T) {
+	content := []byte(`
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+    image: not/a-container:1
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: docker.io/library/postgres:15.4
+      containers:
+        - name: app
+          image: quay.io/coreos/etcd:v3.5.9
+        - name: proxy
+          image: nginx
+---
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: postgres:15.4
+            - name: exporter
+              image: quay.io/coreos/etcd@sha256:0000000000000000000000000000000000000000000000000000000000000000
+            - name: internal
+              image: registry.example.com/team/tool:1.0
+`)
+
+	images, err := discoverImages(content)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(images) != 6 {
+		t.Fatalf("Expected the 6 container images, got %v", images)
+	}
+
+	want := []Repository{
+		{Name: "nginx", Tags: []string{"latest"}},
+		{Name: "postgres", Tags: []string{"15.4"}},
+		{Name: "coreos/etcd", Host: quay, Tags: []string{"v3.5.9"}, Digests: []PinnedDigest{{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}}},
+	}
+
+	if got := discoveredRepositories(images); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected\n%+v\ngot\n%+v", want, got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// directory of the docker images when the daemon doesn't report its root dir
+const defaultDockerRootDir = "/var/lib/docker"
+
+// DiskConfig keeps the disk of the docker host from filling up during the runs
+type DiskConfig struct {
+	Path         string   `yaml:"path,omitempty"`           // directory on the disk of the docker images (default: the docker root dir)
+	MinFree      ByteSize `yaml:"min_free,omitempty"`       // free space needed to pull a tag, i.e. 5GB
+	CleanupBelow ByteSize `yaml:"cleanup_below,omitempty"`  // free space below which the local images created by docker-mirror are removed
+	PruneOnStart bool     `yaml:"prune_on_start,omitempty"` // remove the local images created by docker-mirror at startup
+}
+
+// freeSpace returns the space available to the docker daemon on the disk of the directory,
+// replaced in tests
+var freeSpace = diskFreeSpace
+
+// diskGuard checks the free space of the docker host before the pulls, and removes the local
+// images created by docker-mirror when it runs low
+type diskGuard struct {
+	client  DockerClient
+	targets []*target
+	path    string
+
+	mu       sync.Mutex
+	inUse    map[string]int // local images of the tags being mirrored, kept by the cleanup
+	cleaning sync.Mutex     // a single cleanup at a time, the other pulls wait for it
+}
+
+var disk = &diskGuard{inUse: map[string]int{}}
+
+// setupDisk resolves the directory of the docker images, the disk is only checked with
+// `min_free` or `cleanup_below`
+func setupDisk(client DockerClient, targets []*target) {
+	c := config.Disk
+	disk.client, disk.targets, disk.path = client, targets, ""
+	if client == nil || (c.MinFree == 0 && c.CleanupBelow == 0) {
+		return
+	}
+
+	disk.path = c.Path
+	if disk.path == "" {
+		disk.path = defaultDockerRootDir
+		if info, err := client.Info(); err == nil && info.DockerRootDir != "" {
+			disk.path = info.DockerRootDir
+		}
+	}
+}
+
+// use keeps the images of a tag from the cleanup until the returned func is called
+func (g *diskGuard) use(images []string) func() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, image := range images {
+		g.inUse[image]++
+	}
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		for _, image := range images {
+			if g.inUse[image]--; g.inUse[image] <= 0 {
+				delete(g.inUse, image)
+			}
+		}
+	}
+}
+
+func (g *diskGuard) used(image string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.inUse[image] > 0
+}
+
+// check makes sure there is `min_free` space before a pull. Below `cleanup_below` (or
+// `min_free`) the queued cleanups are flushed, and the local images created by docker-mirror
+// that no tag being mirrored uses are removed, before checking again
+func (g *diskGuard) check(logger *log.Entry, c *cleaner) error {
+	cfg := config.Disk
+	if g.path == "" {
+		return nil
+	}
+
+	g.cleaning.Lock()
+	defer g.cleaning.Unlock()
+
+	free, err := freeSpace(g.path)
+	if err != nil {
+		logger.Warnf("Failed to check the free disk space of %s: %s", g.path, err)
+		return nil
+	}
+	if free >= int64(cfg.CleanupBelow) && free >= int64(cfg.MinFree) {
+		return nil
+	}
+
+	logger.Warnf("Only %s free in %s, cleaning up the local images", humanBytes(free), g.path)
+	if c != nil {
+		c.flush()
+	}
+	logger.Infof("Removed %d local images", g.prune())
+
+	if free, err = freeSpace(g.path); err != nil {
+		logger.Warnf("Failed to check the free disk space of %s: %s", g.path, err)
+		return nil
+	}
+	if free < int64(cfg.MinFree) {
+		return fmt.Errorf("Not enough disk space to pull the image, %s free in %s and `min_free` is %s", humanBytes(free), g.path, cfg.MinFree)
+	}
+
+	return nil
+}
+
+// prune removes the local images created by docker-mirror that no tag being mirrored uses,
+// and returns how many were removed
+func (g *diskGuard) prune() int {
+	images, err := g.client.ListImages(docker.ListImagesOptions{Digests: true})
+	if err != nil {
+		log.Warnf("Failed to list the local images: %s", err)
+		return 0
+	}
+
+	repositories := g.createdRepositories()
+	removed := 0
+	for _, image := range images {
+		for _, ref := range append(image.RepoTags, image.RepoDigests...) {
+			created := repositories[imageRepository(ref)] || strings.HasPrefix(ref, labelledRepository+"/")
+			if !created || g.used(ref) {
+				continue
+			}
+
+			// the last reference removes the image, the other references of it are gone then
+			if err := g.client.RemoveImage(ref); err != nil {
+				if err != docker.ErrNoSuchImage {
+					log.Warnf("Failed to remove the local image %s: %s", ref, err)
+				}
+				continue
+			}
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// createdRepositories returns the local repositories docker-mirror pulls and tags images in,
+// the source repositories and the target repositories of the config. Images of other
+// repositories on the docker host, even of the target registries, are never removed
+func (g *diskGuard) createdRepositories() map[string]bool {
+	res := map[string]bool{}
+	for _, repo := range config.allRepositories() {
+		if repo.Host == "" {
+			repo.Host = dockerHub
+		}
+
+		m, err := targetsMirror(repo)
+		if err != nil {
+			continue
+		}
+
+		// docker lists the official images without their library/ namespace
+		res[m.sourceRepository()] = true
+		res[strings.TrimPrefix(m.sourceRepository(), "library/")] = true
+		for _, t := range tenantTargets(g.targets, repo.tenant) {
+			res[fmt.Sprintf("%s/%s", t.registry, m.targetRepositoryName(t))] = true
+		}
+	}
+
+	return res
+}
+
+// imageRepository returns the repository of an image reference, without its tag or digest
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+
+	return ref
+}
+
+// localImages lists every local image of the tag, the pulled source image, the labelled
+// image and the (re)tagged image of each target, whatever the `cleanup_scope`
+func (m *mirror) localImages(tag string, targets []*target, day time.Time) []string {
+	return m.scopedImages(tag, targets, day, cleanupScopeBoth)
+}
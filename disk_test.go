@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]ByteSize{
+		"512":    512,
+		"512B":   512,
+		"10KB":   10 << 10,
+		"5GB":    5 << 30,
+		"5GiB":   5 << 30,
+		"1TB":    1 << 40,
+		"1536MB": 1536 << 20,
+	}
+
+	for s, want := range tests {
+		got, err := ParseByteSize(s)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d (%v)", s, want, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "5 G", "1.5GB", "-1GB", "5PB"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	if got := ByteSize(1536 << 20).String(); got != "1536MB" {
+		t.Errorf("Expected 1536MB, got %s", got)
+	}
+}
+
+func diskTestClient(images []docker.APIImages) (*diskGuard, *ResponseContainer) {
+	rc := &ResponseContainer{ListImages: images}
+	ecr := &target{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", primary: true, config: TargetConfig{Prefix: "hub/"}}
+	return &diskGuard{client: CreateTestDockerClient(rc), targets: []*target{ecr}, path: "/var/lib/docker", inUse: map[string]int{}}, rc
+}
+
+func TestDiskPrune(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{Repositories: []Repository{{Name: "library/redis"}, {Name: "grafana/grafana", Host: quay}}}
+
+	g, rc := diskTestClient([]docker.APIImages{
+		{RepoTags: []string{"redis:7.2", "123456789012.dkr.ecr.us-east-1.amazonaws.com/hub/library/redis:7.2"}},
+		{RepoTags: []string{"redis:6.2"}},
+		{RepoDigests: []string{"quay.io/grafana/grafana@sha256:abc"}},
+		{RepoTags: []string{"docker-mirror-labelled/redis:7.2"}},
+		{RepoTags: []string{"123456789012.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0", "postgres:16", "<none>:<none>"}},
+	})
+
+	release := g.use([]string{"redis:6.2"})
+	if n := g.prune(); n != 4 {
+		t.Errorf("Expected 4 removed images, got %d", n)
+	}
+
+	sort.Strings(rc.RemovedImages)
+	want := []string{"123456789012.dkr.ecr.us-east-1.amazonaws.com/hub/library/redis:7.2", "docker-mirror-labelled/redis:7.2", "quay.io/grafana/grafana@sha256:abc", "redis:7.2"}
+	if !reflect.DeepEqual(rc.RemovedImages, want) {
+		t.Errorf("Expected %v, got %v", want, rc.RemovedImages)
+	}
+
+	release()
+	rc.RemovedImages = nil
+	if g.prune(); !reflect.DeepEqual(rc.RemovedImages, []string{"redis:7.2", "123456789012.dkr.ecr.us-east-1.amazonaws.com/hub/library/redis:7.2", "redis:6.2", "quay.io/grafana/grafana@sha256:abc", "docker-mirror-labelled/redis:7.2"}) {
+		t.Errorf("Expected the released image to be removed, got %v", rc.RemovedImages)
+	}
+}
+
+func TestDiskCheck(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(f func(string) (int64, error)) { freeSpace = f }(freeSpace)
+	config = Config{Repositories: []Repository{{Name: "redis"}}, Disk: DiskConfig{MinFree: 5 << 30, CleanupBelow: 10 << 30}}
+
+	g, rc := diskTestClient([]docker.APIImages{{RepoTags: []string{"redis:7.2"}}})
+	logger := log.WithField("test", "disk")
+
+	free := []int64{20 << 30}
+	freeSpace = func(path string) (int64, error) {
+		f := free[0]
+		if len(free) > 1 {
+			free = free[1:]
+		}
+		return f, nil
+	}
+
+	if err := g.check(logger, nil); err != nil || len(rc.RemovedImages) != 0 {
+		t.Errorf("Expected no cleanup above cleanup_below, got %v and %v", err, rc.RemovedImages)
+	}
+
+	// below cleanup_below, the images are removed
+	free = []int64{8 << 30, 12 << 30}
+	if err := g.check(logger, nil); err != nil || !reflect.DeepEqual(rc.RemovedImages, []string{"redis:7.2"}) {
+		t.Errorf("Expected a cleanup, got %v and %v", err, rc.RemovedImages)
+	}
+
+	// still below min_free after the cleanup
+	free = []int64{4 << 30, 4 << 30}
+	if err := g.check(logger, nil); err == nil {
+		t.Error("Expected not enough disk space")
+	}
+
+	// the disk isn't checked without min_free or cleanup_below
+	free = []int64{0}
+	g.path = ""
+	if err := g.check(logger, nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// diskFreeSpace returns the space available to unprivileged users on the disk of the directory
+func diskFreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
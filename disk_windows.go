@@ -0,0 +1,18 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// diskFreeSpace returns the space available to the user on the disk of the directory
+func diskFreeSpace(path string) (int64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, nil, nil); err != nil {
+		return 0, err
+	}
+
+	return int64(free), nil
+}
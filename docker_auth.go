@@ -3,16 +3,62 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
 	"github.com/fsouza/go-dockerclient"
-	log "github.com/sirupsen/logrus"
 )
 
+// dockerCredentialStores is the credential helpers part of the docker config
+type dockerCredentialStores struct {
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// getDockerCredentials returns the credentials of the registry in the docker config. As with
+// the docker cli, the `credHelpers` of the registry, or the `credsStore`, is asked first
+// (i.e. `ecr-login` or `pass`), then the static `auths` entries
 func getDockerCredentials(registry string) (*docker.AuthConfiguration, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+
+	var stores dockerCredentialStores
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "config.json")); err == nil {
+		if err := json.Unmarshal(content, &stores); err != nil {
+			return nil, fmt.Errorf("Could not parse the docker config: %s", err)
+		}
+	}
+
+	helper := stores.CredHelpers[registry]
+	if helper == "" {
+		helper = stores.CredsStore
+	}
+
+	if helper != "" {
+		creds, err := client.Get(client.NewShellProgramFunc("docker-credential-"+helper), registry)
+		switch {
+		case err == nil:
+			auth := &docker.AuthConfiguration{Username: creds.Username, Password: creds.Secret, ServerAddress: registry}
+			// the helpers return identity tokens (i.e. of Azure) with this username
+			if creds.Username == "<token>" {
+				auth = &docker.AuthConfiguration{IdentityToken: creds.Secret, ServerAddress: registry}
+			}
+			return auth, nil
+		case !credentials.IsErrCredentialsNotFound(err):
+			return nil, fmt.Errorf("Could not get the credentials of %s from docker-credential-%s: %s", registry, helper, err)
+		}
+	}
+
 	authOptions, err := docker.NewAuthConfigurationsFromDockerCfg()
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("No auth found for %s: %s", registry, err)
 	}
 
 	creds, ok := authOptions.Configs[registry]
@@ -0,0 +1,59 @@
+// +build !darwin
+
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerCredentialHelpers(t *testing.T) {
+	dir := t.TempDir()
+
+	// a credential helper answering for a single registry, as `docker-credential-pass` does
+	helper := `#!/bin/sh
+read server
+case "$server" in
+  registry.example.com) echo '{"ServerURL": "registry.example.com", "Username": "mirror", "Secret": "from-pass"}' ;;
+  *.azurecr.io) echo '{"ServerURL": "acme.azurecr.io", "Username": "<token>", "Secret": "identity-token"}' ;;
+  *) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	static := base64.StdEncoding.EncodeToString([]byte("AWS:static"))
+	config := `{"credsStore": "fake", "credHelpers": {"broken.example.com": "missing"}, "auths": {"harbor.example.com": {"auth": "` + static + `"}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := getDockerCredentials("registry.example.com")
+	if err != nil || creds.Username != "mirror" || creds.Password != "from-pass" {
+		t.Errorf("Expected the credentials of the helper, got %+v (%v)", creds, err)
+	}
+
+	creds, err = getDockerCredentials("acme.azurecr.io")
+	if err != nil || creds.IdentityToken != "identity-token" || creds.Username != "" {
+		t.Errorf("Expected the identity token of the helper, got %+v (%v)", creds, err)
+	}
+
+	// the static entries of the registries the helper doesn't know
+	creds, err = getDockerCredentials("harbor.example.com")
+	if err != nil || creds.Username != "AWS" || creds.Password != "static" {
+		t.Errorf("Expected the static credentials, got %+v (%v)", creds, err)
+	}
+
+	if _, err := getDockerCredentials("other.example.com"); err == nil {
+		t.Error("Expected a registry without credentials to fail")
+	}
+	if _, err := getDockerCredentials("broken.example.com"); err == nil {
+		t.Error("Expected a missing credential helper to fail")
+	}
+}
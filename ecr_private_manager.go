@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
 	"github.com/cenkalti/backoff"
 	log "github.com/sirupsen/logrus"
 )
@@ -34,14 +38,82 @@ func (e *ecrPrivateManager) create(name string) error {
 		RepositoryName: &name,
 	})
 
-	if err != nil {
+	// the repository isn't in the cache when it wasn't pre-loaded
+	var alreadyExists *types.RepositoryAlreadyExistsException
+	if err != nil && !errors.As(err, &alreadyExists) {
 		return err
 	}
 
+	if e.repositories == nil {
+		e.repositories = make(map[string]bool)
+	}
 	e.repositories[name] = true
 	return nil
 }
 
+// putCatalogData is a no-op, catalog data only exist in ECR public
+func (e *ecrPrivateManager) putCatalogData(name string, catalog *CatalogData) error {
+	return nil
+}
+
+// listTags returns all the tags of the repository, with their manifest digest
+func (e *ecrPrivateManager) listTags(name string) (map[string]string, error) {
+	tags := make(map[string]string)
+	var nextToken *string
+	for {
+		resp, err := e.client.ListImages(context.TODO(), &ecr.ListImagesInput{
+			RepositoryName: &name,
+			Filter:         &types.ListImagesFilter{TagStatus: types.TagStatusTagged},
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range resp.ImageIds {
+			if id.ImageTag != nil {
+				tags[*id.ImageTag] = aws.ToString(id.ImageDigest)
+			}
+		}
+
+		if resp.NextToken == nil {
+			return tags, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+// deleteTags removes the tags from the repository, ECR only deletes the image once its last tag is removed
+func (e *ecrPrivateManager) deleteTags(name string, tags []string) error {
+	for start := 0; start < len(tags); start += ecrBatchDeleteSize {
+		end := start + ecrBatchDeleteSize
+		if end > len(tags) {
+			end = len(tags)
+		}
+
+		var ids []types.ImageIdentifier
+		for _, tag := range tags[start:end] {
+			ids = append(ids, types.ImageIdentifier{ImageTag: aws.String(tag)})
+		}
+
+		resp, err := e.client.BatchDeleteImage(context.TODO(), &ecr.BatchDeleteImageInput{
+			RepositoryName: &name,
+			ImageIds:       ids,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, f := range resp.Failures {
+			if f.FailureCode != types.ImageFailureCodeImageNotFound && f.FailureCode != types.ImageFailureCodeImageTagDoesNotMatchDigest {
+				return fmt.Errorf("Could not delete %s:%s: %s", name, aws.ToString(f.ImageId.ImageTag), aws.ToString(f.FailureReason))
+			}
+		}
+	}
+
+	return nil
+}
+
 func (e *ecrPrivateManager) buildCache(nextToken *string) error {
 	if nextToken == nil {
 		log.Info("Loading list of ECR repositories")
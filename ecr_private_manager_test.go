@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+func TestEnsureWithoutCache(t *testing.T) {
+	creates := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creates++
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"RepositoryAlreadyExistsException","message":"The repository already exists"}`))
+	}))
+	defer server.Close()
+
+	// a manager without pre-loaded cache
+	e := &ecrPrivateManager{client: ecr.New(ecr.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: ecr.EndpointResolverFromURL(server.URL),
+		HTTPClient:       server.Client(),
+	})}
+
+	if err := e.ensure("redis"); err != nil {
+		t.Fatalf("Expected an existing repository not to be an error, got %s", err)
+	}
+
+	if err := e.ensure("redis"); err != nil || creates != 1 {
+		t.Errorf("Expected the repository to be cached after the first create, got %d creates", creates)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
 	"github.com/cenkalti/backoff"
 	log "github.com/sirupsen/logrus"
 )
@@ -33,14 +38,123 @@ func (e *ecrPublicManager) create(name string) error {
 	_, err := e.client.CreateRepository(context.TODO(), &ecrpublic.CreateRepositoryInput{
 		RepositoryName: &name,
 	})
-	if err != nil {
+
+	// the repository isn't in the cache when it wasn't pre-loaded
+	var alreadyExists *types.RepositoryAlreadyExistsException
+	if err != nil && !errors.As(err, &alreadyExists) {
 		return err
 	}
 
+	if e.repositories == nil {
+		e.repositories = make(map[string]bool)
+	}
 	e.repositories[name] = true
 	return nil
 }
 
+// putCatalogData sets the ECR Public Gallery metadata of the repository
+func (e *ecrPublicManager) putCatalogData(name string, catalog *CatalogData) error {
+	input, err := catalogDataInput(catalog)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.PutRepositoryCatalogData(context.TODO(), &ecrpublic.PutRepositoryCatalogDataInput{
+		RepositoryName: &name,
+		CatalogData:    input,
+	})
+
+	return err
+}
+
+// catalogDataInput converts the catalog config to the ECR Public API input, empty fields are left unset
+func catalogDataInput(catalog *CatalogData) (*types.RepositoryCatalogDataInput, error) {
+	input := &types.RepositoryCatalogDataInput{
+		Architectures:    catalog.Architectures,
+		OperatingSystems: catalog.OperatingSystems,
+	}
+
+	if catalog.Description != "" {
+		input.Description = &catalog.Description
+	}
+
+	if catalog.AboutText != "" {
+		input.AboutText = &catalog.AboutText
+	}
+
+	if catalog.UsageText != "" {
+		input.UsageText = &catalog.UsageText
+	}
+
+	if catalog.Logo != "" {
+		logo, err := ioutil.ReadFile(catalog.Logo)
+		if err != nil {
+			return nil, err
+		}
+
+		input.LogoImageBlob = logo
+	}
+
+	return input, nil
+}
+
+// listTags returns all the tags of the repository, with their manifest digest
+func (e *ecrPublicManager) listTags(name string) (map[string]string, error) {
+	tags := make(map[string]string)
+	var nextToken *string
+	for {
+		resp, err := e.client.DescribeImageTags(context.TODO(), &ecrpublic.DescribeImageTagsInput{
+			RepositoryName: &name,
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, detail := range resp.ImageTagDetails {
+			if detail.ImageTag != nil && detail.ImageDetail != nil {
+				tags[*detail.ImageTag] = aws.ToString(detail.ImageDetail.ImageDigest)
+			}
+		}
+
+		if resp.NextToken == nil {
+			return tags, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+// deleteTags removes the tags from the repository
+func (e *ecrPublicManager) deleteTags(name string, tags []string) error {
+	for start := 0; start < len(tags); start += ecrBatchDeleteSize {
+		end := start + ecrBatchDeleteSize
+		if end > len(tags) {
+			end = len(tags)
+		}
+
+		var ids []types.ImageIdentifier
+		for _, tag := range tags[start:end] {
+			ids = append(ids, types.ImageIdentifier{ImageTag: aws.String(tag)})
+		}
+
+		resp, err := e.client.BatchDeleteImage(context.TODO(), &ecrpublic.BatchDeleteImageInput{
+			RepositoryName: &name,
+			ImageIds:       ids,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, f := range resp.Failures {
+			if f.FailureCode != types.ImageFailureCodeImageNotFound && f.FailureCode != types.ImageFailureCodeImageTagDoesNotMatchDigest {
+				return fmt.Errorf("Could not delete %s:%s: %s", name, aws.ToString(f.ImageId.ImageTag), aws.ToString(f.FailureReason))
+			}
+		}
+	}
+
+	return nil
+}
+
 func (e *ecrPublicManager) buildCache(nextToken *string) error {
 	if nextToken == nil {
 		log.Info("Loading the list of ECR public repositories")
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogDataInput(t *testing.T) {
+	input, err := catalogDataInput(&CatalogData{
+		Description:   "Mirror of redis",
+		Architectures: []string{"x86-64"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if input.Description == nil || *input.Description != "Mirror of redis" {
+		t.Errorf("Expected description to be set, got %v", input.Description)
+	}
+
+	if len(input.Architectures) != 1 || input.Architectures[0] != "x86-64" {
+		t.Errorf("Expected architectures to be set, got %v", input.Architectures)
+	}
+
+	if input.AboutText != nil || input.UsageText != nil || input.LogoImageBlob != nil || input.OperatingSystems != nil {
+		t.Errorf("Expected empty fields to stay nil, got %+v", input)
+	}
+
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logo := filepath.Join(dir, "logo.png")
+	if err := ioutil.WriteFile(logo, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input, err = catalogDataInput(&CatalogData{Logo: logo})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if string(input.LogoImageBlob) != "png" {
+		t.Errorf("Expected logo to be read, got %q", input.LogoImageBlob)
+	}
+
+	if _, err := catalogDataInput(&CatalogData{Logo: filepath.Join(dir, "missing.png")}); err == nil {
+		t.Errorf("Expected an error for a missing logo")
+	}
+}
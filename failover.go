@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+// standbys returns the standby targets of the primary target that want the tag, in the order
+// of the config
+func (m *mirror) standbys(primary *target, targets []*target, tag string) []*target {
+	var res []*target
+	for _, t := range targets {
+		if t.config.StandbyFor != "" && t.config.StandbyFor == primary.config.Registry && t.wantsTag(m.mapTag(tag)) {
+			res = append(res, t)
+		}
+	}
+
+	return res
+}
+
+// failover mirrors the tag to the standby targets of every target it failed to land on, one
+// after the other until one succeeds. The failed primary is kept in the report, and the
+// standby result records the primary in its `failover_from` for the reconciliation. Returns
+// an error when a failed target has no standby that succeeded, and err when the tag failed
+// before reaching the targets (i.e. the pull failed)
+func (m *mirror) failover(tr *tagReport, targets []*target, mirrorTo func([]*target) error, err error) error {
+	var failed error
+	attempted := false
+	for _, result := range append([]*targetReport(nil), tr.Targets...) {
+		if result.Result != resultFailed || result.target == nil {
+			continue
+		}
+		attempted = true
+
+		standbyErr := errors.New(result.Error)
+		for _, standby := range m.standbys(result.target, targets, tr.Tag) {
+			m.log.Warnf("Failing over from %s to the standby %s: %s", result.Registry, standby.registry, result.Error)
+
+			n := len(tr.Targets)
+			standbyErr = mirrorTo([]*target{standby})
+			for _, r := range tr.Targets[n:] {
+				r.FailoverFrom = result.Registry
+			}
+			if standbyErr == nil {
+				m.report.failover()
+				break
+			}
+		}
+
+		if standbyErr != nil {
+			failed = standbyErr
+		}
+	}
+
+	if !attempted {
+		return err
+	}
+
+	return failed
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestFailover(t *testing.T) {
+	primary := &target{registry: "primary", config: TargetConfig{Registry: "primary"}}
+	broken := &target{registry: "broken", config: TargetConfig{Registry: "broken", StandbyFor: "primary"}}
+	standby := &target{registry: "standby", config: TargetConfig{Registry: "standby", StandbyFor: "primary"}}
+	other := &target{registry: "other", config: TargetConfig{Registry: "other", StandbyFor: "elsewhere"}}
+	targets := []*target{primary, broken, standby, other}
+
+	m := mirror{log: log.WithField("test", "failover"), report: newRunReport().repository("redis", "")}
+	tr := &tagReport{Tag: "7"}
+
+	var pushed []string
+	mirrorTo := func(tagTargets []*target) error {
+		for _, t := range tagTargets {
+			pushed = append(pushed, t.registry)
+			if t == standby {
+				tr.Targets = append(tr.Targets, &targetReport{Registry: t.registry, Result: resultMirrored, target: t})
+				continue
+			}
+			tr.Targets = append(tr.Targets, &targetReport{Registry: t.registry, Result: resultFailed, Error: "unavailable", target: t})
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+
+	err := mirrorTo([]*target{primary})
+	if err := m.failover(tr, targets, mirrorTo, err); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(pushed) != 3 || pushed[1] != "broken" || pushed[2] != "standby" {
+		t.Errorf("Expected the standbys of the primary to be tried in order, got %v", pushed)
+	}
+	if last := tr.Targets[len(tr.Targets)-1]; last.Registry != "standby" || last.FailoverFrom != "primary" {
+		t.Errorf("Expected the tag to land on the standby, got %+v", last)
+	}
+	if tr.Targets[0].FailoverFrom != "" || tr.Targets[0].Result != resultFailed {
+		t.Errorf("Expected the primary to stay failed, got %+v", tr.Targets[0])
+	}
+	if m.report.run.Failovers != 1 {
+		t.Errorf("Expected 1 failover, got %d", m.report.run.Failovers)
+	}
+}
+
+func TestFailoverSourceError(t *testing.T) {
+	m := mirror{log: log.WithField("test", "failover")}
+	tr := &tagReport{Tag: "7"}
+	pullErr := errors.New("pull failed")
+
+	err := m.failover(tr, nil, func([]*target) error {
+		t.Fatal("Expected no failover without a failed target")
+		return nil
+	}, pullErr)
+	if err != pullErr {
+		t.Errorf("Expected the pull error, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	foreignLayersPreserve = "preserve"
+	foreignLayersCopy     = "copy"
+)
+
+// blobSource is where copyBlob reads a blob from: the source registry, or the URLs of a
+// foreign layer
+type blobSource interface {
+	openBlob(repository, digest string, offset int64) (io.ReadCloser, error)
+}
+
+// urlBlobSource reads a foreign layer from the URLs of its descriptor, i.e. a Windows base
+// layer served by the Microsoft CDN
+type urlBlobSource struct {
+	urls []string
+}
+
+func (u *urlBlobSource) openBlob(repository, digest string, offset int64) (io.ReadCloser, error) {
+	var failed error
+	for _, url := range u.urls {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			failed = err
+			continue
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			failed = err
+			continue
+		}
+
+		switch res.StatusCode {
+		case http.StatusPartialContent:
+			return res.Body, nil
+		case http.StatusOK:
+			if _, err := io.CopyN(ioutil.Discard, res.Body, offset); err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+			return res.Body, nil
+		}
+
+		res.Body.Close()
+		failed = fmt.Errorf("Getting foreign layer %s from %s returned %d", digest, url, res.StatusCode)
+	}
+
+	return nil, failed
+}
+
+// validateForeignLayers checks the `foreign_layers` of a repository
+func validateForeignLayers(mode string) error {
+	switch mode {
+	case "", foreignLayersPreserve, foreignLayersCopy:
+		return nil
+	default:
+		return fmt.Errorf("Unknown foreign_layers %q, we support %s and %s", mode, foreignLayersPreserve, foreignLayersCopy)
+	}
+}
+
+// copyForeignLayers uploads the foreign layers of the manifests to the destination, which
+// copyImage leaves out. They are read from the source registry, or from their URLs when the
+// source doesn't distribute them. The manifests keep their foreign layer descriptors, so the
+// digests don't change: the clients try the URLs first, then fall back to the destination
+func copyForeignLayers(src *registryClient, srcRepository string, references []string, dst *registryClient, dstRepository string) (int64, error) {
+	var transferred int64
+	for _, reference := range references {
+		body, _, _, err := src.manifest(srcRepository, reference)
+		if err != nil {
+			return transferred, err
+		}
+
+		var m manifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return transferred, fmt.Errorf("Could not parse manifest %s:%s: %s", srcRepository, reference, err)
+		}
+
+		var children []string
+		for _, child := range m.Manifests {
+			children = append(children, child.Digest)
+		}
+		n, err := copyForeignLayers(src, srcRepository, children, dst, dstRepository)
+		transferred += n
+		if err != nil {
+			return transferred, err
+		}
+
+		for _, layer := range m.Layers {
+			if len(layer.URLs) == 0 {
+				continue
+			}
+
+			n, err := copyBlob(src, srcRepository, dst, dstRepository, layer)
+			if err != nil {
+				n, err = copyBlob(&urlBlobSource{urls: layer.URLs}, srcRepository, dst, dstRepository, layer)
+			}
+			transferred += n
+			if err != nil {
+				return transferred, fmt.Errorf("Could not copy foreign layer %s: %s", layer.Digest, err)
+			}
+		}
+	}
+
+	return transferred, nil
+}
+
+// copyForeignLayers copies the foreign layers of the tag being mirrored with `foreign_layers:
+// copy`, only the ones of the selected platforms with `platforms`
+func (m *mirror) copyForeignLayers(src *registryClient, srcRepository, reference string, index *platformIndex, dst *registryClient, dstRepository string) (int64, error) {
+	if m.repo.ForeignLayers != foreignLayersCopy || m.schema1 != "" {
+		return 0, nil
+	}
+
+	references := []string{reference}
+	if index != nil {
+		references = index.manifests
+	}
+
+	return copyForeignLayers(src, srcRepository, references, dst, dstRepository)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCopyForeignLayers(t *testing.T) {
+	base := []byte("windows servercore base layer")
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(base)
+	}))
+	defer cdn.Close()
+
+	// the source registry doesn't distribute the foreign layer
+	source := newFakeRegistry()
+	source.image("windows/servercore", "ltsc2022", []byte("app layer"))
+	var m manifest
+	json.Unmarshal(source.manifests["windows/servercore:ltsc2022"], &m)
+	foreign := descriptor{MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip", Digest: sha256Digest(base), Size: int64(len(base)), URLs: []string{cdn.URL + "/layer"}}
+	m.Layers = append([]descriptor{foreign}, m.Layers...)
+	body, _ := json.Marshal(m)
+	source.manifests["windows/servercore:ltsc2022"] = body
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	for _, mode := range []string{foreignLayersPreserve, foreignLayersCopy} {
+		dest := newFakeRegistry()
+		dstServer := httptest.NewServer(dest)
+		dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+		mi := &mirror{repo: Repository{ForeignLayers: mode}}
+		if _, err := mi.copyForeignLayers(src, "windows/servercore", "ltsc2022", nil, dst, "mirror/servercore"); err != nil {
+			t.Fatal(err)
+		}
+		digest, _, err := copyImage(src, "windows/servercore", "ltsc2022", dst, "mirror/servercore", "ltsc2022")
+		dstServer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the foreign layer descriptor is kept, the digest doesn't change
+		if digest != sha256Digest(body) || string(dest.manifests["mirror/servercore:ltsc2022"]) != string(body) {
+			t.Errorf("Expected the manifest to be copied as is with %s", mode)
+		}
+
+		_, copied := dest.blobs[foreign.Digest]
+		if copied != (mode == foreignLayersCopy) {
+			t.Errorf("Expected the foreign layer to be copied only with %s, copied: %t with %s", foreignLayersCopy, copied, mode)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// `remote_tags_config -> mode` of the github remote_tags_source
+	gitHubModeTags     = "tags"
+	gitHubModeReleases = "releases"
+)
+
+// gitHubReleaseData is the data the `tag_template` of a GitHub release is evaluated with
+type gitHubReleaseData struct {
+	TagName string // git tag of the release, i.e. v1.4.0
+	Name    string // title of the release
+}
+
+// gitHubTokenTransport authenticates the GitHub API requests with a token
+type gitHubTokenTransport struct {
+	token string
+}
+
+func (t *gitHubTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+t.token)
+
+	return PTransport.RoundTrip(req)
+}
+
+// gitHubToken returns the token of the GitHub API: the `token` of `remote_tags_config`,
+// the env var named by its `token_env`, or GITHUB_TOKEN
+func (m *mirror) gitHubToken() string {
+	cfg := m.repo.RemoteTagConfig
+	if cfg["token"] != "" {
+		return cfg["token"]
+	}
+
+	if cfg["token_env"] != "" {
+		return os.Getenv(cfg["token_env"])
+	}
+
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// gitHubClient returns the GitHub API client, for the GitHub Enterprise API when
+// `remote_tags_config -> base_url` is set (i.e. https://github.example.com/api/v3).
+// Unauthenticated, the API is limited to 60 requests per hour
+func (m *mirror) gitHubClient() (*github.Client, error) {
+	client := &http.Client{Timeout: httpClient.Timeout, Transport: PTransport}
+	if token := m.gitHubToken(); token != "" {
+		client.Transport = &gitHubTokenTransport{token: token}
+	}
+
+	base := m.repo.RemoteTagConfig["base_url"]
+	if base == "" {
+		return github.NewClient(client), nil
+	}
+
+	return github.NewEnterpriseClient(base, base, client)
+}
+
+// gitHubRetry calls the GitHub API until it succeeds, waiting for the reset of the rate
+// limit when it is exhausted. A reset further than maxRetryAfter fails the call
+func (m *mirror) gitHubRetry(call func() (*github.Response, error)) error {
+	var err error
+	for attempt := 0; attempt < httpRetries; attempt++ {
+		var res *github.Response
+		res, err = call()
+		if err == nil {
+			return nil
+		}
+
+		var (
+			delay         time.Duration
+			rateLimitErr  *github.RateLimitError
+			abuseLimitErr *github.AbuseRateLimitError
+			now           = time.Now()
+		)
+		switch {
+		case errors.As(err, &rateLimitErr):
+			delay = rateLimitErr.Rate.Reset.Sub(now)
+			if delay > maxRetryAfter {
+				return fmt.Errorf("%s, the rate limit resets at %s", err, rateLimitErr.Rate.Reset.Format(time.RFC3339))
+			}
+		case errors.As(err, &abuseLimitErr) && abuseLimitErr.RetryAfter != nil:
+			delay = *abuseLimitErr.RetryAfter
+		case res != nil && retryable(res.StatusCode):
+			delay = retryDelay(res.Response, attempt, now)
+		default:
+			return err
+		}
+
+		if delay < time.Second {
+			delay = time.Second
+		}
+		m.log.Warnf("GitHub API call failed, retrying in %s: %s", delay.Round(time.Second), err)
+		runState.retrySleep("api.github.com", err.Error(), delay)
+	}
+
+	return err
+}
+
+// getGitHubTags returns the git tags, or the releases when `mode: releases`, of the
+// `owner`/`repo` GitHub repository in `remote_tags_config`
+func (m *mirror) getGitHubTags() ([]RepositoryTag, error) {
+	cfg := m.repo.RemoteTagConfig
+
+	limit, err := strconv.Atoi(cfg["num_releases"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid/missing int value for remote_tag_config -> num_releases")
+	}
+
+	client, err := m.gitHubClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg["mode"] == gitHubModeReleases {
+		return m.getGitHubReleases(client, limit)
+	}
+
+	var remoteTags []*github.RepositoryTag
+	err = m.gitHubRetry(func() (res *github.Response, err error) {
+		remoteTags, res, err = client.Repositories.ListTags(context.Background(), cfg["owner"], cfg["repo"], &github.ListOptions{PerPage: limit})
+		return res, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var allTags []RepositoryTag
+	for _, tag := range remoteTags {
+		allTags = append(allTags, RepositoryTag{
+			Name: strings.TrimPrefix(*tag.Name, "v"),
+		})
+	}
+
+	return allTags, nil
+}
+
+// getGitHubReleases returns the tags of the latest GitHub releases, drafts and
+// prereleases are skipped unless `include_drafts` / `include_prereleases` is true
+func (m *mirror) getGitHubReleases(client *github.Client, limit int) ([]RepositoryTag, error) {
+	cfg := m.repo.RemoteTagConfig
+
+	tmpl, err := parseReleaseTagTemplate(cfg["tag_template"])
+	if err != nil {
+		return nil, err
+	}
+
+	var releases []*github.RepositoryRelease
+	err = m.gitHubRetry(func() (res *github.Response, err error) {
+		releases, res, err = client.Repositories.ListReleases(context.Background(), cfg["owner"], cfg["repo"], &github.ListOptions{PerPage: limit})
+		return res, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var allTags []RepositoryTag
+	for _, release := range releases {
+		if release.GetDraft() && cfg["include_drafts"] != "true" {
+			continue
+		}
+		if release.GetPrerelease() && cfg["include_prereleases"] != "true" {
+			continue
+		}
+
+		data := gitHubReleaseData{TagName: release.GetTagName(), Name: release.GetName()}
+		tag := strings.TrimPrefix(data.TagName, "v")
+		if tmpl != nil {
+			var b strings.Builder
+			if err := tmpl.Execute(&b, data); err != nil {
+				return nil, err
+			}
+			tag = strings.TrimSpace(b.String())
+		}
+
+		if tag == "" {
+			m.log.Warnf("Skipping GitHub release %q, its tag_template renders an empty tag", data.TagName)
+			continue
+		}
+
+		remoteTag := RepositoryTag{Name: tag}
+		if release.PublishedAt != nil {
+			remoteTag.LastUpdated = release.PublishedAt.Time
+		}
+		allTags = append(allTags, remoteTag)
+	}
+
+	return allTags, nil
+}
+
+// parseReleaseTagTemplate parses the `tag_template` mapping a release to its image tag
+// (i.e. {{ .TagName | trimPrefix "release-" }}), nil when not set
+func parseReleaseTagTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("tag_template").Funcs(nameTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid remote_tags_config -> tag_template: %s", err)
+	}
+
+	if err := tmpl.Execute(&strings.Builder{}, gitHubReleaseData{TagName: "v1.0.0", Name: "v1.0.0"}); err != nil {
+		return nil, fmt.Errorf("Invalid remote_tags_config -> tag_template: %s", err)
+	}
+
+	return tmpl, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGitHubReleases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/tools/scanner/releases" || r.URL.Query().Get("per_page") != "4" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+
+		w.Write([]byte(`[
+			{"tag_name":"release-1.5.0","draft":true},
+			{"tag_name":"release-1.5.0-rc.1","prerelease":true,"published_at":"2021-06-10T10:00:00Z"},
+			{"tag_name":"release-1.4.0","published_at":"2021-06-01T10:00:00Z"},
+			{"tag_name":"release-1.3.2","published_at":"2021-05-01T10:00:00Z"}
+		]`))
+	}))
+	defer server.Close()
+
+	m := mirror{log: log.WithField("test", "github"), repo: Repository{Name: "tools/scanner", RemoteTagSource: remoteTagSourceGitHub, RemoteTagConfig: map[string]string{
+		"owner":        "tools",
+		"repo":         "scanner",
+		"num_releases": "4",
+		"base_url":     server.URL,
+		"mode":         gitHubModeReleases,
+		"tag_template": `{{ .TagName | trimPrefix "release-" }}`,
+	}}}
+
+	tags, err := m.getRemoteTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(tags) != 2 || tags[0].Name != "1.4.0" || tags[1].Name != "1.3.2" || !tags[0].LastUpdated.Equal(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the published releases, got %+v", tags)
+	}
+
+	m.repo.RemoteTagConfig["include_prereleases"] = "true"
+	tags, err = m.getRemoteTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(tags) != 3 || tags[0].Name != "1.5.0-rc.1" {
+		t.Errorf("Expected the prerelease to be included, got %+v", tags)
+	}
+}
+
+func TestGitHubRateLimitRetry(t *testing.T) {
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "token secret" {
+			t.Errorf("Expected the GITHUB_TOKEN, got %q", r.Header.Get("Authorization"))
+		}
+
+		// the first request exhausts the rate limit, which resets right away
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Limit", "5000")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Unix()))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"API rate limit exceeded for 10.0.0.1."}`))
+			return
+		}
+
+		w.Write([]byte(`[{"name":"v1.4.0"},{"name":"v1.3.2"}]`))
+	}))
+	defer server.Close()
+
+	defer os.Unsetenv("GITHUB_TOKEN")
+	os.Setenv("GITHUB_TOKEN", "secret")
+
+	m := mirror{log: log.WithField("test", "github"), repo: Repository{Name: "tools/scanner", RemoteTagSource: remoteTagSourceGitHub, RemoteTagConfig: map[string]string{
+		"owner":        "tools",
+		"repo":         "scanner",
+		"num_releases": "2",
+		"base_url":     server.URL,
+	}}}
+
+	tags, err := m.getRemoteTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(tags) != 2 || tags[0].Name != "1.4.0" {
+		t.Errorf("Expected the tags after the rate limit reset, got %+v", tags)
+	}
+
+	if requests != 2 || len(slept) != 1 {
+		t.Errorf("Expected a single retry, got %d requests and %d waits", requests, len(slept))
+	}
+}
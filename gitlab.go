@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	remoteTagSourceGitLab = "gitlab"
+
+	// the GitLab API used unless `remote_tags_config -> base_url` is set
+	defaultGitLabBaseURL = "https://gitlab.com"
+)
+
+// gitLabRelease is a release of the GitLab releases API
+type gitLabRelease struct {
+	TagName    string    `json:"tag_name"`
+	ReleasedAt time.Time `json:"released_at"`
+}
+
+// getGitLabTags returns the tags of the latest GitLab releases of the `project` (i.e.
+// group/tool) in `remote_tags_config`, authenticated with its `token` or GITLAB_TOKEN
+func (m *mirror) getGitLabTags() ([]RepositoryTag, error) {
+	cfg := m.repo.RemoteTagConfig
+
+	limit, err := strconv.Atoi(cfg["num_releases"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid/missing int value for remote_tags_config -> num_releases")
+	}
+
+	if cfg["project"] == "" {
+		return nil, fmt.Errorf("Missing remote_tags_config -> project")
+	}
+
+	base := cfg["base_url"]
+	if base == "" {
+		base = defaultGitLabBaseURL
+	}
+
+	releasesURL := fmt.Sprintf("%s/api/v4/projects/%s/releases?per_page=%d", strings.TrimSuffix(base, "/"), url.PathEscape(cfg["project"]), limit)
+	req, err := http.NewRequest("GET", releasesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := cfg["token"]
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Get %s failed with %d", releasesURL, res.StatusCode)
+	}
+
+	var releases []gitLabRelease
+	if err := json.NewDecoder(res.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+
+	var allTags []RepositoryTag
+	for _, release := range releases {
+		allTags = append(allTags, RepositoryTag{
+			Name:        strings.TrimPrefix(release.TagName, "v"),
+			LastUpdated: release.ReleasedAt,
+		})
+	}
+
+	return allTags, nil
+}
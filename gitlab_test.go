@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGitLabTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/projects/tools%2Fscanner/releases" || r.URL.Query().Get("per_page") != "2" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`[{"tag_name":"v1.4.0","released_at":"2021-06-01T10:00:00Z"},{"tag_name":"1.3.2","released_at":"2021-05-01T10:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	m := mirror{log: log.WithField("test", "gitlab"), repo: Repository{Name: "tools/scanner", RemoteTagSource: remoteTagSourceGitLab, RemoteTagConfig: map[string]string{
+		"project":      "tools/scanner",
+		"num_releases": "2",
+		"base_url":     server.URL,
+	}}}
+
+	if _, err := m.getRemoteTags(); err == nil {
+		t.Errorf("Expected an error without token")
+	}
+
+	defer os.Unsetenv("GITLAB_TOKEN")
+	os.Setenv("GITLAB_TOKEN", "secret")
+
+	tags, err := m.getRemoteTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(tags) != 2 || tags[0].Name != "1.4.0" || tags[1].Name != "1.3.2" || !tags[0].LastUpdated.Equal(time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the release tags, got %+v", tags)
+	}
+}
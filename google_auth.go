@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// GoogleAuthConfig are the credentials of the gcr.io and Artifact Registry (*-docker.pkg.dev)
+// sources
+type GoogleAuthConfig struct {
+	KeyFile            string `yaml:"key_file,omitempty"`            // a service account JSON key
+	DefaultCredentials bool   `yaml:"default_credentials,omitempty"` // the application default credentials
+}
+
+// enabled returns true when the Google registries are pulled with credentials
+func (c GoogleAuthConfig) enabled() bool {
+	return c.KeyFile != "" || c.DefaultCredentials
+}
+
+var (
+	// endpoints of the access tokens, replaced in the tests
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	googleTokensMu sync.Mutex
+	googleTokens   = map[string]*googleToken{} // access tokens by credentials file, "" for the metadata server
+)
+
+// googleToken is an OAuth access token, the registries accept it as the password of the
+// oauth2accesstoken user
+type googleToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	expires     time.Time
+}
+
+// googleRegistry returns true for the registries of Google Container Registry and Artifact Registry
+func googleRegistry(host string) bool {
+	return host == gcr || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, "-docker.pkg.dev")
+}
+
+// googleCredentials returns the docker credentials of the Google registries with the
+// service account key file, or the application default credentials without it: the file
+// of GOOGLE_APPLICATION_CREDENTIALS, the file of `gcloud auth application-default login`,
+// then the metadata server (i.e. GKE workload identity)
+func googleCredentials(keyFile string) (docker.AuthConfiguration, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if keyFile == "" {
+		dir := os.Getenv("CLOUDSDK_CONFIG")
+		if dir == "" {
+			home, _ := os.UserHomeDir()
+			dir = filepath.Join(home, ".config", "gcloud")
+		}
+		file := filepath.Join(dir, "application_default_credentials.json")
+		if _, err := os.Stat(file); err == nil {
+			keyFile = file
+		}
+	}
+
+	if keyFile == "" {
+		token, err := cachedGoogleToken("", func() (*http.Request, error) {
+			req, err := http.NewRequest("GET", googleMetadataURL, nil)
+			if err == nil {
+				req.Header.Set("Metadata-Flavor", "Google")
+			}
+			return req, err
+		})
+		if err != nil {
+			return docker.AuthConfiguration{}, fmt.Errorf("Could not get an access token from the metadata server: %s", err)
+		}
+		return docker.AuthConfiguration{Username: "oauth2accesstoken", Password: token}, nil
+	}
+
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+
+	var key struct {
+		Type         string `json:"type"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(content, &key); err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("Could not parse %s: %s", keyFile, err)
+	}
+
+	switch key.Type {
+	case "service_account":
+		// the registries accept the key itself
+		return docker.AuthConfiguration{Username: "_json_key", Password: string(content)}, nil
+	case "authorized_user":
+		token, err := cachedGoogleToken(keyFile, func() (*http.Request, error) {
+			form := url.Values{"grant_type": {"refresh_token"}, "client_id": {key.ClientID}, "client_secret": {key.ClientSecret}, "refresh_token": {key.RefreshToken}}
+			req, err := http.NewRequest("POST", googleTokenURL, strings.NewReader(form.Encode()))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			return req, err
+		})
+		if err != nil {
+			return docker.AuthConfiguration{}, fmt.Errorf("Could not refresh the access token of %s: %s", keyFile, err)
+		}
+		return docker.AuthConfiguration{Username: "oauth2accesstoken", Password: token}, nil
+	}
+
+	return docker.AuthConfiguration{}, fmt.Errorf("Unsupported credentials type %q in %s, we support service_account and authorized_user", key.Type, keyFile)
+}
+
+// cachedGoogleToken returns the access token of the credentials, requested again a minute
+// before it expires
+func cachedGoogleToken(key string, request func() (*http.Request, error)) (string, error) {
+	googleTokensMu.Lock()
+	defer googleTokensMu.Unlock()
+
+	if t, ok := googleTokens[key]; ok && time.Now().Before(t.expires) {
+		return t.AccessToken, nil
+	}
+
+	req, err := request()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s returned %d", req.Method, req.URL, res.StatusCode)
+	}
+
+	var t googleToken
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	t.expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+	googleTokens[key] = &t
+
+	return t.AccessToken, nil
+}
+
+// googleAuth returns the credentials of the repository pulled from a Google registry, with
+// its `google_key_file` or the `google_auth` config. ok is false for the other registries,
+// and when the Google registries aren't pulled with credentials
+func (m *mirror) googleAuth() (auth docker.AuthConfiguration, ok bool) {
+	host, _ := splitReference(m.sourceRepository())
+	// the Docker Hub images of `pull_host_override: mirror.gcr.io` are public
+	if !googleRegistry(host) || m.repo.PullHost != "" {
+		return auth, false
+	}
+
+	keyFile := config.GoogleAuth.KeyFile
+	switch {
+	case m.repo.Auth != nil && m.repo.Auth.GoogleKeyFile != "":
+		keyFile = m.repo.Auth.GoogleKeyFile
+	case !config.GoogleAuth.enabled():
+		return auth, false
+	}
+
+	auth, err := googleCredentials(keyFile)
+	if err != nil {
+		m.log.Errorf("Failed to get the credentials of %s: %s", host, err)
+		return auth, false
+	}
+	auth.ServerAddress = host
+
+	return auth, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGoogleAuth(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(key, []byte(`{"type": "service_account", "client_email": "mirror@acme.iam.gserviceaccount.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/metadata":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "metadata-token", "expires_in": 3600}`)
+		case "/token":
+			if r.FormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "user-token", "expires_in": 3600}`)
+		}
+	}))
+	defer server.Close()
+
+	defer func(token, metadata string) { googleTokenURL, googleMetadataURL = token, metadata }(googleTokenURL, googleMetadataURL)
+	googleTokenURL, googleMetadataURL = server.URL+"/token", server.URL+"/metadata"
+	googleTokens = map[string]*googleToken{}
+
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+
+	keyContent, _ := ioutil.ReadFile(key)
+
+	tests := []struct {
+		global GoogleAuthConfig
+		repo   Repository
+		auth   docker.AuthConfiguration
+	}{
+		// public without credentials
+		{GoogleAuthConfig{}, Repository{Name: "acme/app", Host: gcr}, docker.AuthConfiguration{}},
+		// the key is the password of _json_key
+		{GoogleAuthConfig{KeyFile: key}, Repository{Name: "acme/app", Host: gcr}, docker.AuthConfiguration{Username: "_json_key", Password: string(keyContent), ServerAddress: gcr}},
+		{GoogleAuthConfig{KeyFile: key}, Repository{Name: "us-docker.pkg.dev/acme/images/app", Host: dockerHub}, docker.AuthConfiguration{Username: "_json_key", Password: string(keyContent), ServerAddress: "us-docker.pkg.dev"}},
+		// not sent to the other registries
+		{GoogleAuthConfig{KeyFile: key}, Repository{Name: "ghcr.io/acme/app", Host: dockerHub}, docker.AuthConfiguration{}},
+		// the key of the repository takes precedence
+		{GoogleAuthConfig{DefaultCredentials: true}, Repository{Name: "acme/app", Host: gcr, Auth: &SourceAuth{GoogleKeyFile: key}}, docker.AuthConfiguration{Username: "_json_key", Password: string(keyContent), ServerAddress: gcr}},
+		// the metadata server without application default credentials file
+		{GoogleAuthConfig{DefaultCredentials: true}, Repository{Name: "acme/app", Host: gcr}, docker.AuthConfiguration{Username: "oauth2accesstoken", Password: "metadata-token", ServerAddress: gcr}},
+		{GoogleAuthConfig{DefaultCredentials: true}, Repository{Name: "acme/other", Host: gcr}, docker.AuthConfiguration{Username: "oauth2accesstoken", Password: "metadata-token", ServerAddress: gcr}},
+	}
+
+	for i, tt := range tests {
+		config.GoogleAuth = tt.global
+		m := &mirror{repo: tt.repo, log: log.WithField("repo", tt.repo.Name)}
+		if auth := m.sourceAuth(); auth != tt.auth {
+			t.Errorf("%d: expected %+v, got %+v", i, tt.auth, auth)
+		}
+	}
+
+	// the token of the metadata server is cached
+	if requests != 1 {
+		t.Errorf("Expected 1 request of an access token, got %d", requests)
+	}
+
+	// the gcloud application default credentials take precedence over the metadata server
+	if err := ioutil.WriteFile(filepath.Join(dir, "application_default_credentials.json"), []byte(`{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "refresh"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := googleCredentials("")
+	if err != nil || auth.Password != "user-token" {
+		t.Errorf("Expected the access token of the user, got %+v (%v)", auth, err)
+	}
+
+	if _, err := googleCredentials(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected a missing key file to fail")
+	}
+
+	config.GoogleAuth = GoogleAuthConfig{KeyFile: key}
+	if m := (&mirror{repo: Repository{Name: "acme/app", Host: gcr}}); !m.listsWithCredentials() {
+		t.Error("Expected the tags of gcr.io to be listed with the registry API with credentials")
+	}
+
+	if err := validateSourceAuth(Repository{Name: "acme/app", Auth: &SourceAuth{Username: "acme", GoogleKeyFile: key}}); err == nil {
+		t.Error("Expected an `auth` with both a `username` and a `google_key_file` to be invalid")
+	}
+}
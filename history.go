@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	historyFormatCSV     = "csv"
+	historyFormatParquet = "parquet"
+)
+
+// HistoryConfig configures the export of the mirrored tags of every run, for long-term
+// analytics (i.e. in Athena)
+type HistoryConfig struct {
+	Format   string `yaml:"format,omitempty"`   // csv or parquet (default: csv)
+	Location string `yaml:"location,omitempty"` // local directory or s3://bucket/prefix, the export is disabled when empty
+}
+
+// s3 client writing the s3:// history, created on first use
+var historyS3Client s3Client
+
+// historyRecord is the result of a tag in a run, a row of the history
+type historyRecord struct {
+	RunStartedAt time.Time
+	Repository   string
+	Host         string
+	Tag          string
+	Result       string
+	Reason       string // the reason, or the error
+	SourceDigest string
+	Bytes        int64
+	PullDuration float64
+	Duration     float64
+	Lag          float64
+	Targets      string // registries the tag landed on, comma separated
+}
+
+// historyColumns are the columns of the history, in the order of historyRecord
+var historyColumns = []string{
+	"run_started_at", "repository", "host", "tag", "result", "reason", "source_digest",
+	"bytes_transferred", "pull_duration_seconds", "duration_seconds", "lag_seconds", "targets",
+}
+
+// validateHistoryConfig checks the format and location of the `history` config
+func validateHistoryConfig(c HistoryConfig) error {
+	switch c.Format {
+	case "", historyFormatCSV, historyFormatParquet:
+	default:
+		return fmt.Errorf("Unknown `history -> format` %q, we support %s and %s", c.Format, historyFormatCSV, historyFormatParquet)
+	}
+
+	if c.Format != "" && c.Location == "" {
+		return fmt.Errorf("The `history` needs a `location`, a local directory or s3://bucket/prefix")
+	}
+
+	return nil
+}
+
+// historyRecords returns a record per tag of the run, the filtered tags aren't recorded
+func historyRecords(r *runReport) []historyRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []historyRecord
+	for _, rr := range r.Repositories {
+		rr.mu.Lock()
+		for _, tr := range rr.Tags {
+			reason := tr.Reason
+			if tr.Error != "" {
+				reason = tr.Error
+			}
+
+			var targets []string
+			for _, t := range tr.Targets {
+				if t.Result == resultMirrored {
+					targets = append(targets, t.Registry)
+				}
+			}
+
+			records = append(records, historyRecord{
+				RunStartedAt: r.StartedAt.UTC(),
+				Repository:   rr.Name,
+				Host:         rr.Host,
+				Tag:          tr.Tag,
+				Result:       tr.Result,
+				Reason:       reason,
+				SourceDigest: tr.SourceDigest,
+				Bytes:        tr.BytesTransferred,
+				PullDuration: tr.PullDuration,
+				Duration:     tr.Duration,
+				Lag:          tr.Lag,
+				Targets:      strings.Join(targets, ","),
+			})
+		}
+		rr.mu.Unlock()
+	}
+
+	return records
+}
+
+// writeHistory appends the tags of the run to the history: every run writes a new file,
+// partitioned by day (dt=2024-05-01/...) so Athena can prune the days a query doesn't need.
+// Nothing is written for a run without tags
+func writeHistory(c HistoryConfig, r *runReport) error {
+	records := historyRecords(r)
+	if c.Location == "" || len(records) == 0 {
+		return nil
+	}
+
+	format := c.Format
+	if format == "" {
+		format = historyFormatCSV
+	}
+
+	var content []byte
+	if format == historyFormatParquet {
+		content = historyParquet(records)
+	} else {
+		var err error
+		if content, err = historyCSV(records); err != nil {
+			return err
+		}
+	}
+
+	// runs of several instances don't overwrite each other
+	hostname, _ := os.Hostname()
+	started := r.StartedAt.UTC()
+	key := fmt.Sprintf("dt=%s/%s-%s.%s", started.Format("2006-01-02"), started.Format("20060102T150405Z"), hostname, format)
+
+	if strings.HasPrefix(c.Location, "s3://") {
+		return putS3History(c.Location, key, content)
+	}
+
+	file := filepath.Join(c.Location, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+// historyCSV returns the records as CSV, with a header
+func historyCSV(records []historyRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(historyColumns)
+
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, rec := range records {
+		w.Write([]string{
+			rec.RunStartedAt.Format(time.RFC3339), rec.Repository, rec.Host, rec.Tag, rec.Result, rec.Reason, rec.SourceDigest,
+			strconv.FormatInt(rec.Bytes, 10), float(rec.PullDuration), float(rec.Duration), float(rec.Lag), rec.Targets,
+		})
+	}
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
+
+// historyParquet returns the records as a Parquet file
+func historyParquet(records []historyRecord) []byte {
+	columns := make([]*parquetColumn, len(historyColumns))
+	for i, name := range historyColumns {
+		columns[i] = &parquetColumn{name: name, physical: parquetByteArray, converted: parquetUTF8}
+	}
+	columns[0].physical, columns[0].converted = parquetInt64, parquetTimestampMillis
+	columns[7].physical, columns[7].converted = parquetInt64, parquetNoConvertedType
+	for _, c := range columns[8:11] {
+		c.physical, c.converted = parquetDouble, parquetNoConvertedType
+	}
+
+	for _, rec := range records {
+		columns[0].int64(rec.RunStartedAt.UnixNano() / int64(time.Millisecond))
+		for i, s := range []string{rec.Repository, rec.Host, rec.Tag, rec.Result, rec.Reason, rec.SourceDigest} {
+			columns[i+1].string(s)
+		}
+		columns[7].int64(rec.Bytes)
+		columns[8].double(rec.PullDuration)
+		columns[9].double(rec.Duration)
+		columns[10].double(rec.Lag)
+		columns[11].string(rec.Targets)
+	}
+
+	return writeParquet(columns, len(records))
+}
+
+// putS3History writes the history file under the s3://bucket/prefix location
+func putS3History(location, key string, content []byte) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	if historyS3Client == nil {
+		cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return err
+		}
+		historyS3Client = s3.NewFromConfig(cfg)
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	_, err = historyS3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(prefix + key),
+		Body:   bytes.NewReader(content),
+	})
+	return err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func historyReport() *runReport {
+	r := newRunReport()
+	r.StartedAt = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	rr := r.repository("redis", "hub.docker.com")
+	tr := rr.tag("7.2")
+	tr.SourceDigest = "sha256:abc"
+	tr.BytesTransferred = 1024
+	tr.Duration = 2.5
+	tr.Targets = []*targetReport{
+		{Registry: "1.dkr.ecr.us-east-1.amazonaws.com", Result: resultMirrored},
+		{Registry: "1.dkr.ecr.eu-west-1.amazonaws.com", Result: resultFailed},
+	}
+	rr.tag("6.2").fail(rr, errors.New("Pull failed, \"quoted\""))
+	rr.filter("latest", "match_tags", "doesn't match")
+
+	return r
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeHistory(HistoryConfig{Location: dir}, historyReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "dt=2024-05-01", "20240501T123000Z-*.csv"))
+	if len(files) != 1 {
+		t.Fatalf("Expected a file in the partition of the day, got %v", files)
+	}
+
+	content, _ := ioutil.ReadFile(files[0])
+	want := strings.Join(historyColumns, ",") + "\n" +
+		"2024-05-01T12:30:00Z,redis,hub.docker.com,7.2,mirrored,,sha256:abc,1024,0,2.5,0,1.dkr.ecr.us-east-1.amazonaws.com\n" +
+		"2024-05-01T12:30:00Z,redis,hub.docker.com,6.2,failed,\"Pull failed, \"\"quoted\"\"\",,0,0,0,0,\n"
+	if string(content) != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, content)
+	}
+
+	// a run without tags writes nothing
+	if err := writeHistory(HistoryConfig{Location: filepath.Join(dir, "empty")}, newRunReport()); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "empty", "*")); len(files) != 0 {
+		t.Errorf("Expected no file, got %v", files)
+	}
+}
+
+func TestWriteHistoryParquetS3(t *testing.T) {
+	defer func(c s3Client) { historyS3Client = c }(historyS3Client)
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	historyS3Client = s3
+
+	if err := writeHistory(HistoryConfig{Format: historyFormatParquet, Location: "s3://analytics/docker-mirror/history/"}, historyReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	var content []byte
+	for key, c := range s3.objects {
+		if !strings.HasPrefix(key, "analytics/docker-mirror/history/dt=2024-05-01/20240501T123000Z-") || !strings.HasSuffix(key, ".parquet") {
+			t.Errorf("Unexpected object %s", key)
+		}
+		content = c
+	}
+
+	if !bytes.HasPrefix(content, []byte("PAR1")) || !bytes.HasSuffix(content, []byte("PAR1")) {
+		t.Fatalf("Expected the parquet magic, got %q", content)
+	}
+
+	footer := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	if footer <= 0 || footer > len(content)-12 {
+		t.Fatalf("Invalid footer length %d", footer)
+	}
+	metadata := readThrift(t, content[len(content)-8-footer:len(content)-8])
+	if metadata[3] != int64(2) || metadata[6] != "docker-mirror" {
+		t.Errorf("Expected 2 rows written by docker-mirror, got %v rows by %v", metadata[3], metadata[6])
+	}
+
+	// the schema root, then a required column per history column
+	schema := metadata[2].([]interface{})
+	if len(schema) != len(historyColumns)+1 || schema[0].(map[int16]interface{})[4] != "schema" || schema[0].(map[int16]interface{})[5] != int64(len(historyColumns)) {
+		t.Fatalf("Unexpected schema %v", schema)
+	}
+	types := map[string][2]interface{}{
+		"run_started_at":    {int64(parquetInt64), int64(parquetTimestampMillis)},
+		"repository":        {int64(parquetByteArray), int64(parquetUTF8)},
+		"bytes_transferred": {int64(parquetInt64), nil},
+		"lag_seconds":       {int64(parquetDouble), nil},
+	}
+	for i, name := range historyColumns {
+		element := schema[i+1].(map[int16]interface{})
+		if element[4] != name || element[3] != int64(0) {
+			t.Errorf("Expected the required column %s, got %v", name, element)
+		}
+		if want, ok := types[name]; ok && (element[1] != want[0] || element[6] != want[1]) {
+			t.Errorf("Expected column %s of type %v, got %v", name, want, element)
+		}
+	}
+
+	// a single row group, whose column chunks point to a data page of every row
+	groups := metadata[4].([]interface{})
+	if len(groups) != 1 || groups[0].(map[int16]interface{})[3] != int64(2) {
+		t.Fatalf("Expected a row group of 2 rows, got %v", groups)
+	}
+	chunks := groups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(historyColumns) {
+		t.Fatalf("Expected a column chunk per column, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		if path := meta[3].([]interface{}); len(path) != 1 || path[0] != historyColumns[i] || meta[5] != int64(2) {
+			t.Errorf("Unexpected column chunk %v", meta)
+		}
+		page := readThrift(t, content[meta[9].(int64):])
+		if page[1] != int64(0) || page[5].(map[int16]interface{})[1] != int64(2) {
+			t.Errorf("Expected a data page of 2 values for %s, got %v", historyColumns[i], page)
+		}
+	}
+
+	if !bytes.Contains(content, []byte("1.dkr.ecr.us-east-1.amazonaws.com")) {
+		t.Errorf("Expected the targets in the data")
+	}
+}
+
+// readThrift decodes the Thrift compact struct at the start of the data, its fields by id:
+// the integers as int64, the binaries as string, the lists as []interface{} and the structs
+// as map[int16]interface{}
+func readThrift(t *testing.T, data []byte) map[int16]interface{} {
+	pos := 0
+	varint := func() uint64 {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			t.Fatalf("Invalid varint at %d", pos)
+		}
+		pos += n
+		return v
+	}
+	unzigzag := func(v uint64) int64 {
+		return int64(v>>1) ^ -int64(v&1)
+	}
+
+	var value func(kind byte) interface{}
+	readStruct := func() map[int16]interface{} {
+		fields := make(map[int16]interface{})
+		var id int16
+		for {
+			header := data[pos]
+			pos++
+			if header == 0 {
+				return fields
+			}
+			if delta := int16(header >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(unzigzag(varint()))
+			}
+			fields[id] = value(header & 0x0f)
+		}
+	}
+	value = func(kind byte) interface{} {
+		switch kind {
+		case thriftI32, thriftI64:
+			return unzigzag(varint())
+		case thriftBinary:
+			n := int(varint())
+			pos += n
+			return string(data[pos-n : pos])
+		case thriftList:
+			header := data[pos]
+			pos++
+			n := int(header >> 4)
+			if n == 15 {
+				n = int(varint())
+			}
+			list := make([]interface{}, n)
+			for i := range list {
+				list[i] = value(header & 0x0f)
+			}
+			return list
+		case thriftStruct:
+			return readStruct()
+		}
+		t.Fatalf("Unsupported thrift type %d at %d", kind, pos)
+		return nil
+	}
+
+	return readStruct()
+}
+
+func TestValidateHistoryConfig(t *testing.T) {
+	if err := validateHistoryConfig(HistoryConfig{Format: "json", Location: "/tmp"}); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+	if err := validateHistoryConfig(HistoryConfig{Format: historyFormatParquet}); err == nil {
+		t.Error("Expected a missing location to fail")
+	}
+	if err := validateHistoryConfig(HistoryConfig{Location: "s3://analytics/history"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// tag API styles of the `hosts`, an Artifactory virtual repository exposes the registry v2 API
+	hostTypeArtifactory = "artifactory"
+	hostTypeRegistry    = "registry-v2" // the registry v2 tags list, the default
+	hostTypeDockerHub   = "dockerhub"   // the API of Docker Hub, /v2/repositories/<name>/tags
+	hostTypeQuay        = "quay"        // the API of Quay, /api/v1/repository/<name>/tag
+
+	// auth methods of the `hosts`
+	hostAuthBasic  = "basic"  // the username and password, the default with a username
+	hostAuthBearer = "bearer" // the password is a token, sent as `Authorization: Bearer`
+	hostAuthNone   = "none"
+)
+
+// customHost returns the `hosts` config of the given host, or nil if it isn't configured
+func customHost(name string) *HostConfig {
+	for i, h := range config.Hosts {
+		if h.Name == name {
+			return &config.Hosts[i]
+		}
+	}
+
+	return nil
+}
+
+// supportedHost returns true for the built-in hosts and the hosts configured in `hosts`
+func supportedHost(name string) bool {
+	switch name {
+	case dockerHub, quay, gcr, k8s:
+		return true
+	}
+
+	return customHost(name) != nil
+}
+
+// validateHosts checks the `hosts` config
+func validateHosts(hosts []HostConfig) error {
+	for _, h := range hosts {
+		if h.Name == "" {
+			return fmt.Errorf("Missing `name` for host")
+		}
+
+		switch h.Name {
+		case dockerHub, quay, gcr, k8s:
+			return fmt.Errorf("Host %s is built in, it can't be configured in `hosts`", h.Name)
+		}
+
+		switch h.Type {
+		case "", hostTypeArtifactory, hostTypeRegistry, hostTypeDockerHub, hostTypeQuay:
+		default:
+			return fmt.Errorf("Unknown type %q for host %s, we support %s, %s, %s and %s", h.Type, h.Name, hostTypeRegistry, hostTypeDockerHub, hostTypeQuay, hostTypeArtifactory)
+		}
+
+		switch h.Auth {
+		case "", hostAuthBasic, hostAuthNone:
+		case hostAuthBearer:
+			if h.Password == "" {
+				return fmt.Errorf("The bearer `auth` of host %s needs the token as `password`", h.Name)
+			}
+		default:
+			return fmt.Errorf("Unknown auth %q for host %s, we support %s, %s and %s", h.Auth, h.Name, hostAuthBasic, hostAuthBearer, hostAuthNone)
+		}
+	}
+
+	return nil
+}
+
+// repository returns the path images of the repository are pulled from, the virtual
+// repository prefix is only part of the pull path, not of the tag discovery API
+func (h *HostConfig) repository(name string) string {
+	return fmt.Sprintf("%s/%s%s", h.Name, h.PathPrefix, name)
+}
+
+// tagsAPI returns the style of the tag API of the host
+func (h *HostConfig) tagsAPI() string {
+	if h.Type == "" || h.Type == hostTypeArtifactory {
+		return hostTypeRegistry
+	}
+
+	return h.Type
+}
+
+// tagsURL returns the URL listing the tags of the repository with the tag API of the host,
+// i.e. Artifactory exposes the registry v2 API of a virtual repository under
+// /artifactory/api/docker/<repo-key>
+func (h *HostConfig) tagsURL(name string) string {
+	base := h.APIBase
+	if base == "" {
+		base = "https://" + h.Name
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	switch h.tagsAPI() {
+	case hostTypeDockerHub:
+		return fmt.Sprintf("%s/v2/repositories/%s/tags/?page_size=100", base, name)
+	case hostTypeQuay:
+		return fmt.Sprintf("%s/api/v1/repository/%s/tag", base, name)
+	}
+
+	return fmt.Sprintf("%s/v2/%s/tags/list", base, name)
+}
+
+// authorize sets the credentials of the host on a request to its tag API
+func (h *HostConfig) authorize(req *http.Request) {
+	switch h.Auth {
+	case hostAuthNone:
+	case hostAuthBearer:
+		req.Header.Set("Authorization", "Bearer "+h.Password)
+	default:
+		if h.Username != "" {
+			req.SetBasicAuth(h.Username, h.Password)
+		}
+	}
+}
+
+// auth returns the credentials used to pull from the host
+func (h *HostConfig) auth() docker.AuthConfiguration {
+	switch h.Auth {
+	case hostAuthNone:
+		return docker.AuthConfiguration{ServerAddress: h.Name}
+	case hostAuthBearer:
+		return docker.AuthConfiguration{RegistryToken: h.Password, ServerAddress: h.Name}
+	}
+
+	return docker.AuthConfiguration{
+		Username:      h.Username,
+		Password:      h.Password,
+		ServerAddress: h.Name,
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestArtifactoryHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "mirror" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/artifactory/api/docker/docker-virtual/v2/library/nginx/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write([]byte(`{"name":"library/nginx","tags":["1.25","latest"]}`))
+	}))
+	defer server.Close()
+
+	defer func(hosts []HostConfig) { config.Hosts = hosts }(config.Hosts)
+	config.Hosts = []HostConfig{{
+		Name:       "artifactory.example.com",
+		Type:       hostTypeArtifactory,
+		APIBase:    server.URL + "/artifactory/api/docker/docker-virtual",
+		PathPrefix: "docker-virtual/",
+		Username:   "mirror",
+		Password:   "secret",
+	}}
+
+	responseContainer := &ResponseContainer{}
+	var client DockerClient
+	client = CreateTestDockerClient(responseContainer)
+	m := mirror{
+		dockerClient: &client,
+		log:          log.WithField("repo", "library/nginx"),
+		repo:         Repository{Name: "library/nginx", Host: "artifactory.example.com"},
+	}
+
+	tags, err := m.getRemoteTags()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(tags) != 2 || tags[0].Name != "1.25" || tags[1].Name != "latest" {
+		t.Errorf("Unexpected tags %+v", tags)
+	}
+
+	if err := m.pullImage("1.25"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if got, want := responseContainer.PullImageOptions.Repository, "artifactory.example.com/docker-virtual/library/nginx"; got != want {
+		t.Errorf("Expected pull from %q, got %q", want, got)
+	}
+
+	if auth := responseContainer.PullImageAuthConfiguration; auth.Username != "mirror" || auth.Password != "secret" || auth.ServerAddress != "artifactory.example.com" {
+		t.Errorf("Unexpected pull credentials %+v", auth)
+	}
+
+	if got, want := m.cleanupImages("1.25", nil, time.Now()), "artifactory.example.com/docker-virtual/library/nginx:1.25"; len(got) != 1 || got[0] != want {
+		t.Errorf("Expected cleanup of %q, got %q", want, got)
+	}
+}
+
+func TestHostTagsAPI(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.RequestURI() {
+		case "/v2/repositories/acme/app/tags/?page_size=100":
+			w.Write([]byte(`{"next":"` + server.URL + `/v2/repositories/acme/app/tags/?page=2","results":[{"name":"1.0","last_updated":"2024-01-01T00:00:00Z"}]}`))
+		case "/v2/repositories/acme/app/tags/?page=2":
+			w.Write([]byte(`{"results":[{"name":"2.0","last_updated":"2024-02-01T00:00:00Z"}]}`))
+		case "/api/v1/repository/acme/app/tag":
+			w.Write([]byte(`{"tags":[{"name":"1.0","last_modified":"2024-01-01T00:00:00Z"},{"name":"2.0","last_modified":"2024-02-01T00:00:00Z"}]}`))
+		case "/v2/acme/app/tags/list":
+			w.Header().Set("Link", `</v2/acme/app/tags/list?last=1.0&n=1>; rel="next"`)
+			w.Write([]byte(`{"name":"acme/app","tags":["1.0"]}`))
+		case "/v2/acme/app/tags/list?last=1.0&n=1":
+			w.Write([]byte(`{"name":"acme/app","tags":["2.0"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer func(hosts []HostConfig) { config.Hosts = hosts }(config.Hosts)
+
+	// the tags of the Docker Hub and Quay styles are sorted newest first, the registry v2
+	// tags list is in registry order
+	tests := map[string][]string{
+		hostTypeDockerHub: {"2.0", "1.0"},
+		hostTypeQuay:      {"2.0", "1.0"},
+		hostTypeRegistry:  {"1.0", "2.0"},
+	}
+
+	for style, want := range tests {
+		config.Hosts = []HostConfig{{Name: "registry.example.com", Type: style, APIBase: server.URL, Auth: hostAuthBearer, Password: "s3cr3t"}}
+		m := mirror{log: log.WithField("repo", "acme/app"), repo: Repository{Name: "acme/app", Host: "registry.example.com"}}
+
+		tags, err := m.getRemoteTags()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", style, err)
+		}
+		if len(tags) != 2 || tags[0].Name != want[0] || tags[1].Name != want[1] {
+			t.Errorf("%s: expected the tags %v, got %+v", style, want, tags)
+		}
+
+		if auth := m.sourceAuth(); auth.RegistryToken != "s3cr3t" || auth.Username != "" {
+			t.Errorf("%s: expected the token to pull, got %+v", style, auth)
+		}
+	}
+}
+
+func TestValidateHosts(t *testing.T) {
+	tests := []struct {
+		hosts []HostConfig
+		ok    bool
+	}{
+		{hosts: []HostConfig{{Name: "artifactory.example.com", Type: hostTypeArtifactory}}, ok: true},
+		{hosts: []HostConfig{{Type: hostTypeArtifactory}}},
+		{hosts: []HostConfig{{Name: quay, Type: hostTypeArtifactory}}},
+		{hosts: []HostConfig{{Name: "nexus.example.com", Type: "nexus"}}},
+		{hosts: []HostConfig{{Name: "registry.example.com"}}, ok: true},
+		{hosts: []HostConfig{{Name: "harbor.example.com", Type: hostTypeDockerHub, Auth: hostAuthNone}}, ok: true},
+		{hosts: []HostConfig{{Name: "quay.example.com", Type: hostTypeQuay, Auth: hostAuthBearer, Password: "token"}}, ok: true},
+		{hosts: []HostConfig{{Name: "quay.example.com", Type: hostTypeQuay, Auth: hostAuthBearer}}},
+		{hosts: []HostConfig{{Name: "quay.example.com", Type: hostTypeQuay, Auth: "oauth"}}},
+	}
+
+	for _, tt := range tests {
+		if err := validateHosts(tt.hosts); (err == nil) != tt.ok {
+			t.Errorf("Expected %+v to be valid %v, got %v", tt.hosts, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the Docker Hub OAuth tenant and client of the docker CLI, replaced in tests
+var (
+	hubOAuthURL      = "https://login.docker.com"
+	hubOAuthClientID = "L4v0dmlNBpYUjGGab0C2JtgTgXr1Qz4d"
+)
+
+const (
+	hubOAuthAudience = "https://hub.docker.com"
+	hubOAuthScope    = "openid offline_access"
+	deviceCodeGrant  = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// hubLogin is the cached Docker Hub login of the `login` command
+type hubLogin struct {
+	Username     string    `json:"username"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// oauthToken is the response of the OAuth token endpoint
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// cachedHubLogin is the login loaded by the run, refreshed when it expires
+var cachedHubLogin struct {
+	mu     sync.Mutex
+	login  *hubLogin
+	loaded bool
+}
+
+// loginCommand logs into Docker Hub with the OAuth device flow, and caches the tokens for
+// the next runs, instead of a password in DOCKERHUB_PASSWORD
+func loginCommand(args []string) {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	logout := flags.Bool("logout", false, "remove the cached Docker Hub login")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror login [--logout]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *logout {
+		if err := deleteHubLogin(); err != nil {
+			log.Fatalf("Could not remove the Docker Hub login: %s", err)
+		}
+		fmt.Println("Removed the Docker Hub login")
+		return
+	}
+
+	login, err := deviceLogin(func(userCode, verificationURL string) {
+		fmt.Printf("Open %s in a browser and confirm the code %s\n", verificationURL, userCode)
+	})
+	if err != nil {
+		log.Fatalf("Could not log into Docker Hub: %s", err)
+	}
+
+	if err := saveHubLogin(login); err != nil {
+		log.Fatalf("Could not save the Docker Hub login: %s", err)
+	}
+
+	fmt.Printf("Logged into Docker Hub as %s\n", login.Username)
+}
+
+// deviceLogin runs the OAuth device flow: the user confirms the code in a browser while
+// the token endpoint is polled
+func deviceLogin(prompt func(userCode, verificationURL string)) (*hubLogin, error) {
+	var device struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_uri_complete"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	err := postForm(hubOAuthURL+"/oauth/device/code", url.Values{
+		"client_id": {hubOAuthClientID},
+		"audience":  {hubOAuthAudience},
+		"scope":     {hubOAuthScope},
+	}, &device)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt(device.UserCode, device.VerificationURL)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	expires := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+	for time.Now().Before(expires) {
+		sleep(interval)
+
+		var token oauthToken
+		err := postForm(hubOAuthURL+"/oauth/token", url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {device.DeviceCode},
+			"client_id":   {hubOAuthClientID},
+		}, &token)
+		if err != nil {
+			return nil, err
+		}
+
+		switch token.Error {
+		case "":
+			return newHubLogin(token, "")
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("%s: %s", token.Error, token.Description)
+		}
+	}
+
+	return nil, errors.New("The code expired before it was confirmed")
+}
+
+// newHubLogin returns the login of a token, the username is a claim of the access token
+func newHubLogin(token oauthToken, refreshToken string) (*hubLogin, error) {
+	if token.RefreshToken != "" {
+		refreshToken = token.RefreshToken
+	}
+
+	login := &hubLogin{AccessToken: token.AccessToken, RefreshToken: refreshToken, ExpiresAt: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)}
+
+	chunk := strings.Split(token.AccessToken, ".")
+	if len(chunk) != 3 {
+		return nil, errors.New("Invalid access token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(chunk[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid access token: %s", err)
+	}
+
+	var claims map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("Invalid access token: %s", err)
+	}
+
+	var hub struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(claims[hubOAuthAudience], &hub); err != nil || hub.Username == "" {
+		return nil, errors.New("The access token has no Docker Hub username")
+	}
+	login.Username = hub.Username
+
+	return login, nil
+}
+
+// refresh returns a new access token for the login
+func (l *hubLogin) refresh() (*hubLogin, error) {
+	var token oauthToken
+	err := postForm(hubOAuthURL+"/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {l.RefreshToken},
+		"client_id":     {hubOAuthClientID},
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Error != "" {
+		return nil, fmt.Errorf("%s: %s", token.Error, token.Description)
+	}
+
+	return newHubLogin(token, l.RefreshToken)
+}
+
+// dockerHubLogin returns the cached login of the `login` command, nil when there is none.
+// The access token is refreshed a minute before it expires
+func dockerHubLogin() *hubLogin {
+	cachedHubLogin.mu.Lock()
+	defer cachedHubLogin.mu.Unlock()
+
+	if !cachedHubLogin.loaded {
+		cachedHubLogin.loaded = true
+
+		login, err := loadHubLogin()
+		if err != nil {
+			log.Warnf("Could not load the Docker Hub login: %s", err)
+		}
+		cachedHubLogin.login = login
+	}
+
+	login := cachedHubLogin.login
+	if login == nil || time.Now().Add(time.Minute).Before(login.ExpiresAt) {
+		return login
+	}
+
+	refreshed, err := login.refresh()
+	if err != nil {
+		log.Warnf("Could not refresh the Docker Hub login, run `docker-mirror login` again: %s", err)
+		cachedHubLogin.login = nil
+		return nil
+	}
+
+	if err := saveHubLogin(refreshed); err != nil {
+		log.Warnf("Could not save the Docker Hub login: %s", err)
+	}
+	cachedHubLogin.login = refreshed
+
+	return refreshed
+}
+
+// postForm posts the form, and decodes the JSON response. OAuth errors are 4xx
+// responses with an `error`, they are decoded too
+func postForm(endpoint string, form url.Values, v interface{}) error {
+	res, err := httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s responded %d", endpoint, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// hubLoginFile is where the login is cached on systems without a keychain
+func hubLoginFile() (string, error) {
+	if file := os.Getenv("DOCKERHUB_LOGIN_FILE"); file != "" {
+		return file, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "docker-mirror", "hub-login.json"), nil
+}
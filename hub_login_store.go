@@ -0,0 +1,74 @@
+// +build !darwin
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// saveHubLogin caches the login in a file only readable by the user
+func saveHubLogin(login *hubLogin) error {
+	file, err := hubLoginFile()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+
+	// the tokens are written to a new file, an existing file could be readable by others
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// loadHubLogin returns the cached login, nil when there is none
+func loadHubLogin() (*hubLogin, error) {
+	file, err := hubLoginFile()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var login hubLogin
+	if err := json.Unmarshal(content, &login); err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
+
+// deleteHubLogin removes the cached login
+func deleteHubLogin() error {
+	file, err := hubLoginFile()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
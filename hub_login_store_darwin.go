@@ -0,0 +1,53 @@
+// +build darwin
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// the keychain item of the Docker Hub login
+const hubLoginServerURL = "https://docker-mirror.hub.docker.com"
+
+// saveHubLogin caches the login in the macOS keychain
+func saveHubLogin(login *hubLogin) error {
+	content, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+
+	// the keychain doesn't replace an existing item
+	if err := deleteHubLogin(); err != nil {
+		return err
+	}
+
+	return keychain.Add(&credentials.Credentials{ServerURL: hubLoginServerURL, Username: login.Username, Secret: string(content)})
+}
+
+// loadHubLogin returns the cached login, nil when there is none
+func loadHubLogin() (*hubLogin, error) {
+	_, secret, err := keychain.Get(hubLoginServerURL)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var login hubLogin
+	if err := json.Unmarshal([]byte(secret), &login); err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
+
+// deleteHubLogin removes the cached login
+func deleteHubLogin() error {
+	if err := keychain.Delete(hubLoginServerURL); err != nil && !credentials.IsErrCredentialsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
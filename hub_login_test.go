@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeAccessToken returns an unsigned JWT with the Docker Hub username claim
+func fakeAccessToken(username string) string {
+	claims, _ := json.Marshal(map[string]interface{}{hubOAuthAudience: map[string]string{"username": username}})
+	return "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+func TestHubDeviceLogin(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case r.URL.Path == "/oauth/device/code":
+			fmt.Fprint(w, `{"device_code": "dc", "user_code": "ABCD-EFGH", "verification_uri_complete": "https://login.docker.com/activate?user_code=ABCD-EFGH", "expires_in": 900, "interval": 5}`)
+		case r.Form.Get("grant_type") == deviceCodeGrant:
+			if polls++; polls == 1 {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"error": "authorization_pending"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": %q, "refresh_token": "rt", "expires_in": 3600}`, fakeAccessToken("jippi"))
+		case r.Form.Get("grant_type") == "refresh_token" && r.Form.Get("refresh_token") == "rt":
+			fmt.Fprintf(w, `{"access_token": %q, "expires_in": 3600}`, fakeAccessToken("jippi"))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": "invalid_grant"}`)
+		}
+	}))
+	defer server.Close()
+
+	defer func(u string) { hubOAuthURL = u }(hubOAuthURL)
+	hubOAuthURL = server.URL
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	sleep = func(time.Duration) {}
+
+	var userCode string
+	login, err := deviceLogin(func(code, _ string) { userCode = code })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userCode != "ABCD-EFGH" || polls != 2 || login.Username != "jippi" || login.RefreshToken != "rt" {
+		t.Errorf("Unexpected login %+v after %d polls", login, polls)
+	}
+
+	// the login is cached in a file only the user can read
+	file := filepath.Join(t.TempDir(), "hub-login.json")
+	os.Setenv("DOCKERHUB_LOGIN_FILE", file)
+	defer os.Unsetenv("DOCKERHUB_LOGIN_FILE")
+
+	login.ExpiresAt = time.Now().Add(-time.Hour)
+	if err := saveHubLogin(login); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the login file to be 0600, got %v (%v)", info.Mode(), err)
+	}
+
+	// an expired access token is refreshed, keeping the refresh token
+	cachedHubLogin.login, cachedHubLogin.loaded = nil, false
+	defer func() { cachedHubLogin.login, cachedHubLogin.loaded = nil, false }()
+
+	refreshed := dockerHubLogin()
+	if refreshed == nil || refreshed.RefreshToken != "rt" || !refreshed.ExpiresAt.After(time.Now()) {
+		t.Fatalf("Expected the login to be refreshed, got %+v", refreshed)
+	}
+
+	saved, err := loadHubLogin()
+	if err != nil || saved.AccessToken != refreshed.AccessToken {
+		t.Errorf("Expected the refreshed login to be saved, got %+v (%v)", saved, err)
+	}
+
+	if err := deleteHubLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := loadHubLogin(); saved != nil || err != nil {
+		t.Errorf("Expected the login to be removed, got %+v (%v)", saved, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the repository Docker Hub documents to read the pull quota, a HEAD of its manifest
+	// doesn't count as a pull
+	hubRateLimitRepository = "ratelimitpreview/test"
+
+	// between two reads of the quota, the pulls of the docker daemon are only estimated
+	hubRateLimitProbeInterval = time.Minute
+
+	defaultHubRateLimitMaxWait = time.Hour
+)
+
+// PullLimitConfig spreads the pulls from Docker Hub over its pull quota
+type PullLimitConfig struct {
+	Enabled bool      `yaml:"enabled,omitempty"`
+	Reserve int       `yaml:"reserve,omitempty"`  // pulls of the quota left to the other users of the account or IP (default: 0)
+	MaxWait *Duration `yaml:"max_wait,omitempty"` // longest wait of a pull for the quota, the tag fails beyond (default: 1h)
+}
+
+// hubPulls is the Docker Hub pull quota, shared by all the workers
+var hubPulls = &pullLimiter{}
+
+// pullLimiter is a token bucket of the Docker Hub pull quota: it is filled with the
+// `ratelimit-remaining` of the responses of Docker Hub (minus the `reserve`), every pull
+// takes a token and the tokens refill at the rate of the quota, `ratelimit-limit` per window.
+// When the bucket is empty the pulls wait for their token, one refill apart
+type pullLimiter struct {
+	mu       sync.Mutex
+	limit    int // pulls per window, 0 when unknown or unlimited
+	window   time.Duration
+	tokens   float64
+	updated  time.Time // of the tokens
+	observed time.Time // last response with the quota headers
+	probed   time.Time // last read of the quota
+}
+
+// parseRateLimit parses a Docker Hub rate limit header, i.e. `100;w=21600`
+func parseRateLimit(header string) (int, time.Duration, bool) {
+	parts := strings.Split(header, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	window := 6 * time.Hour
+	for _, p := range parts[1:] {
+		if s := strings.TrimPrefix(strings.TrimSpace(p), "w="); s != strings.TrimSpace(p) {
+			if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+				window = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return n, window, true
+}
+
+// observe refills the bucket with the quota of a Docker Hub response
+func (l *pullLimiter) observe(header http.Header, now time.Time) {
+	limit, window, ok := parseRateLimit(header.Get("Ratelimit-Limit"))
+	remaining, _, ok2 := parseRateLimit(header.Get("Ratelimit-Remaining"))
+	if !ok || !ok2 || limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit, l.window, l.updated, l.observed = limit, window, now, now
+	l.tokens = float64(remaining - config.HubRateLimit.Reserve)
+}
+
+// take takes a token for a pull, and returns how long the pull waits for it. Returns an
+// error when the wait is longer than the `max_wait`
+func (l *pullLimiter) take(now time.Time) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit == 0 {
+		return 0, nil
+	}
+
+	rate := float64(l.limit) / l.window.Seconds()
+	l.tokens += now.Sub(l.updated).Seconds() * rate
+	if max := float64(l.limit - config.HubRateLimit.Reserve); l.tokens > max {
+		l.tokens = max
+	}
+	l.updated = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0, nil
+	}
+
+	wait := time.Duration(-l.tokens / rate * float64(time.Second))
+	maxWait := defaultHubRateLimitMaxWait
+	if config.HubRateLimit.MaxWait != nil {
+		maxWait = time.Duration(*config.HubRateLimit.MaxWait)
+	}
+	if wait > maxWait {
+		l.tokens++
+		return 0, fmt.Errorf("The Docker Hub pull quota is exhausted, the next pull is available in %s (`max_wait` is %s)", wait.Round(time.Second), maxWait)
+	}
+
+	return wait, nil
+}
+
+// startProbe returns true when the quota should be read again, a single worker reads it
+func (l *pullLimiter) startProbe(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.probed) < hubRateLimitProbeInterval {
+		return false
+	}
+
+	l.probed = now
+	return true
+}
+
+// finishProbe records a read of the quota started at start, without the quota headers
+// the pulls of the account (i.e. a paid one) are unlimited
+func (l *pullLimiter) finishProbe(start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.observed.Before(start) {
+		l.limit = 0
+	}
+}
+
+// wait blocks until the quota has a pull for the tag. The quota is read again every minute,
+// with the credentials of the pulls, as the pulls of the docker daemon only lower the estimate
+func (l *pullLimiter) wait(logger *log.Entry, auth docker.AuthConfiguration) error {
+	if start := time.Now(); l.startProbe(start) {
+		// the quota headers of the response are observed by the registry client
+		if _, err := registryClientFor(dockerHubRegistry, auth, false).manifestExists(hubRateLimitRepository, "latest"); err != nil {
+			logger.Warnf("Failed to read the Docker Hub pull quota: %s", err)
+		} else {
+			l.finishProbe(start)
+		}
+	}
+
+	wait, err := l.take(time.Now())
+	if err != nil || wait == 0 {
+		return err
+	}
+
+	logger.Infof("Waiting %s for the Docker Hub pull quota", wait.Round(time.Second))
+	runState.pause(dockerHubRegistry, "Docker Hub pull quota", wait)
+	return nil
+}
+
+// waitPullQuota waits for the Docker Hub pull quota with `hub_rate_limit`, before pulling
+// the tag from Docker Hub
+func (m *mirror) waitPullQuota() error {
+	if !config.HubRateLimit.Enabled || m.repo.Host != dockerHub {
+		return nil
+	}
+	if host, _ := splitReference(m.sourceRepository()); host != dockerHubRegistry {
+		return nil
+	}
+
+	return hubPulls.wait(m.log, m.sourceAuth())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	if n, window, ok := parseRateLimit("100;w=21600"); !ok || n != 100 || window != 6*time.Hour {
+		t.Errorf("Expected 100 per 6h, got %d per %s", n, window)
+	}
+	if n, window, ok := parseRateLimit("76"); !ok || n != 76 || window != 6*time.Hour {
+		t.Errorf("Expected 76 per 6h, got %d per %s", n, window)
+	}
+	if _, _, ok := parseRateLimit(""); ok {
+		t.Error("Expected an empty header to be invalid")
+	}
+}
+
+func TestPullLimiter(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	maxWait := Duration(7 * time.Minute)
+	config = Config{HubRateLimit: PullLimitConfig{Enabled: true, Reserve: 1, MaxWait: &maxWait}}
+
+	l := &pullLimiter{}
+	now := time.Now()
+	if wait, err := l.take(now); wait != 0 || err != nil {
+		t.Errorf("Expected unknown quotas to be unlimited, got %s %v", wait, err)
+	}
+
+	header := http.Header{}
+	header.Set("Ratelimit-Limit", "100;w=21600")
+	header.Set("Ratelimit-Remaining", "2;w=21600")
+	l.observe(header, now)
+
+	// 2 pulls left, 1 of them reserved
+	if wait, err := l.take(now); wait != 0 || err != nil {
+		t.Errorf("Expected a pull, got %s %v", wait, err)
+	}
+
+	// then the pulls are staggered by the refill of the quota, 100 pulls per 6h
+	if wait, err := l.take(now); wait != 216*time.Second || err != nil {
+		t.Errorf("Expected a 216s wait, got %s %v", wait, err)
+	}
+	if wait, err := l.take(now.Add(time.Minute)); wait != 372*time.Second || err != nil {
+		t.Errorf("Expected a 372s wait, got %s %v", wait, err)
+	}
+	if _, err := l.take(now.Add(time.Minute)); err == nil {
+		t.Error("Expected a wait longer than max_wait to fail")
+	}
+
+	// a read of the quota without the headers is an unlimited account
+	l.finishProbe(now.Add(time.Second))
+	if wait, err := l.take(now.Add(time.Minute)); wait != 0 || err != nil {
+		t.Errorf("Expected unlimited pulls, got %s %v", wait, err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// the platform the docker daemon pulls from an index without `platforms`
+var defaultPullPlatform = platform{OS: "linux", Architecture: "amd64"}
+
+// imageSize returns the size of the manifest of the reference, its config and its layers as
+// stored in the targets. The size of an index is the size of the platforms mirrored: the
+// first of the `platforms` of the repository (default linux/amd64) with the docker daemon,
+// the `platforms` (default all) in the daemonless mode
+func imageSize(src *registryClient, repository, reference string, platforms []string, daemonless bool) (int64, error) {
+	body, _, _, err := src.manifest(repository, reference)
+	if err != nil {
+		return 0, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return 0, fmt.Errorf("Could not parse manifest %s:%s: %s", repository, reference, err)
+	}
+
+	size := int64(len(body))
+	if m.Config != nil {
+		size += m.Config.Size
+	}
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+
+	if len(m.Manifests) == 0 {
+		return size, nil
+	}
+
+	var wanted []platform
+	for _, s := range platforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			return 0, err
+		}
+		wanted = append(wanted, p)
+	}
+	if !daemonless {
+		wanted = append(wanted, defaultPullPlatform)[:1]
+	}
+
+	for _, d := range m.Manifests {
+		selected := len(wanted) == 0
+		for _, p := range wanted {
+			selected = selected || d.Platform.matches(p)
+		}
+		if !selected {
+			continue
+		}
+
+		n, err := imageSize(src, repository, d.Digest, nil, true)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+
+		// the docker daemon pulls a single platform
+		if !daemonless {
+			break
+		}
+	}
+
+	return size, nil
+}
+
+// checkImageSize returns why the tag is skipped when it is larger than the `max_image_size`
+// of the repository, before anything is pulled. Empty when it can be mirrored
+func (m *mirror) checkImageSize(tr *tagReport, tag string) (string, error) {
+	if m.repo.MaxImageSize <= 0 {
+		return "", nil
+	}
+
+	srcHost, srcRepository := splitReference(m.sourceRepository())
+	src := registryClientFor(srcHost, m.sourceAuth(), false)
+
+	reference := tag
+	if m.signedDigest != "" {
+		reference = m.signedDigest
+	}
+
+	size, err := imageSize(src, srcRepository, reference, m.repo.Platforms, config.Daemonless || m.repo.Artifacts)
+	if err != nil {
+		return "", fmt.Errorf("Could not get the size of %s: %s", tag, err)
+	}
+	tr.ImageSize = size
+
+	if size > int64(m.repo.MaxImageSize) {
+		return fmt.Sprintf("The image is %s, larger than the max_image_size of %s", humanBytes(size), m.repo.MaxImageSize), nil
+	}
+
+	return "", nil
+}
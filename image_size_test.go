@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestImageSize(t *testing.T) {
+	source := newFakeRegistry()
+	sizes := map[string]int64{}
+	var entries []descriptor
+	for i, arch := range []string{"amd64", "arm64"} {
+		body := source.image("library/redis", arch, bytes.Repeat([]byte("x"), 1000*(i+1)))
+		source.manifests["library/redis:"+sha256Digest(body)] = body
+		sizes[arch] = int64(len(body)) + int64(len(`{"architecture":"amd64"}`)) + int64(1000*(i+1))
+
+		entries = append(entries, descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: sha256Digest(body), Size: int64(len(body)), Platform: &platform{OS: "linux", Architecture: arch}})
+	}
+	index, _ := json.Marshal(map[string]interface{}{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": entries})
+	source.manifests["library/redis:7"] = index
+
+	server := httptest.NewServer(source)
+	defer server.Close()
+	src := newRegistryClient(strings.TrimPrefix(server.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	tests := []struct {
+		reference  string
+		platforms  []string
+		daemonless bool
+		size       int64
+	}{
+		{"amd64", nil, false, sizes["amd64"]},
+		{"7", nil, false, int64(len(index)) + sizes["amd64"]},
+		{"7", []string{"linux/arm64"}, false, int64(len(index)) + sizes["arm64"]},
+		{"7", nil, true, int64(len(index)) + sizes["amd64"] + sizes["arm64"]},
+	}
+
+	for _, tt := range tests {
+		size, err := imageSize(src, "library/redis", tt.reference, tt.platforms, tt.daemonless)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != tt.size {
+			t.Errorf("%s %v (daemonless: %v): expected %d bytes, got %d", tt.reference, tt.platforms, tt.daemonless, tt.size, size)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFiles returns the files making up the config: every .yaml and .yml file of a
+// directory, in name order, or the file followed by the files matching its `include` globs
+func configFiles(path string) ([]string, error) {
+	if isRemoteConfig(path) {
+		return []string{path}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s", err)
+	}
+
+	if info.IsDir() {
+		var files []string
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+
+		if len(files) == 0 {
+			return nil, fmt.Errorf("Config directory %s has no .yaml or .yml file", path)
+		}
+
+		return files, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s", err)
+	}
+
+	// parse errors are reported when the file itself is loaded
+	var root struct {
+		Include []string `yaml:"include"`
+	}
+	yaml.Unmarshal(content, &root)
+
+	files := []string{path}
+	seen := map[string]bool{filepath.Clean(path): true}
+	for _, pattern := range root.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include %q: %s", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			if !seen[filepath.Clean(match)] {
+				seen[filepath.Clean(match)] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// mergeConfig merges the config fragment read from file into config. Lists (repositories,
+// targets, hosts) are appended, any other setting can only be set by a single fragment.
+// settings records which file set each setting, and repositories which file configured
+// each repository, across the calls
+func mergeConfig(config *Config, fragment Config, file string, settings, repositories map[string]string) error {
+	for _, repo := range fragment.Repositories {
+		if other, ok := repositories[repo.Name]; ok && other != file {
+			return fmt.Errorf("Repository %s is configured in both %s and %s", repo.Name, other, file)
+		}
+		repositories[repo.Name] = file
+	}
+
+	dst := reflect.ValueOf(config).Elem()
+	src := reflect.ValueOf(fragment)
+	for i := 0; i < dst.NumField(); i++ {
+		field := src.Field(i)
+		if field.IsZero() {
+			continue
+		}
+
+		if field.Kind() == reflect.Slice {
+			dst.Field(i).Set(reflect.AppendSlice(dst.Field(i), field))
+			continue
+		}
+
+		key := strings.Split(dst.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if other, ok := settings[key]; ok {
+			return fmt.Errorf("The `%s` setting is set in both %s and %s", key, other, file)
+		}
+		settings[key] = file
+		dst.Field(i).Set(field)
+	}
+
+	return nil
+}
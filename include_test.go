@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"00-settings.yaml": "target:\n  registry: registry.example.com\nworkers: 4\n",
+		"search.yml":       "repositories:\n  - name: elasticsearch\n",
+		"cache.yaml":       "cleanup: true\nrepositories:\n  - name: redis\n  - name: memcached\n",
+		"README.md":        "not a config file",
+	})
+
+	if err := loadConfig(dir); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var names []string
+	for _, repo := range config.Repositories {
+		names = append(names, repo.Name)
+	}
+
+	if got := strings.Join(names, ","); got != "redis,memcached,elasticsearch" {
+		t.Errorf("Expected the repositories of every file in name order, got %s", got)
+	}
+
+	if config.Target.Registry != "registry.example.com" || config.Workers != 4 || !config.Cleanup {
+		t.Errorf("Expected the settings of every file, got %+v", config)
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml":         "include:\n  - teams/*.yaml\ntarget:\n  registry: registry.example.com\nrepositories:\n  - name: redis\n",
+		"teams/search.yaml":   "repositories:\n  - name: elasticsearch\n",
+		"teams/platform.yaml": "hosts:\n  - name: artifactory.example.com\n    type: artifactory\n",
+	})
+
+	if err := loadConfig(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(config.Repositories) != 2 || config.Repositories[1].Name != "elasticsearch" {
+		t.Errorf("Expected the included repositories, got %+v", config.Repositories)
+	}
+
+	if len(config.Hosts) != 1 {
+		t.Errorf("Expected the included hosts, got %+v", config.Hosts)
+	}
+}
+
+func TestLoadConfigConflicts(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	tests := map[string]struct {
+		files map[string]string
+		err   string
+	}{
+		"duplicate repository": {
+			map[string]string{
+				"a.yaml": "repositories:\n  - name: redis\n",
+				"b.yaml": "repositories:\n  - name: elasticsearch\n  - name: redis\n",
+			},
+			"Repository redis is configured in both",
+		},
+		"duplicate setting": {
+			map[string]string{
+				"a.yaml": "target:\n  registry: a.example.com\n",
+				"b.yaml": "target:\n  registry: b.example.com\n",
+			},
+			"The `target` setting is set in both",
+		},
+		"nested include": {
+			map[string]string{
+				"a.yaml": "include: [\"*.yaml\"]\n",
+			},
+			"can't use `include`",
+		},
+		"invalid yaml": {
+			map[string]string{
+				"a.yaml": "repositories: [\n",
+			},
+			"Could not parse config file",
+		},
+	}
+
+	for name, tt := range tests {
+		err := loadConfig(writeConfigFiles(t, tt.files))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error %q, got %v", name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	formatSkopeoSync = "skopeo-sync"
+	formatRegsync    = "regsync"
+)
+
+// skopeoSyncConfig is the `skopeo sync --src yaml` format, keyed by source registry
+type skopeoSyncConfig map[string]skopeoSyncRegistry
+
+// skopeoSyncRegistry is the skopeo sync configuration of a single source registry
+type skopeoSyncRegistry struct {
+	Images           map[string][]string    `yaml:"images,omitempty"`
+	ImagesByTagRegex map[string]string      `yaml:"images-by-tag-regex,omitempty"`
+	Credentials      *skopeoSyncCredentials `yaml:"credentials,omitempty"`
+	TLSVerify        *bool                  `yaml:"tls-verify,omitempty"`
+}
+
+// skopeoSyncCredentials are the credentials skopeo uses for a source registry
+type skopeoSyncCredentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// regsyncConfig is the regclient `regsync` configuration format
+type regsyncConfig struct {
+	Version int           `yaml:"version"`
+	Sync    []regsyncSync `yaml:"sync"`
+}
+
+// regsyncSync is a single regsync sync entry
+type regsyncSync struct {
+	Source string      `yaml:"source"`
+	Target string      `yaml:"target"`
+	Type   string      `yaml:"type"`
+	Tags   regsyncTags `yaml:"tags"`
+}
+
+// regsyncTags holds the regsync tag filters, both are lists of regular expressions matching the whole tag
+type regsyncTags struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// matches the regular expression syntax we can't express as a glob, a `.` or `*`
+// left after replacing `.*` is a regex wildcard or quantifier, not a glob one
+var regexMetaRE = regexp.MustCompile(`[\\\[\](){}|+?^$.*]`)
+
+// exportCommand converts the docker-mirror config into a skopeo sync config
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := configFlag(flags)
+	format := flags.String("format", formatSkopeoSync, "format to export to (skopeo-sync)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror export --format skopeo-sync\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *format != formatSkopeoSync {
+		log.Fatalf("Unknown export format %q, we support %s", *format, formatSkopeoSync)
+	}
+
+	if err := loadConfig(*configFile); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := yaml.Marshal(exportSkopeoSync(config))
+	if err != nil {
+		log.Fatalf("Could not render skopeo sync config: %s", err)
+	}
+
+	fmt.Print("---\n" + string(out))
+}
+
+// exportSkopeoSync converts the config into a skopeo sync config, filters skopeo
+// can't express (ignore_tag, max_tags, max_tag_age, remote tag sources) are logged
+func exportSkopeoSync(cfg Config) skopeoSyncConfig {
+	// registry -> repository name -> tags (empty for all tags)
+	res := map[string]map[string][]string{}
+
+	// registry -> repository name -> match_tag_regex
+	regexes := map[string]map[string]string{}
+
+	for _, repo := range cfg.Repositories {
+		name, digests := repo.pinnedDigests()
+		tags := repo.MatchTags
+		if len(repo.Tags) > 0 || len(digests) > 0 {
+			tags = repo.Tags
+			for _, d := range digests {
+				tags = append(tags, d.Digest)
+			}
+		}
+		if strings.Contains(name, ":") {
+			chunk := strings.SplitN(name, ":", 2)
+			name, tags = chunk[0], []string{chunk[1]}
+		}
+
+		if repo.RemoteTagSource != "" && repo.RemoteTagSource != remoteTagSourceRegistry {
+			log.Warnf("Skipping %s, remote_tags_source %q can't be exported", name, repo.RemoteTagSource)
+			continue
+		}
+
+		// the synced images are named after their source, they can't be renamed
+		if repo.Source != "" {
+			log.Warnf("Skipping %s, its source %s can't be exported", name, repo.Source)
+			continue
+		}
+
+		if len(repo.DropTags) > 0 || len(repo.IgnoreTagRegex) > 0 || repo.Semver != "" || repo.Keep != nil || len(repo.TagMap) > 0 || repo.MaxTags > 0 || repo.MaxTagAge != nil {
+			log.Warnf("Exporting %s without ignore_tag, ignore_tag_regex, semver, keep, tag_map, max_tags and max_tag_age, skopeo sync doesn't support them", name)
+		}
+
+		registry := exportRegistry(repo)
+		if res[registry] == nil {
+			res[registry] = map[string][]string{}
+		}
+
+		// skopeo has a single tag regex per repository, which can't be combined with tags
+		if len(repo.MatchTagRegex) > 0 {
+			if _, seen := res[registry][name]; seen || len(tags) > 0 || regexes[registry][name] != "" {
+				log.Warnf("Skipping %s, its match_tag_regex can't be combined with other tags in skopeo sync", name)
+				continue
+			}
+
+			if regexes[registry] == nil {
+				regexes[registry] = map[string]string{}
+			}
+			regexes[registry][name] = joinRegexes(repo.MatchTagRegex)
+			continue
+		}
+
+		if regexes[registry][name] != "" {
+			log.Warnf("Skipping %s, its tags can't be combined with the match_tag_regex of another entry in skopeo sync", name)
+			continue
+		}
+
+		// the same repository can be listed several times (e.g. redis:6 and redis:7), merge their tags
+
+		existing, seen := res[registry][name]
+		switch {
+		case seen && len(existing) == 0:
+			// already mirroring all tags
+		case len(tags) == 0:
+			res[registry][name] = []string{}
+		default:
+			res[registry][name] = append(append([]string{}, existing...), tags...)
+		}
+	}
+
+	out := skopeoSyncConfig{}
+	for registry, images := range res {
+		reg := skopeoSyncRegistry{}
+		for name, tags := range images {
+			if strings.Contains(strings.Join(tags, ""), "*") {
+				if reg.ImagesByTagRegex == nil {
+					reg.ImagesByTagRegex = map[string]string{}
+				}
+				reg.ImagesByTagRegex[name] = globsToRegex(tags)
+				continue
+			}
+
+			if reg.Images == nil {
+				reg.Images = map[string][]string{}
+			}
+			reg.Images[name] = tags
+		}
+
+		for name, regex := range regexes[registry] {
+			if reg.ImagesByTagRegex == nil {
+				reg.ImagesByTagRegex = map[string]string{}
+			}
+			reg.ImagesByTagRegex[name] = regex
+		}
+		out[registry] = reg
+	}
+
+	return out
+}
+
+// exportRegistry returns the registry host skopeo should pull the repository from
+func exportRegistry(repo Repository) string {
+	switch repo.Host {
+	case "", dockerHub:
+		if repo.PrivateRegistry != "" {
+			return repo.PrivateRegistry
+		}
+		return "docker.io"
+	}
+
+	return repo.Host
+}
+
+// globsToRegex converts a list of tag globs into a single anchored regular expression
+func globsToRegex(globs []string) string {
+	parts := make([]string, 0, len(globs))
+	for _, g := range globs {
+		quoted := make([]string, 0)
+		for _, chunk := range strings.Split(g, "*") {
+			quoted = append(quoted, regexp.QuoteMeta(chunk))
+		}
+		parts = append(parts, strings.Join(quoted, ".*"))
+	}
+
+	return "^(" + strings.Join(parts, "|") + ")$"
+}
+
+// joinRegexes combines regular expressions into one matching any of them
+func joinRegexes(regexes []string) string {
+	if len(regexes) == 1 {
+		return regexes[0]
+	}
+
+	parts := make([]string, 0, len(regexes))
+	for _, re := range regexes {
+		parts = append(parts, "(?:"+re+")")
+	}
+
+	return strings.Join(parts, "|")
+}
+
+// importCommand converts a skopeo sync or regsync config into a docker-mirror config
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "format of the file to import (skopeo-sync or regsync)")
+	registry := flags.String("target", "ACCOUNT_ID.dkr.ecr.REGION.amazonaws.com", "target registry, used when the imported format doesn't define one")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror import --format skopeo-sync|regsync [--target registry] <file>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	content, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Could not read %s: %s", flags.Arg(0), err)
+	}
+
+	var imported Config
+	switch *format {
+	case formatSkopeoSync:
+		imported, err = importSkopeoSync(content, *registry)
+	case formatRegsync:
+		imported, err = importRegsync(content, *registry)
+	default:
+		log.Fatalf("Unknown import format %q, we support %s and %s", *format, formatSkopeoSync, formatRegsync)
+	}
+	if err != nil {
+		log.Fatalf("Could not import %s: %s", flags.Arg(0), err)
+	}
+
+	out, err := yaml.Marshal(imported)
+	if err != nil {
+		log.Fatalf("Could not render config: %s", err)
+	}
+
+	fmt.Print("---\n" + string(out))
+}
+
+// importSkopeoSync converts a skopeo sync YAML file
+func importSkopeoSync(content []byte, registry string) (Config, error) {
+	var src skopeoSyncConfig
+	if err := yaml.Unmarshal(content, &src); err != nil {
+		return Config{}, err
+	}
+
+	res := Config{Target: TargetConfig{Registry: registry}}
+
+	// map iteration is random, sort to get a stable output
+	for _, sourceRegistry := range sortedKeys(src) {
+		reg := src[sourceRegistry]
+
+		host, ok := importHost(sourceRegistry)
+		if !ok {
+			log.Warnf("Skipping registry %s, we support %s, %s, %s, and %s", sourceRegistry, dockerHub, quay, gcr, k8s)
+			continue
+		}
+
+		if reg.Credentials != nil {
+			log.Warnf("Ignoring credentials for registry %s, configure them through the environment instead", sourceRegistry)
+		}
+
+		names := make([]string, 0, len(reg.Images))
+		for name := range reg.Images {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			repo := Repository{Name: name, Host: host}
+
+			// an empty tag list means all tags
+			if len(reg.Images[name]) > 0 {
+				repo.MatchTags = reg.Images[name]
+			}
+
+			res.Repositories = append(res.Repositories, repo)
+		}
+
+		names = names[:0]
+		for name := range reg.ImagesByTagRegex {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			repo := Repository{Name: name, Host: host}
+
+			// skopeo matches the tag regex anywhere in the tag, like match_tag_regex
+			if pattern, ok := regexToGlob(reg.ImagesByTagRegex[name], false); ok {
+				repo.MatchTags = []string{pattern}
+			} else {
+				repo.MatchTagRegex = []string{reg.ImagesByTagRegex[name]}
+			}
+
+			res.Repositories = append(res.Repositories, repo)
+		}
+	}
+
+	return res, nil
+}
+
+// importRegsync converts a regsync YAML file
+func importRegsync(content []byte, registry string) (Config, error) {
+	var src regsyncConfig
+	if err := yaml.Unmarshal(content, &src); err != nil {
+		return Config{}, err
+	}
+
+	res := Config{}
+
+	for _, entry := range src.Sync {
+		sourceRegistry, name, tag := splitImageReference(entry.Source)
+
+		host, ok := importHost(sourceRegistry)
+		if !ok {
+			log.Warnf("Skipping %s, we support %s, %s, %s, and %s", entry.Source, dockerHub, quay, gcr, k8s)
+			continue
+		}
+
+		repo := Repository{Name: name, Host: host}
+
+		// the target registry is taken from the first entry, the remaining path
+		// in front of the repository name becomes the prefix
+		targetRegistry, targetName, _ := splitImageReference(entry.Target)
+		if !strings.HasSuffix(targetName, name) {
+			log.Warnf("Skipping %s, target %s doesn't end with the source repository name", entry.Source, entry.Target)
+			continue
+		}
+
+		prefix := strings.TrimSuffix(targetName, name)
+		if res.Target.Registry == "" {
+			res.Target = TargetConfig{Registry: targetRegistry, Prefix: prefix}
+		} else if targetRegistry != res.Target.Registry {
+			log.Warnf("Skipping %s, all targets must use the same registry (%s)", entry.Source, res.Target.Registry)
+			continue
+		} else if prefix != res.Target.Prefix {
+			repo.TargetPrefix = &prefix
+		}
+
+		// regsync syncs `latest` for image entries without a tag
+		if entry.Type == "image" {
+			if tag == "" {
+				tag = "latest"
+			}
+			repo.MatchTags = []string{tag}
+		}
+
+		// a tag is allowed when it matches any allow filter, as soon as one of them can't be
+		// converted to a glob they are all imported as regexes
+		var allowGlobs []string
+		for _, allow := range entry.Tags.Allow {
+			pattern, ok := regexToGlob(allow, true)
+			if !ok {
+				allowGlobs = nil
+				for _, allow := range entry.Tags.Allow {
+					repo.MatchTagRegex = append(repo.MatchTagRegex, anchorRegex(allow))
+				}
+				break
+			}
+			allowGlobs = append(allowGlobs, pattern)
+		}
+		repo.MatchTags = append(repo.MatchTags, allowGlobs...)
+
+		for _, deny := range entry.Tags.Deny {
+			if pattern, ok := regexToGlob(deny, true); ok {
+				repo.DropTags = append(repo.DropTags, pattern)
+			} else {
+				repo.IgnoreTagRegex = append(repo.IgnoreTagRegex, anchorRegex(deny))
+			}
+		}
+
+		res.Repositories = append(res.Repositories, repo)
+	}
+
+	if res.Target.Registry == "" {
+		res.Target.Registry = registry
+	}
+
+	return res, nil
+}
+
+// anchorRegex anchors a regular expression matching the whole tag, as regsync filters do
+func anchorRegex(re string) string {
+	return "^(?:" + re + ")$"
+}
+
+// importHost maps a registry host to a host docker-mirror can mirror from
+func importHost(registry string) (string, bool) {
+	switch registry {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", dockerHub:
+		return dockerHub, true
+	case quay, gcr, k8s:
+		return registry, true
+	}
+
+	return "", false
+}
+
+// splitImageReference splits an image reference into registry, repository name and tag,
+// e.g. quay.io/coreos/etcd:latest becomes quay.io, coreos/etcd and latest
+func splitImageReference(ref string) (registry, name, tag string) {
+	name = ref
+	if i := strings.Index(name, "/"); i > 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			registry = first
+			name = name[i+1:]
+		}
+	}
+
+	if i := strings.LastIndex(name, ":"); i > 0 && !strings.Contains(name[i:], "/") {
+		tag = name[i+1:]
+		name = name[:i]
+	}
+
+	return registry, name, tag
+}
+
+// regexToGlob converts simple regular expressions (literals and `.*` wildcards) to a glob,
+// unless anchored is set, a regex matches anywhere in the tag unless it starts with `^` or ends with `$`
+func regexToGlob(re string, anchored bool) (string, bool) {
+	pattern := re
+	start, end := anchored, anchored
+	if strings.HasPrefix(pattern, "^") {
+		pattern = pattern[1:]
+		start = true
+	}
+	if strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, `\$`) {
+		pattern = pattern[:len(pattern)-1]
+		end = true
+	}
+
+	pattern = strings.ReplaceAll(pattern, `\.`, "\x00")
+	pattern = strings.ReplaceAll(pattern, ".*", "\x01")
+
+	if regexMetaRE.MatchString(pattern) {
+		return "", false
+	}
+
+	pattern = strings.ReplaceAll(pattern, "\x00", ".")
+	pattern = strings.ReplaceAll(pattern, "\x01", "*")
+
+	if !start && !strings.HasPrefix(pattern, "*") {
+		pattern = "*" + pattern
+	}
+	if !end && !strings.HasSuffix(pattern, "*") {
+		pattern = pattern + "*"
+	}
+
+	return pattern, true
+}
+
+func sortedKeys(m skopeoSyncConfig) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportSkopeoSync(t *testing.T) {
+	content := []byte(`
+docker.io:
+  images:
+    busybox: []
+    redis:
+      - "1.0"
+      - "2.0"
+  images-by-tag-regex:
+    nginx: ^1\.13\..*$
+    postgres: ^1[0-9]\.[0-9]+$
+quay.io:
+  tls-verify: false
+  images:
+    coreos/etcd:
+      - latest
+registry.example.com:
+  images:
+    foo: []
+`)
+
+	got, err := importSkopeoSync(content, "123456789012.dkr.ecr.us-east-1.amazonaws.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := []Repository{
+		{Name: "busybox", Host: dockerHub},
+		{Name: "redis", Host: dockerHub, MatchTags: []string{"1.0", "2.0"}},
+		{Name: "nginx", Host: dockerHub, MatchTags: []string{"1.13.*"}},
+		{Name: "postgres", Host: dockerHub, MatchTagRegex: []string{`^1[0-9]\.[0-9]+$`}},
+		{Name: "coreos/etcd", Host: quay, MatchTags: []string{"latest"}},
+	}
+
+	if !reflect.DeepEqual(got.Repositories, want) {
+		t.Errorf("Expected %+v, got %+v", want, got.Repositories)
+	}
+
+	if got.Target.Registry != "123456789012.dkr.ecr.us-east-1.amazonaws.com" {
+		t.Errorf("Unexpected target registry %q", got.Target.Registry)
+	}
+}
+
+func TestImportRegsync(t *testing.T) {
+	content := []byte(`
+version: 1
+sync:
+  - source: busybox:latest
+    target: registry.example.com/hub/busybox:latest
+    type: image
+  - source: alpine
+    target: registry.example.com/hub/alpine
+    type: image
+  - source: quay.io/coreos/etcd
+    target: registry.example.com/quay/coreos/etcd
+    type: repository
+    tags:
+      allow:
+        - "v3.*"
+      deny:
+        - ".*-rc.*"
+  - source: quay.io/prometheus/prometheus
+    target: registry.example.com/quay/prometheus/prometheus
+    type: repository
+    tags:
+      allow:
+        - "latest"
+        - "v2\\.[0-9]+\\.[0-9]+"
+      deny:
+        - ".*-rc\\.[0-9]+"
+`)
+
+	got, err := importRegsync(content, "unused")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	quayPrefix := "quay/"
+	want := Config{
+		Target: TargetConfig{Registry: "registry.example.com", Prefix: "hub/"},
+		Repositories: []Repository{
+			{Name: "busybox", Host: dockerHub, MatchTags: []string{"latest"}},
+			{Name: "alpine", Host: dockerHub, MatchTags: []string{"latest"}},
+			{Name: "coreos/etcd", Host: quay, MatchTags: []string{"v3*"}, DropTags: []string{"*-rc*"}, TargetPrefix: &quayPrefix},
+			{Name: "prometheus/prometheus", Host: quay, MatchTagRegex: []string{"^(?:latest)$", `^(?:v2\.[0-9]+\.[0-9]+)$`}, IgnoreTagRegex: []string{`^(?:.*-rc\.[0-9]+)$`}, TargetPrefix: &quayPrefix},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestSplitImageReference(t *testing.T) {
+	tests := map[string][3]string{
+		"busybox":                  {"", "busybox", ""},
+		"library/redis:7":          {"", "library/redis", "7"},
+		"quay.io/coreos/etcd:v3":   {"quay.io", "coreos/etcd", "v3"},
+		"localhost:5000/foo/bar":   {"localhost:5000", "foo/bar", ""},
+		"localhost:5000/foo:1.2.3": {"localhost:5000", "foo", "1.2.3"},
+	}
+
+	for ref, want := range tests {
+		registry, name, tag := splitImageReference(ref)
+		if got := [3]string{registry, name, tag}; got != want {
+			t.Errorf("Expected %q to split into %q, got %q", ref, want, got)
+		}
+	}
+}
+
+func TestRegexToGlob(t *testing.T) {
+	tests := []struct {
+		re       string
+		anchored bool
+		want     string
+		ok       bool
+	}{
+		{re: `^1\.13\..*$`, want: "1.13.*", ok: true},
+		{re: `1\.13`, want: "*1.13*", ok: true},
+		{re: `^v1\.13`, want: "v1.13*", ok: true},
+		{re: `-alpine$`, want: "*-alpine", ok: true},
+		{re: `.*-rc.*`, anchored: true, want: "*-rc*", ok: true},
+		{re: `v3\..*`, anchored: true, want: "v3.*", ok: true},
+		{re: `^v1*$`, ok: false},
+		{re: `^v1\.*$`, ok: false},
+		{re: `3.4`, ok: false},
+		{re: `^1\.13\.[12]-alpine$`, ok: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := regexToGlob(tt.re, tt.anchored)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("Expected regexToGlob(%q, %v) to be (%q, %v), got (%q, %v)", tt.re, tt.anchored, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestExportSkopeoSync(t *testing.T) {
+	cfg := Config{
+		Repositories: []Repository{
+			{Name: "elasticsearch", MatchTags: []string{"5.6.8", "6.*"}},
+			{Name: "redis", MatchTags: []string{"7.2", "latest"}},
+			{Name: "redis:6"},
+			{Name: "jippi/go-metadataproxy", PrivateRegistry: "private-registry-name"},
+			{Name: "coreos/etcd", Host: quay},
+			{Name: "jippi/hashi-ui", RemoteTagSource: "github"},
+			{Name: "kubectl", MatchTagRegex: []string{`^v1\.2[0-9]\.`, "^latest$"}},
+			{Name: "postgres", MatchTagRegex: []string{`^1[0-9]$`}},
+			{Name: "postgres", MatchTags: []string{"latest"}},
+		},
+	}
+
+	got := exportSkopeoSync(cfg)
+	want := skopeoSyncConfig{
+		"docker.io": {
+			Images:           map[string][]string{"redis": {"7.2", "latest", "6"}},
+			ImagesByTagRegex: map[string]string{"elasticsearch": `^(5\.6\.8|6\..*)$`, "kubectl": `(?:^v1\.2[0-9]\.)|(?:^latest$)`, "postgres": `^1[0-9]$`},
+		},
+		"private-registry-name": {Images: map[string][]string{"jippi/go-metadataproxy": {}}},
+		quay:                    {Images: map[string][]string{"coreos/etcd": {}}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
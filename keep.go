@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// KeepConfig is a retention rule on the semantic version of the tags, e.g. the latest
+// patch of each of the last 3 minor versions is `{per_minor: 1, minors: 3}`
+type KeepConfig struct {
+	Majors   int `yaml:"majors,omitempty"`    // only the N newest major versions
+	PerMajor int `yaml:"per_major,omitempty"` // the N newest tags of each major version
+	Minors   int `yaml:"minors,omitempty"`    // only the N newest minor versions
+	PerMinor int `yaml:"per_minor,omitempty"` // the N newest tags of each minor version
+}
+
+// apply returns the tags kept by the rule, in their original order. Only the releases are
+// kept, prereleases and variants (i.e. 1.25.0-rc.1 or 7.2-alpine) are dropped, as are the
+// tags that aren't semantic versions unless keepOther is set
+func (k *KeepConfig) apply(tags []RepositoryTag, keepOther bool) []RepositoryTag {
+	type version struct {
+		name string
+		v    semver
+	}
+
+	var versions []version
+	for _, tag := range tags {
+		if v, ok := parseSemver(tag.Name); ok && v.prerelease == "" {
+			versions = append(versions, version{tag.Name, v})
+		}
+	}
+
+	// newest first
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].v.compare(versions[j].v) > 0
+	})
+
+	var (
+		kept     = make(map[string]bool)
+		majors   []int
+		minors   []string
+		perMajor = make(map[int]int)
+		perMinor = make(map[string]int)
+	)
+	for _, version := range versions {
+		major := version.v.major
+		minor := fmt.Sprintf("%d.%d", version.v.major, version.v.minor)
+
+		if len(majors) == 0 || majors[len(majors)-1] != major {
+			majors = append(majors, major)
+		}
+		if k.Majors > 0 && len(majors) > k.Majors {
+			continue
+		}
+
+		if len(minors) == 0 || minors[len(minors)-1] != minor {
+			minors = append(minors, minor)
+		}
+		if k.Minors > 0 && len(minors) > k.Minors {
+			continue
+		}
+
+		if (k.PerMajor > 0 && perMajor[major] >= k.PerMajor) || (k.PerMinor > 0 && perMinor[minor] >= k.PerMinor) {
+			continue
+		}
+
+		perMajor[major]++
+		perMinor[minor]++
+		kept[version.name] = true
+	}
+
+	res := make([]RepositoryTag, 0, len(kept))
+	for _, tag := range tags {
+		if _, ok := parseSemver(tag.Name); (!ok && keepOther) || kept[tag.Name] {
+			res = append(res, tag)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how long the kill switch URL response is cached, it is checked before every tag
+const killSwitchCacheDuration = 10 * time.Second
+
+// killSwitch stops scheduling new work once engaged
+var killSwitch = &stopper{}
+
+// stopper is engaged by the kill switch file, URL or SIGUSR1, the work in progress is
+// drained but no new repositories or tags are started
+type stopper struct {
+	mu        sync.Mutex
+	config    KillSwitchConfig
+	reason    string    // why the run was stopped, empty while running
+	checkedAt time.Time // last time the kill switch URL was checked
+}
+
+// watch configures the kill switch and engages it on SIGUSR1
+func (s *stopper) watch(cfg KillSwitchConfig) {
+	s.mu.Lock()
+	s.config = cfg
+	s.mu.Unlock()
+
+	ch := make(chan os.Signal, 1)
+	notifyKillSignal(ch)
+	go func() {
+		<-ch
+		s.stop("received SIGUSR1")
+	}()
+}
+
+// stop engages the kill switch
+func (s *stopper) stop(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reason != "" {
+		return
+	}
+
+	s.reason = reason
+	log.Warnf("Kill switch engaged (%s), draining work in progress", reason)
+}
+
+// stopped returns the reason the kill switch is engaged, or an empty string
+func (s *stopper) stopped() string {
+	s.mu.Lock()
+	cfg, reason := s.config, s.reason
+	checkURL := cfg.URL != "" && time.Since(s.checkedAt) > killSwitchCacheDuration
+	if checkURL {
+		s.checkedAt = time.Now()
+	}
+	s.mu.Unlock()
+
+	if reason != "" {
+		return reason
+	}
+
+	if cfg.File != "" {
+		if _, err := os.Stat(cfg.File); err == nil {
+			s.stop("found kill switch file " + cfg.File)
+		}
+	}
+
+	if checkURL && killSwitchURLEngaged(cfg.URL) {
+		s.stop("kill switch URL " + cfg.URL + " is engaged")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+// killSwitchURLEngaged returns true when the URL responds 200 with `true` or `stop`,
+// errors are logged and don't stop the run
+func killSwitchURLEngaged(url string) bool {
+	res, err := httpClient.Get(url)
+	if err != nil {
+		log.Warnf("Failed to check kill switch URL %s: %s", url, err)
+		return false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(body))) {
+	case "true", "stop":
+		return true
+	}
+
+	return false
+}
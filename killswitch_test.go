@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestStopperFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stop")
+	s := &stopper{config: KillSwitchConfig{File: file}}
+
+	if reason := s.stopped(); reason != "" {
+		t.Fatalf("Expected the run not to be stopped, got %q", reason)
+	}
+
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if reason := s.stopped(); reason != "found kill switch file "+file {
+		t.Errorf("Expected the run to be stopped by the file, got %q", reason)
+	}
+}
+
+func TestStopperURL(t *testing.T) {
+	engaged := "false"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(engaged + "\n"))
+	}))
+	defer server.Close()
+
+	s := &stopper{config: KillSwitchConfig{URL: server.URL}}
+	if reason := s.stopped(); reason != "" {
+		t.Fatalf("Expected the run not to be stopped, got %q", reason)
+	}
+
+	// the URL response is cached
+	engaged = "true"
+	if reason := s.stopped(); reason != "" {
+		t.Fatalf("Expected the cached URL response to be used, got %q", reason)
+	}
+
+	s.checkedAt = s.checkedAt.Add(-killSwitchCacheDuration)
+	if reason := s.stopped(); reason == "" {
+		t.Error("Expected the run to be stopped by the URL")
+	}
+}
+
+func TestKillSwitchSkipsTags(t *testing.T) {
+	defer func(s *stopper) { killSwitch = s }(killSwitch)
+	killSwitch = &stopper{}
+	killSwitch.stop("received SIGUSR1")
+
+	r := newRunReport()
+	m := mirror{
+		report:     r.repository("redis", dockerHub),
+		log:        log.WithField("repo", "redis"),
+		repo:       Repository{Name: "redis", Host: dockerHub},
+		remoteTags: []RepositoryTag{{Name: "7"}, {Name: "6"}},
+	}
+	m.work()
+
+	if len(m.report.Tags) != 2 {
+		t.Fatalf("Expected 2 tags in the report, got %d", len(m.report.Tags))
+	}
+
+	for _, tr := range m.report.Tags {
+		if tr.Result != resultSkipped || tr.Reason != "received SIGUSR1" {
+			t.Errorf("Expected tag %s to be skipped by the kill switch, got %+v", tr.Tag, tr)
+		}
+	}
+}
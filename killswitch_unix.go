@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyKillSignal relays SIGUSR1 to the channel
+func notifyKillSignal(ch chan os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifyKillSignal does nothing, Windows has no SIGUSR1: the kill switch is engaged with
+// its file or URL
+func notifyKillSignal(ch chan os.Signal) {}
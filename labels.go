@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// local repository of the images rebuilt with the `add_labels`, kept apart from the pulled
+// images so a labelled image never shadows an upstream tag
+const labelledRepository = "docker-mirror-labelled"
+
+// addLabels returns the `add_labels` of the config merged with the ones of the repository,
+// the repository wins on conflicts
+func (m *mirror) addLabels() map[string]string {
+	if len(config.AddLabels) == 0 && len(m.repo.AddLabels) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string)
+	for k, v := range config.AddLabels {
+		labels[k] = v
+	}
+	for k, v := range m.repo.AddLabels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// labelledImage returns the local name of the pulled image rebuilt with the labels
+func (m *mirror) labelledImage(tag string) string {
+	return fmt.Sprintf("%s/%s:%s", labelledRepository, m.sourceRepository(), strings.Replace(tag, ":", "-", 1))
+}
+
+// labelImage rebuilds the pulled image with the labels, a Dockerfile with a single LABEL
+// instruction only changes the image config and doesn't add any layer
+func (m *mirror) labelImage(tag string, labels map[string]string) error {
+	m.log.Info("Starting docker build")
+	defer m.timeTrack(time.Now(), "Completed docker build")
+
+	context, err := labelContext(m.sourceImage(tag), labels)
+	if err != nil {
+		return err
+	}
+
+	output := newLogWriter(m.log, "build")
+	return output.result((*m.dockerClient).BuildImage(docker.BuildImageOptions{
+		Name:              m.labelledImage(tag),
+		InputStream:       context,
+		OutputStream:      output,
+		RawJSONStream:     true,
+		RmTmpContainer:    true,
+		InactivityTimeout: 1 * time.Minute,
+	}))
+}
+
+// labelContext returns the build context of the image, a tar with only the Dockerfile
+func labelContext(image string, labels map[string]string) (*bytes.Buffer, error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dockerfile := fmt.Sprintf("FROM %s\nLABEL", image)
+	for _, k := range keys {
+		dockerfile += fmt.Sprintf(" %s=%s", strconv.Quote(k), strconv.Quote(labels[k]))
+	}
+	dockerfile += "\n"
+
+	context := &bytes.Buffer{}
+	w := tar.NewWriter(context)
+	if err := w.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile))}); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte(dockerfile)); err != nil {
+		return nil, err
+	}
+
+	return context, w.Close()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestLabelImage(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{AddLabels: map[string]string{"com.company.approved": "true", "team": "platform"}}
+
+	responseContainer := &ResponseContainer{}
+	var client DockerClient = CreateTestDockerClient(responseContainer)
+	m := mirror{dockerClient: &client, log: log.WithField("test", t.Name())}
+	m.repo = Repository{Name: "redis", Host: dockerHub, AddLabels: map[string]string{"team": "cache"}}
+
+	if err := m.labelImage("7", m.addLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := responseContainer.BuildImageOptions
+	if want := "docker-mirror-labelled/redis:7"; opts.Name != want {
+		t.Errorf("Expected the labelled image %s, got %s", want, opts.Name)
+	}
+
+	r := tar.NewReader(opts.InputStream)
+	if h, err := r.Next(); err != nil || h.Name != "Dockerfile" {
+		t.Fatalf("Expected a Dockerfile in the build context, got %v %v", h, err)
+	}
+	dockerfile, _ := ioutil.ReadAll(r)
+
+	// the labels of the repository win
+	want := "FROM redis:7\nLABEL \"com.company.approved\"=\"true\" \"team\"=\"cache\"\n"
+	if string(dockerfile) != want {
+		t.Errorf("Expected the Dockerfile %q, got %q", want, dockerfile)
+	}
+
+	if want := "docker-mirror-labelled/redis:sha256-abc"; m.labelledImage("sha256:abc") != want {
+		t.Errorf("Expected %s, got %s", want, m.labelledImage("sha256:abc"))
+	}
+}
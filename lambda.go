@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// the AWS Lambda runtime API version
+const lambdaRuntimeAPIVersion = "2018-06-01"
+
+// how long before the Lambda deadline the kill switch stops scheduling new work, so the
+// work in progress is drained and the result returned
+const lambdaDeadlineMargin = 2 * time.Minute
+
+// lambdaEvent is the event of an invocation, i.e. of an EventBridge schedule
+type lambdaEvent struct {
+	Config string `json:"config"` // config location, i.e. s3://bucket/config.yaml (default: CONFIG_FILE)
+	Prefix string `json:"prefix"` // only mirror the repositories starting with this prefix
+}
+
+// lambdaResult is the output of an invocation, a summary of the run report
+type lambdaResult struct {
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at"`
+	Stopped      string         `json:"stopped,omitempty"`
+	Repositories map[string]int `json:"repositories"` // number of repositories by result
+	Tags         map[string]int `json:"tags"`         // number of tags by result
+	Failed       []string       `json:"failed"`       // the failed repositories
+	Errors       []*errorGroup  `json:"errors"`
+}
+
+// lambdaRuntime is a client of the AWS Lambda runtime API, for the `provided` runtimes
+type lambdaRuntime struct {
+	api    string
+	client *http.Client
+}
+
+// lambdaInvocation is an invocation of the function
+type lambdaInvocation struct {
+	requestID string
+	deadline  time.Time
+	event     []byte
+}
+
+// runLambda handles the invocations of the AWS Lambda function until the runtime is shut
+// down. Every invocation loads the config, and copies the images with the registry API:
+// there is no Docker daemon in Lambda
+func runLambda(configFile string, workers int) {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		log.Fatal("AWS_LAMBDA_RUNTIME_API is not set, --lambda must run in an AWS Lambda custom runtime")
+	}
+
+	// waiting for the next invocation blocks until there is one
+	r := &lambdaRuntime{api: api, client: &http.Client{}}
+
+	for {
+		inv, err := r.next()
+		if err != nil {
+			log.Fatalf("Could not get the next Lambda invocation: %s", err)
+		}
+
+		result, err := handleLambda(inv, configFile, workers)
+		if err != nil {
+			log.Errorf("Lambda invocation %s failed: %s", inv.requestID, err)
+			err = r.respond(inv, "error", map[string]string{"errorMessage": err.Error(), "errorType": "MirrorError"})
+		} else {
+			err = r.respond(inv, "response", result)
+		}
+		if err != nil {
+			log.Fatalf("Could not respond to Lambda invocation %s: %s", inv.requestID, err)
+		}
+	}
+}
+
+// handleLambda mirrors the repositories of the config of the event
+func handleLambda(inv *lambdaInvocation, configFile string, workers int) (*lambdaResult, error) {
+	var event lambdaEvent
+	if len(bytes.TrimSpace(inv.event)) > 0 {
+		if err := json.Unmarshal(inv.event, &event); err != nil {
+			return nil, fmt.Errorf("Invalid event: %s", err)
+		}
+	}
+	if event.Config != "" {
+		configFile = event.Config
+	}
+
+	if err := loadConfig(configFile); err != nil {
+		return nil, err
+	}
+
+	// the function has no docker daemon, the config is validated as daemonless
+	config.Daemonless = true
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if workers > 0 {
+		config.Workers = workers
+	}
+	if config.Workers == 0 {
+		config.Workers = runtime.NumCPU()
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load AWS SDK config, %s", err)
+	}
+
+	targets := setupTargets(cfg)
+	setupTagSlots()
+
+	// a new kill switch per invocation, engaged before the function times out
+	killSwitch = &stopper{config: config.KillSwitch}
+	if !inv.deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(inv.deadline.Add(-lambdaDeadlineMargin)), func() {
+			killSwitch.stop("the Lambda function is about to time out")
+		})
+		defer timer.Stop()
+	}
+
+	var client DockerClient
+	run(&client, targets, nil, event.Prefix, "")
+
+	return newLambdaResult(report), nil
+}
+
+// newLambdaResult summarizes the run report
+func newLambdaResult(r *runReport) *lambdaResult {
+	res := &lambdaResult{
+		StartedAt:    r.StartedAt,
+		FinishedAt:   time.Now(),
+		Stopped:      r.Stopped,
+		Repositories: make(map[string]int),
+		Tags:         make(map[string]int),
+		Failed:       []string{},
+		Errors:       r.errors(),
+	}
+
+	for _, rr := range r.Repositories {
+		res.Repositories[rr.Result]++
+		if rr.Result == resultFailed {
+			res.Failed = append(res.Failed, rr.Name)
+		}
+
+		for _, tr := range rr.Tags {
+			res.Tags[tr.Result]++
+		}
+	}
+
+	return res
+}
+
+// next waits for the next invocation
+func (r *lambdaRuntime) next() (*lambdaInvocation, error) {
+	res, err := r.client.Get(fmt.Sprintf("http://%s/%s/runtime/invocation/next", r.api, lambdaRuntimeAPIVersion))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Lambda runtime API responded %d", res.StatusCode)
+	}
+
+	event, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &lambdaInvocation{requestID: res.Header.Get("Lambda-Runtime-Aws-Request-Id"), event: event}
+	if ms, err := strconv.ParseInt(res.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		inv.deadline = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	return inv, nil
+}
+
+// respond posts the response or error of the invocation
+func (r *lambdaRuntime) respond(inv *lambdaInvocation, kind string, body interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Post(fmt.Sprintf("http://%s/%s/runtime/invocation/%s/%s", r.api, lambdaRuntimeAPIVersion, inv.requestID, kind), "application/json", bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Lambda runtime API responded %d", res.StatusCode)
+	}
+
+	return nil
+}
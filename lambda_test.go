@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLambdaRuntime(t *testing.T) {
+	var response string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2018-06-01/runtime/invocation/next":
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "8476a536")
+			w.Header().Set("Lambda-Runtime-Deadline-Ms", "1768435200000")
+			w.Write([]byte(`{"config": "s3://bucket/config.yaml", "prefix": "library/"}`))
+		case "/2018-06-01/runtime/invocation/8476a536/response":
+			body, _ := ioutil.ReadAll(r.Body)
+			response = string(body)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := &lambdaRuntime{api: strings.TrimPrefix(server.URL, "http://"), client: server.Client()}
+	inv, err := r.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.requestID != "8476a536" || inv.deadline.Unix() != 1768435200 || !strings.Contains(string(inv.event), "s3://bucket/config.yaml") {
+		t.Errorf("Unexpected invocation %+v", inv)
+	}
+
+	rep := newRunReport()
+	rep.repository("library/redis", dockerHub).tag("7")
+	failed := rep.repository("library/nginx", dockerHub)
+	failed.fail(errors.New("pull failed"))
+
+	if err := r.respond(inv, "response", newLambdaResult(rep)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"repositories":{"failed":1,"mirrored":1}`, `"tags":{"mirrored":1}`, `"failed":["library/nginx"]`} {
+		if !strings.Contains(response, want) {
+			t.Errorf("Expected %s in the response, got %s", want, response)
+		}
+	}
+
+	if err := r.respond(&lambdaInvocation{requestID: "unknown"}, "error", map[string]string{}); err == nil {
+		t.Errorf("Expected an error for an unknown invocation")
+	}
+}
+
+func TestLambdaValidatesDaemonless(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": "target:\n  registry: registry.example.com\nadd_labels:\n  mirrored-by: docker-mirror\nrepositories:\n  - name: redis\n",
+	})
+
+	// the labels need the docker daemon the function doesn't have
+	_, err := handleLambda(&lambdaInvocation{}, dir+"/config.yaml", 1)
+	if err == nil || !strings.Contains(err.Error(), "daemonless") {
+		t.Errorf("Expected the config to be invalid without docker daemon, got %v", err)
+	}
+}
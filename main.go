@@ -2,18 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"runtime"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ecr"
-	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
 	"github.com/cenkalti/backoff"
 	docker "github.com/fsouza/go-dockerclient"
 	log "github.com/sirupsen/logrus"
@@ -23,13 +23,13 @@ import (
 const (
 	ecrPublicRegistryPrefix = "public.ecr.aws"
 	ecrPublicRegion         = "us-east-1"
-)
 
-var (
-	config       Config
-	isPrivateECR bool
+	// max number of images per ECR BatchDeleteImage call
+	ecrBatchDeleteSize = 100
 )
 
+var config Config
+
 // ecrManager is an interface which defines the methods ECR private or public managers should implement.
 type ecrManager interface {
 	exists(name string) bool
@@ -37,34 +37,157 @@ type ecrManager interface {
 	create(name string) error
 	buildCache(nextToken *string) error
 	buildCacheBackoff() backoff.Operation
+	putCatalogData(name string, catalog *CatalogData) error
+	listTags(name string) (map[string]string, error)
+	deleteTags(name string, tags []string) error
 }
 
 // Config is the result of the parsed yaml file
 type Config struct {
-	Cleanup      bool         `yaml:"cleanup"`
-	Workers      int          `yaml:"workers"`
-	Repositories []Repository `yaml:"repositories,flow"`
-	Target       TargetConfig `yaml:"target"`
+	Include             []string          `yaml:"include,omitempty"`
+	IncludeRepositories []string          `yaml:"include_repositories,omitempty"`
+	ExcludeRepositories []string          `yaml:"exclude_repositories,omitempty"`
+	Cleanup             bool              `yaml:"cleanup,omitempty"`
+	CleanupScope        string            `yaml:"cleanup_scope,omitempty"`
+	Workers             int               `yaml:"workers,omitempty"`
+	AdaptiveWorkers     *AdaptiveConfig   `yaml:"adaptive_workers,omitempty"`
+	Daemonless          bool              `yaml:"daemonless,omitempty"`
+	VerifyPush          bool              `yaml:"verify_push,omitempty"`
+	AddLabels           map[string]string `yaml:"add_labels,omitempty"`
+	LogFormat           string            `yaml:"log_format,omitempty"`
+	FreshnessSLA        *Duration         `yaml:"freshness_sla,omitempty"`
+	MinTags             int               `yaml:"min_tags,omitempty"`
+	KillSwitch          KillSwitchConfig  `yaml:"kill_switch,omitempty"`
+	WarmUp              WarmUpConfig      `yaml:"warm_up,omitempty"`
+	Deprecation         DeprecationConfig `yaml:"deprecation,omitempty"`
+	Queue               QueueConfig       `yaml:"queue,omitempty"`
+	Webhook             WebhookConfig     `yaml:"webhook,omitempty"`
+	Scan                ScanConfig        `yaml:"scan,omitempty"`
+	CircuitBreaker      BreakerConfig     `yaml:"circuit_breaker,omitempty"`
+	History             HistoryConfig     `yaml:"history,omitempty"`
+	Disk                DiskConfig        `yaml:"disk,omitempty"`
+	HubRateLimit        PullLimitConfig   `yaml:"hub_rate_limit,omitempty"`
+	Retries             int               `yaml:"retries,omitempty"`
+	RetryBackoff        *Duration         `yaml:"retry_backoff,omitempty"`
+	RepositoryTimeout   *Duration         `yaml:"repository_timeout,omitempty"`
+	SkipExisting        bool              `yaml:"skip_existing,omitempty"`
+	Hosts               []HostConfig      `yaml:"hosts,omitempty"`
+	GoogleAuth          GoogleAuthConfig  `yaml:"google_auth,omitempty"`
+	State               StateConfig       `yaml:"state,omitempty"`
+	Repositories        []Repository      `yaml:"repositories,omitempty"`
+	Target              TargetConfig      `yaml:"target,omitempty"`
+	Targets             []TargetConfig    `yaml:"targets,omitempty"`
+	Tenants             []TenantConfig    `yaml:"tenants,omitempty"`
 }
 
 // TargetConfig contains info on where to mirror repositories to
 type TargetConfig struct {
-	Registry string `yaml:"registry"`
-	Prefix   string `yaml:"prefix"`
+	Type               string    `yaml:"type,omitempty"`
+	Registry           string    `yaml:"registry,omitempty"`
+	Prefix             string    `yaml:"prefix,omitempty"`
+	Regions            []string  `yaml:"regions,omitempty"`
+	MatchRepositories  []string  `yaml:"match_repository,omitempty"`
+	MatchTags          []string  `yaml:"match_tag,omitempty"`
+	DropTags           []string  `yaml:"ignore_tag,omitempty"`
+	Username           string    `yaml:"username,omitempty"`
+	Password           string    `yaml:"password,omitempty"`
+	InsecureSkipVerify bool      `yaml:"insecure_skip_verify,omitempty"`
+	Insecure           bool      `yaml:"insecure,omitempty"`
+	Archive            bool      `yaml:"archive,omitempty"`
+	ArchiveMaxAge      *Duration `yaml:"archive_max_age,omitempty"`
+	ArchiveMaxTags     int       `yaml:"archive_max_tags,omitempty"`
+	PreloadCache       *bool     `yaml:"preload_cache,omitempty"`
+	NameTemplate       string    `yaml:"name_template,omitempty"`
+	StandbyFor         string    `yaml:"standby_for,omitempty"`
+}
+
+// KillSwitchConfig configures how on-call can stop a run
+type KillSwitchConfig struct {
+	File string `yaml:"file,omitempty"`
+	URL  string `yaml:"url,omitempty"`
+}
+
+// StateConfig configures the remote state backend, recording what was mirrored across runs
+type StateConfig struct {
+	Type   string `yaml:"type,omitempty"`
+	Table  string `yaml:"table,omitempty"`
+	Bucket string `yaml:"bucket,omitempty"`
+	Prefix string `yaml:"prefix,omitempty"`
+	Region string `yaml:"region,omitempty"`
+}
+
+// HostConfig is a custom source host, e.g. an Artifactory virtual docker repository
+type HostConfig struct {
+	Name       string `yaml:"name,omitempty"`
+	Type       string `yaml:"type,omitempty"`
+	APIBase    string `yaml:"api_base,omitempty"`
+	PathPrefix string `yaml:"path_prefix,omitempty"`
+	Username   string `yaml:"username,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	Auth       string `yaml:"auth,omitempty"` // basic (default), bearer or none
 }
 
 // Repository is a single docker hub repository to mirror
 type Repository struct {
-	PrivateRegistry string            `yaml:"private_registry"`
-	Name            string            `yaml:"name"`
-	MatchTags       []string          `yaml:"match_tag"`
-	DropTags        []string          `yaml:"ignore_tag"`
-	MaxTags         int               `yaml:"max_tags"`
-	MaxTagAge       *Duration         `yaml:"max_tag_age"`
-	RemoteTagSource string            `yaml:"remote_tags_source"`
-	RemoteTagConfig map[string]string `yaml:"remote_tags_config"`
-	TargetPrefix    *string           `yaml:"target_prefix"`
-	Host            string            `yaml:"host"`
+	PrivateRegistry string            `yaml:"private_registry,omitempty"`
+	Name            string            `yaml:"name,omitempty"`
+	Tags            []string          `yaml:"tags,omitempty"`
+	Digests         []PinnedDigest    `yaml:"digests,omitempty"`
+	DigestTag       string            `yaml:"digest_tag,omitempty"`
+	MatchTags       []string          `yaml:"match_tag,omitempty"`
+	DropTags        []string          `yaml:"ignore_tag,omitempty"`
+	MatchTagRegex   []string          `yaml:"match_tag_regex,omitempty"`
+	IgnoreTagRegex  []string          `yaml:"ignore_tag_regex,omitempty"`
+	Semver          string            `yaml:"semver,omitempty"`
+	SemverKeepOther bool              `yaml:"semver_keep_other,omitempty"`
+	Keep            *KeepConfig       `yaml:"keep,omitempty"`
+	TagMap          []TagMapping      `yaml:"tag_map,omitempty"`
+	MaxTags         int               `yaml:"max_tags,omitempty"`
+	MaxTagAge       *Duration         `yaml:"max_tag_age,omitempty"`
+	FreshnessSLA    *Duration         `yaml:"freshness_sla,omitempty"`
+	MinTags         *int              `yaml:"min_tags,omitempty"`
+	TagConcurrency  int               `yaml:"tag_concurrency,omitempty"`
+	RemoteTagSource string            `yaml:"remote_tags_source,omitempty"`
+	RemoteTagConfig map[string]string `yaml:"remote_tags_config,omitempty"`
+	TargetPrefix    *string           `yaml:"target_prefix,omitempty"`
+	Host            string            `yaml:"host,omitempty"`
+	Catalog         *CatalogData      `yaml:"catalog,omitempty"`
+	ContentTrust    bool              `yaml:"content_trust,omitempty"`
+	TrustServer     string            `yaml:"content_trust_server,omitempty"`
+	TrustRootKeys   []string          `yaml:"content_trust_root_keys,omitempty"`
+	PruneTarget     bool              `yaml:"prune_target,omitempty"`
+	PruneDryRun     bool              `yaml:"prune_dry_run,omitempty"`
+	PruneProtect    []string          `yaml:"prune_protect,omitempty"`
+	TargetMaxTags   int               `yaml:"target_max_tags,omitempty"`
+	TargetMaxTagAge *Duration         `yaml:"target_max_tag_age,omitempty"`
+	CheckMediaTypes bool              `yaml:"validate_media_types,omitempty"`
+	ConvertSchema1  bool              `yaml:"convert_schema1,omitempty"`
+	CopySignatures  bool              `yaml:"copy_signatures,omitempty"`
+	CopyReferrers   bool              `yaml:"copy_referrers,omitempty"`
+	TrackDeletions  bool              `yaml:"track_deletions,omitempty"`
+	Quarantine      string            `yaml:"quarantine_suffix,omitempty"`
+	Platforms       []string          `yaml:"platforms,omitempty"`
+	AddLabels       map[string]string `yaml:"add_labels,omitempty"`
+	ForeignLayers   string            `yaml:"foreign_layers,omitempty"`
+	Artifacts       bool              `yaml:"artifacts,omitempty"`
+	Source          string            `yaml:"source,omitempty"`
+	PullHost        string            `yaml:"pull_host_override,omitempty"`
+	Timeout         *Duration         `yaml:"timeout,omitempty"`
+	MaxImageSize    ByteSize          `yaml:"max_image_size,omitempty"`
+	Auth            *SourceAuth       `yaml:"auth,omitempty"`
+
+	tenant     string // name of the tenant the repository is mirrored for, empty for `repositories`
+	sourceName string // path of the repository in the host of its `source`
+}
+
+// CatalogData is the ECR Public Gallery metadata of a repository
+type CatalogData struct {
+	Description      string   `yaml:"description,omitempty"`
+	AboutText        string   `yaml:"about_text,omitempty"`
+	UsageText        string   `yaml:"usage_text,omitempty"`
+	Architectures    []string `yaml:"architectures,omitempty"`
+	OperatingSystems []string `yaml:"operating_systems,omitempty"`
+	Logo             string   `yaml:"logo,omitempty"`
 }
 
 func createDockerClient() (*docker.Client, error) {
@@ -72,6 +195,131 @@ func createDockerClient() (*docker.Client, error) {
 	return client, err
 }
 
+// setLogFormat switches logrus to the text or json formatter
+func setLogFormat(format string) error {
+	switch format {
+	case "", "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("Unknown log format %q, we support text and json", format)
+	}
+
+	return nil
+}
+
+// envDefault returns the env var, or the default if it isn't set
+func envDefault(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+
+	return def
+}
+
+// envDuration parses a duration from the env var, used as flag default
+func envDuration(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Could not parse %s env: %s", name, err)
+	}
+
+	return d
+}
+
+// envInt parses an integer from the env var, used as flag default
+func envInt(name string) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Could not parse %s env: %s", name, err)
+	}
+
+	return i
+}
+
+// configFilePath returns the config file to read
+func configFilePath() string {
+	if f := os.Getenv("CONFIG_FILE"); f != "" {
+		return f
+	}
+
+	return "config.yaml"
+}
+
+// loadConfig reads and parses the config file, or the config directory, into the global config
+func loadConfig(configFile string) error {
+	files, err := configFiles(configFile)
+	if err != nil {
+		return err
+	}
+
+	var merged Config
+	settings := make(map[string]string)
+	repositories := make(map[string]string)
+	for _, file := range files {
+		fragment, err := loadConfigFragment(file)
+		if err != nil {
+			return err
+		}
+
+		if len(fragment.Include) > 0 && (file != configFile || isRemoteConfig(file)) {
+			return fmt.Errorf("Config file %s can't use `include`, only a local main config file can", file)
+		}
+
+		if err := mergeConfig(&merged, fragment, file, settings, repositories); err != nil {
+			return err
+		}
+	}
+
+	filterRepositories(&merged)
+
+	if err := resolveSecrets(&merged); err != nil {
+		return err
+	}
+
+	config = merged
+	return nil
+}
+
+// loadConfigFragment reads and parses a single config file
+func loadConfigFragment(file string) (Config, error) {
+	var fragment Config
+
+	content, err := readConfigFile(file)
+	if err != nil {
+		return fragment, fmt.Errorf("Could not read config file: %s", err)
+	}
+
+	if err := yaml.Unmarshal(content, &fragment); err != nil {
+		return fragment, fmt.Errorf("Could not parse config file %s: %s", file, err)
+	}
+
+	// unknown keys (e.g. a `match_tags` typo) are ignored by the lenient decoder
+	if err := yaml.UnmarshalStrict(content, &Config{}); err != nil {
+		log.Warnf("Config file %s has unknown or invalid keys, run `docker-mirror validate` for details: %s", file, err)
+	}
+
+	// catalog logos are relative to the config file, they must be absolute in a remote config
+	for _, repo := range fragment.Repositories {
+		if repo.Catalog != nil && repo.Catalog.Logo != "" && !filepath.IsAbs(repo.Catalog.Logo) && !isRemoteConfig(file) {
+			repo.Catalog.Logo = filepath.Join(filepath.Dir(file), repo.Catalog.Logo)
+		}
+	}
+
+	return fragment, nil
+}
+
 func main() {
 	// log level
 	if rawLevel := os.Getenv("LOG_LEVEL"); rawLevel != "" {
@@ -82,45 +330,263 @@ func main() {
 		log.SetLevel(logLevel)
 	}
 
-	// mirror file to read
-	configFile := "config.yaml"
-	if f := os.Getenv("CONFIG_FILE"); f != "" {
-		configFile = f
+	// log format, the env var takes precedence over the config file
+	if err := setLogFormat(os.Getenv("LOG_FORMAT")); err != nil {
+		log.Fatal(err)
 	}
 
-	content, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Could not read config file: %s", err))
+	// the subcommand defaults to run, so `docker-mirror` and `docker-mirror --flag` keep working
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runCommand(args)
+	case "validate":
+		validateCommand(args)
+	case "plan":
+		planCommand(args)
+	case "diff":
+		diffCommand(args)
+	case "verify":
+		verifyCommand(args)
+	case "sync-targets":
+		syncTargetsCommand(args)
+	case "version":
+		fmt.Println(version)
+	case "import":
+		importCommand(args)
+	case "export":
+		exportCommand(args)
+	case "login":
+		loginCommand(args)
+	case "operator":
+		operatorCommand(args)
+	case "queue":
+		queueCommand(args)
+	case "copy":
+		copyCommand(args)
+	case "dashboards":
+		dashboardsCommand(args)
+	case "discover":
+		discoverCommand(args)
+	case "scan-cluster":
+		scanClusterCommand(args)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// runCommand mirrors the configured repositories, once or as a daemon
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configFile := configFlag(flags)
+	workers := flags.Int("workers", envInt("NUM_WORKERS"), "number of repositories mirrored in parallel (default: workers config or number of CPUs)")
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "only mirror the repositories starting with this prefix")
+	reportFile := flags.String("report-file", os.Getenv("REPORT_FILE"), "write a JSON report of the run to this file")
+	interval := flags.Duration("interval", envDuration("INTERVAL"), "run as a daemon, mirroring all repositories at this interval")
+	adminAddr := flags.String("admin-addr", os.Getenv("ADMIN_ADDR"), "address of the admin server, e.g. :8080")
+	checkpointFile := flags.String("checkpoint-file", envDefault("CHECKPOINT_FILE", ".docker-mirror-checkpoint.json"), "file the progress of the run is saved to, empty to disable")
+	resume := flags.Bool("resume", false, "continue from the checkpoint of an interrupted run")
+	confirm := flags.Bool("confirm", false, "hold the deletions of target tags until the end of the run, and ask to confirm them")
+	yes := flags.Bool("yes", false, "print the deletions of target tags held by --confirm at the end of the run, and confirm them without asking")
+	lambda := flags.Bool("lambda", false, "handle the invocations of an AWS Lambda function, the config location and prefix can be set by the event")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror run [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *lambda {
+		runLambda(*configFile, *workers)
+		return
 	}
 
-	if err := yaml.Unmarshal(content, &config); err != nil {
-		log.Fatal(fmt.Sprintf("Could not parse config file: %s", err))
+	setupConfig(*configFile)
+
+	startRun(runOptions{
+		configFile:     *configFile,
+		workers:        *workers,
+		prefix:         *prefix,
+		reportFile:     *reportFile,
+		interval:       *interval,
+		adminAddr:      *adminAddr,
+		checkpointFile: *checkpointFile,
+		resume:         *resume,
+		confirm:        *confirm,
+		yes:            *yes,
+	})
+}
+
+// runOptions are the flags of a mirror run
+type runOptions struct {
+	configFile     string
+	workers        int
+	prefix         string
+	reportFile     string
+	interval       time.Duration
+	adminAddr      string
+	checkpointFile string
+	resume         bool
+	confirm        bool   // hold the deletions of target tags until confirmed
+	yes            bool   // confirm the held deletions without asking
+	beforeRun      func() // i.e. the operator lists the repositories to mirror
+	afterRun       func() // i.e. the operator updates the status of the repositories
+}
+
+// startRun mirrors the repositories of the loaded config, once or as a daemon
+func startRun(opts runOptions) {
+	if opts.workers > 0 {
+		config.Workers = opts.workers
 	}
 
-	if config.Target.Registry == "" {
-		log.Fatal("Missing `target -> registry` yaml config")
+	client := connectDocker()
+
+	deletions.enabled, deletions.yes = opts.confirm || opts.yes, opts.yes
+
+	// init AWS client
+	log.Info("Creating AWS client")
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
 	}
 
-	isPrivateECR = !strings.HasPrefix(config.Target.Registry, ecrPublicRegistryPrefix)
+	targets := setupTargets(cfg)
 
-	if config.Workers == 0 {
-		config.Workers = runtime.NumCPU()
+	// check the free disk space before the pulls, and remove the images a previous run left
+	// behind (i.e. when it was killed, or with cleanup disabled)
+	setupDisk(client, targets)
+	if config.Disk.PruneOnStart && client != nil {
+		log.Infof("Removed %d local images left by previous runs", disk.prune())
 	}
 
-	// number of workers
-	if w := os.Getenv("NUM_WORKERS"); w != "" {
-		p, err := strconv.Atoi(w)
+	// tags mirrored concurrently within a repository share the worker budget
+	setupTagSlots()
+
+	// start background cleanup workers
+	var c *cleaner
+	if config.Cleanup && !config.Daemonless {
+		c = newCleaner(&client, config.Workers)
+	}
+
+	// save the progress of the run, so an interrupted run can be resumed
+	if opts.checkpointFile != "" {
+		checkpoint, err = openCheckpoint(opts.checkpointFile, opts.resume)
 		if err != nil {
-			log.Fatal(fmt.Sprintf("Could not parse NUM_WORKERS env: %s", err))
+			log.Fatalf("Could not read checkpoint: %s", err)
+		}
+
+		if n := len(checkpoint.Tags); n > 0 {
+			log.Infof("Resuming from checkpoint, skipping %d completed tags", n)
+		}
+	}
+
+	// stop scheduling new work once the kill switch is engaged
+	killSwitch.watch(config.KillSwitch)
+	watchStateSignal()
+
+	// mirror the tags pushed upstream as soon as their webhook is received
+	webhookExecute := func(targets []*target) func(queueJob) error {
+		return func(job queueJob) error {
+			return executeJob(job, config.Webhook.AllowUnlisted, &client, targets, c)
+		}
+	}
+	if config.Webhook.Token != "" {
+		if opts.adminAddr == "" {
+			log.Warn("The webhook is set without --admin-addr, the webhook endpoint is not served")
+		} else {
+			webhooks = newWebhookListener(config.Webhook, webhookExecute(targets))
+			webhooks.start(config.Workers)
 		}
+	}
 
-		config.Workers = p
+	if opts.adminAddr != "" {
+		startAdminServer(opts.adminAddr)
+	}
+
+	// profiling, to diagnose the memory growth of long runs
+	if pprofEnabled() {
+		if opts.adminAddr == "" {
+			log.Warn("DEBUG_PPROF is set without --admin-addr, the pprof endpoints are not served")
+		}
+
+		if dir := os.Getenv("DEBUG_PPROF_DIR"); dir != "" {
+			interval := envDuration("DEBUG_PPROF_INTERVAL")
+			if interval == 0 {
+				interval = defaultProfileInterval
+			}
+			startProfileWriter(dir, interval)
+		}
+	}
+
+	// with a `Type=notify` systemd unit, the service is ready once the runs are scheduled
+	startWatchdog(envDuration("WATCHDOG_STALL"))
+	sdNotify("READY=1")
+
+	for {
+		if opts.beforeRun != nil {
+			opts.beforeRun()
+		}
+
+		sdNotify("STATUS=Mirroring")
+		run(&client, targets, c, opts.prefix, opts.reportFile)
+
+		if opts.afterRun != nil {
+			opts.afterRun()
+		}
+
+		if opts.interval == 0 || killSwitch.stopped() != "" {
+			break
+		}
+
+		log.Infof("Next run in %s", opts.interval)
+		sdNotify(fmt.Sprintf("STATUS=Next run at %s", time.Now().Add(opts.interval).Format(time.RFC3339)))
+		time.Sleep(opts.interval)
+
+		// a remote config is fetched again, its ETag tells if it changed
+		if reloadConfig(opts.configFile) {
+			if opts.workers > 0 {
+				config.Workers = opts.workers
+			}
+			setupTagSlots()
+			targets = setupTargets(cfg)
+			setupDisk(client, targets)
+			if webhooks != nil {
+				webhooks.setExecute(webhookExecute(targets))
+			}
+		}
+	}
+
+	sdNotify("STOPPING=1")
+
+	// wait for all queued images to be cleaned
+	if c != nil {
+		c.wait()
+	}
+
+	// a repository resolving to too few tags fails a one-off run, i.e. in CI
+	if n := report.tooFewTagsCount(); n > 0 && opts.interval == 0 {
+		log.Fatalf("%d repositories resolved to fewer tags than their min_tags", n)
+	}
+
+	log.Info("Done")
+}
+
+// connectDocker creates the Docker client, nil in daemonless mode which copies images
+// with the registry API instead
+func connectDocker() DockerClient {
+	if config.Daemonless {
+		return nil
 	}
 
-	// init Docker client
 	log.Info("Creating Docker client")
-	var client DockerClient
-	client, err = createDockerClient()
+	client, err := createDockerClient()
 	if err != nil {
 		log.Fatalf("Could not create Docker client: %s", err.Error())
 	}
@@ -131,22 +597,25 @@ func main() {
 	}
 	log.Infof("Connected to Docker daemon: %s @ %s", info.Name, info.ServerVersion)
 
-	// init AWS client
-	log.Info("Creating AWS client")
-	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
+	return client
+}
+
+// setupTargets creates the remote state backend and the targets, with their ECR
+// repositories pre-loaded
+func setupTargets(cfg aws.Config) []*target {
+	// init the remote state backend
+	if config.State.Type != "" {
+		var err error
+		state, err = newStateBackend(config.State, cfg)
+		if err != nil {
+			log.Fatalf("Could not create state backend: %s", err)
+		}
 	}
 
 	// pre-load ECR repositories
-	var ecrManager ecrManager
-
-	if !isPrivateECR {
-		// Override the AWS region with the ecrPublicRegion for ECR authentication.
-		cfg.Region = ecrPublicRegion
-		ecrManager = &ecrPublicManager{client: ecrpublic.NewFromConfig(cfg)}
-	} else {
-		ecrManager = &ecrPrivateManager{client: ecr.NewFromConfig(cfg)}
+	targets, err := buildTargets(cfg)
+	if err != nil {
+		log.Fatalf("Could not create targets: %s", err)
 	}
 
 	backoffSettings := backoff.NewExponentialBackOff()
@@ -157,65 +626,209 @@ func main() {
 		log.Errorf("%v (%s)", err, d.String())
 	}
 
-	if err = backoff.RetryNotify(ecrManager.buildCacheBackoff(), backoffSettings, notifyError); err != nil {
-		log.Fatalf("Could not build ECR cache: %s", err)
+	for _, t := range targets {
+		if !t.preloadCache() {
+			log.Infof("Not pre-loading the repositories of %s, they are created on first push", t.registry)
+			continue
+		}
+
+		if err = backoff.RetryNotify(t.ecrManager.buildCacheBackoff(), backoffSettings, notifyError); err != nil {
+			log.Fatalf("Could not build ECR cache for %s: %s", t.registry, err)
+		}
 	}
 
+	return targets
+}
+
+// run mirrors all the repositories once, and writes the report of the run
+func run(client *DockerClient, targets []*target, c *cleaner, prefix, reportFile string) {
+	report = newRunReport()
+	targetTagsCache.reset()
+
+	// every repository is traced as a child of the run
+	runSpan := tracer.start(nil, "docker-mirror run")
+
 	workerCh := make(chan Repository, 5)
 	var wg sync.WaitGroup
 
 	// start background workers
 	for i := 0; i < config.Workers; i++ {
-		go worker(&wg, workerCh, &client, ecrManager)
+		go worker(i, &wg, workerCh, client, targets, c, runSpan)
 	}
 
-	prefix := os.Getenv("PREFIX")
+	// the most used repositories are mirrored first, in case the run is cut short
+	repositories := config.allRepositories()
+	if config.WarmUp.enabled() {
+		repositories = warmUpOrder(repositories, config.WarmUp)
+	}
+
+	var scheduled []Repository
+	var names []string
+	for _, repo := range repositories {
+		if prefix == "" || strings.HasPrefix(repo.Name, prefix) {
+			scheduled = append(scheduled, repo)
+			names = append(names, repo.Name)
+		}
+	}
+	runState.queued(names)
+	defer runState.queued(nil)
 
 	// add jobs for the workers
-	for _, repo := range config.Repositories {
-		if prefix != "" && !strings.HasPrefix(repo.Name, prefix) {
+	for _, repo := range scheduled {
+		scheduler.wait(log.WithField("full_repo", repo.Name))
+
+		if reason := killSwitch.stopped(); reason != "" {
+			runState.dequeue(repo.Name)
+			report.repository(repo.Name, repo.Host).skip(reason)
 			continue
 		}
 
 		wg.Add(1)
 		workerCh <- repo
 	}
+	close(workerCh)
 
 	// wait for all workers to complete
 	wg.Wait()
-	log.Info("Done")
+
+	// the run completed, the next run starts from scratch
+	if killSwitch.stopped() == "" {
+		if err := checkpoint.remove(); err != nil {
+			log.Warnf("Could not remove checkpoint: %s", err)
+		}
+	}
+
+	// wait for all queued images of the run to be cleaned
+	if c != nil {
+		log.Info("Waiting for image cleanup to complete")
+		c.flush()
+	}
+
+	// the deletions held by --confirm
+	deletions.confirm()
+
+	runSpan.finish(nil)
+	tracer.flush()
+
+	report.logErrors()
+	report.Stopped = killSwitch.stopped()
+	metrics.observe(report, time.Now())
+
+	if err := writeHistory(config.History, report); err != nil {
+		log.Warnf("Failed to write the history of the run: %s", err)
+	}
+
+	if reportFile != "" {
+		if err := report.write(reportFile); err != nil {
+			log.Fatalf("Could not write report: %s", err)
+		}
+		log.Infof("Wrote report to %s", reportFile)
+	}
 }
 
-func worker(wg *sync.WaitGroup, workerCh chan Repository, dc *DockerClient, ecrm ecrManager) {
+func worker(id int, wg *sync.WaitGroup, workerCh chan Repository, dc *DockerClient, targets []*target, c *cleaner, parent *span) {
 	log.Debug("Starting worker")
 
-	for {
-		select {
-		case repo := <-workerCh:
-			// Check if the given host is from our support list.
-			if repo.Host != "" && repo.Host != dockerHub && repo.Host != quay && repo.Host != gcr && repo.Host != k8s {
-				log.Errorf("Could not pull images from host: %s. We support %s, %s, %s, and %s", repo.Host, dockerHub, quay, gcr, k8s)
-				wg.Done()
-				continue
-			}
+	for repo := range workerCh {
+		runState.dequeue(repo.Name)
+		rr := report.repository(repo.Name, repo.Host)
 
-			// If Host is not specified, will mirror repos from Docker Hub.
-			if repo.Host == "" {
-				repo.Host = dockerHub
-			}
+		// the repository was queued before the kill switch was engaged
+		if reason := killSwitch.stopped(); reason != "" {
+			rr.skip(reason)
+			wg.Done()
+			continue
+		}
 
-			m := mirror{
-				dockerClient: dc,
-				ecrManager:   ecrm,
-			}
-			if err := m.setup(repo); err != nil {
-				log.Errorf("Failed to setup mirror for repository %s: %s", repo.Name, err)
-				wg.Done()
-				continue
-			}
+		// Check if the given host is from our support list.
+		if repo.Host != "" && !supportedHost(repo.Host) {
+			log.Errorf("Could not pull images from host: %s. We support %s, %s, %s, %s and the `hosts` in the config", repo.Host, dockerHub, quay, gcr, k8s)
+			rr.fail(fmt.Errorf("Unsupported host %s", repo.Host))
+			wg.Done()
+			continue
+		}
+
+		// If Host is not specified, will mirror repos from Docker Hub.
+		if repo.Host == "" {
+			repo.Host = dockerHub
+			rr.Host = dockerHub
+		}
+
+		// with `source`, the repository is pulled from the host of the source
+		if repo.Source != "" {
+			repo = repo.upstream()
+			rr.Host = repo.Host
+		}
 
-			m.work()
+		// the host failed for the previous repositories, its breaker is open
+		if reason := breakers.open(tagSourceHost(repo), time.Now(), config.CircuitBreaker); reason != "" {
+			log.WithField("full_repo", repo.Name).Warnf("Deferring the repository: %s", reason)
+			rr.deferred(reason)
 			wg.Done()
+			continue
+		}
+
+		runState.working(id, repo.Name, repo.Host)
+		mirrorRepository(repo, rr, dc, targets, c, parent)
+		runState.working(id, "", "")
+		wg.Done()
+	}
+}
+
+// mirrorRepositoryUntil mirrors the tags of a single repository, until timedOut is closed. A
+// panic (e.g. on a malformed API response) fails the repository instead of crashing the run
+func mirrorRepositoryUntil(repo Repository, rr *repositoryReport, dc *DockerClient, targets []*target, c *cleaner, parent *span, timedOut chan struct{}) {
+	m := mirror{
+		dockerClient: dc,
+		targets:      targets,
+		cleaner:      c,
+		report:       rr,
+		span:         parent.child("mirror repository", "repository", repo.Name, "host", repo.Host),
+		timedOut:     timedOut,
+	}
+
+	// a repository deprecated upstream for `disable_after` runs isn't mirrored anymore
+	if signal := deprecations.disabled(deprecationKey(repo), config.Deprecation); signal != "" {
+		log.WithField("full_repo", repo.Name).Warnf("Skipping the repository, deprecated upstream for %d runs: %s", config.Deprecation.DisableAfter, signal)
+		rr.deprecated(signal)
+		rr.skip("deprecated upstream: " + signal)
+		m.span.finish(nil)
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err := panicError(r)
+			log.WithField("full_repo", repo.Name).Errorf("Recovered from a panic while mirroring the repository: %s\n%s", err, debug.Stack())
+			rr.fail(err)
+			m.span.finish(err)
 		}
+	}()
+
+	if err := m.setup(repo); err != nil {
+		log.Errorf("Failed to setup mirror for repository %s: %s", repo.Name, err)
+		breakers.failure(tagSourceHost(repo), time.Now(), config.CircuitBreaker)
+		rr.fail(err)
+		m.span.finish(err)
+		return
+	}
+	breakers.success(tagSourceHost(repo))
+
+	if config.Deprecation.Check {
+		m.checkDeprecation(deprecationKey(repo))
+	}
+
+	// the tags found are still mirrored, the repository is failed once they are
+	if err := m.checkMinTags(); err != nil {
+		m.log.Error(err)
+		rr.tooFewTags(err)
 	}
+
+	m.work()
+	m.span.finish(nil)
+}
+
+// panicError converts a recovered panic into an error
+func panicError(r interface{}) error {
+	return fmt.Errorf("Panic: %v", r)
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	mediaTypeSchema2       = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerConfig  = "application/vnd.docker.container.image.v1+json"
+	mediaTypeDockerLayer   = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// ecrMediaTypes are the media types of the manifests, configs and layers ECR accepts, some
+// OCI artifacts (i.e. WASM modules) use others and are rejected on push
+var ecrMediaTypes = map[string]bool{
+	"application/vnd.docker.distribution.manifest.v2+json":      true,
+	"application/vnd.docker.distribution.manifest.list.v2+json": true,
+	"application/vnd.oci.image.manifest.v1+json":                true,
+	"application/vnd.oci.image.index.v1+json":                   true,
+
+	"application/vnd.docker.container.image.v1+json": true,
+	"application/vnd.oci.image.config.v1+json":       true,
+
+	// Helm charts, and the empty config of the OCI 1.1 artifacts
+	"application/vnd.cncf.helm.config.v1+json":            true,
+	"application/vnd.cncf.helm.chart.content.v1.tar+gzip": true,
+	"application/vnd.cncf.helm.chart.provenance.v1.prov":  true,
+	"application/vnd.oci.empty.v1+json":                   true,
+
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":            true,
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":    true,
+	"application/vnd.oci.image.layer.v1.tar":                       true,
+	"application/vnd.oci.image.layer.v1.tar+gzip":                  true,
+	"application/vnd.oci.image.layer.v1.tar+zstd":                  true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar":      true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": true,
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+zstd": true,
+}
+
+// unsupportedMediaTypeError is returned for a manifest, config or layer ECR would reject
+type unsupportedMediaTypeError struct {
+	reference string
+	kind      string // manifest, config or layer
+	mediaType string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("The %s of %s has the media type %q, which ECR doesn't support", e.kind, e.reference, e.mediaType)
+}
+
+// schema1Error is returned for a Docker schema1 manifest, which is not converted without
+// `convert_schema1` and that registries reject on push
+type schema1Error struct {
+	reference string
+}
+
+func (e *schema1Error) Error() string {
+	return fmt.Sprintf("%s is a Docker schema1 manifest, set `convert_schema1: true` to convert it to schema2", e.reference)
+}
+
+// checkMediaTypes fetches the manifest of the reference, and of every platform of an index,
+// and returns its media type and digest. An unsupportedMediaTypeError is returned for the
+// first manifest, config or layer ECR would reject. A schema1 manifest is returned as
+// mediaTypeSchema1Signed, it is up to the caller to convert or reject it
+func checkMediaTypes(src *registryClient, repository, reference string) (string, string, error) {
+	body, mediaType, digest, err := src.manifest(repository, reference)
+	if err != nil {
+		return "", "", err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return "", "", fmt.Errorf("Could not parse manifest %s:%s: %s", repository, reference, err)
+	}
+
+	// some registries serve schema1 manifests as application/json
+	if m.SchemaVersion == 1 || mediaType == mediaTypeSchema1 || mediaType == mediaTypeSchema1Signed {
+		return mediaTypeSchema1Signed, digest, nil
+	}
+
+	ref := repository + ":" + reference
+	if !ecrMediaTypes[mediaType] {
+		return mediaType, digest, &unsupportedMediaTypeError{ref, "manifest", mediaType}
+	}
+
+	for _, child := range m.Manifests {
+		childType, _, err := checkMediaTypes(src, repository, child.Digest)
+		if err != nil {
+			return mediaType, digest, err
+		}
+		if childType == mediaTypeSchema1Signed {
+			return mediaType, digest, &unsupportedMediaTypeError{repository + "@" + child.Digest, "manifest", childType}
+		}
+	}
+
+	if m.Config != nil && !ecrMediaTypes[m.Config.MediaType] {
+		return mediaType, digest, &unsupportedMediaTypeError{ref, "config", m.Config.MediaType}
+	}
+
+	for _, layer := range m.Layers {
+		if !ecrMediaTypes[layer.MediaType] {
+			return mediaType, digest, &unsupportedMediaTypeError{ref, "layer", layer.MediaType}
+		}
+	}
+
+	return mediaType, digest, nil
+}
+
+// validateTag pre-validates the manifest of the tag before anything is pulled or pushed, with
+// `validate_media_types` or `convert_schema1`. Returns why the targets would reject the tag,
+// empty when it can be mirrored. A schema1 tag is flagged for conversion with `convert_schema1`
+func (m *mirror) validateTag(tag string, tagTargets []*target) (string, error) {
+	ecr := false
+	for _, t := range tagTargets {
+		ecr = ecr || t.isECR()
+	}
+
+	// only ECR targets reject media types, any target may reject schema1
+	validate := m.repo.CheckMediaTypes && ecr
+	if !validate && !m.repo.ConvertSchema1 {
+		return "", nil
+	}
+
+	srcHost, srcRepository := splitReference(m.sourceRepository())
+	src := registryClientFor(srcHost, m.sourceAuth(), false)
+
+	reference := tag
+	if m.signedDigest != "" {
+		reference = m.signedDigest
+	}
+
+	mediaType, digest, err := checkMediaTypes(src, srcRepository, reference)
+	var unsupported *unsupportedMediaTypeError
+	switch {
+	case errors.As(err, &unsupported):
+		if validate {
+			return err.Error(), nil
+		}
+	case err != nil:
+		return "", err
+	case mediaType == mediaTypeSchema1Signed && m.repo.ConvertSchema1:
+		m.schema1 = digest
+	case mediaType == mediaTypeSchema1Signed && validate:
+		return "The tag is a Docker schema1 manifest, which ECR doesn't support (see `convert_schema1`)", nil
+	}
+
+	return "", nil
+}
+
+// schema1Manifest is a Docker schema1 manifest, its layers and history start from the top layer
+type schema1Manifest struct {
+	FSLayers []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// v1Compatibility is the history of a schema1 layer, in the legacy image config format
+type v1Compatibility struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	ThrowAway       bool      `json:"throwaway,omitempty"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// configHistory is the history of a layer in a schema2 image config
+type configHistory struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// convertSchema1 converts the schema1 manifest to a schema2 manifest and its image config,
+// like the docker daemon does on pull. The config needs the uncompressed digest of every
+// layer, they are streamed from the source to compute it
+func convertSchema1(src *registryClient, repository string, body []byte) ([]byte, []byte, error) {
+	var s1 schema1Manifest
+	if err := json.Unmarshal(body, &s1); err != nil {
+		return nil, nil, fmt.Errorf("Could not parse schema1 manifest: %s", err)
+	}
+	if len(s1.FSLayers) == 0 || len(s1.FSLayers) != len(s1.History) {
+		return nil, nil, fmt.Errorf("Invalid schema1 manifest: %d layers and %d history entries", len(s1.FSLayers), len(s1.History))
+	}
+
+	var (
+		layers  []descriptor
+		diffIDs []string
+		history []configHistory
+	)
+	for i := len(s1.History) - 1; i >= 0; i-- {
+		var v1 v1Compatibility
+		if err := json.Unmarshal([]byte(s1.History[i].V1Compatibility), &v1); err != nil {
+			return nil, nil, fmt.Errorf("Could not parse the history of layer %d: %s", i, err)
+		}
+
+		history = append(history, configHistory{
+			Created:    v1.Created,
+			CreatedBy:  strings.Join(v1.ContainerConfig.Cmd, " "),
+			Author:     v1.Author,
+			Comment:    v1.Comment,
+			EmptyLayer: v1.ThrowAway,
+		})
+		if v1.ThrowAway {
+			continue
+		}
+
+		blob := s1.FSLayers[i].BlobSum
+		diffID, size, err := layerDiffID(src, repository, blob)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not read layer %s: %s", blob, err)
+		}
+
+		layers = append(layers, descriptor{MediaType: mediaTypeDockerLayer, Digest: blob, Size: size})
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	// the config is the top layer legacy config, without the legacy layer fields
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s1.History[0].V1Compatibility), &config); err != nil {
+		return nil, nil, fmt.Errorf("Could not parse the history of the top layer: %s", err)
+	}
+	for _, field := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, field)
+	}
+
+	rootfs, _ := json.Marshal(map[string]interface{}{"type": "layers", "diff_ids": diffIDs})
+	config["rootfs"] = rootfs
+	config["history"], _ = json.Marshal(history)
+
+	configBody, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifestBody, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeSchema2,
+		"config":        descriptor{MediaType: mediaTypeDockerConfig, Digest: sha256Digest(configBody), Size: int64(len(configBody))},
+		"layers":        layers,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return manifestBody, configBody, nil
+}
+
+// layerDiffID streams the gzipped layer, and returns the digest of its uncompressed content
+// and its compressed size
+func layerDiffID(src *registryClient, repository, digest string) (string, int64, error) {
+	body, err := src.openBlob(repository, digest, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+
+	counter := &countingReader{r: body}
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, gz); err != nil {
+		return "", 0, err
+	}
+
+	// the size of the blob includes what follows the gzip stream
+	if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+		return "", 0, err
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), counter.n, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// copySchema1Image converts the schema1 manifest of the source reference to schema2, and copies
+// it with its layers and new config to the destination tag. Returns the digest of the
+// converted manifest, and the number of bytes uploaded
+func copySchema1Image(src *registryClient, srcRepository, reference string, dst *registryClient, dstRepository, tag string) (string, int64, error) {
+	body, _, _, err := src.manifest(srcRepository, reference)
+	if err != nil {
+		return "", 0, err
+	}
+
+	manifestBody, configBody, err := convertSchema1(src, srcRepository, body)
+	if err != nil {
+		return "", 0, fmt.Errorf("Could not convert schema1 manifest %s:%s: %s", srcRepository, reference, err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(manifestBody, &m); err != nil {
+		return "", 0, err
+	}
+
+	var transferred int64
+	for _, layer := range m.Layers {
+		n, err := copyBlob(src, srcRepository, dst, dstRepository, layer)
+		transferred += n
+		if err != nil {
+			return "", transferred, err
+		}
+	}
+
+	n, err := pushBlob(dst, dstRepository, m.Config.Digest, configBody)
+	transferred += n
+	if err != nil {
+		return "", transferred, err
+	}
+
+	return sha256Digest(manifestBody), transferred, dst.putManifest(dstRepository, tag, mediaTypeSchema2, manifestBody)
+}
+
+// pushBlob uploads a small blob (i.e. an image config) in a single chunk, unless the registry
+// already has it. Returns the number of bytes uploaded
+func pushBlob(dst *registryClient, repository, digest string, content []byte) (int64, error) {
+	exists, err := dst.blobExists(repository, digest)
+	if err != nil || exists {
+		return 0, err
+	}
+
+	upload, err := dst.startUpload(repository)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := upload.uploadChunk(content, 0); err != nil {
+		return 0, err
+	}
+
+	return int64(len(content)), upload.complete(digest)
+}
+
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCheckMediaTypes(t *testing.T) {
+	source := newFakeRegistry()
+	server := httptest.NewServer(source)
+	defer server.Close()
+	src := newRegistryClient(strings.TrimPrefix(server.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	image := func(tag, configType string, layerTypes ...string) {
+		m := manifest{SchemaVersion: 2, MediaType: mediaTypeSchema2, Config: &descriptor{MediaType: configType, Digest: sha256Digest([]byte(tag))}}
+		for _, layerType := range layerTypes {
+			m.Layers = append(m.Layers, descriptor{MediaType: layerType, Digest: sha256Digest([]byte(tag + layerType))})
+		}
+		body, _ := json.Marshal(m)
+		source.manifests["charts/redis:"+tag] = body
+	}
+	image("image", mediaTypeDockerConfig, mediaTypeDockerLayer, "application/vnd.oci.image.layer.v1.tar+zstd")
+	image("chart", "application/vnd.cncf.helm.config.v1+json", "application/vnd.cncf.helm.chart.content.v1.tar+gzip")
+	image("wasm", "application/vnd.oci.image.config.v1+json", "application/vnd.wasm.content.layer.v1+wasm")
+	source.manifests["charts/redis:legacy"] = []byte(`{"schemaVersion":1,"fsLayers":[],"history":[]}`)
+
+	tests := map[string]string{
+		"image":  "",
+		"chart":  "",
+		"wasm":   "The layer of charts/redis:wasm has the media type \"application/vnd.wasm.content.layer.v1+wasm\", which ECR doesn't support",
+		"legacy": "",
+	}
+	for tag, want := range tests {
+		_, _, err := checkMediaTypes(src, "charts/redis", tag)
+		var unsupported *unsupportedMediaTypeError
+		if err != nil && !errors.As(err, &unsupported) {
+			t.Errorf("Unexpected error for %s: %s", tag, err)
+			continue
+		}
+
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("Expected %q for %s, got %q", want, tag, got)
+		}
+	}
+
+	if mediaType, _, _ := checkMediaTypes(src, "charts/redis", "legacy"); mediaType != mediaTypeSchema1Signed {
+		t.Errorf("Expected a schema1 manifest, got %s", mediaType)
+	}
+
+	// a daemonless copy skips schema1 tags before pushing anything, unless they are converted
+	var s1 *schema1Error
+	if _, err := fetchImageMetadata(src, "charts/redis", "legacy"); !errors.As(err, &s1) {
+		t.Errorf("Expected a schema1 error, got %v", err)
+	}
+}
+
+func TestCopySchema1Image(t *testing.T) {
+	gzipped := func(content string) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(content))
+		gz.Close()
+		return buf.Bytes()
+	}
+
+	source := newFakeRegistry()
+	base, top, empty := gzipped("base layer"), gzipped("top layer"), gzipped("")
+	for _, layer := range [][]byte{base, top, empty} {
+		source.blobs[sha256Digest(layer)] = layer
+	}
+	source.manifests["library/busybox:1.0"] = []byte(`{
+		"schemaVersion": 1,
+		"name": "library/busybox",
+		"tag": "1.0",
+		"architecture": "amd64",
+		"fsLayers": [{"blobSum": "` + sha256Digest(empty) + `"}, {"blobSum": "` + sha256Digest(top) + `"}, {"blobSum": "` + sha256Digest(base) + `"}],
+		"history": [
+			{"v1Compatibility": "{\"id\":\"c\",\"parent\":\"b\",\"architecture\":\"amd64\",\"os\":\"linux\",\"config\":{\"Cmd\":[\"sh\"]},\"created\":\"2014-10-01T00:00:03Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) CMD [sh]\"]},\"throwaway\":true}"},
+			{"v1Compatibility": "{\"id\":\"b\",\"parent\":\"a\",\"created\":\"2014-10-01T00:00:02Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) ADD top\"]}}"},
+			{"v1Compatibility": "{\"id\":\"a\",\"created\":\"2014-10-01T00:00:01Z\",\"container_config\":{\"Cmd\":[\"/bin/sh\",\"-c\",\"#(nop) ADD base\"]},\"Size\":10}"}
+		],
+		"signatures": []
+	}`)
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+
+	dest := newFakeRegistry()
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+	dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	digest, _, err := copySchema1Image(src, "library/busybox", "1.0", dst, "hub/busybox", "1.0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	body := dest.manifests["hub/busybox:1.0"]
+	if digest != sha256Digest(body) {
+		t.Errorf("Expected the digest of the converted manifest, got %s", digest)
+	}
+
+	var m manifest
+	json.Unmarshal(body, &m)
+	if m.SchemaVersion != 2 || m.MediaType != mediaTypeSchema2 || len(m.Layers) != 2 || m.Layers[0].Digest != sha256Digest(base) || m.Layers[1].Size != int64(len(top)) {
+		t.Fatalf("Unexpected converted manifest %s", body)
+	}
+
+	var config struct {
+		Architecture string                 `json:"architecture"`
+		ID           string                 `json:"id"`
+		Config       map[string]interface{} `json:"config"`
+		RootFS       struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+		History []configHistory `json:"history"`
+	}
+	if err := json.Unmarshal(dest.blobs[m.Config.Digest], &config); err != nil {
+		t.Fatalf("Unexpected config %s: %s", dest.blobs[m.Config.Digest], err)
+	}
+	if config.Architecture != "amd64" || config.ID != "" || config.Config["Cmd"] == nil {
+		t.Errorf("Expected the config of the top layer without its legacy fields, got %+v", config)
+	}
+	if want := []string{sha256Digest([]byte("base layer")), sha256Digest([]byte("top layer"))}; len(config.RootFS.DiffIDs) != 2 || config.RootFS.DiffIDs[0] != want[0] || config.RootFS.DiffIDs[1] != want[1] {
+		t.Errorf("Expected the uncompressed digests %v, got %v", want, config.RootFS.DiffIDs)
+	}
+	if len(config.History) != 3 || !config.History[2].EmptyLayer || config.History[0].CreatedBy != "/bin/sh -c #(nop) ADD base" {
+		t.Errorf("Unexpected history %+v", config.History)
+	}
+	if _, ok := dest.blobs[sha256Digest(empty)]; ok {
+		t.Errorf("Expected the empty throwaway layer not to be copied")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// labels holding the maintainer of an image, when the config has no author
+var maintainerLabels = []string{"maintainer", "org.opencontainers.image.authors"}
+
+// imageMetadata is the provenance of an upstream tag, read from its image config, so
+// catalogs can display it without pulling the image
+type imageMetadata struct {
+	Labels     map[string]string `json:"labels,omitempty"`
+	Created    *time.Time        `json:"created,omitempty"`
+	Maintainer string            `json:"maintainer,omitempty"`
+
+	artifactType string // of an OCI artifact, i.e. a Helm chart, which isn't scanned
+}
+
+// imageConfig is the subset of the OCI image config holding the metadata
+type imageConfig struct {
+	Created *time.Time `json:"created"`
+	Author  string     `json:"author"`
+	Config  struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// newImageMetadata returns the metadata of an image config, the maintainer is the author
+// of the image (the deprecated MAINTAINER instruction) or its maintainer labels
+func newImageMetadata(labels map[string]string, created time.Time, author string) *imageMetadata {
+	md := &imageMetadata{Labels: labels, Maintainer: author}
+	if !created.IsZero() {
+		created = created.UTC()
+		md.Created = &created
+	}
+
+	for _, label := range maintainerLabels {
+		if md.Maintainer == "" {
+			md.Maintainer = labels[label]
+		}
+	}
+
+	return md
+}
+
+// fetchImageMetadata reads the metadata from the config of the image in the registry, for
+// a multi-platform image the config of linux/amd64, or of its first platform
+func fetchImageMetadata(r *registryClient, repository, reference string) (*imageMetadata, error) {
+	body, _, _, err := r.manifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	if m.SchemaVersion == 1 {
+		return nil, &schema1Error{repository + ":" + reference}
+	}
+
+	if len(m.Manifests) > 0 {
+		child := m.Manifests[0]
+		for _, d := range m.Manifests {
+			if d.Platform != nil && d.Platform.OS == "linux" && d.Platform.Architecture == "amd64" {
+				child = d
+				break
+			}
+		}
+
+		return fetchImageMetadata(r, repository, child.Digest)
+	}
+
+	if m.Config == nil {
+		return nil, fmt.Errorf("Manifest %s:%s has no config", repository, reference)
+	}
+
+	if artifact := artifactType(m); artifact != "" {
+		return artifactMetadata(body, artifact), nil
+	}
+
+	blob, err := r.openBlob(repository, m.Config.Digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	var config imageConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	var created time.Time
+	if config.Created != nil {
+		created = *config.Created
+	}
+
+	return newImageMetadata(config.Config.Labels, created, config.Author), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestNewImageMetadata(t *testing.T) {
+	created := time.Date(2021, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+
+	tests := map[string]struct {
+		labels     map[string]string
+		author     string
+		maintainer string
+	}{
+		"author":      {map[string]string{"maintainer": "label"}, "author", "author"},
+		"label":       {map[string]string{"maintainer": "label"}, "", "label"},
+		"oci label":   {map[string]string{"org.opencontainers.image.authors": "oci"}, "", "oci"},
+		"no metadata": {nil, "", ""},
+	}
+
+	for name, tt := range tests {
+		md := newImageMetadata(tt.labels, created, tt.author)
+		if md.Maintainer != tt.maintainer {
+			t.Errorf("%s: expected maintainer %q, got %q", name, tt.maintainer, md.Maintainer)
+		}
+
+		if md.Created == nil || !md.Created.Equal(created) || md.Created.Location() != time.UTC {
+			t.Errorf("%s: expected created %s in UTC, got %v", name, created, md.Created)
+		}
+	}
+
+	if md := newImageMetadata(nil, time.Time{}, ""); md.Created != nil {
+		t.Errorf("Expected no created date, got %s", md.Created)
+	}
+}
+
+func TestFetchImageMetadata(t *testing.T) {
+	registry := newFakeRegistry()
+
+	config := []byte(`{"created":"2021-06-01T10:00:00Z","author":"Redis Docker Maintainers","config":{"Labels":{"org.opencontainers.image.source":"https://github.com/docker-library/redis"}}}`)
+	registry.blobs[sha256Digest(config)] = config
+	image, _ := json.Marshal(manifest{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Config:    &descriptor{Digest: sha256Digest(config), Size: int64(len(config))},
+	})
+	registry.manifests["library/redis:"+sha256Digest(image)] = image
+
+	arm := registry.image("library/redis", "arm")
+	index, _ := json.Marshal(manifest{
+		MediaType: "application/vnd.oci.image.index.v1+json",
+		Manifests: []descriptor{
+			{Digest: sha256Digest(arm), Platform: &platform{OS: "linux", Architecture: "arm64"}},
+			{Digest: sha256Digest(image), Platform: &platform{OS: "linux", Architecture: "amd64"}},
+		},
+	})
+	registry.manifests["library/redis:"+sha256Digest(arm)] = arm
+	registry.manifests["library/redis:7"] = index
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	c := newRegistryClient(strings.TrimPrefix(server.URL, "http://"), docker.AuthConfiguration{}, true)
+	md, err := fetchImageMetadata(c, "library/redis", "7")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := newImageMetadata(map[string]string{"org.opencontainers.image.source": "https://github.com/docker-library/redis"}, time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC), "Redis Docker Maintainers")
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("Expected the metadata of the linux/amd64 config %+v, got %+v", want, md)
+	}
+}
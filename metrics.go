@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricDefinition is a metric exposed on the /metrics endpoint of the admin server, the
+// dashboards and alert rules are generated from the same definitions
+type metricDefinition struct {
+	name string
+	kind string // prometheus metric type, counter or gauge
+	help string
+}
+
+var (
+	metricRuns             = metricDefinition{"docker_mirror_runs_total", "counter", "Number of completed runs"}
+	metricTags             = metricDefinition{"docker_mirror_tags_total", "counter", "Number of tags processed, by result"}
+	metricBytes            = metricDefinition{"docker_mirror_bytes_transferred_total", "counter", "Bytes of the mirrored images"}
+	metricSLAViolations    = metricDefinition{"docker_mirror_freshness_sla_violations_total", "counter", "Number of tags landing after their freshness SLA"}
+	metricLastRunTimestamp = metricDefinition{"docker_mirror_last_run_timestamp_seconds", "gauge", "Unix time the last run completed"}
+	metricLastRunDuration  = metricDefinition{"docker_mirror_last_run_duration_seconds", "gauge", "Duration of the last run"}
+	metricLastRunRepos     = metricDefinition{"docker_mirror_last_run_repositories", "gauge", "Number of repositories of the last run, by result"}
+	metricLastRunTags      = metricDefinition{"docker_mirror_last_run_tags", "gauge", "Number of tags of the last run, by result"}
+	metricPaused           = metricDefinition{"docker_mirror_paused", "gauge", "1 when the scheduler is paused"}
+	metricUpstreamRequests = metricDefinition{"docker_mirror_upstream_requests_total", "counter", "Upstream tag API calls and pulls, by host and kind"}
+	metricTagSlots         = metricDefinition{"docker_mirror_tag_slots", "gauge", "Number of tags mirrored at the same time, scaled with adaptive_workers"}
+	metricTransfers        = metricDefinition{"docker_mirror_docker_transfer_bytes_total", "counter", "Layer bytes downloaded and uploaded by the docker pulls and pushes, by action"}
+	metricLayers           = metricDefinition{"docker_mirror_docker_layers_total", "counter", "Layers done by the docker pulls and pushes (including the layers already there), by action"}
+	metricDefinitions      = []metricDefinition{
+		metricRuns, metricTags, metricBytes, metricSLAViolations, metricLastRunTimestamp, metricLastRunDuration,
+		metricLastRunRepos, metricLastRunTags, metricPaused, metricUpstreamRequests, metricTagSlots, metricTransfers,
+		metricLayers,
+	}
+)
+
+// metrics collects the results of the runs since the start
+var metrics = newRunMetrics()
+
+// runMetrics are the counters of all the runs, and the results of the last run
+type runMetrics struct {
+	mu            sync.Mutex
+	runs          int
+	tags          map[string]int // result -> count since start
+	bytes         int64
+	slaViolations int
+	lastFinished  time.Time
+	lastDuration  time.Duration
+	lastRepos     map[string]int // result -> count in the last run
+	lastTags      map[string]int // result -> count in the last run
+	transfers     map[string]int // pull or push -> layer bytes reported by docker
+	layers        map[string]int // pull or push -> layers done
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{tags: make(map[string]int), transfers: map[string]int{"pull": 0, "push": 0}, layers: map[string]int{"pull": 0, "push": 0}}
+}
+
+// transferred adds the layer bytes a docker pull or push reported as transferred, while the
+// run is in progress
+func (m *runMetrics) transferred(action string, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transfers[action] += int(bytes)
+}
+
+// layerDone counts a layer a docker pull or push is done with
+func (m *runMetrics) layerDone(action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.layers[action]++
+}
+
+// observe adds the results of a completed run
+func (m *runMetrics) observe(r *runReport, finished time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs++
+	m.slaViolations += r.SLAViolations
+	m.lastFinished = finished
+	m.lastDuration = finished.Sub(r.StartedAt)
+	m.lastRepos = map[string]int{resultMirrored: 0, resultSkipped: 0, resultFailed: 0, resultDeferred: 0}
+	m.lastTags = map[string]int{resultMirrored: 0, resultSkipped: 0, resultFailed: 0, resultUnsupported: 0, resultBlocked: 0}
+
+	for _, rr := range r.Repositories {
+		rr.mu.Lock()
+		m.lastRepos[rr.Result]++
+		for _, tr := range rr.Tags {
+			m.lastTags[tr.Result]++
+			m.tags[tr.Result]++
+			m.bytes += tr.BytesTransferred
+		}
+		rr.mu.Unlock()
+	}
+}
+
+// write renders the metrics in the prometheus text format
+func (m *runMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paused, _ := scheduler.status()
+
+	values := map[string][]string{
+		metricRuns.name:          {fmt.Sprintf("%d", m.runs)},
+		metricBytes.name:         {fmt.Sprintf("%d", m.bytes)},
+		metricSLAViolations.name: {fmt.Sprintf("%d", m.slaViolations)},
+		metricPaused.name:        {fmt.Sprintf("%d", boolMetric(paused))},
+		metricTagSlots.name:      {fmt.Sprintf("%d", adaptive.current())},
+		metricTags.name:          labelled("result", m.tags),
+		metricLastRunRepos.name:  labelled("result", m.lastRepos),
+		metricLastRunTags.name:   labelled("result", m.lastTags),
+		metricTransfers.name:     labelled("action", m.transfers),
+		metricLayers.name:        labelled("action", m.layers),
+	}
+
+	// there is no last run before the first run completes
+	if m.runs > 0 {
+		values[metricLastRunTimestamp.name] = []string{fmt.Sprintf("%d", m.lastFinished.Unix())}
+		values[metricLastRunDuration.name] = []string{fmt.Sprintf("%g", m.lastDuration.Seconds())}
+	}
+
+	var requests []string
+	for host, usage := range quotas.usage() {
+		for kind, count := range usage.Total {
+			requests = append(requests, fmt.Sprintf("{host=%q,kind=%q} %d", host, kind, count))
+		}
+	}
+	sort.Strings(requests)
+	values[metricUpstreamRequests.name] = requests
+
+	for _, d := range metricDefinitions {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+		for _, value := range values[d.name] {
+			if !strings.HasPrefix(value, "{") {
+				value = " " + value
+			}
+			fmt.Fprintf(w, "%s%s\n", d.name, value)
+		}
+	}
+}
+
+// labelled renders the counts by label value, in a stable order
+func labelled(label string, counts map[string]int) []string {
+	var res []string
+	for value, count := range counts {
+		res = append(res, fmt.Sprintf("{%s=%q} %d", label, value, count))
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricsHandler serves the metrics in the prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.write(w)
+}
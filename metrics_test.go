@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetrics(t *testing.T) {
+	r := newRunReport()
+	rr := r.repository("redis", dockerHub)
+	rr.tag("7").BytesTransferred = 1024
+	rr.tag("6").Result = resultSkipped
+	r.repository("postgres", dockerHub).fail(errors.New("Not found"))
+
+	m := newRunMetrics()
+	m.observe(r, r.StartedAt.Add(90*time.Second))
+	m.observe(r, r.StartedAt.Add(90*time.Second))
+
+	var buf bytes.Buffer
+	m.write(&buf)
+
+	for _, line := range []string{
+		"# TYPE docker_mirror_runs_total counter",
+		"docker_mirror_runs_total 2",
+		`docker_mirror_tags_total{result="mirrored"} 2`,
+		`docker_mirror_last_run_tags{result="mirrored"} 1`,
+		`docker_mirror_last_run_repositories{result="failed"} 1`,
+		"docker_mirror_bytes_transferred_total 2048",
+		"docker_mirror_last_run_duration_seconds 90",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected metric line %q, got\n%s", line, buf.String())
+		}
+	}
+}
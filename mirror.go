@@ -2,18 +2,20 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
-	"github.com/google/go-github/github"
 	"github.com/ryanuber/go-glob"
 	log "github.com/sirupsen/logrus"
 )
@@ -23,6 +25,10 @@ const (
 	quay      = "quay.io"
 	gcr       = "gcr.io"
 	k8s       = "k8s.gcr.io"
+
+	// remote_tags_source values, by default the tags come from the API of the host
+	remoteTagSourceGitHub   = "github"
+	remoteTagSourceRegistry = "registry"
 )
 
 var (
@@ -54,56 +60,359 @@ type GCRTagsResponse struct {
 // RepositoryTag is Docker, Quay, GCR API compatible struct, holding the individual
 // tags for the requested repository
 type RepositoryTag struct {
-	Name         string    `json:"name"`
-	LastUpdated  time.Time `json:"last_updated"`
-	LastModified time.Time `json:"last_modified"`
+	Name           string    `json:"name"`
+	LastUpdated    time.Time `json:"last_updated"`
+	LastModified   time.Time `json:"last_modified"`
+	Digest         string    `json:"digest"`          // Docker Hub
+	ManifestDigest string    `json:"manifest_digest"` // Quay
+}
+
+// digest returns the upstream digest of the tag, if the tag API exposes it
+func (t RepositoryTag) digest() string {
+	if t.Digest != "" {
+		return t.Digest
+	}
+
+	return t.ManifestDigest
 }
 
-// logWriter is a io.Writer compatible wrapper, piping the output
-// to a specific logrus entry
+const (
+	// longest line of the docker JSON stream buffered, longer lines are dropped
+	maxDockerLine = 64 * 1024
+
+	// interval between two logs of the progress of a docker pull or push
+	dockerProgressInterval = 30 * time.Second
+)
+
+// statuses of the docker JSON stream reporting the progress of a layer, they are counted
+// instead of logged
+var dockerProgressStatuses = map[string]bool{"Downloading": true, "Pushing": true, "Extracting": true, "Waiting": true, "Preparing": true}
+
+// statuses of the docker JSON stream naming a layer, and whether the layer is done (pushes
+// also report "Mounted from <repository>" for the layers mounted from another repository)
+var dockerLayerStatuses = map[string]bool{
+	"Pulling fs layer": false, "Waiting": false, "Preparing": false, "Downloading": false, "Pushing": false, "Verifying Checksum": false,
+	"Download complete": true, "Extracting": true, "Pull complete": true, "Already exists": true, "Pushed": true, "Layer already exists": true,
+}
+
+// logWriter is a io.Writer compatible wrapper, decoding the raw docker JSON stream of a
+// pull or push to a specific logrus entry. The layer progress is counted in the metrics
+// and logged as a summary with an ETA, instead of logging every progress bar
 type logWriter struct {
-	logger *log.Entry
+	logger     *log.Entry
+	action     string                    // pull or push
+	buf        []byte                    // incomplete line of the raw JSON stream
+	err        error                     // error reported in the raw JSON stream
+	layers     map[string]*layerProgress // layer id -> bytes transferred
+	started    time.Time
+	lastReport time.Time
+	onProgress func(transferProgress) // called on every progress, nil to only log it
 }
 
-func (l logWriter) Write(p []byte) (n int, err error) {
-	l.logger.Debug(strings.Trim(string(p), "\n"))
+// layerProgress is the progress of the download or upload of a layer
+type layerProgress struct {
+	current int64
+	total   int64
+	done    bool
+}
+
+// transferProgress is the progress of a docker pull or push, as logged and listed with the
+// tag in /debug/state
+type transferProgress struct {
+	Action     string  `json:"action"`
+	LayersDone int     `json:"layers_done"`
+	Layers     int     `json:"layers"`
+	Bytes      int64   `json:"bytes"`
+	Total      int64   `json:"total_bytes"`
+	ETA        float64 `json:"eta_seconds,omitempty"`
+}
+
+// dockerMessage is a single message of the docker pull/push JSON stream
+type dockerMessage struct {
+	Status         string `json:"status"`
+	ID             string `json:"id"`
+	Progress       string `json:"progress"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+func newLogWriter(logger *log.Entry, action string) *logWriter {
+	now := time.Now()
+	return &logWriter{logger: logger.WithField("docker_action", action), action: action, layers: make(map[string]*layerProgress), started: now, lastReport: now}
+}
+
+func (l *logWriter) Write(p []byte) (n int, err error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		l.logMessage(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+
+	// docker writes a line per message, anything longer isn't worth holding in memory
+	if len(l.buf) > maxDockerLine {
+		l.logger.Debugf("Dropping %d bytes of docker output without a line break", len(l.buf))
+		l.buf = nil
+	}
+
 	return len(p), nil
 }
 
+// logMessage logs a single line of the raw JSON stream with its fields
+func (l *logWriter) logMessage(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var msg dockerMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		l.logger.Debug(string(line))
+		return
+	}
+
+	if msg.Error != "" {
+		l.err = errors.New(msg.Error)
+		l.logger.WithField("docker_error", msg.Error).Debug(msg.Error)
+		return
+	}
+
+	if done, ok := dockerLayerStatuses[msg.Status]; msg.ID != "" && (ok || strings.HasPrefix(msg.Status, "Mounted from ")) {
+		l.layerStatus(msg.ID, done || !ok)
+	}
+
+	if dockerProgressStatuses[msg.Status] {
+		// extracting a layer doesn't transfer anything
+		if msg.ID != "" && msg.ProgressDetail.Total > 0 && msg.Status != "Extracting" {
+			l.progress(msg.ID, msg.ProgressDetail.Current, msg.ProgressDetail.Total)
+		}
+		return
+	}
+
+	fields := log.Fields{"docker_status": msg.Status}
+	if msg.ID != "" {
+		fields["docker_layer"] = msg.ID
+	}
+
+	l.logger.WithFields(fields).Debug(msg.Status)
+}
+
+// layer returns the progress of the layer, added on its first message
+func (l *logWriter) layer(id string) *layerProgress {
+	layer, ok := l.layers[id]
+	if !ok {
+		layer = &layerProgress{}
+		l.layers[id] = layer
+	}
+
+	return layer
+}
+
+// layerStatus records the layer named by a status, and counts it once done
+func (l *logWriter) layerStatus(id string, done bool) {
+	layer := l.layer(id)
+	if done && !layer.done {
+		layer.done = true
+		metrics.layerDone(l.action)
+		if l.onProgress != nil {
+			l.onProgress(l.status(time.Now()))
+		}
+	}
+}
+
+// progress records the bytes transferred of the layer, and logs the progress of all the
+// layers every dockerProgressInterval
+func (l *logWriter) progress(id string, current, total int64) {
+	layer := l.layer(id)
+	if current > layer.current {
+		metrics.transferred(l.action, current-layer.current)
+		runState.progressed()
+	}
+	layer.current, layer.total = current, total
+
+	now := time.Now()
+	p := l.status(now)
+	if l.onProgress != nil {
+		l.onProgress(p)
+	}
+
+	if now.Sub(l.lastReport) < dockerProgressInterval {
+		return
+	}
+	l.lastReport = now
+
+	fields := log.Fields{"docker_progress_current": p.Bytes, "docker_progress_total": p.Total, "docker_layers_done": p.LayersDone, "docker_layers_total": p.Layers}
+	message := fmt.Sprintf("Docker %s progress: %d/%d layers, %s of %s", l.action, p.LayersDone, p.Layers, humanBytes(p.Bytes), humanBytes(p.Total))
+	if p.Bytes > 0 {
+		fields["docker_eta_seconds"] = p.ETA
+		message += fmt.Sprintf(", ETA %s", time.Duration(p.ETA)*time.Second)
+	}
+
+	l.logger.WithFields(fields).Info(message)
+}
+
+// status sums the progress of the layers, the ETA is extrapolated from the bytes transferred
+// since the start
+func (l *logWriter) status(now time.Time) transferProgress {
+	p := transferProgress{Action: l.action, Layers: len(l.layers)}
+	for _, layer := range l.layers {
+		p.Bytes += layer.current
+		p.Total += layer.total
+		if layer.done {
+			p.LayersDone++
+		}
+	}
+
+	if p.Bytes > 0 {
+		p.ETA = time.Duration(float64(now.Sub(l.started)) * float64(p.Total-p.Bytes) / float64(p.Bytes)).Round(time.Second).Seconds()
+	}
+
+	return p
+}
+
+// humanBytes formats a number of bytes with a binary unit, i.e. 12.3MiB
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// result returns the error of the docker call, or the error reported in the raw JSON
+// stream, docker doesn't fail the call itself when the stream is not decoded
+func (l *logWriter) result(err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(l.buf) > 0 {
+		l.logMessage(l.buf)
+		l.buf = nil
+	}
+
+	return l.err
+}
+
 type DockerClient interface {
 	Info() (*docker.DockerInfo, error)
 	TagImage(string, docker.TagImageOptions) error
 	PullImage(docker.PullImageOptions, docker.AuthConfiguration) error
 	PushImage(docker.PushImageOptions, docker.AuthConfiguration) error
 	RemoveImage(string) error
+	InspectImage(string) (*docker.Image, error)
+	ListImages(docker.ListImagesOptions) ([]docker.APIImages, error)
+	BuildImage(docker.BuildImageOptions) error
 }
 
 type mirror struct {
-	dockerClient *DockerClient   // docker client used to pull, tag and push images
-	ecrManager   ecrManager      // ECR manager, used to ensure the ECR repository exist
-	log          *log.Entry      // logrus logger with the relevant custom fields
-	repo         Repository      // repository the mirror
-	remoteTags   []RepositoryTag // list of remote repository tags (post filtering)
+	dockerClient *DockerClient     // docker client used to pull, tag and push images
+	targets      []*target         // registries to push the mirrored images to
+	cleaner      *cleaner          // background remover of local images, used when cleanup is enabled
+	report       *repositoryReport // run report entry of the repository
+	span         *span             // trace span of the repository
+	log          *log.Entry        // logrus logger with the relevant custom fields
+	repo         Repository        // repository the mirror
+	remoteTags   []RepositoryTag   // list of remote repository tags (post filtering)
+	upstream     map[string]string // all the remote tags and their digest (pre filtering), with `track_deletions`
+	matchTagRE   []*regexp.Regexp  // compiled `match_tag_regex` of the repository
+	ignoreTagRE  []*regexp.Regexp  // compiled `ignore_tag_regex` of the repository
+	semver       *semverConstraint // parsed `semver` constraint of the repository
+	tagMap       []tagMapping      // compiled `tag_map` of the repository
+	trust        *trustData        // signed tags of the repository, with `content_trust`
+	signedDigest string            // signed digest of the tag being mirrored, with `content_trust`
+	schema1      string            // source digest of the tag being mirrored when it is a schema1 manifest, with `convert_schema1`
+	timedOut     chan struct{}     // closed once the `timeout` of the repository is exceeded, nil without timeout
+	tag          string            // remote tag mirrored by the copy of the mirror of every tag
 }
 
 const defaultSleepDuration time.Duration = 60 * time.Second
 
+// tagSlots limits the number of tags mirrored at the same time across all
+// repositories to the number of workers, nil means no limit
+var tagSlots chan struct{}
+
 func (m *mirror) setup(repo Repository) (err error) {
 	m.log = log.WithField("full_repo", repo.Name)
-	m.repo = repo
+	m.repo = repo.upstream()
+
+	// a tenant repository is only pushed to the targets of its tenant
+	m.targets = tenantTargets(m.targets, repo.tenant)
+	if repo.tenant != "" {
+		m.log = m.log.WithField("tenant", repo.tenant)
+	}
+
+	var digests []PinnedDigest
+	m.repo.Name, digests = repo.pinnedDigests()
+
 	// specific tag to mirror
-	if strings.Contains(repo.Name, ":") {
-		chunk := strings.SplitN(repo.Name, ":", 2)
+	if strings.Contains(m.repo.Name, ":") {
+		chunk := strings.SplitN(m.repo.Name, ":", 2)
 		m.repo.Name = chunk[0]
 		m.repo.MatchTags = []string{chunk[1]}
 	}
 
+	if err := m.compileTagFilters(); err != nil {
+		return err
+	}
+
+	if m.repo.ContentTrust {
+		m.trust = &trustData{}
+	}
+
+	// pinned digests are pushed as their tag
+	var pinned []tagMapping
+	for _, d := range digests {
+		pinned = append(pinned, tagMapping{from: d.Digest, to: d.targetTag()})
+	}
+	m.tagMap = append(pinned, m.tagMap...)
+
+	// a static list of tags and digests is mirrored as is, without listing the tags of the host
+	if len(m.repo.Tags) > 0 || len(digests) > 0 {
+		m.remoteTags = nil
+		for _, tag := range m.repo.Tags {
+			m.remoteTags = append(m.remoteTags, RepositoryTag{Name: tag})
+		}
+		for _, d := range digests {
+			m.remoteTags = append(m.remoteTags, RepositoryTag{Name: d.Digest, Digest: d.Digest})
+		}
+		m.remoteTags = m.dropTagMapCollisions(m.remoteTags)
+
+		m.log = m.log.WithField("repo", m.repo.Name)
+		m.log = m.log.WithField("num_tags", len(m.remoteTags))
+		return nil
+	}
+
 	// fetch remote tags
+	s := m.span.child("list tags")
 	m.remoteTags, err = m.getRemoteTags()
+	s.set("num_tags", strconv.Itoa(len(m.remoteTags)))
+	s.finish(err)
 	if err != nil {
 		return err
 	}
 
+	if m.repo.TrackDeletions && state != nil {
+		m.upstream = make(map[string]string, len(m.remoteTags))
+		for _, tag := range m.remoteTags {
+			m.upstream[tag.Name] = tag.digest()
+		}
+	}
+
 	m.filterTags()
 
 	m.log = m.log.WithField("repo", m.repo.Name)
@@ -112,53 +421,25 @@ func (m *mirror) setup(repo Repository) (err error) {
 }
 
 // filter tags by
-//  - by matching tag name (with glob support)
-//  - by exluding tag name (with glob support)
-//  - by tag age
-//  - by max number of tags to process
+//   - by matching tag name (with glob and regex support)
+//   - by exluding tag name (with glob and regex support)
+//   - by tag age
+//   - by max number of tags to process
 func (m *mirror) filterTags() {
 	now := time.Now()
 	res := make([]RepositoryTag, 0)
 
 	for _, remoteTag := range m.remoteTags {
-		// match tags, with glob
-		if len(m.repo.MatchTags) > 0 {
-			keep := false
-			for _, tag := range m.repo.MatchTags {
-				if !glob.Glob(tag, remoteTag.Name) {
-					m.log.Debugf("Dropping tag '%s', it doesn't match glob pattern '%s'", remoteTag.Name, tag)
-					continue
-				}
-
-				keep = true
-			}
-
-			if !keep {
-				continue
-			}
-		}
-
-		// filter all tags what should be ignored, with glob
-		if len(m.repo.DropTags) > 0 {
-			keep := true
-			for _, tag := range m.repo.DropTags {
-				if glob.Glob(tag, remoteTag.Name) {
-					m.log.Debugf("Dropping tag '%s', its ignored by glob '%s'", remoteTag.Name, tag)
-					keep = false
-					break
-				}
-			}
-
-			if !keep {
-				continue
-			}
+		if filter, reason := m.tagFilter(remoteTag.Name); filter != "" {
+			m.dropTag(remoteTag.Name, filter, reason)
+			continue
 		}
 
 		// filter on tag age
 		if m.repo.MaxTagAge != nil {
 			dur := time.Duration(*m.repo.MaxTagAge)
 			if now.Sub(remoteTag.LastUpdated) > dur {
-				m.log.Debugf("Dropping tag '%s', its older than %s", remoteTag.Name, m.repo.MaxTagAge.String())
+				m.dropTag(remoteTag.Name, filterAge, fmt.Sprintf("its older than %s", m.repo.MaxTagAge.String()))
 				continue
 			}
 		}
@@ -166,23 +447,167 @@ func (m *mirror) filterTags() {
 		res = append(res, remoteTag)
 	}
 
+	// retention rule on the semantic versions, e.g. the latest patch of the last 3 minors
+	if m.repo.Keep != nil {
+		kept := m.repo.Keep.apply(res, m.repo.SemverKeepOther)
+
+		keptNames := make(map[string]bool, len(kept))
+		for _, tag := range kept {
+			keptNames[tag.Name] = true
+		}
+		for _, tag := range res {
+			if !keptNames[tag.Name] {
+				m.dropTag(tag.Name, filterKeep, "its not kept by the keep rule")
+			}
+		}
+		res = kept
+	}
+
 	// limit list of tags to $n newest (sorted by age by default)
 	if m.repo.MaxTags > 0 && len(res) > m.repo.MaxTags {
-		m.log.Debugf("Dropping %d tags, only need %d newest", len(res)-m.repo.MaxTags, m.repo.MaxTags)
+		for _, tag := range res[m.repo.MaxTags:] {
+			m.dropTag(tag.Name, filterMaxTags, fmt.Sprintf("only need %d newest", m.repo.MaxTags))
+		}
 		res = res[:m.repo.MaxTags]
 	}
 
+	res = m.dropTagMapCollisions(res)
+
 	m.remoteTags = res
 }
 
+// compileTagFilters compiles the `match_tag_regex`, `ignore_tag_regex` and `semver` of the repository
+func (m *mirror) compileTagFilters() (err error) {
+	if m.matchTagRE, err = compileRegexes(m.repo.MatchTagRegex); err != nil {
+		return fmt.Errorf("Invalid match_tag_regex: %s", err)
+	}
+
+	if m.ignoreTagRE, err = compileRegexes(m.repo.IgnoreTagRegex); err != nil {
+		return fmt.Errorf("Invalid ignore_tag_regex: %s", err)
+	}
+
+	m.semver = nil
+	if m.repo.Semver != "" {
+		if m.semver, err = parseSemverConstraint(m.repo.Semver); err != nil {
+			return fmt.Errorf("Invalid semver: %s", err)
+		}
+	}
+
+	if m.tagMap, err = compileTagMap(m.repo.TagMap); err != nil {
+		return fmt.Errorf("Invalid tag_map: %s", err)
+	}
+
+	return nil
+}
+
+func compileRegexes(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// wantsTag returns true if the tag passes the `match_tag` and `ignore_tag` globs, the
+// `match_tag_regex` and `ignore_tag_regex`, and the `semver` constraint of the repository
+func (m *mirror) wantsTag(name string) bool {
+	filter, _ := m.tagFilter(name)
+	return filter == ""
+}
+
+// tagFilter returns the filter dropping the tag and why, empty when the tag is wanted
+func (m *mirror) tagFilter(name string) (string, string) {
+	// match tags, with glob
+	if len(m.repo.MatchTags) > 0 {
+		keep := false
+		for _, tag := range m.repo.MatchTags {
+			if glob.Glob(tag, name) {
+				keep = true
+				break
+			}
+		}
+
+		if !keep {
+			return filterGlob, fmt.Sprintf("it doesn't match any match_tag glob (%s)", strings.Join(m.repo.MatchTags, ", "))
+		}
+	}
+
+	// filter all tags what should be ignored, with glob
+	for _, tag := range m.repo.DropTags {
+		if glob.Glob(tag, name) {
+			return filterGlob, fmt.Sprintf("its ignored by glob '%s'", tag)
+		}
+	}
+
+	// match tags, with regex, on top of the globs
+	if len(m.matchTagRE) > 0 {
+		keep := false
+		for _, re := range m.matchTagRE {
+			if re.MatchString(name) {
+				keep = true
+				break
+			}
+		}
+
+		if !keep {
+			return filterRegex, "it doesn't match any match_tag_regex"
+		}
+	}
+
+	// filter all tags what should be ignored, with regex
+	for _, re := range m.ignoreTagRE {
+		if re.MatchString(name) {
+			return filterRegex, fmt.Sprintf("its ignored by regex '%s'", re)
+		}
+	}
+
+	// match tags, with a semver constraint
+	if m.semver != nil {
+		v, ok := parseSemver(name)
+		if !ok && !m.repo.SemverKeepOther {
+			return filterSemver, "it isn't a semantic version"
+		}
+
+		if ok && !m.semver.matches(v) {
+			return filterSemver, fmt.Sprintf("it doesn't match semver '%s'", m.repo.Semver)
+		}
+	}
+
+	return "", ""
+}
+
+// dropTag records why the tag isn't mirrored, in the debug logs and the run report
+func (m *mirror) dropTag(name, filter, reason string) {
+	m.log.Debugf("Dropping tag '%s', %s", name, reason)
+	if m.report != nil {
+		m.report.filter(name, filter, reason)
+	}
+}
+
 // return the name of repostiory, as it should be on the target
 // this include any target repository prefix + the repository name in DockerHub
-func (m *mirror) targetRepositoryName() string {
-	if m.repo.TargetPrefix != nil {
+// the repository `target_prefix` only overrides the prefix of the primary `target`,
+// the registries in `targets` always use their own prefix, or `name_template`
+func (m *mirror) targetRepositoryName(t *target) string {
+	// an explicit empty prefix opts the repository out of the prefix of every target
+	if m.repo.TargetPrefix != nil && (t.primary || *m.repo.TargetPrefix == "") {
 		return fmt.Sprintf("%s%s", *m.repo.TargetPrefix, m.repo.Name)
 	}
 
-	return fmt.Sprintf("%s%s", config.Target.Prefix, m.repo.Name)
+	if t.nameTemplate != nil {
+		name, err := executeNameTemplate(t.nameTemplate, nameTemplateData{Host: m.repo.Host, Name: m.repo.Name, Prefix: t.config.Prefix})
+		if err == nil {
+			return name
+		}
+		m.log.Warnf("Could not render the name_template of %s, using the prefix: %s", t.registry, err)
+	}
+
+	return fmt.Sprintf("%s%s", t.config.Prefix, m.repo.Name)
 }
 
 // pull the image from remote repository to local docker agent
@@ -190,196 +615,649 @@ func (m *mirror) pullImage(tag string) error {
 	m.log.Info("Starting docker pull")
 	defer m.timeTrack(time.Now(), "Completed docker pull")
 
+	quotas.record(m.pullHost(), quotaPull)
+
+	output := m.transferLog("pull")
 	pullOptions := docker.PullImageOptions{
 		Tag:               tag,
 		InactivityTimeout: 1 * time.Minute,
-		OutputStream:      &logWriter{logger: m.log.WithField("docker_action", "pull")},
+		OutputStream:      output,
+		RawJSONStream:     true,
+	}
+	pullOptions.Repository = m.sourceRepository()
+
+	// the docker daemon pulls a single platform, the first of `platforms`
+	if len(m.repo.Platforms) > 0 {
+		pullOptions.Platform = m.repo.Platforms[0]
+	}
+
+	return output.result((*m.dockerClient).PullImage(pullOptions, m.sourceAuth()))
+}
+
+// transferLog returns the writer of the docker output of a pull or push of the tag, with
+// its progress listed with the tag in /debug/state
+func (m *mirror) transferLog(action string) *logWriter {
+	output := newLogWriter(m.log, action)
+	if m.report != nil && m.tag != "" {
+		host, repo, tag := m.report.Host, m.report.Name, m.tag
+		output.onProgress = func(p transferProgress) { runState.transferring(host, repo, tag, p) }
 	}
+
+	return output
+}
+
+// return the credentials used to pull from the source host
+func (m *mirror) sourceAuth() docker.AuthConfiguration {
 	authConfig := docker.AuthConfiguration{}
 
+	// gcr.io and Artifact Registry use the Google credentials, of the repository or global
+	if auth, ok := m.googleAuth(); ok {
+		return auth
+	}
+
+	// the `auth` of the repository takes precedence over the credentials of its host
+	if m.repo.Auth != nil && m.repo.Auth.Username != "" {
+		authConfig.Username, authConfig.Password = m.repo.Auth.Username, m.repo.Auth.Password
+		if host, _ := splitReference(m.sourceRepository()); host != dockerHubRegistry {
+			authConfig.ServerAddress = host
+		}
+		return authConfig
+	}
+
 	switch m.repo.Host {
 	case dockerHub:
-		pullOptions.Repository = m.repo.Name
+		// a name with a registry (i.e. ghcr.io/org/charts/foo) isn't pulled from Docker Hub
+		if host, _ := splitReference(m.sourceRepository()); host != dockerHubRegistry {
+			return authConfig
+		}
 
 		if os.Getenv("DOCKERHUB_USER") != "" && os.Getenv("DOCKERHUB_PASSWORD") != "" {
 			m.log.Info("Using docker hub credentials from environment")
 			authConfig.Username = os.Getenv("DOCKERHUB_USER")
 			authConfig.Password = os.Getenv("DOCKERHUB_PASSWORD")
+		} else if login := dockerHubLogin(); login != nil {
+			// the registry accepts the OAuth access token as password
+			authConfig.Username = login.Username
+			authConfig.Password = login.AccessToken
 		}
+	case quay:
+		authConfig = quayAuth()
+	default:
+		if h := customHost(m.repo.Host); h != nil {
+			authConfig = h.auth()
+		}
+	}
 
+	return authConfig
+}
+
+// return the repository the image is pulled from, as known by the local docker agent
+func (m *mirror) sourceRepository() string {
+	switch m.repo.Host {
+	case dockerHub:
 		if m.repo.PrivateRegistry != "" {
-			pullOptions.Repository = m.repo.PrivateRegistry + "/" + m.repo.Name
-			return (*m.dockerClient).PullImage(pullOptions, authConfig)
+			return m.repo.PrivateRegistry + "/" + m.repo.Name
 		}
-	case quay:
-		pullOptions.Repository = quay + "/" + m.repo.Name
-	case gcr:
-		pullOptions.Repository = gcr + "/" + m.repo.Name
-	case k8s:
-		pullOptions.Repository = k8s + "/" + m.repo.Name
+		if m.repo.PullHost != "" {
+			_, path := splitReference(m.upstreamName())
+			return m.repo.PullHost + "/" + path
+		}
+		return m.upstreamName()
+	case quay, gcr, k8s:
+		return m.repo.Host + "/" + m.upstreamName()
+	}
+
+	if h := customHost(m.repo.Host); h != nil {
+		return h.repository(m.upstreamName())
 	}
 
-	return (*m.dockerClient).PullImage(pullOptions, authConfig)
+	return m.upstreamName()
 }
 
 // (re)tag the (local) docker image with the target repository name
-func (m *mirror) tagImage(tag string) error {
+func (m *mirror) tagImage(t *target, image, targetTag string) error {
 	m.log.Info("Starting docker tag")
 	defer m.timeTrack(time.Now(), "Completed docker tag")
 
 	tagOptions := docker.TagImageOptions{
-		Repo:  fmt.Sprintf("%s/%s", config.Target.Registry, m.targetRepositoryName()),
-		Tag:   tag,
+		Repo:  fmt.Sprintf("%s/%s", t.registry, m.targetRepositoryName(t)),
+		Tag:   targetTag,
 		Force: true,
 	}
 
-	switch m.repo.Host {
-	case dockerHub:
-		return (*m.dockerClient).TagImage(fmt.Sprintf("%s:%s", m.repo.Name, tag), tagOptions)
-	case quay:
-		return (*m.dockerClient).TagImage(fmt.Sprintf("%s/%s:%s", quay, m.repo.Name, tag), tagOptions)
-	case gcr:
-		return (*m.dockerClient).TagImage(fmt.Sprintf("%s/%s:%s", gcr, m.repo.Name, tag), tagOptions)
-	case k8s:
-		return (*m.dockerClient).TagImage(fmt.Sprintf("%s/%s:%s", k8s, m.repo.Name, tag), tagOptions)
-	}
-
-	return nil
+	return (*m.dockerClient).TagImage(image, tagOptions)
 }
 
 // push the local (re)tagged image to the target docker registry
-func (m *mirror) pushImage(tag string) error {
+func (m *mirror) pushImage(t *target, tag string) error {
 	m.log.Info("Starting docker push")
 	defer m.timeTrack(time.Now(), "Completed docker push")
 
+	output := m.transferLog("push")
 	pushOptions := docker.PushImageOptions{
-		Name:              fmt.Sprintf("%s/%s", config.Target.Registry, m.targetRepositoryName()),
-		Registry:          config.Target.Registry,
+		Name:              fmt.Sprintf("%s/%s", t.registry, m.targetRepositoryName(t)),
+		Registry:          t.registry,
 		Tag:               tag,
-		OutputStream:      &logWriter{logger: m.log.WithField("docker_action", "push")},
+		OutputStream:      output,
+		RawJSONStream:     true,
 		InactivityTimeout: 1 * time.Minute,
 	}
 
-	var (
-		creds *docker.AuthConfiguration
-		err   error
-	)
-
-	if !isPrivateECR {
-		creds, err = getDockerCredentials(ecrPublicRegistryPrefix)
-	} else {
-		creds, err = getDockerCredentials(config.Target.Registry)
-	}
+	creds, err := t.credentials()
 	if err != nil {
 		return err
 	}
 
-	return (*m.dockerClient).PushImage(pushOptions, *creds)
+	return output.result((*m.dockerClient).PushImage(pushOptions, *creds))
 }
 
-func (m *mirror) deleteImage(tag string) error {
-	var repository string
-	switch m.repo.Host {
-	case dockerHub:
-		repository = fmt.Sprintf("%s:%s", m.repo.Name, tag)
-	case quay:
-		repository = fmt.Sprintf("%s/%s:%s", quay, m.repo.Name, tag)
-	case gcr:
-		repository = fmt.Sprintf("%s/%s:%s", gcr, m.repo.Name, tag)
-	case k8s:
-		repository = fmt.Sprintf("%s/%s:%s", k8s, m.repo.Name, tag)
+// list the local images created while mirroring the tag, the source image and/or
+// the (re)tagged image for each target, depending on the `cleanup_scope`
+func (m *mirror) cleanupImages(tag string, targets []*target, day time.Time) []string {
+	return m.scopedImages(tag, targets, day, config.CleanupScope)
+}
+
+func (m *mirror) scopedImages(tag string, targets []*target, day time.Time, scope string) []string {
+	var images []string
+	if scope != cleanupScopeTargetLocal {
+		images = append(images, m.sourceImage(tag))
+		if len(m.addLabels()) > 0 {
+			images = append(images, m.labelledImage(tag))
+		}
 	}
-	m.log.Info("Cleaning images: " + repository)
-	err := (*m.dockerClient).RemoveImage(repository)
-	if err != nil {
-		return err
+
+	if scope == cleanupScopeSource {
+		return images
 	}
 
-	target := fmt.Sprintf("%s/%s:%s", config.Target.Registry, m.targetRepositoryName(), tag)
-	m.log.Info("Cleaning images: " + target)
-	err = (*m.dockerClient).RemoveImage(target)
-	if err != nil {
-		return err
+	for _, t := range targets {
+		images = append(images, fmt.Sprintf("%s/%s:%s", t.registry, m.targetRepositoryName(t), m.targetTag(t, tag, day)))
 	}
 
-	return nil
+	return images
 }
 
 func (m *mirror) work() {
 	m.log.Debugf("Starting work")
 
-	if err := m.ecrManager.ensure(m.targetRepositoryName()); err != nil {
-		log.Errorf("Failed to create ECR repo %s: %s", m.targetRepositoryName(), err)
-		return
-	}
-
-	for _, tag := range m.remoteTags {
-		m.log = m.log.WithField("tag", tag.Name)
-		m.log.Info("Start mirror tag")
-
-		if err := m.pullImage(tag.Name); err != nil {
-			m.log.Errorf("Failed to pull docker image: %s", err)
+	// only keep the targets that want this repository, and that got the repository created
+	var targets []*target
+	for _, t := range m.targets {
+		if !t.wantsRepository(m.repo.Name) {
+			m.log.Debugf("Skipping target %s, repository is not matched by its filters", t.registry)
 			continue
 		}
 
-		if err := m.tagImage(tag.Name); err != nil {
-			m.log.Errorf("Failed to (re)tag docker image: %s", err)
+		if err := t.ecrManager.ensure(m.targetRepositoryName(t)); err != nil {
+			log.Errorf("Failed to create ECR repo %s in %s: %s", m.targetRepositoryName(t), t.registry, err)
 			continue
 		}
 
-		if err := m.pushImage(tag.Name); err != nil {
-			m.log.Errorf("Failed to push (re)tagged image: %s", err)
-			continue
+		if m.repo.Catalog != nil {
+			if err := t.ecrManager.putCatalogData(m.targetRepositoryName(t), m.repo.Catalog); err != nil {
+				m.log.Errorf("Failed to set catalog data for %s in %s: %s", m.targetRepositoryName(t), t.registry, err)
+			}
 		}
 
-		if config.Cleanup == true {
-			if err := m.deleteImage(tag.Name); err != nil {
-				m.log.Errorf("Failed to clean image: %s", err)
-				continue
+		targets = append(targets, t)
+	}
+
+	concurrency := m.repo.TagConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i, tag := range m.remoteTags {
+		sem <- struct{}{}
+		scheduler.wait(m.log)
+
+		if reason := m.stopped(); reason != "" {
+			<-sem
+			m.log.Warnf("Skipping the remaining %d tags: %s", len(m.remoteTags)-i, reason)
+			for _, skipped := range m.remoteTags[i:] {
+				tr := m.report.tag(skipped.Name)
+				tr.Result, tr.Reason = resultSkipped, reason
 			}
+			break
 		}
 
-		m.log.Info("Successfully pushed (re)tagged image")
+		wg.Add(1)
+		go func(tag RepositoryTag) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if tagSlots != nil {
+				tagSlots <- struct{}{}
+				defer func() { <-tagSlots }()
+			}
+
+			// every tag gets its own copy of the mirror, so the tag logger isn't shared
+			tm := *m
+			tm.log = m.log.WithField("tag", tag.Name)
+			tm.tag = tag.Name
+			defer runState.tagStarted(m.report.Host, m.report.Name, tag.Name)()
+			tm.mirrorTag(targets, tag)
+		}(tag)
+	}
+	wg.Wait()
+
+	// before pruning, so the quarantined copy of a deleted tag is kept
+	if m.upstream != nil {
+		m.trackDeletions(targets, time.Now())
+	}
+	if m.repo.PruneTarget {
+		m.pruneTargets(targets)
+	}
+	if m.repo.TargetMaxTags > 0 || m.repo.TargetMaxTagAge != nil {
+		m.applyRetention(targets, time.Now())
 	}
 
 	m.log.WithField("tag", "")
 	m.log.Info("Repository mirror completed")
 }
 
-// get the remote tags from the remote compatible registry.
-// read out the image tag and when it was updated, and sort by the updated time if applicable
-func (m *mirror) getRemoteTags() ([]RepositoryTag, error) {
-	if m.repo.RemoteTagSource == "github" {
-		client := github.NewClient(nil)
-		limit, err := strconv.Atoi(m.repo.RemoteTagConfig["num_releases"])
+// mirror a single tag to all the targets that want it, recording the result in the report
+func (m *mirror) mirrorTag(targets []*target, remoteTag RepositoryTag) {
+	tag := remoteTag.Name
+	start := time.Now()
+	tr := m.report.tag(tag)
+	ts := m.span.child("mirror tag", "tag", tag)
+	defer func() {
+		// tags are mirrored in their own goroutine, a panic would crash the run
+		if r := recover(); r != nil {
+			err := panicError(r)
+			m.log.Errorf("Recovered from a panic while mirroring the tag: %s\n%s", err, debug.Stack())
+			tr.fail(m.report, err)
+		}
+
+		tr.Duration = time.Since(start).Seconds()
+		if tr.Result == resultMirrored {
+			adaptive.completed(tr.BytesTransferred)
+		}
+		ts.set("result", tr.Result)
+		ts.finish(nil)
+	}()
+
+	// the standby targets only get the tags their primary target failed to get
+	var tagTargets []*target
+	for _, t := range targets {
+		if t.config.StandbyFor == "" && t.wantsTag(m.mapTag(tag)) {
+			tagTargets = append(tagTargets, t)
+		}
+	}
+
+	if len(tagTargets) == 0 {
+		m.log.Debug("Skipping tag, no target wants it")
+		tr.Result = resultSkipped
+		return
+	}
+
+	source := m.sourceImage(tag)
+	var targetImages []string
+	for _, t := range tagTargets {
+		// archive snapshots only hold what was mirrored to the other targets, an unchanged
+		// tag doesn't need a new snapshot every day
+		if !t.config.Archive {
+			targetImages = append(targetImages, fmt.Sprintf("%s/%s:%s", t.registry, m.targetRepositoryName(t), m.mapTag(tag)))
+		}
+	}
+	if len(targetImages) == 0 {
+		for _, t := range tagTargets {
+			targetImages = append(targetImages, fmt.Sprintf("%s/%s:%s", t.registry, m.targetRepositoryName(t), m.targetTag(t, tag, start)))
+		}
+	}
+
+	if checkpoint.completed(source, targetImages) {
+		m.log.Info("Skipping tag, it was mirrored before the previous run got interrupted")
+		tr.Result, tr.Reason = resultSkipped, "completed in checkpoint"
+		return
+	}
+
+	if m.mirroredBefore(source, remoteTag.digest(), targetImages) {
+		m.log.Info("Skipping tag, the upstream digest was already mirrored to all targets")
+		tr.Result, tr.Reason, tr.SourceDigest = resultSkipped, "already mirrored", remoteTag.digest()
+		return
+	}
+
+	if m.existsInTargets(remoteTag, tagTargets, start) {
+		m.log.Info("Skipping tag, it is up to date in all targets")
+		tr.Result, tr.Reason, tr.SourceDigest = resultSkipped, "already in the targets", remoteTag.digest()
+		return
+	}
+
+	// with content trust, only the digest the tag is signed with is mirrored
+	if m.trust != nil {
+		digest, err := m.trustedDigest(tag)
 		if err != nil {
-			return nil, fmt.Errorf("Invalid/missing int value for remote_tag_config -> num_releases")
+			m.log.Error(err)
+			tr.fail(m.report, err)
+			return
 		}
+		m.signedDigest = digest
+	}
 
-		remoteTags, _, err := client.Repositories.ListTags(context.Background(), m.repo.RemoteTagConfig["owner"], m.repo.RemoteTagConfig["repo"], &github.ListOptions{PerPage: limit})
+	if m.repo.CheckMediaTypes || m.repo.ConvertSchema1 {
+		reason, err := m.validateTag(tag, tagTargets)
 		if err != nil {
-			return nil, err
+			m.log.Errorf("Failed to validate the manifest: %s", err)
+			tr.fail(m.report, err)
+			return
+		}
+		if reason != "" {
+			m.log.Warnf("Skipping tag: %s", reason)
+			tr.unsupported(m.report, reason)
+			return
 		}
+	}
 
-		var allTags []RepositoryTag
-		for _, tag := range remoteTags {
-			allTags = append(allTags, RepositoryTag{
-				Name: strings.TrimPrefix(*tag.Name, "v"),
-			})
+	// an image larger than `max_image_size` is never pulled
+	reason, err := m.checkImageSize(tr, tag)
+	if err != nil {
+		m.log.Errorf("Failed to check the image size: %s", err)
+		tr.fail(m.report, err)
+		return
+	}
+	if reason != "" {
+		m.log.Warnf("Skipping tag: %s", reason)
+		tr.Result, tr.Reason = resultSkipped, reason
+		return
+	}
+
+	m.log.Info("Start mirror tag")
+
+	var metadata *imageMetadata
+	mirrorTo := func(tagTargets []*target) (err error) {
+		// the docker daemon can't pull OCI artifacts, they are copied with the registry API
+		if config.Daemonless || m.repo.Artifacts {
+			metadata, err = m.copyTag(ts, tr, tagTargets, tag, start)
+		} else {
+			metadata, err = m.dockerMirrorTag(ts, tr, tagTargets, tag, start)
 		}
+		return err
+	}
+
+	err = mirrorTo(tagTargets)
+	if err != nil {
+		err = m.failover(tr, targets, mirrorTo, err)
+	}
+	if err == nil && config.VerifyPush {
+		err = m.verifyPushes(tr)
+	}
+	if err == nil && m.repo.CopySignatures {
+		err = m.copySignatures(tr)
+	}
+	if err == nil && m.repo.CopyReferrers {
+		err = m.copyReferrers(tr)
+	}
+	var s1 *schema1Error
+	var pe *platformError
+	if errors.As(err, &s1) || errors.As(err, &pe) {
+		m.log.Warnf("Skipping tag: %s", err)
+		tr.unsupported(m.report, err.Error())
+		return
+	}
+	var vulnerable *vulnerableImageError
+	if errors.As(err, &vulnerable) {
+		m.log.Warnf("Not pushing the tag: %s", err)
+		tr.blocked(m.report, vulnerable)
+		return
+	}
+	if err != nil {
+		tr.fail(m.report, err)
+		return
+	}
+
+	m.log.Info("Successfully pushed (re)tagged image")
+	m.trackFreshness(tr, remoteTag, time.Now())
 
-		return allTags, nil
+	// the targets a tag failed over from are mirrored again by the next run
+	digests := make(map[string]string)
+	for _, t := range tr.Targets {
+		if t.Result != resultMirrored {
+			continue
+		}
+		digests[fmt.Sprintf("%s/%s:%s", t.Registry, t.Repository, t.Tag)] = t.Digest
+	}
+	if err := checkpoint.complete(source, tr.SourceDigest, digests); err != nil {
+		m.log.Warnf("Failed to save checkpoint: %s", err)
+	}
+
+	if state != nil {
+		sourceDigest := tr.SourceDigest
+		if sourceDigest == "" {
+			sourceDigest = remoteTag.digest()
+		}
+
+		if err := state.save(source, &mirroredTag{SourceDigest: sourceDigest, Targets: digests, MirroredAt: time.Now(), Metadata: metadata}); err != nil {
+			m.log.Warnf("Failed to save state: %s", err)
+		}
+	}
+}
+
+// dockerMirrorTag pulls the tag with the docker daemon, and (re)tags and pushes it to every
+// target. Returns the metadata of the pulled image
+func (m *mirror) dockerMirrorTag(ts *span, tr *tagReport, tagTargets []*target, tag string, start time.Time) (*imageMetadata, error) {
+	defer disk.use(m.localImages(tag, tagTargets, start))()
+	if err := disk.check(m.log, m.cleaner); err != nil {
+		m.log.Error(err)
+		return nil, err
+	}
+
+	if err := m.waitPullQuota(); err != nil {
+		m.log.Error(err)
+		return nil, err
+	}
+
+	s := ts.child("docker pull", "image", m.sourceImage(tag))
+	err := m.retryStep(tr, "pull", func() error { return m.pullImage(tag) })
+	s.finish(err)
+	if err != nil {
+		m.log.Errorf("Failed to pull docker image: %s", err)
+		return nil, err
+	}
+	tr.PullDuration = time.Since(start).Seconds()
+
+	var metadata *imageMetadata
+	if image, err := (*m.dockerClient).InspectImage(m.sourceImage(tag)); err == nil {
+		tr.SourceDigest = repoDigest(image.RepoDigests, m.sourceRepository())
+		tr.BytesTransferred = image.Size
+
+		var labels map[string]string
+		if image.Config != nil {
+			labels = image.Config.Labels
+		}
+		metadata = newImageMetadata(labels, image.Created, image.Author)
+	} else {
+		m.log.Warnf("Failed to inspect docker image: %s", err)
+	}
+
+	if err := checkTrustedDigest(m.signedDigest, tr.SourceDigest); err != nil {
+		m.log.Error(err)
+		return nil, err
+	}
+
+	if config.Scan.Scanner != "" {
+		s := ts.child("scan", "scanner", config.Scan.Scanner, "image", m.sourceImage(tag))
+		err := scanImage(config.Scan, m.sourceImage(tag), true)
+		s.finish(err)
+		if err != nil {
+			if config.Cleanup == true {
+				m.cleaner.remove(m.log, m.cleanupImages(tag, nil, start), ts)
+			}
+			return metadata, err
+		}
 	}
 
-	// Get tags information from Docker Hub, Quay, GCR or k8s.gcr.io.
+	// with `add_labels`, the targets are tagged from the labelled image
+	image := m.sourceImage(tag)
+	if labels := m.addLabels(); len(labels) > 0 {
+		s := ts.child("docker build", "image", m.labelledImage(tag))
+		err := m.labelImage(tag, labels)
+		s.finish(err)
+		if err != nil {
+			m.log.Errorf("Failed to add the labels to the docker image: %s", err)
+			if config.Cleanup == true {
+				m.cleaner.remove(m.log, m.cleanupImages(tag, nil, start), ts)
+			}
+			return metadata, err
+		}
+		image = m.labelledImage(tag)
+	}
+
+	// the pulled image is reused for every target, a failing target does not block the others
+	var tagged []*target
+	var failed error
+	for _, t := range tagTargets {
+		repository := fmt.Sprintf("%s/%s", t.registry, m.targetRepositoryName(t))
+		targetTag := m.targetTag(t, tag, start)
+		result := &targetReport{Registry: t.registry, Repository: m.targetRepositoryName(t), Tag: targetTag, Result: resultMirrored, target: t}
+		tr.Targets = append(tr.Targets, result)
+
+		s := ts.child("docker tag", "registry", t.registry)
+		err := m.retryStep(tr, "tag", func() error { return m.tagImage(t, image, targetTag) })
+		s.finish(err)
+		if err != nil {
+			m.log.Errorf("Failed to (re)tag docker image for %s: %s", t.registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+			continue
+		}
+		tagged = append(tagged, t)
+
+		pushStart := time.Now()
+		s = ts.child("docker push", "registry", t.registry, "image", fmt.Sprintf("%s:%s", repository, targetTag))
+		err = m.retryStep(tr, "push", func() error { return m.pushImage(t, targetTag) })
+		s.finish(err)
+		result.PushDuration = time.Since(pushStart).Seconds()
+		if err != nil {
+			m.log.Errorf("Failed to push (re)tagged image to %s: %s", t.registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+			continue
+		}
+
+		if image, err := (*m.dockerClient).InspectImage(fmt.Sprintf("%s:%s", repository, targetTag)); err == nil {
+			result.Digest = repoDigest(image.RepoDigests, repository)
+		}
+
+		if err := m.pruneArchive(t, m.mapTag(tag), start); err != nil {
+			m.log.Warnf("Failed to prune archive snapshots in %s: %s", t.registry, err)
+		}
+	}
+
+	if config.Cleanup == true {
+		m.cleaner.remove(m.log, m.cleanupImages(tag, tagged, start), ts)
+	}
+
+	return metadata, failed
+}
+
+// mirroredBefore returns true if the state backend recorded the upstream digest of the
+// tag as mirrored to all the target images, tags without a known digest are always mirrored
+func (m *mirror) mirroredBefore(source, digest string, targetImages []string) bool {
+	if state == nil || digest == "" {
+		return false
+	}
+
+	tag, err := state.load(source)
+	if err != nil {
+		m.log.Warnf("Failed to load state: %s", err)
+		return false
+	}
+
+	return tag != nil && tag.SourceDigest == digest && tag.covers(targetImages)
+}
+
+// minTags returns the min number of tags the repository must resolve to, the repository
+// setting overrides the global one
+func (m *mirror) minTags() int {
+	if m.repo.MinTags != nil {
+		return *m.repo.MinTags
+	}
+
+	return config.MinTags
+}
+
+// checkMinTags returns an error when the repository resolved to fewer tags than its
+// `min_tags`, i.e. on a typo in the name or filters matching nothing. Without `min_tags`
+// a repository without tags is only logged as a warning
+func (m *mirror) checkMinTags() error {
+	min := m.minTags()
+	if len(m.remoteTags) >= min && len(m.remoteTags) > 0 {
+		return nil
+	}
+
+	if min < 1 {
+		m.log.Warn("Resolved to 0 tags, check the repository name and its filters")
+		return nil
+	}
+
+	return fmt.Errorf("Resolved to %d tags, the min_tags is %d", len(m.remoteTags), min)
+}
+
+// freshnessSLA returns the max allowed lag between an upstream tag update and
+// the tag landing in the targets, the repository setting overrides the global one
+func (m *mirror) freshnessSLA() *Duration {
+	if m.repo.FreshnessSLA != nil {
+		return m.repo.FreshnessSLA
+	}
+
+	return config.FreshnessSLA
+}
+
+// record the lag between the upstream tag update and the tag landing in the
+// targets, and flag the tag when it exceeds the freshness SLA
+func (m *mirror) trackFreshness(tr *tagReport, remoteTag RepositoryTag, landed time.Time) {
+	updated := remoteTag.LastUpdated
+	if updated.IsZero() {
+		updated = remoteTag.LastModified
+	}
+
+	// github releases, GCR and k8s.gcr.io don't expose when a tag was updated
+	if updated.IsZero() {
+		return
+	}
+
+	lag := landed.Sub(updated)
+	tr.Lag = lag.Seconds()
+
+	sla := m.freshnessSLA()
+	if sla == nil || lag <= time.Duration(*sla) {
+		return
+	}
+
+	tr.SLAViolation = true
+	m.report.violation()
+	m.log.WithField("lag_seconds", int64(tr.Lag)).Warnf("Tag landed %s after its upstream update, exceeding the freshness SLA of %s", lag.Round(time.Second), sla.String())
+}
+
+// get the remote tags from the remote compatible registry.
+// read out the image tag and when it was updated, and sort by the updated time if applicable
+func (m *mirror) getRemoteTags() ([]RepositoryTag, error) {
+	if m.repo.RemoteTagSource == remoteTagSourceRegistry || m.listsWithCredentials() {
+		return m.getRegistryTags()
+	}
+
+	if m.repo.RemoteTagSource == remoteTagSourceGitLab {
+		return m.getGitLabTags()
+	}
+
+	if m.repo.RemoteTagSource == remoteTagSourceGitHub {
+		return m.getGitHubTags()
+	}
+
+	// Get tags information from Docker Hub, Quay, GCR, k8s.gcr.io or a custom host.
 	var url string
-	fullRepoName := m.repo.Name
-	token := ""
+	fullRepoName := m.upstreamName()
+	authorization := ""
+	host := customHost(m.repo.Host)
 
 	switch m.repo.Host {
 	case dockerHub:
 		if !strings.Contains(fullRepoName, "/") {
-			fullRepoName = "library/" + m.repo.Name
+			fullRepoName = "library/" + fullRepoName
 		}
 
 		if os.Getenv("DOCKERHUB_USER") != "" && os.Getenv("DOCKERHUB_PASSWORD") != "" {
@@ -402,7 +1280,10 @@ func (m *mirror) getRemoteTags() ([]RepositoryTag, error) {
 			var result map[string]interface{}
 
 			json.NewDecoder(resp.Body).Decode(&result)
-			token = result["token"].(string)
+			authorization = fmt.Sprintf("JWT %s", result["token"].(string))
+		} else if login := dockerHubLogin(); login != nil {
+			m.log.Info("Getting tags using the docker hub login")
+			authorization = "Bearer " + login.AccessToken
 		}
 
 		url = fmt.Sprintf("https://registry.hub.docker.com/v2/repositories/%s/tags/?page_size=2048", fullRepoName)
@@ -412,6 +1293,11 @@ func (m *mirror) getRemoteTags() ([]RepositoryTag, error) {
 		url = fmt.Sprintf("https://gcr.io/v2/%s/tags/list", fullRepoName)
 	case k8s:
 		url = fmt.Sprintf("https://k8s.gcr.io/v2/%s/tags/list", fullRepoName)
+	default:
+		if host == nil {
+			return nil, fmt.Errorf("Unknown host %s", m.repo.Host)
+		}
+		url = host.tagsURL(fullRepoName)
 	}
 
 	var allTags []RepositoryTag
@@ -419,51 +1305,61 @@ func (m *mirror) getRemoteTags() ([]RepositoryTag, error) {
 search:
 	for {
 		var (
-			err     error
-			res     *http.Response
-			req     *http.Request
-			retries int = 5
+			err error
+			res *http.Response
+			req *http.Request
 		)
 
-		for retries > 0 {
+		for attempt := 0; ; attempt++ {
 			req, err = http.NewRequest("GET", url, nil)
 			if err != nil {
 				return nil, err
 			}
 
-			if token != "" {
-				req.Header.Set("Authorization", fmt.Sprintf("JWT %s", token))
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
 			}
 
+			if host != nil {
+				host.authorize(req)
+			}
+
+			quotas.record(m.repo.Host, quotaTagAPI)
 			res, err = httpClient.Do(req)
+			if err == nil && res.StatusCode >= 200 && res.StatusCode < 300 {
+				break
+			}
 
-			if err != nil {
-				m.log.Warningf(err.Error())
-				m.log.Warningf("Failed to get %s, retrying", url)
-				retries--
-			} else if res.StatusCode == 429 {
-				sleepTime := getSleepTime(res.Header.Get("X-RateLimit-Reset"), time.Now())
-				m.log.Infof("Rate limited on %s, sleeping for %s", url, sleepTime)
-				time.Sleep(sleepTime)
-				retries--
-			} else if res.StatusCode < 200 || res.StatusCode >= 300 {
-				m.log.Warningf("Get %s failed with %d, retrying", url, res.StatusCode)
-				retries--
+			if err == nil {
+				res.Body.Close()
+				err = fmt.Errorf("Get %s failed with %d", url, res.StatusCode)
+
+				// e.g. 404 for an unknown repository, retrying won't help
+				if !retryable(res.StatusCode) {
+					return nil, err
+				}
 			} else {
-				break
+				res = nil
 			}
 
-		}
+			if attempt+1 >= httpRetries {
+				return nil, err
+			}
 
-		if err != nil {
-			return nil, err
+			delay := retryDelay(res, attempt, time.Now())
+			if res != nil && res.StatusCode == http.StatusTooManyRequests {
+				m.log.Infof("Rate limited on %s, sleeping for %s", url, delay)
+			} else {
+				m.log.Warningf("%s, retrying in %s", err, delay)
+			}
+			runState.retrySleep(m.repo.Host, err.Error(), delay)
 		}
 		defer res.Body.Close()
 
 		dc := json.NewDecoder(res.Body)
 
-		switch m.repo.Host {
-		case dockerHub:
+		switch m.tagsAPI() {
+		case hostTypeDockerHub:
 			var tags DockerTagsResponse
 			if err = dc.Decode(&tags); err != nil {
 				return nil, err
@@ -475,14 +1371,15 @@ search:
 			}
 
 			url = *tags.Next
-		case quay:
+		case hostTypeQuay:
 			var tags QuayTagsResponse
 			if err := dc.Decode(&tags); err != nil {
 				return nil, err
 			}
 			allTags = append(allTags, tags.Tags...)
 			break search
-		case gcr:
+		default:
+			// gcr.io, k8s.gcr.io and the registry v2 hosts use the registry v2 tags list
 			var tags GCRTagsResponse
 			if err := dc.Decode(&tags); err != nil {
 				return nil, err
@@ -492,28 +1389,27 @@ search:
 					Name: tag,
 				})
 			}
-			break search
-		case k8s:
-			var tags GCRTagsResponse
-			if err := dc.Decode(&tags); err != nil {
-				return nil, err
+
+			// the tags list may be paginated with a Link header
+			matches := nextLinkRE.FindStringSubmatch(res.Header.Get("Link"))
+			if matches == nil {
+				break search
 			}
-			for _, tag := range tags.Tags {
-				allTags = append(allTags, RepositoryTag{
-					Name: tag,
-				})
+			next, err := res.Request.URL.Parse(matches[1])
+			if err != nil {
+				return nil, err
 			}
-			break search
+			url = next.String()
 		}
 	}
 
 	// sort the tags by updated/modified time if applicable, newest first
-	switch m.repo.Host {
-	case dockerHub:
+	switch m.tagsAPI() {
+	case hostTypeDockerHub:
 		sort.Slice(allTags, func(i, j int) bool {
 			return allTags[i].LastUpdated.After(allTags[j].LastUpdated)
 		})
-	case quay:
+	case hostTypeQuay:
 		sort.Slice(allTags, func(i, j int) bool {
 			return allTags[i].LastModified.After(allTags[j].LastModified)
 		})
@@ -522,6 +1418,42 @@ search:
 	return allTags, nil
 }
 
+// tagsAPI returns the style of the tag API of the host of the repository
+func (m *mirror) tagsAPI() string {
+	switch m.repo.Host {
+	case dockerHub:
+		return hostTypeDockerHub
+	case quay:
+		return hostTypeQuay
+	}
+
+	if h := customHost(m.repo.Host); h != nil {
+		return h.tagsAPI()
+	}
+
+	return hostTypeRegistry
+}
+
+// get the remote tags from the registry v2 tags list of the source, even for the hosts with a
+// richer API (e.g. Docker Hub). The tags list has no timestamps, the tags are in registry order
+func (m *mirror) getRegistryTags() ([]RepositoryTag, error) {
+	host, repository := splitReference(m.sourceRepository())
+	client := registryClientFor(host, m.sourceAuth(), false)
+
+	quotas.record(m.repo.Host, quotaTagAPI)
+	names, err := client.tags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	allTags := make([]RepositoryTag, 0, len(names))
+	for _, name := range names {
+		allTags = append(allTags, RepositoryTag{Name: name})
+	}
+
+	return allTags, nil
+}
+
 // will help output how long time a function took to do its work
 func (m *mirror) timeTrack(start time.Time, name string) {
 	elapsed := time.Since(start)
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestGetSleepTime(t *testing.T) {
@@ -49,6 +56,10 @@ type ResponseContainer struct {
 	PushImageOptions           docker.PushImageOptions
 	PushImageAuthConfiguration docker.AuthConfiguration
 	RemoveImageName            string
+	BuildImageOptions          docker.BuildImageOptions
+	Images                     map[string]*docker.Image
+	ListImages                 []docker.APIImages
+	RemovedImages              []string
 }
 
 type TestDockerClient struct {
@@ -79,6 +90,23 @@ func (t *TestDockerClient) PushImage(opts docker.PushImageOptions, auth docker.A
 
 func (t *TestDockerClient) RemoveImage(name string) error {
 	t.ResponseContainer.RemoveImageName = name
+	t.ResponseContainer.RemovedImages = append(t.ResponseContainer.RemovedImages, name)
+	return nil
+}
+
+func (t *TestDockerClient) InspectImage(name string) (*docker.Image, error) {
+	if image, ok := t.ResponseContainer.Images[name]; ok {
+		return image, nil
+	}
+	return nil, docker.ErrNoSuchImage
+}
+
+func (t *TestDockerClient) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	return t.ResponseContainer.ListImages, nil
+}
+
+func (t *TestDockerClient) BuildImage(opts docker.BuildImageOptions) error {
+	t.ResponseContainer.BuildImageOptions = opts
 	return nil
 }
 
@@ -87,6 +115,9 @@ func CreateTestDockerClient(responseContainer *ResponseContainer) *TestDockerCli
 }
 
 func TestPullImage(t *testing.T) {
+	// setup lists the tags, don't wait between the retries when the tag API isn't reachable
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	sleep = func(time.Duration) {}
 
 	t.Run("tests to ensure that PrivateRegistry creates the proper repo name", func(t *testing.T) {
 		responseContainer := &ResponseContainer{}
@@ -141,3 +172,337 @@ func TestPullImage(t *testing.T) {
 func getTimeAsString(date time.Time) string {
 	return strconv.FormatInt(date.Unix(), 10)
 }
+
+func TestLogWriterStructured(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New()
+	logger.Out = &out
+	logger.Level = log.DebugLevel
+	logger.Formatter = &log.JSONFormatter{}
+
+	w := newLogWriter(log.NewEntry(logger), "pull")
+	pulled, layers := metrics.transfers["pull"], metrics.layers["pull"]
+	var progress []transferProgress
+	w.onProgress = func(p transferProgress) { progress = append(progress, p) }
+
+	// the stream is written in arbitrary chunks, lines are only logged once complete
+	w.Write([]byte(`{"status":"Downloading","progressDetail":{"current":10,"total":20},"id":"abc123"}` + "\n" + `{"status":"Pull com`))
+	w.Write([]byte(`plete","id":"abc123"}` + "\n"))
+
+	// the progress is only logged every dockerProgressInterval, with an ETA
+	w.lastReport = w.lastReport.Add(-dockerProgressInterval)
+	w.started = w.started.Add(-10 * time.Second)
+	w.Write([]byte(`{"status":"Downloading","progressDetail":{"current":20,"total":40},"id":"def456"}` + "\n"))
+	w.Write([]byte(`{"status":"Extracting","progressDetail":{"current":20,"total":20},"id":"abc123"}` + "\n"))
+	w.Write([]byte(`{"error":"manifest unknown","errorDetail":{"message":"manifest unknown"}}`))
+
+	if err := w.result(nil); err == nil || err.Error() != "manifest unknown" {
+		t.Errorf("Expected the stream error to be returned, got %v", err)
+	}
+
+	var lines []map[string]interface{}
+	dc := json.NewDecoder(&out)
+	for dc.More() {
+		var line map[string]interface{}
+		if err := dc.Decode(&line); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 log lines, got %d: %v", len(lines), lines)
+	}
+
+	if lines[0]["docker_status"] != "Pull complete" || lines[0]["docker_layer"] != "abc123" || lines[0]["docker_action"] != "pull" {
+		t.Errorf("Unexpected log line %v", lines[0])
+	}
+
+	if lines[1]["msg"] != "Docker pull progress: 1/2 layers, 30B of 60B, ETA 10s" || lines[1]["docker_progress_total"] != float64(60) || lines[1]["docker_layers_done"] != float64(1) {
+		t.Errorf("Unexpected log line %v", lines[1])
+	}
+
+	if lines[2]["docker_error"] != "manifest unknown" {
+		t.Errorf("Unexpected log line %v", lines[2])
+	}
+
+	if got := metrics.transfers["pull"] - pulled; got != 30 {
+		t.Errorf("Expected 30 bytes pulled in the metrics, got %d", got)
+	}
+	if got := metrics.layers["pull"] - layers; got != 1 {
+		t.Errorf("Expected 1 layer pulled in the metrics, got %d", got)
+	}
+
+	// every progress is reported, not only the logged ones
+	if len(progress) != 3 || progress[2] != (transferProgress{Action: "pull", LayersDone: 1, Layers: 2, Bytes: 30, Total: 60, ETA: 10}) {
+		t.Errorf("Unexpected progress %+v", progress)
+	}
+}
+
+func TestLogWriterLongLine(t *testing.T) {
+	w := newLogWriter(log.WithField("test", "docker"), "push")
+	w.Write(bytes.Repeat([]byte("x"), maxDockerLine+1))
+	if len(w.buf) != 0 {
+		t.Errorf("Expected a line without a line break to be dropped, got %d bytes buffered", len(w.buf))
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := map[int64]string{0: "0B", 1023: "1023B", 1536: "1.5KiB", 12 * 1024 * 1024: "12.0MiB", 3 << 30: "3.0GiB"}
+	for n, want := range tests {
+		if got := humanBytes(n); got != want {
+			t.Errorf("Expected %d to be %s, got %s", n, want, got)
+		}
+	}
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer setLogFormat("text")
+
+	if err := setLogFormat("json"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if _, ok := log.StandardLogger().Formatter.(*log.JSONFormatter); !ok {
+		t.Errorf("Expected json logs, got %T", log.StandardLogger().Formatter)
+	}
+
+	if err := setLogFormat("text"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if _, ok := log.StandardLogger().Formatter.(*log.TextFormatter); !ok {
+		t.Errorf("Expected text logs, got %T", log.StandardLogger().Formatter)
+	}
+
+	if err := setLogFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown log format")
+	}
+}
+
+// ConcurrencyDockerClient tracks the max number of concurrent pulls
+type ConcurrencyDockerClient struct {
+	TestDockerClient
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *ConcurrencyDockerClient) PullImage(opts docker.PullImageOptions, authConfig docker.AuthConfiguration) error {
+	c.mu.Lock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	c.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mu.Lock()
+	c.current--
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ConcurrencyDockerClient) TagImage(name string, opts docker.TagImageOptions) error {
+	return nil
+}
+
+func (c *ConcurrencyDockerClient) PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) error {
+	return nil
+}
+
+func TestTagConcurrency(t *testing.T) {
+	defer func(slots chan struct{}) { tagSlots = slots }(tagSlots)
+
+	tests := []struct {
+		concurrency int
+		slots       int
+		want        int
+	}{
+		{concurrency: 0, slots: 4, want: 1},
+		{concurrency: 3, slots: 4, want: 3},
+		{concurrency: 3, slots: 2, want: 2},
+	}
+
+	for _, tt := range tests {
+		tagSlots = make(chan struct{}, tt.slots)
+
+		dc := &ConcurrencyDockerClient{TestDockerClient: TestDockerClient{ResponseContainer: &ResponseContainer{}}}
+		var client DockerClient = dc
+		tc := TargetConfig{Registry: "registry.example.com", Username: "user"}
+		r := newRunReport()
+
+		m := mirror{
+			dockerClient: &client,
+			targets:      []*target{{registry: tc.Registry, config: tc, ecrManager: newRegistryManager(tc)}},
+			report:       r.repository("redis", dockerHub),
+			log:          log.WithField("repo", "redis"),
+			repo:         Repository{Name: "redis", Host: dockerHub, TagConcurrency: tt.concurrency},
+		}
+		for i := 0; i < 6; i++ {
+			m.remoteTags = append(m.remoteTags, RepositoryTag{Name: strconv.Itoa(i)})
+		}
+		m.work()
+
+		if dc.max != tt.want {
+			t.Errorf("Expected at most %d concurrent pulls with tag_concurrency %d and %d slots, got %d", tt.want, tt.concurrency, tt.slots, dc.max)
+		}
+
+		if len(m.report.Tags) != 6 {
+			t.Errorf("Expected 6 tags in the report, got %d", len(m.report.Tags))
+		}
+	}
+}
+
+func TestFilterTagsRegex(t *testing.T) {
+	m := mirror{
+		log: log.WithField("test", "regex"),
+		repo: Repository{
+			Name:           "kubectl",
+			MatchTags:      []string{"v1*"},
+			MatchTagRegex:  []string{`^v1\.2[0-9]\.`},
+			IgnoreTagRegex: []string{`-rc\.?[0-9]+$`},
+		},
+	}
+
+	for _, name := range []string{"v1.19.3", "v1.24.0", "v1.24.1-rc.1", "v1.25.2", "v2.20.0", "v1.26.0-rc1"} {
+		m.remoteTags = append(m.remoteTags, RepositoryTag{Name: name})
+	}
+
+	if err := m.compileTagFilters(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	m.filterTags()
+
+	var got []string
+	for _, tag := range m.remoteTags {
+		got = append(got, tag.Name)
+	}
+
+	if strings.Join(got, ",") != "v1.24.0,v1.25.2" {
+		t.Errorf("Expected the v1.2x tags without the release candidates, got %v", got)
+	}
+
+	m.repo.IgnoreTagRegex = []string{"v1.2[0-"}
+	if err := m.compileTagFilters(); err == nil {
+		t.Errorf("Expected an error for an invalid regex")
+	}
+}
+
+func TestFilterTagsKeep(t *testing.T) {
+	tags := []string{"latest", "1.26.1", "1.26.0", "1.25.4", "1.25.3", "1.24.9", "1.23.0", "0.9.1", "1.25.5-rc.1"}
+
+	tests := []struct {
+		keep      KeepConfig
+		keepOther bool
+		expected  string
+	}{
+		{KeepConfig{PerMinor: 1, Minors: 3}, false, "1.26.1,1.25.4,1.24.9"},
+		{KeepConfig{PerMinor: 2, Minors: 2}, true, "latest,1.26.1,1.26.0,1.25.4,1.25.3"},
+		{KeepConfig{PerMajor: 1}, false, "1.26.1,0.9.1"},
+		{KeepConfig{Majors: 1, PerMinor: 1}, false, "1.26.1,1.25.4,1.24.9,1.23.0"},
+	}
+
+	for _, tt := range tests {
+		m := mirror{log: log.WithField("test", "keep"), repo: Repository{Name: "kubectl", Keep: &tt.keep, SemverKeepOther: tt.keepOther}}
+		for _, name := range tags {
+			m.remoteTags = append(m.remoteTags, RepositoryTag{Name: name})
+		}
+		m.filterTags()
+
+		var got []string
+		for _, tag := range m.remoteTags {
+			got = append(got, tag.Name)
+		}
+
+		if strings.Join(got, ",") != tt.expected {
+			t.Errorf("%+v: expected %s, got %v", tt.keep, tt.expected, got)
+		}
+	}
+}
+
+func TestSetupStaticTags(t *testing.T) {
+	// the host is never queried for its tags
+	m := mirror{}
+	if err := m.setup(Repository{Name: "redis", Host: "unknown.example.com", Tags: []string{"7.0.11", "7.2.4"}}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(m.remoteTags) != 2 || m.remoteTags[0].Name != "7.0.11" || m.remoteTags[1].Name != "7.2.4" {
+		t.Errorf("Expected the static tags to be mirrored as is, got %+v", m.remoteTags)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"redis","tags":["7","6"]}`))
+	}))
+	defer server.Close()
+
+	defer func(hosts []HostConfig) { config.Hosts = hosts }(config.Hosts)
+	config.Hosts = []HostConfig{{Name: "artifactory.example.com", Type: hostTypeArtifactory, APIBase: server.URL}}
+
+	// a target without ECR manager panics when the repository is created
+	r := newRunReport()
+	rr := r.repository("redis", "artifactory.example.com")
+	mirrorRepository(Repository{Name: "redis", Host: "artifactory.example.com"}, rr, nil, []*target{{registry: "registry.example.com"}}, nil, nil)
+
+	if rr.Result != resultFailed || !strings.HasPrefix(rr.Error, "Panic: ") {
+		t.Errorf("Expected the repository to fail with the panic, got %s %q", rr.Result, rr.Error)
+	}
+
+	// without docker client, every tag panics when pulled
+	tc := TargetConfig{Registry: "registry.example.com"}
+	m := mirror{
+		targets:    []*target{{registry: tc.Registry, config: tc, ecrManager: newRegistryManager(tc)}},
+		report:     r.repository("postgres", dockerHub),
+		log:        log.WithField("repo", "postgres"),
+		repo:       Repository{Name: "postgres", Host: dockerHub, TagConcurrency: 2},
+		remoteTags: []RepositoryTag{{Name: "14"}, {Name: "13"}},
+	}
+	m.work()
+
+	for _, tr := range m.report.Tags {
+		if tr.Result != resultFailed || !strings.HasPrefix(tr.Error, "Panic: ") {
+			t.Errorf("Expected tag %s to fail with the panic, got %s %q", tr.Tag, tr.Result, tr.Error)
+		}
+	}
+
+	if m.report.Result != resultFailed {
+		t.Errorf("Expected the repository to fail, got %s", m.report.Result)
+	}
+}
+
+func TestCheckMinTags(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.MinTags = 0
+
+	m := mirror{log: log.WithField("test", "min_tags"), repo: Repository{Name: "redsi"}}
+
+	// warn-only by default
+	if err := m.checkMinTags(); err != nil {
+		t.Errorf("Expected no error without min_tags, got %s", err)
+	}
+
+	config.MinTags = 1
+	if err := m.checkMinTags(); err == nil || err.Error() != "Resolved to 0 tags, the min_tags is 1" {
+		t.Errorf("Expected the global min_tags to fail, got %v", err)
+	}
+
+	m.remoteTags = []RepositoryTag{{Name: "7"}}
+	if err := m.checkMinTags(); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	// the repository setting overrides the global one
+	min := 2
+	m.repo.MinTags = &min
+	if err := m.checkMinTags(); err == nil {
+		t.Errorf("Expected the repository min_tags to fail")
+	}
+
+	rr := newRunReport().repository("redis", dockerHub)
+	rr.tooFewTags(m.checkMinTags())
+	if rr.Result != resultFailed || rr.run.tooFewTagsCount() != 1 {
+		t.Errorf("Expected the repository to fail and be counted, got %s and %d", rr.Result, rr.run.tooFewTagsCount())
+	}
+}
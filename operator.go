@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// the MirrorRepository custom resource
+const (
+	crdGroup    = "docker-mirror.seatgeek.com"
+	crdResource = "mirrorrepositories." + crdGroup
+)
+
+// conditions of the status of a MirrorRepository
+const (
+	conditionReady      = "Ready"
+	conditionDeprecated = "Deprecated"
+)
+
+// mirrorRepositoryCRD is the CustomResourceDefinition of MirrorRepository, its spec takes the
+// fields of a repository of the config and is validated by the operator
+const mirrorRepositoryCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: ` + crdResource + `
+spec:
+  group: ` + crdGroup + `
+  scope: Namespaced
+  names:
+    kind: MirrorRepository
+    listKind: MirrorRepositoryList
+    plural: mirrorrepositories
+    singular: mirrorrepository
+    shortNames: [mirror]
+  versions:
+    - name: v1alpha1
+      served: true
+      storage: true
+      subresources:
+        status: {}
+      additionalPrinterColumns:
+        - name: Repository
+          type: string
+          jsonPath: .spec.name
+        - name: Ready
+          type: string
+          jsonPath: .status.conditions[?(@.type=="Ready")].status
+        - name: Last Sync
+          type: date
+          jsonPath: .status.lastSyncTime
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required: [name]
+              x-kubernetes-preserve-unknown-fields: true
+              properties:
+                name:
+                  type: string
+                host:
+                  type: string
+            status:
+              type: object
+              x-kubernetes-preserve-unknown-fields: true
+`
+
+// mirrorResource is a MirrorRepository, as listed by kubectl
+type mirrorResource struct {
+	Metadata struct {
+		Name       string `yaml:"name"`
+		Namespace  string `yaml:"namespace"`
+		Generation int64  `yaml:"generation"`
+	} `yaml:"metadata"`
+	Spec interface{} `yaml:"spec"`
+}
+
+// mirrorStatus is the status subresource of a MirrorRepository
+type mirrorStatus struct {
+	ObservedGeneration int64             `json:"observedGeneration"`
+	LastSyncTime       time.Time         `json:"lastSyncTime"`
+	Conditions         []mirrorCondition `json:"conditions"`
+	Tags               []mirrorTagStatus `json:"tags"`
+}
+
+type mirrorCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"` // True or False
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// mirrorTagStatus is the result of the last sync of a tag
+type mirrorTagStatus struct {
+	Tag    string `json:"tag"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// operator reconciles the MirrorRepository resources of a cluster: every run mirrors the
+// repositories of the resources, and patches their status with the result
+type operator struct {
+	kubectl   []string // kubectl and its cluster flags
+	namespace string   // only watch this namespace, all namespaces when empty
+
+	repos     []Repository                          // repositories of the last list
+	resources map[string]*mirrorResource            // resource of each repository, by host/name
+	statuses  map[string]*mirrorStatus              // status of each resource, by namespace/name
+	previous  map[string]map[string]mirrorCondition // last conditions of each resource
+}
+
+// operatorCommand runs docker-mirror as a Kubernetes operator mirroring the repositories
+// of the MirrorRepository resources, or prints their CRD
+func operatorCommand(args []string) {
+	if len(args) > 0 && args[0] == "crd" {
+		fmt.Print(mirrorRepositoryCRD)
+		return
+	}
+
+	flags := flag.NewFlagSet("operator", flag.ExitOnError)
+	configFile := configFlag(flags)
+	kubeconfig := flags.String("kubeconfig", os.Getenv("KUBECONFIG"), "kubeconfig file of the cluster (default: in-cluster service account)")
+	kubeContext := flags.String("context", "", "kubeconfig context of the cluster (default: current context)")
+	namespace := flags.String("namespace", "", "only watch the MirrorRepository resources of this namespace (default: all namespaces)")
+	interval := flags.Duration("interval", 5*time.Minute, "reconcile the MirrorRepository resources at this interval")
+	workers := flags.Int("workers", envInt("NUM_WORKERS"), "number of repositories mirrored in parallel (default: workers config or number of CPUs)")
+	adminAddr := flags.String("admin-addr", os.Getenv("ADMIN_ADDR"), "address of the admin server, e.g. :8080")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror operator [--namespace ns] [flags]\n       docker-mirror operator crd\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *interval <= 0 {
+		log.Fatal("The operator needs an --interval")
+	}
+
+	setupConfig(*configFile)
+
+	o := newOperator(*kubeconfig, *kubeContext, *namespace)
+
+	startRun(runOptions{
+		configFile: *configFile,
+		workers:    *workers,
+		interval:   *interval,
+		adminAddr:  *adminAddr,
+		beforeRun:  o.reconcile,
+		afterRun:   o.updateStatus,
+	})
+}
+
+func newOperator(kubeconfig, kubeContext, namespace string) *operator {
+	o := &operator{
+		kubectl:   []string{"kubectl"},
+		namespace: namespace,
+		previous:  make(map[string]map[string]mirrorCondition),
+	}
+
+	if kubeconfig != "" {
+		o.kubectl = append(o.kubectl, "--kubeconfig", kubeconfig)
+	}
+	if kubeContext != "" {
+		o.kubectl = append(o.kubectl, "--context", kubeContext)
+	}
+
+	return o
+}
+
+// reconcile lists the MirrorRepository resources, and replaces the repositories of the
+// config with their specs. The repositories of the last list are kept when the cluster
+// can't be reached
+func (o *operator) reconcile() {
+	// the repositories of the config file are never mirrored by the operator
+	defer func() {
+		config.Repositories = o.repos
+		config.Tenants = nil
+	}()
+
+	command := append(append([]string{}, o.kubectl...), "get", crdResource, "--output", "yaml")
+	if o.namespace != "" {
+		command = append(command, "--namespace", o.namespace)
+	} else {
+		command = append(command, "--all-namespaces")
+	}
+
+	out, err := commandOutput(command...)
+	if err != nil {
+		log.Errorf("Could not list the MirrorRepository resources, keeping the last list: %s", err)
+		return
+	}
+
+	var list struct {
+		Items []*mirrorResource `yaml:"items"`
+	}
+	if err := yaml.Unmarshal(out, &list); err != nil {
+		log.Errorf("Could not parse the MirrorRepository resources, keeping the last list: %s", err)
+		return
+	}
+
+	o.repos = o.repositories(list.Items, time.Now())
+	log.Infof("Reconciling %d MirrorRepository resources", len(o.repos))
+}
+
+// repositories returns the repositories of the resources, an invalid resource or one
+// mirroring the same repository as another resource gets a failed status instead
+func (o *operator) repositories(resources []*mirrorResource, now time.Time) []Repository {
+	o.resources = make(map[string]*mirrorResource)
+	o.statuses = make(map[string]*mirrorStatus)
+
+	hosts := make(map[string]bool)
+	for _, h := range config.Hosts {
+		hosts[h.Name] = true
+	}
+
+	seen := make(map[string]bool)
+	var repos []Repository
+	for _, res := range resources {
+		key := res.Metadata.Namespace + "/" + res.Metadata.Name
+		seen[key] = true
+
+		repo, err := parseMirrorSpec(res.Spec, hosts)
+		if err != nil {
+			o.statuses[key] = o.status(res, now, mirrorCondition{Type: conditionReady, Status: "False", Reason: "Invalid", Message: err.Error()})
+			continue
+		}
+
+		if repo.Host == "" {
+			repo.Host = dockerHub
+		}
+
+		repoKey := repo.Host + "/" + repo.Name
+		if other, ok := o.resources[repoKey]; ok {
+			o.statuses[key] = o.status(res, now, mirrorCondition{Type: conditionReady, Status: "False", Reason: "Conflict", Message: fmt.Sprintf("%s is already mirrored by %s/%s", repoKey, other.Metadata.Namespace, other.Metadata.Name)})
+			continue
+		}
+
+		o.resources[repoKey] = res
+		repos = append(repos, repo)
+	}
+
+	// the conditions of the deleted resources
+	for key := range o.previous {
+		if !seen[key] {
+			delete(o.previous, key)
+		}
+	}
+
+	return repos
+}
+
+// parseMirrorSpec decodes and lints the spec of a resource, like a repository of the config
+func parseMirrorSpec(spec interface{}, hosts map[string]bool) (Repository, error) {
+	content, err := yaml.Marshal(map[string]interface{}{"repositories": []interface{}{spec}})
+	if err != nil {
+		return Repository{}, err
+	}
+
+	errs, cfg := lintFragment(content, hosts)
+	if len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, e := range errs {
+			messages[i] = e.Message
+		}
+		return Repository{}, fmt.Errorf("%s", strings.Join(messages, ", "))
+	}
+
+	return cfg.Repositories[0], nil
+}
+
+// updateStatus patches the status of every resource with the result of the run
+func (o *operator) updateStatus() {
+	now := time.Now()
+	for _, rr := range report.Repositories {
+		res, ok := o.resources[rr.Host+"/"+rr.Name]
+		if !ok {
+			continue
+		}
+
+		o.statuses[res.Metadata.Namespace+"/"+res.Metadata.Name] = o.repositoryStatus(res, rr, now)
+	}
+
+	for key, status := range o.statuses {
+		chunk := strings.SplitN(key, "/", 2)
+
+		patch, err := json.Marshal(map[string]interface{}{"status": status})
+		if err != nil {
+			log.Errorf("Could not render the status of MirrorRepository %s: %s", key, err)
+			continue
+		}
+
+		command := append(append([]string{}, o.kubectl...), "patch", crdResource, chunk[1], "--namespace", chunk[0], "--subresource", "status", "--type", "merge", "--patch", string(patch))
+		if _, err := commandOutput(command...); err != nil {
+			log.Errorf("Could not update the status of MirrorRepository %s: %s", key, err)
+		}
+	}
+}
+
+// repositoryStatus returns the status of a resource from the report of its repository
+func (o *operator) repositoryStatus(res *mirrorResource, rr *repositoryReport, now time.Time) *mirrorStatus {
+	ready := mirrorCondition{Type: conditionReady, Status: "True", Reason: "Mirrored"}
+	switch rr.Result {
+	case resultFailed:
+		ready = mirrorCondition{Type: conditionReady, Status: "False", Reason: "Failed", Message: rr.Error}
+	case resultSkipped:
+		ready = mirrorCondition{Type: conditionReady, Status: "False", Reason: "Skipped", Message: rr.Reason}
+	}
+
+	deprecated := mirrorCondition{Type: conditionDeprecated, Status: "False", Reason: "Active"}
+	if rr.Deprecated != "" {
+		deprecated = mirrorCondition{Type: conditionDeprecated, Status: "True", Reason: "Deprecated", Message: rr.Deprecated}
+	}
+
+	status := o.status(res, now, ready, deprecated)
+	for _, tr := range rr.Tags {
+		status.Tags = append(status.Tags, mirrorTagStatus{Tag: tr.Tag, Result: tr.Result, Reason: tr.Reason, Error: tr.Error, Digest: tr.SourceDigest})
+	}
+
+	return status
+}
+
+// status returns the status of the resource with the conditions, a condition keeps its
+// last transition time while its status doesn't change
+func (o *operator) status(res *mirrorResource, now time.Time, conditions ...mirrorCondition) *mirrorStatus {
+	key := res.Metadata.Namespace + "/" + res.Metadata.Name
+	previous, ok := o.previous[key]
+	if !ok {
+		previous = make(map[string]mirrorCondition)
+		o.previous[key] = previous
+	}
+
+	for i, c := range conditions {
+		if last, ok := previous[c.Type]; ok && last.Status == c.Status {
+			conditions[i].LastTransitionTime = last.LastTransitionTime
+		} else {
+			conditions[i].LastTransitionTime = now.UTC()
+		}
+		previous[c.Type] = conditions[i]
+	}
+
+	return &mirrorStatus{ObservedGeneration: res.Metadata.Generation, LastSyncTime: now.UTC(), Conditions: conditions, Tags: []mirrorTagStatus{}}
+}
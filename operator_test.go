@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestOperatorRepositories(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{}
+
+	var list struct {
+		Items []*mirrorResource `yaml:"items"`
+	}
+	err := yaml.Unmarshal([]byte(`
+items:
+  - metadata: {name: redis, namespace: payments, generation: 2}
+    spec: {name: library/redis, match_tag: ["7*"]}
+  - metadata: {name: redis, namespace: search}
+    spec: {name: library/redis, host: hub.docker.com}
+  - metadata: {name: etcd, namespace: search}
+    spec: {name: coreos/etcd, host: quay.io, match_tag_regex: ["v3.(5"]}
+  - metadata: {name: nginx, namespace: search}
+    spec: {name: nginx, unknown_field: true}
+`), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	o := newOperator("", "", "")
+	repos := o.repositories(list.Items, now)
+
+	if len(repos) != 1 || repos[0].Name != "library/redis" || repos[0].Host != dockerHub || repos[0].MatchTags[0] != "7*" {
+		t.Fatalf("Expected only library/redis to be mirrored, got %+v", repos)
+	}
+
+	for key, reason := range map[string]string{"search/redis": "Conflict", "search/etcd": "Invalid", "search/nginx": "Invalid"} {
+		status, ok := o.statuses[key]
+		if !ok || status.Conditions[0].Reason != reason || status.Conditions[0].Status != "False" {
+			t.Errorf("Expected %s to be %s, got %+v", key, reason, status)
+		}
+	}
+
+	if msg := o.statuses["search/etcd"].Conditions[0].Message; !strings.Contains(msg, "Invalid match_tag_regex") {
+		t.Errorf("Expected the lint error in the status, got %q", msg)
+	}
+}
+
+func TestOperatorRepositoryStatus(t *testing.T) {
+	res := &mirrorResource{}
+	res.Metadata.Name, res.Metadata.Namespace, res.Metadata.Generation = "redis", "payments", 3
+
+	rr := newRunReport().repository("library/redis", dockerHub)
+	rr.tag("7").fail(rr, errors.New("pull failed"))
+	rr.tag("6")
+
+	o := newOperator("", "", "")
+	first := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	status := o.repositoryStatus(res, rr, first)
+
+	if status.ObservedGeneration != 3 || len(status.Tags) != 2 || status.Tags[0].Tag != "7" || status.Tags[0].Result != resultFailed {
+		t.Errorf("Expected the tags in the status, got %+v", status)
+	}
+	if ready := status.Conditions[0]; ready.Type != conditionReady || ready.Status != "False" || ready.Reason != "Failed" {
+		t.Errorf("Expected the repository not to be ready, got %+v", ready)
+	}
+
+	// the transition time only changes with the status of the condition
+	second := first.Add(time.Hour)
+	status = o.repositoryStatus(res, rr, second)
+	if !status.Conditions[0].LastTransitionTime.Equal(first) || !status.LastSyncTime.Equal(second) {
+		t.Errorf("Expected the transition time to be kept, got %+v", status.Conditions[0])
+	}
+
+	rr.deprecated("Docker Hub repository library/redis is inactive")
+	status = o.repositoryStatus(res, rr, second)
+	if deprecated := status.Conditions[1]; deprecated.Status != "True" || !deprecated.LastTransitionTime.Equal(second) {
+		t.Errorf("Expected the repository to be deprecated, got %+v", deprecated)
+	}
+}
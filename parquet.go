@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Parquet physical and converted types, and the Thrift compact protocol types of the footer
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetNoConvertedType = -1
+
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetColumn is a required column of a Parquet file, with its PLAIN encoded values
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	data      bytes.Buffer
+}
+
+func (c *parquetColumn) int64(v int64) {
+	binary.Write(&c.data, binary.LittleEndian, v)
+}
+
+func (c *parquetColumn) double(v float64) {
+	binary.Write(&c.data, binary.LittleEndian, math.Float64bits(v))
+}
+
+func (c *parquetColumn) string(s string) {
+	binary.Write(&c.data, binary.LittleEndian, uint32(len(s)))
+	c.data.WriteString(s)
+}
+
+// writeParquet returns a Parquet file of the columns, in a single row group of uncompressed
+// data pages: the history files are small, and Athena reads them as is. The columns are
+// required, so the pages have no repetition nor definition levels
+func writeParquet(columns []*parquetColumn, rows int) []byte {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	offsets := make([]int64, len(columns))
+	sizes := make([]int64, len(columns))
+	var total int64
+	for i, c := range columns {
+		header := &thriftWriter{}
+		header.begin()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(c.data.Len()))
+		header.i32(3, int32(c.data.Len()))
+		header.structField(5)
+		header.i32(1, int32(rows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE
+		header.i32(4, 3) // RLE
+		header.end()
+		header.end()
+
+		offsets[i] = int64(file.Len())
+		sizes[i] = int64(header.buf.Len() + c.data.Len())
+		total += sizes[i]
+		file.Write(header.buf.Bytes())
+		file.Write(c.data.Bytes())
+	}
+
+	footer := &thriftWriter{}
+	footer.begin()
+	footer.i32(1, 1) // version
+	footer.list(2, thriftStruct, len(columns)+1)
+	footer.begin()
+	footer.binary(4, "schema")
+	footer.i32(5, int32(len(columns)))
+	footer.end()
+	for _, c := range columns {
+		footer.begin()
+		footer.i32(1, c.physical)
+		footer.i32(3, 0) // REQUIRED
+		footer.binary(4, c.name)
+		if c.converted != parquetNoConvertedType {
+			footer.i32(6, c.converted)
+		}
+		footer.end()
+	}
+	footer.i64(3, int64(rows))
+
+	footer.list(4, thriftStruct, 1)
+	footer.begin()
+	footer.list(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		footer.begin()
+		footer.i64(2, offsets[i])
+		footer.structField(3)
+		footer.i32(1, c.physical)
+		footer.list(2, thriftI32, 1)
+		footer.varint(zigzag(0)) // PLAIN
+		footer.list(3, thriftBinary, 1)
+		footer.varint(uint64(len(c.name)))
+		footer.buf.WriteString(c.name)
+		footer.i32(4, 0) // UNCOMPRESSED
+		footer.i64(5, int64(rows))
+		footer.i64(6, sizes[i])
+		footer.i64(7, sizes[i])
+		footer.i64(9, offsets[i])
+		footer.end()
+		footer.end()
+	}
+	footer.i64(2, total)
+	footer.i64(3, int64(rows))
+	footer.end()
+
+	footer.binary(6, "docker-mirror")
+	footer.end()
+
+	file.Write(footer.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(footer.buf.Len()))
+	file.WriteString("PAR1")
+
+	return file.Bytes()
+}
+
+// thriftWriter encodes the Parquet metadata with the Thrift compact protocol
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // last field id of the structs being written
+}
+
+// begin starts a struct, either the top level one or an element of a list
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+// end writes the stop field of the struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// list writes the header of a list field, followed by its n elements of the kind
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | kind)
+		return
+	}
+	t.buf.WriteByte(0xf0 | kind)
+	t.varint(uint64(n))
+}
+
+// structField starts a struct field, closed with end
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often a paused scheduler checks if it got resumed
+var pauseCheckInterval = 1 * time.Second
+
+// scheduler is paused and resumed through the admin server
+var scheduler = &pauser{}
+
+// pauser stops scheduling new repositories and tags while paused, the transfers in
+// progress are completed
+type pauser struct {
+	mu     sync.Mutex
+	paused bool
+	since  time.Time
+}
+
+func (p *pauser) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		p.paused = true
+		p.since = time.Now()
+		log.Warn("Paused scheduling new transfers")
+	}
+}
+
+func (p *pauser) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		p.paused = false
+		log.Infof("Resumed scheduling new transfers, after %s", time.Since(p.since).Round(time.Second))
+	}
+}
+
+// status returns if the scheduler is paused, and since when
+func (p *pauser) status() (bool, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused, p.since
+}
+
+// wait blocks while the scheduler is paused, unless the kill switch is engaged
+func (p *pauser) wait(logger *log.Entry) {
+	logged := false
+	for {
+		if paused, _ := p.status(); !paused || killSwitch.stopped() != "" {
+			return
+		}
+
+		if !logged {
+			logger.Info("Paused, waiting to be resumed")
+			logged = true
+		}
+
+		time.Sleep(pauseCheckInterval)
+	}
+}
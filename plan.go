@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	planAdd    = "add"
+	planUpdate = "update"
+	planSkip   = "skip"
+)
+
+// planTarget is the difference between the desired and actual state of a repository in a target
+type planTarget struct {
+	Registry   string
+	Repository string
+	Create     bool // the repository doesn't exist yet in the target
+	Tags       []planTag
+}
+
+// planTag is what the next run would do with a single tag
+type planTag struct {
+	Tag    string
+	Action string
+	Reason string
+}
+
+// planCommand prints, like `terraform plan`, which repositories the next run would create and
+// which tags it would add, update or skip in each target. It only calls the tag APIs of the
+// source hosts and the targets, nothing is pulled or pushed
+func planCommand(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	configFile := configFlag(flags)
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "only plan the repositories starting with this prefix")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror plan [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	setupConfig(*configFile)
+
+	mirrors, failed := planRepositories(*prefix)
+
+	var creates, adds, updates, skips int
+	for _, m := range mirrors {
+		for _, pt := range m.plan(time.Now()) {
+			if pt.Tags == nil {
+				log.Errorf("Failed to list tags of %s/%s", pt.Registry, pt.Repository)
+				failed = true
+				continue
+			}
+
+			fmt.Printf("%s -> %s/%s\n", m.sourceRepository(), pt.Registry, pt.Repository)
+			if pt.Create {
+				fmt.Println("  + create repository")
+				creates++
+			}
+
+			for _, tag := range pt.Tags {
+				switch tag.Action {
+				case planAdd:
+					fmt.Printf("  + %s (%s)\n", tag.Tag, tag.Reason)
+					adds++
+				case planUpdate:
+					fmt.Printf("  ~ %s (%s)\n", tag.Tag, tag.Reason)
+					updates++
+				default:
+					fmt.Printf("    %s (%s)\n", tag.Tag, tag.Reason)
+					skips++
+				}
+			}
+		}
+	}
+
+	fmt.Printf("\nPlan: %d repositories to create, %d tags to add, %d to update, %d to skip.\n", creates, adds, updates, skips)
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// planRepositories lists the tags of the repositories starting with the prefix, failed is
+// true if the tags of a repository could not be listed
+func planRepositories(prefix string) (mirrors []*mirror, failed bool) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
+	}
+
+	targets := setupTargets(cfg)
+
+	for _, repo := range config.allRepositories() {
+		if prefix != "" && !strings.HasPrefix(repo.Name, prefix) {
+			continue
+		}
+
+		if repo.Host == "" {
+			repo.Host = dockerHub
+		}
+
+		if !supportedHost(repo.Host) {
+			log.Errorf("Unsupported host %s for repository %s", repo.Host, repo.Name)
+			failed = true
+			continue
+		}
+
+		m := &mirror{targets: targets, report: report.repository(repo.Name, repo.Host)}
+		if err := m.setup(repo); err != nil {
+			log.Errorf("Failed to list tags of repository %s: %s", repo.Name, err)
+			failed = true
+			continue
+		}
+
+		mirrors = append(mirrors, m)
+	}
+
+	return mirrors, failed
+}
+
+// plan compares the remote tags with the tags of the repository in each target, a
+// target the tags could not be listed from has nil Tags
+func (m *mirror) plan(now time.Time) []planTarget {
+	var res []planTarget
+	for _, t := range m.targets {
+		// the standby targets only get the tags their primary target fails to get
+		if t.config.StandbyFor != "" || !t.wantsRepository(m.repo.Name) {
+			continue
+		}
+
+		pt, _ := m.planTarget(t, now)
+		res = append(res, pt)
+	}
+
+	return res
+}
+
+// planTarget compares the remote tags with the tags of the repository in the target, with
+// a plan tag per remote tag in the same order, and returns the digests of the target tags
+func (m *mirror) planTarget(t *target, now time.Time) (planTarget, map[string]string) {
+	pt := planTarget{Registry: t.registry, Repository: m.targetRepositoryName(t), Create: !t.ecrManager.exists(m.targetRepositoryName(t))}
+
+	existing := map[string]string{}
+	if !pt.Create {
+		var err error
+		if existing, err = t.ecrManager.listTags(pt.Repository); err != nil {
+			m.log.Warnf("Failed to list tags of %s/%s: %s", t.registry, pt.Repository, err)
+			return pt, nil
+		}
+	}
+
+	pt.Tags = []planTag{}
+	for _, remoteTag := range m.remoteTags {
+		if !t.wantsTag(m.mapTag(remoteTag.Name)) {
+			pt.Tags = append(pt.Tags, planTag{remoteTag.Name, planSkip, "ignored by target filters"})
+			continue
+		}
+
+		tag := m.planTag(t, pt.Repository, remoteTag, existing, now)
+		if tag.Action != planSkip && m.repo.CheckMediaTypes && t.isECR() {
+			// a copy of the mirror, validating flags schema1 tags on it
+			tm := *m
+			if reason, err := tm.validateTag(remoteTag.Name, []*target{t}); err != nil {
+				m.log.Warnf("Failed to validate the manifest of %s: %s", remoteTag.Name, err)
+			} else if reason != "" {
+				tag = planTag{tag.Tag, planSkip, reason}
+			}
+		}
+		pt.Tags = append(pt.Tags, tag)
+	}
+
+	return pt, existing
+}
+
+// planTag decides whether the tag would be added, updated or skipped in the target
+func (m *mirror) planTag(t *target, repository string, remoteTag RepositoryTag, existing map[string]string, now time.Time) planTag {
+	tag := m.targetTag(t, remoteTag.Name, now)
+	digest, ok := existing[tag]
+	if !ok {
+		return planTag{tag, planAdd, "new tag"}
+	}
+
+	// the digest pushed to the target is not the upstream one when the upstream tag is a
+	// multi-platform index, the state backend records which upstream digest was mirrored
+	upstream := remoteTag.digest()
+	if upstream == "" {
+		return planTag{tag, planUpdate, "upstream digest unknown"}
+	}
+
+	if state != nil {
+		source := m.sourceImage(remoteTag.Name)
+		mirrored, err := state.load(source)
+		if err != nil {
+			m.log.Warnf("Failed to load state: %s", err)
+		} else if mirrored != nil && mirrored.covers([]string{fmt.Sprintf("%s/%s:%s", t.registry, repository, tag)}) {
+			if mirrored.SourceDigest == upstream {
+				return planTag{tag, planSkip, "up to date"}
+			}
+			return planTag{tag, planUpdate, "upstream changed"}
+		}
+	}
+
+	if digest == upstream {
+		return planTag{tag, planSkip, "up to date"}
+	}
+
+	return planTag{tag, planUpdate, "digest differs"}
+}
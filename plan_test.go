@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPlan(t *testing.T) {
+	defer func(s stateBackend) { state = s }(state)
+	state = nil
+
+	now := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	ecr := &target{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", primary: true, config: TargetConfig{Prefix: "hub/"}, ecrManager: &fakeManager{tags: map[string]map[string]string{
+		"hub/redis": {"6": "sha256:six", "7": "sha256:old"},
+	}}}
+	filtered := &target{registry: "registry.example.com", config: TargetConfig{MatchTags: []string{"7*"}}, ecrManager: &fakeManager{tags: map[string]map[string]string{}}}
+	archive := &target{registry: "archive.example.com", config: TargetConfig{Archive: true}, ecrManager: &fakeManager{tags: map[string]map[string]string{
+		"redis": {"7-20260115": "sha256:seven"},
+	}}}
+
+	m := mirror{
+		log:        log.WithField("test", "plan"),
+		targets:    []*target{ecr, filtered, archive},
+		repo:       Repository{Name: "redis", Host: dockerHub},
+		remoteTags: []RepositoryTag{{Name: "6", Digest: "sha256:six"}, {Name: "7", Digest: "sha256:seven"}, {Name: "8"}},
+	}
+
+	want := []planTarget{
+		{Registry: ecr.registry, Repository: "hub/redis", Tags: []planTag{
+			{"6", planSkip, "up to date"},
+			{"7", planUpdate, "digest differs"},
+			{"8", planAdd, "new tag"},
+		}},
+		{Registry: filtered.registry, Repository: "redis", Create: true, Tags: []planTag{
+			{"6", planSkip, "ignored by target filters"},
+			{"7", planAdd, "new tag"},
+			{"8", planSkip, "ignored by target filters"},
+		}},
+		{Registry: archive.registry, Repository: "redis", Tags: []planTag{
+			{"6-20260115", planAdd, "new tag"},
+			{"7-20260115", planSkip, "up to date"},
+			{"8-20260115", planAdd, "new tag"},
+		}},
+	}
+
+	if got := m.plan(now); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected plan\n%+v\ngot\n%+v", want, got)
+	}
+
+	// the state backend knows which upstream digest was mirrored, even if the target digest differs
+	state = memoryState{
+		"redis:7": {SourceDigest: "sha256:seven", Targets: map[string]string{ecr.registry + "/hub/redis:7": "sha256:old"}},
+	}
+	m.targets = []*target{ecr}
+	if got := m.plan(now)[0].Tags[1]; got != (planTag{"7", planSkip, "up to date"}) {
+		t.Errorf("Expected the state to mark the tag as up to date, got %+v", got)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// annotation of the buildx attestation manifests, with the digest of the platform manifest
+// they are about
+const attestationReferenceAnnotation = "vnd.docker.reference.digest"
+
+// platformError is returned when no platform of the index is in the `platforms` of the
+// repository, the tag is skipped as unsupported
+type platformError struct {
+	reference string
+	platforms []string
+}
+
+func (e *platformError) Error() string {
+	return fmt.Sprintf("%s has none of the platforms %s", e.reference, strings.Join(e.platforms, ", "))
+}
+
+// parsePlatform splits a platform of the `platforms` of a repository, i.e. linux/arm/v7, into
+// its os, architecture and optional variant
+func parsePlatform(s string) (platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return platform{}, fmt.Errorf("Invalid platform %q, we support os/architecture[/variant], i.e. linux/arm64", s)
+	}
+
+	p := platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	return p, nil
+}
+
+// matches returns true when the platform of a manifest is the wanted platform, any variant
+// matches a wanted platform without variant
+func (p *platform) matches(wanted platform) bool {
+	return p != nil && p.OS == wanted.OS && p.Architecture == wanted.Architecture && (wanted.Variant == "" || p.Variant == wanted.Variant)
+}
+
+// platformIndex is an index of the source narrowed down to the `platforms` of the repository
+type platformIndex struct {
+	body      []byte
+	mediaType string
+	digest    string   // of the rebuilt index
+	source    string   // of the upstream index
+	manifests []string // the selected platform manifests, and their attestations
+}
+
+// selectPlatforms rebuilds the index of the reference with only the manifests of the wanted
+// platforms, and the attestations of those. Returns nil when the reference isn't an index,
+// a single platform image is copied as is
+func selectPlatforms(src *registryClient, repository, reference string, platforms []string) (*platformIndex, error) {
+	body, mediaType, digest, err := src.manifest(repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("Could not parse manifest %s:%s: %s", repository, reference, err)
+	}
+	if len(index.Manifests) == 0 {
+		return nil, nil
+	}
+
+	var wanted []platform
+	for _, s := range platforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			return nil, err
+		}
+		wanted = append(wanted, p)
+	}
+
+	// the raw entries are kept, so the fields docker-mirror doesn't know survive the rebuild
+	descriptors := make([]descriptor, len(index.Manifests))
+	selected := make(map[string]bool)
+	for i, raw := range index.Manifests {
+		if err := json.Unmarshal(raw, &descriptors[i]); err != nil {
+			return nil, fmt.Errorf("Could not parse manifest %s:%s: %s", repository, reference, err)
+		}
+
+		for _, p := range wanted {
+			if descriptors[i].Platform.matches(p) {
+				selected[descriptors[i].Digest] = true
+				break
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, &platformError{reference: fmt.Sprintf("%s:%s", repository, reference), platforms: platforms}
+	}
+
+	res := &platformIndex{mediaType: mediaType, source: digest}
+	var kept []json.RawMessage
+	for i, d := range descriptors {
+		if selected[d.Digest] || selected[d.Annotations[attestationReferenceAnnotation]] {
+			kept = append(kept, index.Manifests[i])
+			res.manifests = append(res.manifests, d.Digest)
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	if fields["manifests"], err = json.Marshal(kept); err != nil {
+		return nil, err
+	}
+	if res.body, err = json.Marshal(fields); err != nil {
+		return nil, err
+	}
+	res.digest = sha256Digest(res.body)
+
+	return res, nil
+}
+
+// copy copies the selected platform manifests with their blobs, then the rebuilt index to
+// the destination tag. It has the signature of copyImage, the reference is already resolved
+func (p *platformIndex) copy(src *registryClient, srcRepository, reference string, dst *registryClient, dstRepository, tag string) (string, int64, error) {
+	var transferred int64
+	for _, digest := range p.manifests {
+		_, n, err := copyImage(src, srcRepository, digest, dst, dstRepository, digest)
+		transferred += n
+		if err != nil {
+			return "", transferred, err
+		}
+	}
+
+	return p.digest, transferred, dst.putManifest(dstRepository, tag, p.mediaType, p.body)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := map[string]*platform{
+		"linux/amd64":    {OS: "linux", Architecture: "amd64"},
+		"linux/arm/v7":   {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"linux":          nil,
+		"linux/":         nil,
+		"linux/arm/v7/x": nil,
+	}
+
+	for s, want := range tests {
+		p, err := parsePlatform(s)
+		if want == nil {
+			if err == nil {
+				t.Errorf("Expected %q to be invalid", s)
+			}
+			continue
+		}
+		if err != nil || p != *want {
+			t.Errorf("Expected %q to parse into %+v, got %+v %v", s, *want, p, err)
+		}
+	}
+}
+
+func TestSelectPlatforms(t *testing.T) {
+	source := newFakeRegistry()
+	var entries []descriptor
+	digests := map[string]string{}
+	for _, p := range []platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}} {
+		body := source.image("library/redis", p.Architecture, []byte("layer-"+p.Architecture))
+		digest := sha256Digest(body)
+		source.manifests["library/redis:"+digest] = body
+		digests[p.Architecture] = digest
+
+		p := p
+		entries = append(entries, descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: digest, Size: int64(len(body)), Platform: &p})
+	}
+
+	// the buildx attestation of the arm64 manifest
+	attestation := source.image("library/redis", "attestation", []byte("in-toto"))
+	source.manifests["library/redis:"+sha256Digest(attestation)] = attestation
+	entries = append(entries, descriptor{
+		MediaType:   "application/vnd.oci.image.manifest.v1+json",
+		Digest:      sha256Digest(attestation),
+		Size:        int64(len(attestation)),
+		Platform:    &platform{OS: "unknown", Architecture: "unknown"},
+		Annotations: map[string]string{attestationReferenceAnnotation: digests["arm64"], "vnd.docker.reference.type": "attestation-manifest"},
+	})
+
+	index, _ := json.Marshal(map[string]interface{}{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": entries})
+	source.manifests["library/redis:7"] = index
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	selected, err := selectPlatforms(src, "library/redis", "7", []string{"linux/arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected.source != sha256Digest(index) || selected.digest != sha256Digest(selected.body) {
+		t.Errorf("Expected the source %s and the digest of the rebuilt index, got %s %s", sha256Digest(index), selected.source, selected.digest)
+	}
+	if want := []string{digests["arm64"], sha256Digest(attestation)}; strings.Join(selected.manifests, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected the arm64 manifest and its attestation, got %v", selected.manifests)
+	}
+
+	dest := newFakeRegistry()
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+	dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	digest, _, err := selected.copy(src, "library/redis", "7", dst, "mirror/redis", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != selected.digest {
+		t.Errorf("Expected the digest %s, got %s", selected.digest, digest)
+	}
+
+	var copied struct {
+		MediaType string       `json:"mediaType"`
+		Manifests []descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(dest.manifests["mirror/redis:7"], &copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.MediaType != "application/vnd.oci.image.index.v1+json" || len(copied.Manifests) != 2 || copied.Manifests[0].Digest != digests["arm64"] {
+		t.Errorf("Expected the index with the arm64 manifest and its attestation, got %+v", copied)
+	}
+	if _, ok := dest.manifests["mirror/redis:"+digests["amd64"]]; ok {
+		t.Errorf("Expected the amd64 manifest not to be copied")
+	}
+
+	var pe *platformError
+	if _, err := selectPlatforms(src, "library/redis", "7", []string{"windows/amd64"}); !errors.As(err, &pe) {
+		t.Errorf("Expected a platformError, got %v", err)
+	}
+
+	// a single platform image is copied as is
+	if selected, err := selectPlatforms(src, "library/redis", "amd64", []string{"linux/arm64"}); selected != nil || err != nil {
+		t.Errorf("Expected no index for an image, got %+v %v", selected, err)
+	}
+}
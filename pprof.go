@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// interval of the profiles written to DEBUG_PPROF_DIR, unless DEBUG_PPROF_INTERVAL is set
+	defaultProfileInterval = 15 * time.Minute
+
+	// number of profiles of each kind kept in DEBUG_PPROF_DIR, the oldest are removed
+	maxProfiles = 96
+)
+
+// profiles written to disk, the goroutine profile shows leaked goroutines
+var profileKinds = []string{"heap", "goroutine"}
+
+// pprofEnabled returns true when the profiling is enabled with DEBUG_PPROF=1
+func pprofEnabled() bool {
+	return os.Getenv("DEBUG_PPROF") == "1"
+}
+
+// registerPprof serves the pprof endpoints under /debug/pprof/
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// startProfileWriter writes the heap and goroutine profiles to dir at every interval, in
+// the background, so a slow memory growth can be diagnosed after the fact
+func startProfileWriter(dir string, interval time.Duration) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Could not create profile directory: %s", err)
+	}
+
+	log.Infof("Writing heap and goroutine profiles to %s every %s", dir, interval)
+
+	go func() {
+		for {
+			if err := writeProfiles(dir, time.Now()); err != nil {
+				log.Warnf("Failed to write profiles: %s", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// writeProfiles writes the profiles of every kind, e.g. heap-20210101T120000Z.pprof, and removes
+// the oldest ones
+func writeProfiles(dir string, now time.Time) error {
+	// an up to date heap profile, it is only updated by the garbage collector
+	runtime.GC()
+
+	for _, kind := range profileKinds {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", kind, now.UTC().Format("20060102T150405Z")))
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+
+		err = rpprof.Lookup(kind).WriteTo(f, 0)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		// the timestamps sort in creation order
+		existing, _ := filepath.Glob(filepath.Join(dir, kind+"-*.pprof"))
+		sort.Strings(existing)
+		for len(existing) > maxProfiles {
+			if err := os.Remove(existing[0]); err != nil {
+				return err
+			}
+			existing = existing[1:]
+		}
+	}
+
+	return nil
+}
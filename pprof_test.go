@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdminPprof(t *testing.T) {
+	for env, want := range map[string]int{"": http.StatusNotFound, "1": http.StatusOK} {
+		os.Setenv("DEBUG_PPROF", env)
+		server := httptest.NewServer(newAdminMux())
+
+		res, err := http.Get(server.URL + "/debug/pprof/goroutine?debug=1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		res.Body.Close()
+		server.Close()
+
+		if res.StatusCode != want {
+			t.Errorf("DEBUG_PPROF=%q: expected %d, got %d", env, want, res.StatusCode)
+		}
+	}
+	os.Unsetenv("DEBUG_PPROF")
+}
+
+func TestWriteProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	// a full directory of older heap profiles
+	old := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxProfiles; i++ {
+		name := fmt.Sprintf("heap-%s.pprof", old.Add(time.Duration(i)*time.Minute).Format("20060102T150405Z"))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := writeProfiles(dir, now); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, kind := range profileKinds {
+		info, err := os.Stat(filepath.Join(dir, kind+"-20210102T000000Z.pprof"))
+		if err != nil || info.Size() == 0 {
+			t.Errorf("Expected a %s profile, got %v", kind, err)
+		}
+	}
+
+	heaps, _ := filepath.Glob(filepath.Join(dir, "heap-*.pprof"))
+	if len(heaps) != maxProfiles {
+		t.Errorf("Expected %d heap profiles to be kept, got %d", maxProfiles, len(heaps))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "heap-20210101T000000Z.pprof")); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest heap profile to be removed")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// pruneTargets deletes the tags of the targets that are no longer in the filtered upstream
+// tags, i.e. deleted or retracted upstream, with `prune_target`. Nothing is deleted when
+// the repository failed, timed out or the run is stopped, the upstream tags may be incomplete
+func (m *mirror) pruneTargets(targets []*target) {
+	if reason := m.stopped(); reason != "" {
+		m.log.Warnf("Not pruning the targets: %s", reason)
+		return
+	}
+	if m.report.failed() {
+		m.log.Warn("Not pruning the targets, the repository failed")
+		return
+	}
+	if len(m.remoteTags) == 0 {
+		m.log.Warn("Not pruning the targets, no upstream tag was found")
+		return
+	}
+
+	for _, t := range targets {
+		// archive snapshots have their own retention
+		if t.config.Archive {
+			continue
+		}
+
+		repository := m.targetRepositoryName(t)
+		existing, err := t.ecrManager.listTags(repository)
+		if err != nil {
+			m.log.Errorf("Could not list the tags of %s/%s to prune: %s", t.registry, repository, err)
+			continue
+		}
+
+		stale := m.staleTags(t, existing)
+		if len(stale) == 0 {
+			continue
+		}
+
+		p := &prunedTags{Registry: t.registry, Repository: repository, Tags: stale, Reason: pruneNotUpstream, DryRun: m.repo.PruneDryRun}
+		if m.repo.PruneDryRun {
+			m.log.Infof("Would delete %d tags no longer upstream from %s/%s (prune_dry_run): %v", len(stale), t.registry, repository, stale)
+		} else {
+			m.log.Infof("Deleting %d tags no longer upstream from %s/%s: %v", len(stale), t.registry, repository, stale)
+			if err := deletions.deleteTags(t, repository, stale, pruneNotUpstream, p); err != nil {
+				m.log.Errorf("Could not prune %s/%s: %s", t.registry, repository, err)
+				p.Error = err.Error()
+			}
+		}
+		m.report.prune(p)
+	}
+}
+
+// staleTags returns the tags of the target that aren't mirrored from an upstream tag
+// anymore, except the `prune_protect` globs
+func (m *mirror) staleTags(t *target, existing map[string]string) []string {
+	wanted := make(map[string]bool)
+	for _, tag := range m.remoteTags {
+		wanted[m.mapTag(tag.Name)] = true
+	}
+
+	var stale []string
+	for tag := range existing {
+		if wanted[tag] || !t.wantsTag(tag) || m.pruneProtected(tag) {
+			continue
+		}
+		stale = append(stale, tag)
+	}
+	sort.Strings(stale)
+
+	return stale
+}
+
+// pruneProtected returns true when the tag matches a `prune_protect` glob, is the
+// quarantined copy of a tag deleted upstream, a cosign signature of a mirrored digest or
+// the sha256-<hex> referrers fallback tag of copy_referrers: they aren't copied again once
+// the tag is up to date
+func (m *mirror) pruneProtected(tag string) bool {
+	if m.repo.Quarantine != "" && strings.HasSuffix(tag, m.repo.Quarantine) {
+		return true
+	}
+	if isCosignTag(tag) || (m.repo.CopyReferrers && digestTagRE.MatchString(tag)) {
+		return true
+	}
+
+	for _, pattern := range m.repo.PruneProtect {
+		if glob.Glob(pattern, tag) {
+			return true
+		}
+	}
+
+	return false
+}
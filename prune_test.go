@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPruneTargets(t *testing.T) {
+	newTargets := func() (*fakeManager, []*target) {
+		manager := &fakeManager{tags: map[string]map[string]string{
+			"hub/redis": {"6": "sha256:six", "7": "sha256:seven", "7.0-rc1": "sha256:rc", "latest": "sha256:seven", "5": "sha256:five", "debug": "sha256:debug"},
+		}}
+		ecr := &target{registry: "ecr", config: TargetConfig{Prefix: "hub/", DropTags: []string{"debug"}}, ecrManager: manager}
+		archive := &target{registry: "archive", config: TargetConfig{Prefix: "hub/", Archive: true}, ecrManager: manager}
+		return manager, []*target{ecr, archive}
+	}
+
+	newMirror := func(repo Repository) mirror {
+		repo.Name, repo.Host, repo.PruneTarget = "redis", dockerHub, true
+		return mirror{
+			log:        log.WithField("test", "prune"),
+			repo:       repo,
+			report:     newRunReport().repository("redis", dockerHub),
+			remoteTags: []RepositoryTag{{Name: "6"}, {Name: "7"}},
+		}
+	}
+
+	// the tags no longer upstream are deleted, except the protected ones and the ones the target doesn't want
+	manager, targets := newTargets()
+	m := newMirror(Repository{PruneProtect: []string{"latest"}})
+	m.pruneTargets(targets)
+	if !reflect.DeepEqual(manager.deleted, []string{"hub/redis:5", "hub/redis:7.0-rc1"}) {
+		t.Errorf("Unexpected deleted tags %v", manager.deleted)
+	}
+	if len(m.report.Pruned) != 1 || m.report.Pruned[0].Registry != "ecr" || m.report.Pruned[0].DryRun {
+		t.Errorf("Unexpected pruned report %+v", m.report.Pruned)
+	}
+
+	// a dry run only reports them
+	manager, targets = newTargets()
+	m = newMirror(Repository{PruneDryRun: true})
+	m.pruneTargets(targets)
+	if len(manager.deleted) != 0 {
+		t.Errorf("Expected a dry run to delete nothing, got %v", manager.deleted)
+	}
+	if len(m.report.Pruned) != 1 || !m.report.Pruned[0].DryRun || !reflect.DeepEqual(m.report.Pruned[0].Tags, []string{"5", "7.0-rc1", "latest"}) {
+		t.Errorf("Unexpected dry run report %+v", m.report.Pruned)
+	}
+
+	// nothing is deleted when the upstream tags may be incomplete
+	manager, targets = newTargets()
+	m = newMirror(Repository{})
+	m.report.fail(errors.New("Get https://hub.docker.com failed with 500"))
+	m.pruneTargets(targets)
+
+	m = newMirror(Repository{})
+	m.remoteTags = nil
+	m.pruneTargets(targets)
+	if len(manager.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted, got %v", manager.deleted)
+	}
+}
+
+func TestPruneKeepsSignatures(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	signature := cosignTag("sha256:"+strings.Repeat("a", 64), ".sig")
+	attestation := cosignTag("sha256:"+strings.Repeat("a", 64), ".att")
+	manager := &fakeECRManager{
+		fakeManager: fakeManager{tags: map[string]map[string]string{
+			"hub/redis": {"7": "sha256:seven", "6": "sha256:six", signature: "sha256:sig", attestation: "sha256:att", "sha256-abc.sig": "sha256:other"},
+		}},
+		pushed: map[string]map[string]time.Time{
+			"hub/redis": {"7": now.Add(-1 * day), "6": now.Add(-2 * day), signature: now.Add(-300 * day), attestation: now},
+		},
+	}
+	targets := []*target{{registry: "ecr", config: TargetConfig{Prefix: "hub/"}, ecrManager: manager}}
+
+	maxAge := Duration(30 * day)
+	m := mirror{
+		log:        log.WithField("test", "prune"),
+		repo:       Repository{Name: "redis", Host: dockerHub, PruneTarget: true, TargetMaxTags: 1, TargetMaxTagAge: &maxAge},
+		report:     newRunReport().repository("redis", dockerHub),
+		remoteTags: []RepositoryTag{{Name: "7"}},
+	}
+
+	// the signatures aren't upstream tags, and don't count in the retention
+	m.pruneTargets(targets)
+	m.applyRetention(targets, now)
+	if !reflect.DeepEqual(manager.deleted, []string{"hub/redis:6", "hub/redis:sha256-abc.sig", "hub/redis:6"}) {
+		t.Errorf("Expected the cosign tags to be kept, deleted %v", manager.deleted)
+	}
+}
+
+func TestPruneKeepsReferrersTag(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	referrers := cosignTag("sha256:"+strings.Repeat("b", 64), "")
+	manager := &fakeECRManager{
+		fakeManager: fakeManager{tags: map[string]map[string]string{
+			"hub/redis": {"7": "sha256:seven", "6": "sha256:six", referrers: "sha256:index"},
+		}},
+		pushed: map[string]map[string]time.Time{
+			"hub/redis": {"7": now, "6": now.Add(-time.Hour), referrers: now.Add(time.Hour)},
+		},
+	}
+	targets := []*target{{registry: "ecr", config: TargetConfig{Prefix: "hub/"}, ecrManager: manager}}
+
+	m := mirror{
+		log:        log.WithField("test", "prune"),
+		repo:       Repository{Name: "redis", Host: dockerHub, PruneTarget: true, TargetMaxTags: 1, CopyReferrers: true},
+		report:     newRunReport().repository("redis", dockerHub),
+		remoteTags: []RepositoryTag{{Name: "7"}},
+	}
+
+	// the fallback tag isn't pruned, nor counted as the most recent tag
+	m.pruneTargets(targets)
+	m.applyRetention(targets, now)
+	if !reflect.DeepEqual(manager.deleted, []string{"hub/redis:6", "hub/redis:6"}) {
+		t.Errorf("Expected the referrers fallback tag to be kept, deleted %v", manager.deleted)
+	}
+}
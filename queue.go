@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// max wait of a ReceiveMessage long poll
+	queueWaitSeconds = 20
+	// visibility timeout of the received messages without `visibility_timeout`
+	queueDefaultVisibility = 30 * time.Second
+)
+
+// QueueConfig is the SQS queue of the mirror jobs of the `queue` command
+type QueueConfig struct {
+	URL               string    `yaml:"url,omitempty"`
+	DeadLetterURL     string    `yaml:"dead_letter_url,omitempty"`
+	MaxReceives       int       `yaml:"max_receives,omitempty"`
+	VisibilityTimeout *Duration `yaml:"visibility_timeout,omitempty"`
+	AllowUnlisted     bool      `yaml:"allow_unlisted,omitempty"`
+}
+
+// queueJob is a message of the queue, a repository or a single tag to mirror
+type queueJob struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Tag  string `json:"tag"`
+}
+
+// sqsMessage is a message received from the queue
+type sqsMessage struct {
+	MessageID     string            `json:"MessageId"`
+	ReceiptHandle string            `json:"ReceiptHandle"`
+	Body          string            `json:"Body"`
+	Attributes    map[string]string `json:"Attributes"`
+}
+
+// sqsClient calls the SQS JSON API of a queue
+type sqsClient struct {
+	endpoint string
+	region   string
+	cfg      aws.Config
+	client   *http.Client
+}
+
+// queueCommand long-polls the SQS queue of the config for mirror jobs, i.e.
+// {"name": "library/redis", "tag": "7.2"}, and deletes the message of a completed job.
+// A failed job is received again once its visibility timeout expires
+func queueCommand(args []string) {
+	flags := flag.NewFlagSet("queue", flag.ExitOnError)
+	configFile := configFlag(flags)
+	workers := flags.Int("workers", envInt("NUM_WORKERS"), "number of jobs executed in parallel (default: workers config or number of CPUs)")
+	adminAddr := flags.String("admin-addr", os.Getenv("ADMIN_ADDR"), "address of the admin server, e.g. :8080")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror queue [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	setupConfig(*configFile)
+	if *workers > 0 {
+		config.Workers = *workers
+	}
+
+	if config.Queue.URL == "" {
+		log.Fatal("The queue command needs a `queue -> url` in the config")
+	}
+
+	client := connectDocker()
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
+	}
+
+	targets := setupTargets(cfg)
+	setupTagSlots()
+
+	var c *cleaner
+	if config.Cleanup && !config.Daemonless {
+		c = newCleaner(&client, config.Workers)
+	}
+
+	killSwitch.watch(config.KillSwitch)
+	watchStateSignal()
+	if *adminAddr != "" {
+		startAdminServer(*adminAddr)
+	}
+
+	sqs, err := newSQSClient(config.Queue.URL, cfg)
+	if err != nil {
+		log.Fatalf("Invalid queue url: %s", err)
+	}
+
+	execute := func(job queueJob) error {
+		return executeJob(job, config.Queue.AllowUnlisted, &client, targets, c)
+	}
+
+	// every worker executes a job at a time, and a message is only received for an idle
+	// worker: a received message waiting for a worker would be received again by another
+	// instance once its visibility timeout expires
+	idle := make(chan struct{}, config.Workers)
+	messages := make(chan sqsMessage, config.Workers)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		idle <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range messages {
+				handleMessage(sqs, config.Queue, msg, execute)
+				idle <- struct{}{}
+			}
+		}()
+	}
+
+	log.Infof("Receiving mirror jobs from %s", config.Queue.URL)
+	for killSwitch.stopped() == "" {
+		scheduler.wait(log.WithField("queue", config.Queue.URL))
+
+		// wait for an idle worker, then receive a message per idle worker
+		<-idle
+		free := 1
+	idleWorkers:
+		for free < minInt(config.Workers, 10) {
+			select {
+			case <-idle:
+				free++
+			default:
+				break idleWorkers
+			}
+		}
+
+		var received []sqsMessage
+		if killSwitch.stopped() == "" {
+			if received, err = sqs.receive(config.Queue, free); err != nil {
+				log.Errorf("Could not receive messages: %s", err)
+				sleep(5 * time.Second)
+			}
+		}
+
+		for _, msg := range received {
+			messages <- msg
+		}
+		for i := len(received); i < free; i++ {
+			idle <- struct{}{}
+		}
+	}
+	close(messages)
+	wg.Wait()
+
+	if c != nil {
+		c.wait()
+	}
+
+	log.Info("Done")
+}
+
+// handleMessage executes the job of the message, and deletes the message when the job
+// completes. A message failing `max_receives` times, or that isn't a job, is moved to the
+// `dead_letter_url` queue when it's set
+func handleMessage(sqs *sqsClient, qc QueueConfig, msg sqsMessage, execute func(queueJob) error) {
+	logger := log.WithField("message_id", msg.MessageID)
+
+	var job queueJob
+	err := json.Unmarshal([]byte(msg.Body), &job)
+	if err == nil && job.Name == "" {
+		err = fmt.Errorf("Missing `name`")
+	}
+	if err != nil {
+		logger.Errorf("Invalid mirror job %q: %s", msg.Body, err)
+		if qc.DeadLetterURL != "" {
+			deadLetter(sqs, qc, msg, logger)
+		}
+		return
+	}
+
+	logger = logger.WithField("full_repo", job.Name)
+	stop := sqs.keepVisible(qc.URL, msg, queueVisibility(qc), logger)
+	err = execute(job)
+	stop()
+	if err != nil {
+		receives, _ := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+		logger.Errorf("Mirror job failed (receive %d): %s", receives, err)
+
+		if qc.DeadLetterURL != "" && qc.MaxReceives > 0 && receives >= qc.MaxReceives {
+			deadLetter(sqs, qc, msg, logger)
+		}
+		return
+	}
+
+	if err := sqs.delete(qc.URL, msg.ReceiptHandle); err != nil {
+		logger.Errorf("Could not delete the message of the completed job: %s", err)
+		return
+	}
+	logger.Info("Mirror job completed")
+}
+
+// queueVisibility returns the visibility timeout the messages are received with, and
+// extended by while their job runs
+func queueVisibility(qc QueueConfig) time.Duration {
+	if qc.VisibilityTimeout != nil {
+		return time.Duration(*qc.VisibilityTimeout)
+	}
+
+	return queueDefaultVisibility
+}
+
+// keepVisible extends the visibility timeout of the message every half timeout while its
+// job runs, so a job outlasting the timeout isn't received and executed again. The returned
+// func stops it
+func (s *sqsClient) keepVisible(queueURL string, msg sqsMessage, timeout time.Duration, logger *log.Entry) func() {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.changeVisibility(queueURL, msg.ReceiptHandle, timeout); err != nil {
+					logger.Warnf("Could not extend the visibility timeout of the message: %s", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// deadLetter moves the message to the dead letter queue
+func deadLetter(sqs *sqsClient, qc QueueConfig, msg sqsMessage, logger *log.Entry) {
+	if err := sqs.send(qc.DeadLetterURL, msg.Body); err != nil {
+		logger.Errorf("Could not move the message to the dead letter queue: %s", err)
+		return
+	}
+
+	if err := sqs.delete(qc.URL, msg.ReceiptHandle); err != nil {
+		logger.Errorf("Could not delete the message moved to the dead letter queue: %s", err)
+		return
+	}
+	logger.Warn("Moved the message to the dead letter queue")
+}
+
+// jobRepository returns the repository of the job: the configured repository with the
+// same name and host, limited to the tag of the job when set
+func jobRepository(job queueJob, allowUnlisted bool) (Repository, error) {
+	host := job.Host
+	if host == "" {
+		host = dockerHub
+	}
+
+	repo := Repository{Name: job.Name, Host: host}
+	found := false
+	for _, r := range config.allRepositories() {
+		rHost := r.Host
+		if rHost == "" {
+			rHost = dockerHub
+		}
+
+		if name, _ := r.pinnedDigests(); name == job.Name && rHost == host {
+			repo, found = r, true
+			repo.Host = rHost
+			break
+		}
+	}
+
+	if !found && !allowUnlisted {
+		return repo, fmt.Errorf("Repository %s of %s isn't in the config, set `allow_unlisted` to mirror any repository", job.Name, host)
+	}
+	if !supportedHost(host) {
+		return repo, fmt.Errorf("Unsupported host %s", host)
+	}
+
+	// a single tag of the repository, without its filters
+	if job.Tag != "" {
+		repo.Name, _ = repo.pinnedDigests()
+		repo.Tags = []string{job.Tag}
+		repo.Digests, repo.DigestTag = nil, ""
+		repo.MatchTags, repo.DropTags, repo.MatchTagRegex, repo.IgnoreTagRegex = nil, nil, nil, nil
+		repo.Semver, repo.Keep, repo.MaxTags, repo.MaxTagAge, repo.RemoteTagSource = "", nil, 0, nil, ""
+	}
+
+	return repo, nil
+}
+
+// executeJob mirrors the repository of the job, and returns the error of the repository or
+// of its first failed tag
+func executeJob(job queueJob, allowUnlisted bool, dc *DockerClient, targets []*target, c *cleaner) error {
+	repo, err := jobRepository(job, allowUnlisted)
+	if err != nil {
+		return err
+	}
+
+	rr := newRunReport().repository(repo.Name, repo.Host)
+	mirrorRepository(repo, rr, dc, targets, c, nil)
+
+	if rr.Result != resultFailed {
+		return nil
+	}
+
+	if rr.Error != "" {
+		return fmt.Errorf("%s", rr.Error)
+	}
+	for _, tr := range rr.Tags {
+		if tr.Result == resultFailed {
+			return fmt.Errorf("Tag %s: %s", tr.Tag, tr.Error)
+		}
+	}
+
+	return fmt.Errorf("Repository %s failed", repo.Name)
+}
+
+// newSQSClient returns the client of the queue, the region is the one of the queue URL
+// (i.e. https://sqs.us-east-1.amazonaws.com/123456789012/docker-mirror-jobs)
+func newSQSClient(queueURL string, cfg aws.Config) (*sqsClient, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s has no host", queueURL)
+	}
+
+	region := cfg.Region
+	if chunk := strings.Split(u.Host, "."); len(chunk) > 2 && chunk[0] == "sqs" {
+		region = chunk[1]
+	}
+
+	return &sqsClient{endpoint: u.Scheme + "://" + u.Host + "/", region: region, cfg: cfg, client: httpClient}, nil
+}
+
+// receive long-polls the queue for up to max messages
+func (s *sqsClient) receive(qc QueueConfig, max int) ([]sqsMessage, error) {
+	in := map[string]interface{}{
+		"QueueUrl":            qc.URL,
+		"MaxNumberOfMessages": max,
+		"WaitTimeSeconds":     queueWaitSeconds,
+		"AttributeNames":      []string{"ApproximateReceiveCount"},
+		"VisibilityTimeout":   int(math.Ceil(queueVisibility(qc).Seconds())),
+	}
+
+	var out struct {
+		Messages []sqsMessage `json:"Messages"`
+	}
+	if err := s.call("ReceiveMessage", in, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Messages, nil
+}
+
+// delete deletes the received message
+func (s *sqsClient) delete(queueURL, receiptHandle string) error {
+	return s.call("DeleteMessage", map[string]interface{}{"QueueUrl": queueURL, "ReceiptHandle": receiptHandle}, nil)
+}
+
+// changeVisibility makes the received message invisible for the timeout from now on
+func (s *sqsClient) changeVisibility(queueURL, receiptHandle string, timeout time.Duration) error {
+	seconds := int(math.Ceil(timeout.Seconds()))
+	return s.call("ChangeMessageVisibility", map[string]interface{}{"QueueUrl": queueURL, "ReceiptHandle": receiptHandle, "VisibilityTimeout": seconds}, nil)
+}
+
+// send sends a message to the queue
+func (s *sqsClient) send(queueURL, body string) error {
+	return s.call("SendMessage", map[string]interface{}{"QueueUrl": queueURL, "MessageBody": body}, nil)
+}
+
+// call calls an action of the SQS JSON API, signed with the AWS credentials
+func (s *sqsClient) call(action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	// the long poll of ReceiveMessage outlasts the timeout of the shared http client
+	ctx, cancel := context.WithTimeout(context.Background(), (queueWaitSeconds+10)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	creds, err := s.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "sqs", s.region, time.Now()); err != nil {
+		return err
+	}
+
+	client := *s.client
+	client.Timeout = 0
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return fmt.Errorf("SQS %s returned %d: %s %s", action, res.StatusCode, e.Type, e.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeSQS records the actions called on it, and returns the messages on ReceiveMessage
+func fakeSQS(t *testing.T, messages []sqsMessage) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sqs/aws4_request") {
+			t.Errorf("Unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+
+		var in map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&in)
+
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+		switch action {
+		case "ReceiveMessage":
+			json.NewEncoder(w).Encode(map[string]interface{}{"Messages": messages})
+		case "DeleteMessage":
+			calls = append(calls, "delete "+in["ReceiptHandle"].(string))
+			w.Write([]byte(`{}`))
+		case "ChangeMessageVisibility":
+			calls = append(calls, fmt.Sprintf("visibility %s %v", in["ReceiptHandle"], in["VisibilityTimeout"]))
+			w.Write([]byte(`{}`))
+		case "SendMessage":
+			calls = append(calls, "send "+in["QueueUrl"].(string)+" "+in["MessageBody"].(string))
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "com.amazonaws.sqs#InvalidAction", "message": "unknown action"}`))
+		}
+	}))
+
+	return server, &calls
+}
+
+func testSQSClient(t *testing.T, server *httptest.Server) *sqsClient {
+	cfg := aws.Config{Region: "us-east-1", Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})}
+
+	s, err := newSQSClient(server.URL+"/123456789012/jobs", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSQSClient(t *testing.T) {
+	server, calls := fakeSQS(t, []sqsMessage{{MessageID: "1", ReceiptHandle: "r1", Body: `{"name": "library/redis"}`, Attributes: map[string]string{"ApproximateReceiveCount": "2"}}})
+	defer server.Close()
+
+	s := testSQSClient(t, server)
+	messages, err := s.receive(QueueConfig{URL: server.URL + "/123456789012/jobs"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ReceiptHandle != "r1" || messages[0].Attributes["ApproximateReceiveCount"] != "2" {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+
+	if err := s.delete(server.URL+"/123456789012/jobs", "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*calls, []string{"delete r1"}) {
+		t.Errorf("Unexpected calls %v", *calls)
+	}
+
+	if err := s.call("PurgeQueue", map[string]interface{}{}, nil); err == nil || !strings.Contains(err.Error(), "unknown action") {
+		t.Errorf("Expected the SQS error, got %v", err)
+	}
+
+	if s, _ := newSQSClient("https://sqs.eu-west-1.amazonaws.com/123456789012/jobs", aws.Config{Region: "us-east-1"}); s.region != "eu-west-1" {
+		t.Errorf("Expected the region of the queue URL, got %s", s.region)
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	server, calls := fakeSQS(t, nil)
+	defer server.Close()
+
+	s := testSQSClient(t, server)
+	qc := QueueConfig{URL: server.URL + "/123456789012/jobs", DeadLetterURL: "dlq", MaxReceives: 3}
+
+	failing := func(queueJob) error { return errors.New("pull failed") }
+	for _, test := range []struct {
+		name     string
+		msg      sqsMessage
+		execute  func(queueJob) error
+		expected []string
+	}{
+		{"completed", sqsMessage{ReceiptHandle: "r1", Body: `{"name": "library/redis", "tag": "7.2"}`}, func(job queueJob) error {
+			if job.Name != "library/redis" || job.Tag != "7.2" {
+				t.Errorf("Unexpected job %+v", job)
+			}
+			return nil
+		}, []string{"delete r1"}},
+		{"failed", sqsMessage{ReceiptHandle: "r2", Body: `{"name": "library/redis"}`, Attributes: map[string]string{"ApproximateReceiveCount": "2"}}, failing, nil},
+		{"failed too often", sqsMessage{ReceiptHandle: "r3", Body: `{"name": "library/redis"}`, Attributes: map[string]string{"ApproximateReceiveCount": "3"}}, failing, []string{`send dlq {"name": "library/redis"}`, "delete r3"}},
+		{"invalid", sqsMessage{ReceiptHandle: "r4", Body: `redis`}, failing, []string{"send dlq redis", "delete r4"}},
+		{"no name", sqsMessage{ReceiptHandle: "r5", Body: `{"tag": "7.2"}`}, failing, []string{`send dlq {"tag": "7.2"}`, "delete r5"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			*calls = nil
+			handleMessage(s, qc, test.msg, test.execute)
+			if !reflect.DeepEqual(*calls, test.expected) {
+				t.Errorf("Expected calls %v, got %v", test.expected, *calls)
+			}
+		})
+	}
+}
+
+func TestKeepVisible(t *testing.T) {
+	server, calls := fakeSQS(t, nil)
+	defer server.Close()
+
+	s := testSQSClient(t, server)
+	stop := s.keepVisible(server.URL+"/123456789012/jobs", sqsMessage{ReceiptHandle: "r1"}, 100*time.Millisecond, log.WithField("test", t.Name()))
+	time.Sleep(120 * time.Millisecond)
+	stop()
+
+	if len(*calls) == 0 || (*calls)[0] != "visibility r1 1" {
+		t.Errorf("Expected the visibility of the message to be extended, got %v", *calls)
+	}
+
+	done := len(*calls)
+	time.Sleep(100 * time.Millisecond)
+	if len(*calls) != done {
+		t.Errorf("Expected no extension once stopped, got %v", *calls)
+	}
+}
+
+func TestJobRepository(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{Repositories: []Repository{{Name: "library/redis", MaxTags: 5, MatchTags: []string{"7*"}}}}
+
+	repo, err := jobRepository(queueJob{Name: "library/redis", Tag: "7.2"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Host != dockerHub || !reflect.DeepEqual(repo.Tags, []string{"7.2"}) || repo.MaxTags != 0 || repo.MatchTags != nil {
+		t.Errorf("Expected the single tag of the repository, got %+v", repo)
+	}
+
+	repo, err = jobRepository(queueJob{Name: "library/redis"}, false)
+	if err != nil || repo.MaxTags != 5 {
+		t.Errorf("Expected the configured repository, got %+v %v", repo, err)
+	}
+
+	if _, err := jobRepository(queueJob{Name: "library/nginx"}, false); err == nil {
+		t.Errorf("Expected an error for a repository that isn't in the config")
+	}
+
+	if _, err := jobRepository(queueJob{Name: "library/nginx"}, true); err != nil {
+		t.Errorf("Expected the unlisted repository to be allowed, got %v", err)
+	}
+
+	if _, err := jobRepository(queueJob{Name: "library/nginx", Host: "example.com"}, true); err == nil {
+		t.Errorf("Expected an error for an unsupported host")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	quotaTagAPI = "tag_api_calls"
+	quotaPull   = "pulls"
+)
+
+// quotaWindow is the rolling window upstream usage is counted in, Docker Hub pull
+// limits are counted over 6 hours
+var quotaWindow = 6 * time.Hour
+
+// quotas tracks the upstream API calls and pulls per host
+var quotas = newQuotaTracker()
+
+// quotaTracker records the tag API calls and pulls per upstream host
+type quotaTracker struct {
+	mu     sync.Mutex
+	events map[string]map[string][]time.Time // host -> kind -> times
+	totals map[string]map[string]int         // host -> kind -> count since start
+}
+
+// hostQuota is the usage of a single upstream host
+type hostQuota struct {
+	Window string         `json:"window"`
+	Recent map[string]int `json:"recent"` // counts in the rolling window
+	Total  map[string]int `json:"total"`  // counts since start
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{
+		events: make(map[string]map[string][]time.Time),
+		totals: make(map[string]map[string]int),
+	}
+}
+
+// record a tag API call or pull of the host
+func (q *quotaTracker) record(host, kind string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.events[host] == nil {
+		q.events[host] = make(map[string][]time.Time)
+		q.totals[host] = make(map[string]int)
+	}
+
+	q.events[host][kind] = append(q.prune(q.events[host][kind], time.Now()), time.Now())
+	q.totals[host][kind]++
+}
+
+// usage returns the usage per host
+func (q *quotaTracker) usage() map[string]hostQuota {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	res := make(map[string]hostQuota)
+	for host, kinds := range q.events {
+		hq := hostQuota{Window: quotaWindow.String(), Recent: make(map[string]int), Total: make(map[string]int)}
+		for kind, times := range kinds {
+			times = q.prune(times, now)
+			q.events[host][kind] = times
+			hq.Recent[kind] = len(times)
+			hq.Total[kind] = q.totals[host][kind]
+		}
+		res[host] = hq
+	}
+
+	return res
+}
+
+// prune drops the times outside the rolling window, the times are sorted
+func (q *quotaTracker) prune(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > quotaWindow {
+		i++
+	}
+
+	return times[i:]
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaTracker(t *testing.T) {
+	q := newQuotaTracker()
+	q.record(dockerHub, quotaTagAPI)
+	q.record(dockerHub, quotaTagAPI)
+	q.record(dockerHub, quotaPull)
+	q.record(quay, quotaPull)
+
+	// calls outside the rolling window only count in the total
+	q.events[dockerHub][quotaTagAPI][0] = time.Now().Add(-quotaWindow - time.Minute)
+
+	usage := q.usage()
+	if hub := usage[dockerHub]; hub.Recent[quotaTagAPI] != 1 || hub.Total[quotaTagAPI] != 2 || hub.Recent[quotaPull] != 1 {
+		t.Errorf("Unexpected %s usage %+v", dockerHub, hub)
+	}
+
+	if got := usage[quay]; got.Recent[quotaPull] != 1 || got.Recent[quotaTagAPI] != 0 {
+		t.Errorf("Unexpected %s usage %+v", quay, got)
+	}
+}
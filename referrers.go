@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const mediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+
+// referrers of referrers copied, i.e. the signature of an attestation
+const maxReferrerDepth = 3
+
+// referrers returns the manifests referring to the digest (SBOMs, attestations, provenance)
+// with the OCI referrers API, and whether the registry supports it. Without the API they are
+// listed in the index of the fallback tag sha256-<hex>
+func (r *registryClient) referrers(repository, digest string) ([]descriptor, bool, error) {
+	res, err := r.do("GET", r.url(repository, "/referrers/%s", digest), pullScope(repository), nil, "Accept", mediaTypeOCIIndex)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, true, err
+		}
+
+		var index manifest
+		if err := json.Unmarshal(body, &index); err != nil {
+			return nil, true, fmt.Errorf("Could not parse the referrers of %s:%s: %s", repository, digest, err)
+		}
+		return index.Manifests, true, nil
+	case http.StatusNotFound:
+	default:
+		return nil, false, fmt.Errorf("Getting the referrers of %s:%s from %s returned %d", repository, digest, r.host, res.StatusCode)
+	}
+
+	tag := cosignTag(digest, "")
+	exists, err := r.manifestExists(repository, tag)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+
+	body, _, _, err := r.manifest(repository, tag)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var index manifest
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, false, fmt.Errorf("Could not parse the referrers tag %s:%s: %s", repository, tag, err)
+	}
+	return index.Manifests, false, nil
+}
+
+// copyReferrers copies the referrers of the digest, and theirs, from the source to the
+// destination repository by digest. Registries without the referrers API get the referrers
+// added to their fallback tag. Returns the digests copied
+func copyReferrers(src *registryClient, srcRepository string, dst *registryClient, dstRepository, digest string, depth int) ([]string, error) {
+	refs, _, err := src.referrers(srcRepository, digest)
+	if err != nil || len(refs) == 0 {
+		return nil, err
+	}
+
+	var copied []string
+	for _, ref := range refs {
+		if _, _, err := copyImage(src, srcRepository, ref.Digest, dst, dstRepository, ref.Digest); err != nil {
+			return copied, fmt.Errorf("Could not copy the referrer %s: %s", ref.Digest, err)
+		}
+		copied = append(copied, ref.Digest)
+
+		if depth < maxReferrerDepth {
+			nested, err := copyReferrers(src, srcRepository, dst, dstRepository, ref.Digest, depth+1)
+			copied = append(copied, nested...)
+			if err != nil {
+				return copied, err
+			}
+		}
+	}
+
+	existing, supported, err := dst.referrers(dstRepository, digest)
+	if err != nil || supported {
+		return copied, err
+	}
+
+	// the fallback tag lists the referrers the destination already has, with the copied ones
+	known := make(map[string]bool)
+	for _, ref := range existing {
+		known[ref.Digest] = true
+	}
+	for _, ref := range refs {
+		if !known[ref.Digest] {
+			existing = append(existing, ref)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"schemaVersion": 2, "mediaType": mediaTypeOCIIndex, "manifests": existing})
+	if err != nil {
+		return copied, err
+	}
+
+	return copied, dst.putManifest(dstRepository, cosignTag(digest, ""), mediaTypeOCIIndex, body)
+}
+
+// copyReferrers copies the SBOMs, attestations and provenance referring to the mirrored
+// digest to the targets with `copy_referrers`. Like the signatures, they only apply to the
+// targets that got the upstream digest
+func (m *mirror) copyReferrers(tr *tagReport) error {
+	if tr.SourceDigest == "" {
+		m.log.Warn("Not copying the referrers, the upstream digest is unknown")
+		return nil
+	}
+
+	srcHost, srcRepository := splitReference(m.sourceRepository())
+	src := registryClientFor(srcHost, m.sourceAuth(), false)
+
+	return m.eachSourceDigestTarget(tr, "referrers", func(result *targetReport, dst *registryClient, repository string) error {
+		copied, err := copyReferrers(src, srcRepository, dst, repository, tr.SourceDigest, 1)
+		result.Referrers = append(result.Referrers, copied...)
+		return err
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCopyReferrers(t *testing.T) {
+	source := newFakeRegistry()
+	image := source.image("library/redis", "7", []byte("layer"))
+	digest := sha256Digest(image)
+
+	// an SBOM of the image, and a signature of the SBOM
+	sbom := source.image("library/redis", "sbom", []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	signature := source.image("library/redis", "signature", []byte("signature"))
+	for _, body := range [][]byte{sbom, signature} {
+		source.manifests["library/redis:"+sha256Digest(body)] = body
+	}
+	referrers := map[string][]descriptor{
+		digest:             {{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: sha256Digest(sbom), Size: int64(len(sbom)), ArtifactType: "application/spdx+json"}},
+		sha256Digest(sbom): {{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: sha256Digest(signature), Size: int64(len(signature)), ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json"}},
+	}
+
+	// the source supports the referrers API
+	srcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if i := strings.Index(r.URL.Path, "/referrers/"); i >= 0 {
+			json.NewEncoder(w).Encode(manifest{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: referrers[r.URL.Path[i+len("/referrers/"):]]})
+			return
+		}
+		source.ServeHTTP(w, r)
+	}))
+	defer srcServer.Close()
+
+	dest := newFakeRegistry()
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+	dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	copied, err := copyReferrers(src, "library/redis", dst, "hub/redis", digest, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(copied, []string{sha256Digest(sbom), sha256Digest(signature)}) {
+		t.Errorf("Expected the SBOM and its signature to be copied, got %v", copied)
+	}
+	if string(dest.manifests["hub/redis:"+sha256Digest(sbom)]) != string(sbom) {
+		t.Errorf("Expected the SBOM to be copied by digest")
+	}
+
+	// the destination has no referrers API, the referrers are listed in the fallback tag
+	refs, supported, err := dst.referrers("hub/redis", digest)
+	if err != nil || supported || len(refs) != 1 || refs[0].Digest != sha256Digest(sbom) || refs[0].ArtifactType != "application/spdx+json" {
+		t.Errorf("Unexpected fallback referrers %+v (supported: %t): %v", refs, supported, err)
+	}
+
+	// copying again doesn't list them twice
+	if _, err := copyReferrers(src, "library/redis", dst, "hub/redis", digest, 1); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if refs, _, _ := dst.referrers("hub/redis", digest); len(refs) != 1 {
+		t.Errorf("Expected a single fallback referrer, got %+v", refs)
+	}
+}
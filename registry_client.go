@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// size of the chunks blobs are uploaded in, ECR requires at least 5MiB per chunk but the last
+	blobChunkSize = 20 << 20
+
+	// Docker Hub serves the registry API from a different host than its web API
+	dockerHubRegistry = "registry-1.docker.io"
+)
+
+var (
+	// matches the parameters of a Www-Authenticate challenge, e.g. realm="...",service="..."
+	challengeParamRE = regexp.MustCompile(`([a-z]+)="([^"]*)"`)
+
+	// matches the end of the Range header of an upload status, e.g. 0-1048575
+	uploadRangeRE = regexp.MustCompile(`^(?:bytes=)?0-([0-9]+)$`)
+)
+
+// registryClient talks to the registry v2 API of a single registry, it is used to copy
+// images between registries without a docker daemon
+type registryClient struct {
+	host     string // registry host, e.g. quay.io
+	scheme   string // http for insecure registries, https otherwise
+	username string
+	password string
+	client   *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // bearer tokens, by scope
+}
+
+// manifest is the subset of an image manifest or index needed to copy it
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        *descriptor  `json:"config"`
+	Layers        []descriptor `json:"layers"`
+	Manifests     []descriptor `json:"manifests"`
+	ArtifactType  string       `json:"artifactType,omitempty"` // of an OCI artifact
+}
+
+// descriptor references a blob or a manifest
+type descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	URLs         []string          `json:"urls,omitempty"`
+	Platform     *platform         `json:"platform,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"` // of a referrer
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// platform of a manifest in an index
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func newRegistryClient(host string, auth docker.AuthConfiguration, insecure bool) *registryClient {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+
+	return &registryClient{
+		host:     host,
+		scheme:   scheme,
+		username: auth.Username,
+		password: auth.Password,
+		client:   &http.Client{Transport: PTransport},
+		tokens:   make(map[string]string),
+	}
+}
+
+// splitReference splits a repository as known by docker (e.g. quay.io/coreos/etcd or redis)
+// into its registry host and its path in the registry
+func splitReference(repository string) (string, string) {
+	chunk := strings.SplitN(repository, "/", 2)
+	if len(chunk) == 2 && (strings.ContainsAny(chunk[0], ".:") || chunk[0] == "localhost") {
+		return chunk[0], chunk[1]
+	}
+
+	if !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+
+	return dockerHubRegistry, repository
+}
+
+func (r *registryClient) url(repository, format string, args ...interface{}) string {
+	return fmt.Sprintf("%s://%s/v2/%s", r.scheme, r.host, repository) + fmt.Sprintf(format, args...)
+}
+
+// do sends the request, authenticating with the registry when challenged, and retrying
+// rate limits, server errors and network errors. body is sent again on every attempt
+func (r *registryClient) do(method, rawURL, scope string, body []byte, headers ...string) (*http.Response, error) {
+	authenticated := false
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Add(headers[i], headers[i+1])
+		}
+
+		r.mu.Lock()
+		token := r.tokens[scope]
+		r.mu.Unlock()
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+
+		res, err := r.client.Do(req)
+		if err == nil && r.host == dockerHubRegistry && res.Header.Get("Ratelimit-Limit") != "" {
+			hubPulls.observe(res.Header, time.Now())
+		}
+		if err == nil && res.StatusCode == http.StatusUnauthorized && !authenticated {
+			challenge := res.Header.Get("Www-Authenticate")
+			res.Body.Close()
+
+			authenticated = true
+			if err := r.authenticate(challenge, scope); err != nil {
+				return nil, err
+			}
+			attempt--
+			continue
+		}
+
+		if err == nil && !retryable(res.StatusCode) {
+			return res, nil
+		}
+
+		if attempt+1 >= httpRetries {
+			return res, err
+		}
+
+		if err == nil {
+			res.Body.Close()
+			err = fmt.Errorf("%s %s returned %d", method, rawURL, res.StatusCode)
+		} else {
+			res = nil
+		}
+
+		delay := retryDelay(res, attempt, time.Now())
+		log.Warnf("%s, retrying in %s", err, delay)
+		runState.retrySleep(r.host, err.Error(), delay)
+	}
+}
+
+// authenticate answers a Www-Authenticate challenge, fetching a bearer token for the scope
+// from the token service. Basic challenges are answered with the configured credentials
+func (r *registryClient) authenticate(challenge, scope string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		if r.username == "" {
+			return fmt.Errorf("Registry %s requires credentials", r.host)
+		}
+		return nil
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParamRE.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	if params["realm"] == "" {
+		return fmt.Errorf("Registry %s sent a bearer challenge without realm", r.host)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Token request to %s returned %d", params["realm"], res.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	r.mu.Lock()
+	r.tokens[scope] = token.Token
+	r.mu.Unlock()
+
+	return nil
+}
+
+func pullScope(repository string) string {
+	return fmt.Sprintf("repository:%s:pull", repository)
+}
+
+func pushScope(repository string) string {
+	return fmt.Sprintf("repository:%s:pull,push", repository)
+}
+
+// manifest returns the manifest of the reference (a tag or digest), with its media type and digest
+func (r *registryClient) manifest(repository, reference string) ([]byte, string, string, error) {
+	var headers []string
+	for _, mediaType := range manifestMediaTypes {
+		headers = append(headers, "Accept", mediaType)
+	}
+
+	res, err := r.do("GET", r.url(repository, "/manifests/%s", reference), pullScope(repository), nil, headers...)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("Getting manifest %s:%s from %s returned %d", repository, reference, r.host, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	mediaType := res.Header.Get("Content-Type")
+	var m manifest
+	if err := json.Unmarshal(body, &m); err == nil && m.MediaType != "" {
+		mediaType = m.MediaType
+	}
+
+	return body, mediaType, digest, nil
+}
+
+// manifestExists returns true if the registry has a manifest for the reference
+func (r *registryClient) manifestExists(repository, reference string) (bool, error) {
+	var headers []string
+	for _, mediaType := range manifestMediaTypes {
+		headers = append(headers, "Accept", mediaType)
+	}
+
+	res, err := r.do("HEAD", r.url(repository, "/manifests/%s", reference), pullScope(repository), nil, headers...)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Checking manifest %s:%s in %s returned %d", repository, reference, r.host, res.StatusCode)
+	}
+}
+
+// tags lists the tags of the repository with the v2 tags list, following the pagination
+func (r *registryClient) tags(repository string) ([]string, error) {
+	var tags []string
+
+	next := r.url(repository, "/tags/list")
+	for next != "" {
+		res, err := r.do("GET", next, pullScope(repository), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("Listing tags of %s in %s returned %d", repository, r.host, res.StatusCode)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next = ""
+		if matches := nextLinkRE.FindStringSubmatch(res.Header.Get("Link")); matches != nil {
+			link, err := res.Request.URL.Parse(matches[1])
+			if err != nil {
+				return nil, err
+			}
+			next = link.String()
+		}
+	}
+
+	return tags, nil
+}
+
+// putManifest uploads the manifest under the reference (a tag or digest)
+func (r *registryClient) putManifest(repository, reference, mediaType string, body []byte) error {
+	res, err := r.do("PUT", r.url(repository, "/manifests/%s", reference), pushScope(repository), body, "Content-Type", mediaType)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("Putting manifest %s:%s to %s returned %d: %s", repository, reference, r.host, res.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// blobExists returns true if the registry already has the blob in the repository
+func (r *registryClient) blobExists(repository, digest string) (bool, error) {
+	res, err := r.do("HEAD", r.url(repository, "/blobs/%s", digest), pushScope(repository), nil)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Checking blob %s in %s returned %d", digest, r.host, res.StatusCode)
+	}
+}
+
+// openBlob streams the blob from the offset, registries without range support stream the
+// whole blob and the bytes before the offset are skipped
+func (r *registryClient) openBlob(repository, digest string, offset int64) (io.ReadCloser, error) {
+	var headers []string
+	if offset > 0 {
+		headers = []string{"Range", fmt.Sprintf("bytes=%d-", offset)}
+	}
+
+	res, err := r.do("GET", r.url(repository, "/blobs/%s", digest), pullScope(repository), nil, headers...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+		return res.Body, nil
+	case res.StatusCode == http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		return res.Body, nil
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("Getting blob %s from %s returned %d", digest, r.host, res.StatusCode)
+	}
+}
+
+// blobUpload is a chunked upload session of a single blob
+type blobUpload struct {
+	registry   *registryClient
+	repository string
+	location   string // URL of the upload session, updated after every chunk
+}
+
+// startUpload opens an upload session for a blob in the repository
+func (r *registryClient) startUpload(repository string) (*blobUpload, error) {
+	res, err := r.do("POST", r.url(repository, "/blobs/uploads/"), pushScope(repository), nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("Starting blob upload to %s returned %d", r.host, res.StatusCode)
+	}
+
+	u := &blobUpload{registry: r, repository: repository}
+	if err := u.follow(res); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// follow moves the upload session to the Location of the response, it may be relative
+func (u *blobUpload) follow(res *http.Response) error {
+	location, err := res.Request.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	u.location = location.String()
+	return nil
+}
+
+// uploadChunk sends the chunk starting at offset
+func (u *blobUpload) uploadChunk(chunk []byte, offset int64) error {
+	res, err := u.registry.do("PATCH", u.location, pushScope(u.repository), chunk,
+		"Content-Type", "application/octet-stream",
+		"Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1),
+	)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Uploading chunk at offset %d to %s returned %d", offset, u.registry.host, res.StatusCode)
+	}
+
+	return u.follow(res)
+}
+
+// offset asks the registry how many bytes of the upload it received, to resume a failed upload
+func (u *blobUpload) offset() (int64, error) {
+	res, err := u.registry.do("GET", u.location, pushScope(u.repository), nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusAccepted {
+		return 0, fmt.Errorf("Getting upload status from %s returned %d", u.registry.host, res.StatusCode)
+	}
+
+	if err := u.follow(res); err != nil {
+		return 0, err
+	}
+
+	matches := uploadRangeRE.FindStringSubmatch(res.Header.Get("Range"))
+	if matches == nil {
+		return 0, nil
+	}
+
+	end, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return end + 1, nil
+}
+
+// complete closes the upload session, the registry verifies the blob against its digest
+func (u *blobUpload) complete(digest string) error {
+	location, err := url.Parse(u.location)
+	if err != nil {
+		return err
+	}
+
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	res, err := u.registry.do("PUT", location.String(), pushScope(u.repository), nil, "Content-Type", "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Completing upload of %s to %s returned %d", digest, u.registry.host, res.StatusCode)
+	}
+
+	return nil
+}
+
+// copyBlob copies the blob from the source to the destination repository in chunks. When a
+// chunk fails (e.g. a dropped connection) the upload resumes from the last byte the destination
+// received, instead of restarting the whole blob. Returns the number of bytes uploaded
+func copyBlob(src blobSource, srcRepository string, dst *registryClient, dstRepository string, blob descriptor) (int64, error) {
+	exists, err := dst.blobExists(dstRepository, blob.Digest)
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		return 0, nil
+	}
+
+	upload, err := dst.startUpload(dstRepository)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		offset   int64
+		hasher   hash.Hash = sha256.New()
+		body     io.ReadCloser
+		failures int
+	)
+	defer func() {
+		if body != nil {
+			body.Close()
+		}
+	}()
+
+	chunk := make([]byte, blobChunkSize)
+	for offset < blob.Size {
+		if body == nil {
+			if body, err = src.openBlob(srcRepository, blob.Digest, offset); err != nil {
+				return offset, err
+			}
+		}
+
+		n, err := io.ReadFull(body, chunk[:minInt64(blobChunkSize, blob.Size-offset)])
+		if err == nil {
+			err = upload.uploadChunk(chunk[:n], offset)
+		}
+
+		if err == nil {
+			hasher.Write(chunk[:n])
+			offset += int64(n)
+			failures = 0
+			runState.progressed()
+			continue
+		}
+
+		failures++
+		if failures >= httpRetries {
+			return offset, err
+		}
+
+		// resume from what the destination received, the source is re-opened at that offset
+		body.Close()
+		body = nil
+
+		received, statusErr := upload.offset()
+		if statusErr != nil {
+			return offset, fmt.Errorf("%s, and the upload can't be resumed: %s", err, statusErr)
+		}
+
+		if received > offset && received <= offset+int64(n) {
+			hasher.Write(chunk[:received-offset])
+			offset = received
+		}
+
+		delay := retryDelay(nil, failures-1, time.Now())
+		log.Warnf("Upload of %s to %s failed at %d/%d bytes: %s, resuming in %s", blob.Digest, dst.host, offset, blob.Size, err, delay)
+		runState.retrySleep(dst.host, "upload of "+blob.Digest+" failed: "+err.Error(), delay)
+	}
+
+	if digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); digest != blob.Digest {
+		return offset, fmt.Errorf("Blob %s has digest %s", blob.Digest, digest)
+	}
+
+	return offset, upload.complete(blob.Digest)
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// copyImage copies the manifest of the source reference with all its blobs to the destination
+// tag, for an index all its platform manifests are copied. Returns the digest of the manifest,
+// identical in the source and destination, and the number of bytes uploaded
+func copyImage(src *registryClient, srcRepository, reference string, dst *registryClient, dstRepository, tag string) (string, int64, error) {
+	body, mediaType, digest, err := src.manifest(srcRepository, reference)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return "", 0, fmt.Errorf("Could not parse manifest %s:%s: %s", srcRepository, reference, err)
+	}
+
+	var transferred int64
+	if len(m.Manifests) > 0 {
+		for _, child := range m.Manifests {
+			_, n, err := copyImage(src, srcRepository, child.Digest, dst, dstRepository, child.Digest)
+			transferred += n
+			if err != nil {
+				return "", transferred, err
+			}
+		}
+	} else {
+		blobs := m.Layers
+		if m.Config != nil {
+			blobs = append([]descriptor{*m.Config}, blobs...)
+		}
+
+		for _, blob := range blobs {
+			// foreign layers (e.g. Windows base layers) are not distributed by the registry, see
+			// copyForeignLayers
+			if len(blob.URLs) > 0 {
+				continue
+			}
+
+			n, err := copyBlob(src, srcRepository, dst, dstRepository, blob)
+			transferred += n
+			if err != nil {
+				return "", transferred, err
+			}
+		}
+	}
+
+	return digest, transferred, dst.putManifest(dstRepository, tag, mediaType, body)
+}
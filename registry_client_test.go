@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fakeRegistry is an in-memory registry v2 API, supporting chunked uploads
+type fakeRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte        // digest -> content
+	manifests map[string][]byte        // repository:reference -> manifest
+	uploads   map[string]*bytes.Buffer // upload id -> received bytes
+	failPatch map[int]bool             // PATCH requests (1-based) failing after storing half of the chunk
+	patches   int
+	requests  map[string]int // method -> count
+	ranges    []string       // Range headers of the blob downloads
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+		uploads:   make(map[string]*bytes.Buffer),
+		failPatch: make(map[int]bool),
+		requests:  make(map[string]int),
+	}
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[r.Method]++
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		id := path[strings.LastIndex(path, "/")+1:]
+		repository := path[:strings.Index(path, "/blobs/uploads/")]
+		switch r.Method {
+		case "POST":
+			id = strconv.Itoa(len(f.uploads) + 1)
+			f.uploads[id] = &bytes.Buffer{}
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+			w.WriteHeader(http.StatusAccepted)
+		case "PATCH":
+			f.patches++
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", f.uploads[id].Len(), f.uploads[id].Len()+len(body)-1) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if f.failPatch[f.patches] {
+				// the connection dropped after the registry received half of the chunk
+				f.uploads[id].Write(body[:len(body)/2])
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.uploads[id].Write(body)
+			w.Header().Set("Location", r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case "GET":
+			w.Header().Set("Location", r.URL.Path)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", f.uploads[id].Len()-1))
+			w.WriteHeader(http.StatusNoContent)
+		case "PUT":
+			content := f.uploads[id].Bytes()
+			if sha256Digest(content) != r.URL.Query().Get("digest") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.blobs[r.URL.Query().Get("digest")] = content
+			w.WriteHeader(http.StatusCreated)
+		}
+	case strings.Contains(path, "/blobs/"):
+		digest := path[strings.LastIndex(path, "/")+1:]
+		content, ok := f.blobs[digest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+		f.ranges = append(f.ranges, r.Header.Get("Range"))
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[offset:])
+			return
+		}
+		w.Write(content)
+	case strings.Contains(path, "/manifests/"):
+		i := strings.Index(path, "/manifests/")
+		key := path[:i] + ":" + path[i+len("/manifests/"):]
+		switch r.Method {
+		case "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			f.manifests[key] = body
+			f.manifests[path[:i]+":"+sha256Digest(body)] = body
+			w.WriteHeader(http.StatusCreated)
+		default:
+			body, ok := f.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Write(body)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// image stores an image with a config and the given layers, and returns its manifest
+func (f *fakeRegistry) image(repository, tag string, layers ...[]byte) []byte {
+	m := manifest{MediaType: "application/vnd.docker.distribution.manifest.v2+json"}
+
+	config := []byte(`{"architecture":"amd64"}`)
+	f.blobs[sha256Digest(config)] = config
+	m.Config = &descriptor{Digest: sha256Digest(config), Size: int64(len(config))}
+
+	for _, layer := range layers {
+		f.blobs[sha256Digest(layer)] = layer
+		m.Layers = append(m.Layers, descriptor{Digest: sha256Digest(layer), Size: int64(len(layer))})
+	}
+
+	body, _ := json.Marshal(m)
+	f.manifests[repository+":"+tag] = body
+	return body
+}
+
+func TestSplitReference(t *testing.T) {
+	tests := map[string][2]string{
+		"redis":                          {dockerHubRegistry, "library/redis"},
+		"jippi/hashi-ui":                 {dockerHubRegistry, "jippi/hashi-ui"},
+		"quay.io/coreos/etcd":            {"quay.io", "coreos/etcd"},
+		"localhost:5000/redis":           {"localhost:5000", "redis"},
+		"private-registry-name/redis":    {dockerHubRegistry, "private-registry-name/redis"},
+		"artifactory.example.com/a/b/cd": {"artifactory.example.com", "a/b/cd"},
+	}
+
+	for repository, want := range tests {
+		if host, path := splitReference(repository); host != want[0] || path != want[1] {
+			t.Errorf("Expected %s to split into %v, got %s %s", repository, want, host, path)
+		}
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	sleep = func(time.Duration) {}
+
+	source := newFakeRegistry()
+	layer := bytes.Repeat([]byte("layer"), blobChunkSize/2) // 2.5 chunks
+	body := source.image("library/redis", "7", layer)
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+
+	dest := newFakeRegistry()
+	dest.failPatch[3] = true // the config is the first PATCH, the second chunk of the layer fails half way
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+
+	src := newRegistryClient(strings.TrimPrefix(srcServer.URL, "http://"), docker.AuthConfiguration{}, true)
+	dst := newRegistryClient(strings.TrimPrefix(dstServer.URL, "http://"), docker.AuthConfiguration{}, true)
+
+	digest, transferred, err := copyImage(src, "library/redis", "7", dst, "hub/redis", "7")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if digest != sha256Digest(body) {
+		t.Errorf("Expected the source manifest digest, got %s", digest)
+	}
+
+	if !bytes.Equal(dest.manifests["hub/redis:7"], body) {
+		t.Errorf("Expected the manifest to be copied as is")
+	}
+
+	if !bytes.Equal(dest.blobs[sha256Digest(layer)], layer) {
+		t.Errorf("Expected the layer to be copied")
+	}
+
+	// the upload resumed half way the second chunk, instead of restarting the layer
+	resumed := fmt.Sprintf("bytes=%d-", blobChunkSize+blobChunkSize/2)
+	if len(source.ranges) != 3 || source.ranges[2] != resumed {
+		t.Errorf("Expected the layer download to resume at %s, got %v", resumed, source.ranges)
+	}
+
+	if int(transferred) != len(layer)+len(`{"architecture":"amd64"}`) {
+		t.Errorf("Expected %d bytes to be transferred, got %d", len(layer), transferred)
+	}
+
+	// blobs the target already has are not uploaded again
+	posts := dest.requests["POST"]
+	if _, transferred, err = copyImage(src, "library/redis", "7", dst, "hub/redis", "7-20260115"); err != nil || transferred != 0 {
+		t.Errorf("Expected the second copy to only put the manifest, got %d bytes (%v)", transferred, err)
+	}
+	if dest.requests["POST"] != posts {
+		t.Errorf("Expected no new blob upload")
+	}
+}
+
+func TestRegistryClientBearerAuth(t *testing.T) {
+	var tokenServer *httptest.Server
+	tokenServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "mirror" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:library/redis:pull" || r.URL.Query().Get("service") != "registry.example.com" {
+			t.Errorf("Unexpected token request %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"token":"t0ken"}`))
+	}))
+	defer tokenServer.Close()
+
+	registry := newFakeRegistry()
+	registry.image("library/redis", "7")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com",scope="repository:library/redis:pull"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		registry.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c := newRegistryClient(strings.TrimPrefix(server.URL, "http://"), docker.AuthConfiguration{Username: "mirror", Password: "secret"}, true)
+	if _, _, _, err := c.manifest("library/redis", "7"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestRegistryClientTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/redis/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/library/redis/tags/list?last=6&n=2>; rel="next"`)
+			w.Write([]byte(`{"name":"library/redis","tags":["5","6"]}`))
+			return
+		}
+		w.Write([]byte(`{"name":"library/redis","tags":["7"]}`))
+	}))
+	defer server.Close()
+
+	c := newRegistryClient(strings.TrimPrefix(server.URL, "http://"), docker.AuthConfiguration{}, true)
+	tags, err := c.tags("library/redis")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if strings.Join(tags, ",") != "5,6,7" {
+		t.Errorf("Expected the tags of every page, got %v", tags)
+	}
+
+	if _, err := c.tags("library/unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown repository")
+	}
+}
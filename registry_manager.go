@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	log "github.com/sirupsen/logrus"
+)
+
+// manifest media types accepted when resolving a tag to its digest
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// matches the next page in the `Link` header of a paginated registry response
+var nextLinkRE = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// registryManager is used for plain docker registries (e.g. registry:2 or Harbor), those
+// registries create repositories on push, so there is nothing to manage besides
+// checking that the registry is reachable with the configured credentials
+type registryManager struct {
+	registry string       // registry host, optionally with a path
+	scheme   string       // http for insecure registries, https otherwise
+	username string       // basic auth username
+	password string       // basic auth password
+	client   *http.Client // http client, optionally skipping TLS verification
+}
+
+func newRegistryManager(tc TargetConfig) *registryManager {
+	transport := PTransport.Clone()
+	if tc.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	scheme := "https"
+	if tc.Insecure {
+		scheme = "http"
+	}
+
+	return &registryManager{
+		registry: tc.Registry,
+		scheme:   scheme,
+		username: tc.Username,
+		password: tc.Password,
+		client:   &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+func (r *registryManager) exists(name string) bool {
+	return true
+}
+
+func (r *registryManager) ensure(name string) error {
+	return nil
+}
+
+func (r *registryManager) create(name string) error {
+	return nil
+}
+
+func (r *registryManager) putCatalogData(name string, catalog *CatalogData) error {
+	return nil
+}
+
+// url returns the registry v2 API URL of the repository, the registry path is part of the repository name
+func (r *registryManager) url(name, format string, args ...interface{}) string {
+	chunk := strings.SplitN(r.registry, "/", 2)
+	repository := name
+	if len(chunk) == 2 {
+		repository = chunk[1] + "/" + name
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s", r.scheme, chunk[0], repository) + fmt.Sprintf(format, args...)
+}
+
+func (r *registryManager) do(method, url string, headers ...string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+
+	return r.client.Do(req)
+}
+
+// listTags returns all the tags of the repository with their manifest digest, the registry
+// API has no bulk digest listing so every tag is resolved with a HEAD request
+func (r *registryManager) listTags(name string) (map[string]string, error) {
+	names, err := r.tagNames(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range names {
+		digest, err := r.digest(name, tag)
+		if err != nil {
+			return nil, err
+		}
+		tags[tag] = digest
+	}
+
+	return tags, nil
+}
+
+// tagNames returns the names of all the tags of the repository, following the registry pagination
+func (r *registryManager) tagNames(name string) ([]string, error) {
+	var tags []string
+
+	next := r.url(name, "/tags/list")
+	for next != "" {
+		res, err := r.do("GET", next)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return tags, nil
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("Listing tags of %s returned %d", name, res.StatusCode)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next = ""
+		if matches := nextLinkRE.FindStringSubmatch(res.Header.Get("Link")); matches != nil {
+			next = fmt.Sprintf("%s://%s%s", r.scheme, res.Request.URL.Host, matches[1])
+		}
+	}
+
+	return tags, nil
+}
+
+// digest resolves the tag to its manifest digest
+func (r *registryManager) digest(name, tag string) (string, error) {
+	var headers []string
+	for _, mediaType := range manifestMediaTypes {
+		headers = append(headers, "Accept", mediaType)
+	}
+
+	res, err := r.do("HEAD", r.url(name, "/manifests/%s", tag), headers...)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Resolving %s:%s returned %d", name, tag, res.StatusCode)
+	}
+
+	return res.Header.Get("Docker-Content-Digest"), nil
+}
+
+// deleteTags removes the tags from the repository. The registry API deletes manifests by
+// digest, which removes all the tags of the manifest, so a manifest is only deleted once
+// none of its other tags are kept
+func (r *registryManager) deleteTags(name string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	remove := make(map[string]bool)
+	for _, tag := range tags {
+		remove[tag] = true
+	}
+
+	all, err := r.listTags(name)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	var digests []string
+	seen := make(map[string]bool)
+	for _, tag := range sortedTags(all) {
+		digest := all[tag]
+		if !remove[tag] {
+			kept[digest] = true
+			continue
+		}
+
+		if !seen[digest] {
+			seen[digest] = true
+			digests = append(digests, digest)
+		}
+	}
+
+	for _, digest := range digests {
+		if kept[digest] {
+			log.Debugf("Keeping manifest %s of %s, it is still tagged", digest, name)
+			continue
+		}
+
+		res, err := r.do("DELETE", r.url(name, "/manifests/%s", digest))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("Deleting %s@%s returned %d", name, digest, res.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// buildCache pings the registry v2 API, to fail early on bad credentials or TLS issues
+func (r *registryManager) buildCache(nextToken *string) error {
+	host := strings.SplitN(r.registry, "/", 2)[0]
+	log.Infof("Checking access to registry %s", host)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/v2/", r.scheme, host), nil)
+	if err != nil {
+		return err
+	}
+
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// registries using token auth (e.g. Harbor) answer 401 with a token challenge, and without
+	// configured credentials the Docker agent login is used, only configured basic auth
+	// credentials can be validated here
+	if r.username != "" && res.StatusCode == http.StatusUnauthorized && strings.HasPrefix(strings.ToLower(res.Header.Get("Www-Authenticate")), "basic") {
+		return backoff.Permanent(fmt.Errorf("Invalid credentials for registry %s", host))
+	}
+
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("Registry %s returned %d", host, res.StatusCode)
+	}
+
+	return nil
+}
+
+func (r *registryManager) buildCacheBackoff() backoff.Operation {
+	return func() error {
+		return r.buildCache(nil)
+	}
+}
+
+// sortedTags returns the tags of a listTags result in a stable order
+func sortedTags(tags map[string]string) []string {
+	res := make([]string, 0, len(tags))
+	for tag := range tags {
+		res = append(res, tag)
+	}
+	sort.Strings(res)
+
+	return res
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryManagerBuildCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		status   int
+		header   string
+		wantErr  bool
+	}{
+		{name: "reachable", status: http.StatusOK},
+		{name: "token auth challenge", status: http.StatusUnauthorized, header: `Bearer realm="https://auth.example.com/token"`},
+		{name: "basic auth without configured credentials", status: http.StatusUnauthorized, header: `Basic realm="Registry Realm"`},
+		{name: "basic auth with invalid credentials", username: "mirror", status: http.StatusUnauthorized, header: `Basic realm="Registry Realm"`, wantErr: true},
+		{name: "server error", status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/" {
+					t.Errorf("Unexpected request to %s", r.URL.Path)
+				}
+
+				if user, _, ok := r.BasicAuth(); ok != (tt.username != "") || user != tt.username {
+					t.Errorf("Unexpected basic auth user %q", user)
+				}
+
+				if tt.header != "" {
+					w.Header().Set("Www-Authenticate", tt.header)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			r := newRegistryManager(TargetConfig{
+				Registry: strings.TrimPrefix(server.URL, "http://") + "/mirror",
+				Username: tt.username,
+				Password: "secret",
+				Insecure: true,
+			})
+
+			err := r.buildCache(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRegistryManagerDeleteTags(t *testing.T) {
+	digests := map[string]string{
+		"7.0-20260101": "sha256:old",
+		"7.0-20260102": "sha256:shared",
+		"7.0-20260103": "sha256:shared",
+	}
+
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/mirror/redis/tags/list":
+			w.Write([]byte(`{"name":"mirror/redis","tags":["7.0-20260101","7.0-20260102","7.0-20260103"]}`))
+		case r.Method == "HEAD" && strings.HasPrefix(r.URL.Path, "/v2/mirror/redis/manifests/"):
+			w.Header().Set("Docker-Content-Digest", digests[strings.TrimPrefix(r.URL.Path, "/v2/mirror/redis/manifests/")])
+		case r.Method == "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v2/mirror/redis/manifests/"))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := newRegistryManager(TargetConfig{Registry: strings.TrimPrefix(server.URL, "http://") + "/mirror", Insecure: true})
+
+	// the shared manifest is still tagged by the kept snapshot, deleting it would remove both
+	if err := r.deleteTags("redis", []string{"7.0-20260101", "7.0-20260102"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "sha256:old" {
+		t.Errorf("Expected only sha256:old to be deleted, got %v", deleted)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// http client fetching https:// configs, replaced in tests
+	configHTTPClient = httpClient
+
+	// s3 client fetching s3:// configs, created on first use
+	configS3Client s3Client
+
+	remoteConfigsMu sync.Mutex
+
+	// the last fetched content of the remote configs, by URL
+	remoteConfigs = make(map[string]*remoteConfig)
+)
+
+// remoteConfig is a config file fetched from S3 or HTTPS, with the ETag it was served with
+type remoteConfig struct {
+	etag    string
+	content []byte
+}
+
+// isRemoteConfig returns true for the config files fetched from S3 or HTTPS
+func isRemoteConfig(file string) bool {
+	return strings.HasPrefix(file, "s3://") || strings.HasPrefix(file, "https://")
+}
+
+// readConfigFile reads a local config file, or fetches a remote one
+func readConfigFile(file string) ([]byte, error) {
+	if !isRemoteConfig(file) {
+		return ioutil.ReadFile(file)
+	}
+
+	content, _, err := fetchConfig(file)
+	return content, err
+}
+
+// fetchConfig fetches the remote config, sending the ETag of the last fetch so an unchanged
+// config isn't downloaded again. modified is false when the config didn't change since
+func fetchConfig(file string) (content []byte, modified bool, err error) {
+	remoteConfigsMu.Lock()
+	defer remoteConfigsMu.Unlock()
+
+	cached := remoteConfigs[file]
+	etag := ""
+	if cached != nil {
+		etag = cached.etag
+	}
+
+	var fetched *remoteConfig
+	if strings.HasPrefix(file, "s3://") {
+		fetched, err = fetchS3Config(file, etag)
+	} else {
+		fetched, err = fetchHTTPSConfig(file, etag)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if fetched == nil {
+		return cached.content, false, nil
+	}
+
+	remoteConfigs[file] = fetched
+	return fetched.content, true, nil
+}
+
+// fetchHTTPSConfig downloads the config, nil when it still has the etag
+func fetchHTTPSConfig(file, etag string) (*remoteConfig, error) {
+	req, err := http.NewRequest("GET", file, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := configHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET returned %d", res.StatusCode)
+	}
+
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteConfig{etag: res.Header.Get("ETag"), content: content}, nil
+}
+
+// fetchS3Config downloads the config from s3://bucket/key, nil when it still has the etag
+func fetchS3Config(file, etag string) (*remoteConfig, error) {
+	u, err := url.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if configS3Client == nil {
+		cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		configS3Client = s3.NewFromConfig(cfg)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+
+	resp, err := configS3Client.GetObject(context.TODO(), input)
+
+	var responseErr *awshttp.ResponseError
+	if etag != "" && errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotModified {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteConfig{etag: aws.ToString(resp.ETag), content: content}, nil
+}
+
+// reloadConfig reloads a remote config when it changed since the last fetch, used between
+// the runs of the daemon mode. An invalid config is logged and the current one is kept.
+// Returns true when the config was reloaded
+func reloadConfig(file string) bool {
+	if !isRemoteConfig(file) {
+		return false
+	}
+
+	if _, modified, err := fetchConfig(file); err != nil || !modified {
+		if err != nil {
+			log.Warnf("Could not fetch config file %s, keeping the current config: %s", file, err)
+		}
+		return false
+	}
+
+	current := config
+	err := loadConfig(file)
+	if err == nil {
+		err = validateConfig(config)
+	}
+
+	if err != nil {
+		log.Errorf("Keeping the current config, the changed config is invalid: %s", err)
+		config = current
+		return false
+	}
+
+	if config.Workers == 0 {
+		config.Workers = runtime.NumCPU()
+	}
+
+	log.Infof("Reloaded the changed config %s: %d repositories", file, len(config.allRepositories()))
+	return true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteConfigHTTPS(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	content := "target:\n  registry: registry.example.com\nrepositories:\n  - name: redis\n"
+	etag := `"v1"`
+	downloads := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	defer func(c *http.Client) { configHTTPClient = c }(configHTTPClient)
+	configHTTPClient = server.Client()
+
+	file := server.URL + "/docker-mirror/config.yaml"
+	if err := loadConfig(file); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(config.Repositories) != 1 || config.Target.Registry != "registry.example.com" {
+		t.Errorf("Expected the remote config to be loaded, got %+v", config)
+	}
+
+	if reloadConfig(file) || downloads != 1 {
+		t.Errorf("Expected an unchanged config not to be downloaded again, got %d downloads", downloads)
+	}
+
+	// an invalid config is not applied
+	content, etag = "repositories:\n  - name: redis\n", `"v2"`
+	if reloadConfig(file) || config.Target.Registry != "registry.example.com" {
+		t.Errorf("Expected the invalid config to be ignored, got %+v", config)
+	}
+
+	content, etag = "target:\n  registry: registry.example.com\nrepositories:\n  - name: redis\n  - name: postgres\n", `"v3"`
+	if !reloadConfig(file) || len(config.Repositories) != 2 {
+		t.Errorf("Expected the changed config to be reloaded, got %+v", config.Repositories)
+	}
+}
+
+func TestRemoteConfigS3(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(c s3Client) { configS3Client = c }(configS3Client)
+
+	configS3Client = &fakeS3{objects: map[string][]byte{
+		"mirror-config/prod/config.yaml": []byte("target:\n  registry: registry.example.com\nrepositories:\n  - name: redis\n"),
+	}}
+
+	if err := loadConfig("s3://mirror-config/prod/config.yaml"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(config.Repositories) != 1 {
+		t.Errorf("Expected the config to be read from S3, got %+v", config)
+	}
+
+	if err := loadConfig("s3://mirror-config/missing.yaml"); err == nil {
+		t.Errorf("Expected an error for a missing config")
+	}
+}
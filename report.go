@@ -0,0 +1,447 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	resultMirrored = "mirrored"
+	resultSkipped  = "skipped"
+	resultFailed   = "failed"
+
+	// the tag has media types the targets reject, with `validate_media_types`
+	resultUnsupported = "unsupported"
+
+	// the scan found vulnerabilities above the severity of the `scan` config
+	resultBlocked = "blocked"
+
+	// the circuit breaker of the host of the repository is open
+	resultDeferred = "deferred"
+)
+
+// filters dropping a tag before it is mirrored, in the filtered tags of the report
+const (
+	filterGlob    = "glob"
+	filterRegex   = "regex"
+	filterSemver  = "semver"
+	filterAge     = "age"
+	filterKeep    = "keep"
+	filterMaxTags = "max_tags"
+	filterTagMap  = "tag_map"
+)
+
+// max number of occurrences listed per error group
+const maxErrorOccurrences = 10
+
+// report collects the result of the current run
+var report = newRunReport()
+
+var (
+	digestRE = regexp.MustCompile(`sha256:[a-f0-9]{64}`)
+	hexIDRE  = regexp.MustCompile(`\b[a-f0-9]{12,}\b`)
+)
+
+// runReport is the machine-readable end-of-run report
+type runReport struct {
+	mu            sync.Mutex
+	StartedAt     time.Time           `json:"started_at"`
+	FinishedAt    time.Time           `json:"finished_at"`
+	Stopped       string              `json:"stopped,omitempty"` // why the kill switch stopped the run
+	SLAViolations int                 `json:"sla_violations"`
+	TooFewTags    int                 `json:"too_few_tags,omitempty"` // repositories below their min_tags
+	Unsupported   int                 `json:"unsupported,omitempty"`  // tags with media types the targets reject
+	Failovers     int                 `json:"failovers,omitempty"`    // tags landing on a standby target
+	Blocked       int                 `json:"blocked,omitempty"`      // tags with vulnerabilities blocking the push
+	Deletions     int                 `json:"deletions,omitempty"`    // tags deleted upstream since the previous run
+	Deferred      int                 `json:"deferred,omitempty"`     // repositories of hosts with an open circuit breaker
+	Retries       int                 `json:"retries,omitempty"`      // pulls, tags and pushes retried with `retries`
+	Errors        []*errorGroup       `json:"errors"`
+	Repositories  []*repositoryReport `json:"repositories"`
+}
+
+// errorGroup is a set of identical errors across repositories and tags, e.g. all
+// the tags failing to pull during a Docker Hub outage
+type errorGroup struct {
+	Fingerprint string   `json:"fingerprint"`
+	Message     string   `json:"message"`     // error message, with the repository, tag and digests masked
+	Sample      string   `json:"sample"`      // first occurrence of the error, as is
+	Count       int      `json:"count"`       // number of occurrences
+	Occurrences []string `json:"occurrences"` // first repositories / tags the error occurred for
+}
+
+// repositoryReport is the result of mirroring a single repository
+type repositoryReport struct {
+	mu         sync.Mutex
+	run        *runReport
+	Name       string         `json:"name"`
+	Host       string         `json:"host"`
+	Result     string         `json:"result"`
+	Reason     string         `json:"reason,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Deprecated string         `json:"deprecated,omitempty"` // why the upstream repository is deprecated
+	Tags       []*tagReport   `json:"tags"`
+	Filtered   []*filteredTag `json:"filtered,omitempty"`
+	Pruned     []*prunedTags  `json:"pruned,omitempty"`
+	Deleted    []*deletedTag  `json:"deleted_upstream,omitempty"`
+}
+
+// filteredTag is an upstream tag dropped by the filters of the repository
+type filteredTag struct {
+	Tag    string `json:"tag"`
+	Filter string `json:"filter"` // glob, regex, semver, age, keep, max_tags or tag_map
+	Reason string `json:"reason"`
+}
+
+// prunedTags are the tags of a target deleted by `prune_target` or the target retention, or
+// that would be deleted with `prune_dry_run`
+type prunedTags struct {
+	Registry   string   `json:"registry"`
+	Repository string   `json:"repository"`
+	Tags       []string `json:"tags"`
+	Reason     string   `json:"reason"` // not_upstream, target_max_tags or target_max_tag_age
+	DryRun     bool     `json:"dry_run,omitempty"`
+	Declined   bool     `json:"declined,omitempty"` // the deletion held by --confirm wasn't confirmed
+	Error      string   `json:"error,omitempty"`
+}
+
+// deletedTag is a tag listed upstream by the previous run that disappeared since, with
+// `track_deletions`
+type deletedTag struct {
+	Tag         string    `json:"tag"`
+	Digest      string    `json:"digest,omitempty"` // upstream digest when it was last listed
+	LastSeen    time.Time `json:"last_seen"`
+	Quarantined []string  `json:"quarantined,omitempty"` // target images tagged with the `quarantine_suffix`
+	Error       string    `json:"error,omitempty"`
+}
+
+// tagReport is the result of mirroring a single tag to all its targets
+type tagReport struct {
+	Tag              string          `json:"tag"`
+	Result           string          `json:"result"`
+	Reason           string          `json:"reason,omitempty"`
+	Error            string          `json:"error,omitempty"`
+	SourceDigest     string          `json:"source_digest,omitempty"`
+	ImageSize        int64           `json:"image_size,omitempty"` // size of the upstream image, with `max_image_size`
+	BytesTransferred int64           `json:"bytes_transferred"`
+	PullDuration     float64         `json:"pull_duration_seconds"`
+	Duration         float64         `json:"duration_seconds"`
+	Lag              float64         `json:"lag_seconds,omitempty"`
+	Retries          int             `json:"retries,omitempty"` // pulls, tags and pushes retried with `retries`
+	SLAViolation     bool            `json:"sla_violation,omitempty"`
+	Vulnerabilities  []vulnerability `json:"vulnerabilities,omitempty"` // findings blocking the push
+	Targets          []*targetReport `json:"targets,omitempty"`
+}
+
+// targetReport is the result of pushing a single tag to a single target
+type targetReport struct {
+	Registry     string   `json:"registry"`
+	Repository   string   `json:"repository"`
+	Tag          string   `json:"tag"`
+	Result       string   `json:"result"`
+	Error        string   `json:"error,omitempty"`
+	Digest       string   `json:"digest,omitempty"`
+	PushDuration float64  `json:"push_duration_seconds"`
+	Signatures   []string `json:"signatures,omitempty"`    // cosign artifacts copied with `copy_signatures`
+	Referrers    []string `json:"referrers,omitempty"`     // digests of the referrers copied with `copy_referrers`
+	FailoverFrom string   `json:"failover_from,omitempty"` // the primary target the tag failed to land on
+
+	target *target
+}
+
+func newRunReport() *runReport {
+	return &runReport{StartedAt: time.Now()}
+}
+
+// repository adds a new repository to the report
+func (r *runReport) repository(name, host string) *repositoryReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rr := &repositoryReport{run: r, Name: name, Host: host, Result: resultMirrored, Tags: []*tagReport{}}
+	r.Repositories = append(r.Repositories, rr)
+	return rr
+}
+
+// write the report as JSON to the given file
+func (r *runReport) write(file string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.FinishedAt = time.Now()
+	r.Errors = r.errorGroups()
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+// errors returns the errors of the run grouped by fingerprint, most frequent first
+func (r *runReport) errors() []*errorGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.errorGroups()
+}
+
+func (r *runReport) errorGroups() []*errorGroup {
+	groups := make(map[string]*errorGroup)
+	res := []*errorGroup{}
+
+	add := func(err, repo, tag string) {
+		message, fingerprint := errorFingerprint(err, repo, tag)
+		g, ok := groups[fingerprint]
+		if !ok {
+			g = &errorGroup{Fingerprint: fingerprint, Message: message, Sample: err}
+			groups[fingerprint] = g
+			res = append(res, g)
+		}
+
+		g.Count++
+		if len(g.Occurrences) < maxErrorOccurrences {
+			occurrence := repo
+			if tag != "" {
+				occurrence = repo + ":" + tag
+			}
+			g.Occurrences = append(g.Occurrences, occurrence)
+		}
+	}
+
+	for _, rr := range r.Repositories {
+		rr.mu.Lock()
+		if rr.Error != "" {
+			add(rr.Error, rr.Name, "")
+		}
+
+		for _, tr := range rr.Tags {
+			targetErrors := false
+			for _, target := range tr.Targets {
+				if target.Error != "" {
+					add(target.Error, rr.Name, tr.Tag)
+					targetErrors = true
+				}
+			}
+
+			// the tag error is the last target error, unless the tag failed before the push
+			if tr.Error != "" && !targetErrors {
+				add(tr.Error, rr.Name, tr.Tag)
+			}
+		}
+		rr.mu.Unlock()
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Count > res[j].Count
+	})
+
+	return res
+}
+
+// errorFingerprint masks the repository, tag, digests and ids in the error, so identical
+// errors of different repositories get the same fingerprint
+func errorFingerprint(err, repo, tag string) (string, string) {
+	message := digestRE.ReplaceAllString(err, "sha256:<digest>")
+	message = hexIDRE.ReplaceAllString(message, "<id>")
+	if repo != "" {
+		message = strings.Replace(message, repo, "<repo>", -1)
+	}
+	if tag != "" {
+		message = strings.Replace(message, "<repo>:"+tag, "<repo>:<tag>", -1)
+	}
+
+	sum := sha1.Sum([]byte(message))
+	return message, hex.EncodeToString(sum[:])[:12]
+}
+
+// logErrors logs a single line per error group, instead of the same error for every tag
+func (r *runReport) logErrors() {
+	for _, g := range r.errors() {
+		log.WithField("fingerprint", g.Fingerprint).Warnf("%d× %s (e.g. %s)", g.Count, g.Message, strings.Join(g.Occurrences, ", "))
+	}
+}
+
+// fail marks the whole repository as failed
+func (rr *repositoryReport) fail(err error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.Result = resultFailed
+	rr.Error = err.Error()
+}
+
+// violation counts a tag exceeding the freshness SLA
+func (rr *repositoryReport) violation() {
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.SLAViolations++
+}
+
+// tooFewTags fails the repository for resolving to fewer tags than its min_tags
+func (rr *repositoryReport) tooFewTags(err error) {
+	rr.fail(err)
+
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.TooFewTags++
+}
+
+// tooFewTagsCount returns the number of repositories below their min_tags
+func (r *runReport) tooFewTagsCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.TooFewTags
+}
+
+// deprecated records why the upstream repository is deprecated
+func (rr *repositoryReport) deprecated(signal string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.Deprecated = signal
+}
+
+// skip marks the whole repository as skipped
+func (rr *repositoryReport) skip(reason string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.Result = resultSkipped
+	rr.Reason = reason
+}
+
+// deferred marks the whole repository as deferred, its host has an open circuit breaker
+func (rr *repositoryReport) deferred(reason string) {
+	rr.mu.Lock()
+	rr.Result = resultDeferred
+	rr.Reason = reason
+	rr.mu.Unlock()
+
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Deferred++
+}
+
+// failed returns true when the repository or any of its tags failed
+func (rr *repositoryReport) failed() bool {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	return rr.Result == resultFailed
+}
+
+// deleted records a tag deleted upstream
+func (rr *repositoryReport) deleted(d *deletedTag) {
+	rr.mu.Lock()
+	rr.Deleted = append(rr.Deleted, d)
+	rr.mu.Unlock()
+
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Deletions++
+}
+
+// prune records the tags pruned from a target
+func (rr *repositoryReport) prune(p *prunedTags) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.Pruned = append(rr.Pruned, p)
+}
+
+// tag adds a new tag to the repository report
+func (rr *repositoryReport) tag(name string) *tagReport {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	tr := &tagReport{Tag: name, Result: resultMirrored}
+	rr.Tags = append(rr.Tags, tr)
+	return tr
+}
+
+// filter records a tag dropped by the filters of the repository
+func (rr *repositoryReport) filter(name, filter, reason string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.Filtered = append(rr.Filtered, &filteredTag{Tag: name, Filter: filter, Reason: reason})
+}
+
+// fail marks the tag as failed, the repository result is failed when any tag failed
+func (tr *tagReport) fail(rr *repositoryReport, err error) {
+	tr.Result = resultFailed
+	tr.Error = err.Error()
+
+	rr.mu.Lock()
+	rr.Result = resultFailed
+	rr.mu.Unlock()
+}
+
+// unsupported marks the tag as not mirrored for media types the targets reject, counted apart
+// from the skipped tags
+func (tr *tagReport) unsupported(rr *repositoryReport, reason string) {
+	tr.Result = resultUnsupported
+	tr.Reason = reason
+
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Unsupported++
+}
+
+// blocked marks the tag as not mirrored for the vulnerabilities the scan found, with the
+// findings in the report
+func (tr *tagReport) blocked(rr *repositoryReport, err *vulnerableImageError) {
+	tr.Result = resultBlocked
+	tr.Reason = err.Error()
+	tr.Vulnerabilities = err.vulnerabilities
+
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Blocked++
+}
+
+// failover counts a tag landing on a standby target instead of its primary target
+func (rr *repositoryReport) failover() {
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Failovers++
+}
+
+// retried counts a pull, tag or push retried with `retries`
+func (rr *repositoryReport) retried() {
+	rr.run.mu.Lock()
+	defer rr.run.mu.Unlock()
+
+	rr.run.Retries++
+}
+
+// repoDigest returns the digest of the image in the given repository, from the
+// image RepoDigests (e.g. redis@sha256:...)
+func repoDigest(repoDigests []string, repository string) string {
+	for _, rd := range repoDigests {
+		chunk := strings.SplitN(rd, "@", 2)
+		if len(chunk) == 2 && chunk[0] == repository {
+			return chunk[1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRepoDigest(t *testing.T) {
+	digests := []string{
+		"redis@sha256:aaa",
+		"registry.example.com/hub/redis@sha256:bbb",
+	}
+
+	if got := repoDigest(digests, "redis"); got != "sha256:aaa" {
+		t.Errorf("Expected sha256:aaa, got %q", got)
+	}
+
+	if got := repoDigest(digests, "registry.example.com/hub/redis"); got != "sha256:bbb" {
+		t.Errorf("Expected sha256:bbb, got %q", got)
+	}
+
+	if got := repoDigest(digests, "hub/redis"); got != "" {
+		t.Errorf("Expected no digest, got %q", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	responseContainer := &ResponseContainer{
+		Images: map[string]*docker.Image{
+			"redis:7": {
+				Size:        1024,
+				RepoDigests: []string{"redis@sha256:aaa"},
+			},
+			"registry.example.com/hub/redis:7": {
+				RepoDigests: []string{"redis@sha256:aaa", "registry.example.com/hub/redis@sha256:aaa"},
+			},
+		},
+	}
+	var client DockerClient
+	client = CreateTestDockerClient(responseContainer)
+
+	tc := TargetConfig{Registry: "registry.example.com", Prefix: "hub/", Username: "user", DropTags: []string{"*-rc"}}
+	r := newRunReport()
+
+	m := mirror{
+		dockerClient: &client,
+		targets:      []*target{{registry: tc.Registry, config: tc, ecrManager: newRegistryManager(tc)}},
+		report:       r.repository("redis", dockerHub),
+		log:          log.WithField("repo", "redis"),
+		repo:         Repository{Name: "redis", Host: dockerHub},
+		remoteTags:   []RepositoryTag{{Name: "7"}, {Name: "8-rc"}},
+	}
+	m.work()
+
+	file := filepath.Join(t.TempDir(), "report.json")
+	if err := r.write(file); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var got runReport
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(got.Repositories) != 1 || len(got.Repositories[0].Tags) != 2 {
+		t.Fatalf("Expected 1 repository with 2 tags, got %+v", got.Repositories)
+	}
+
+	repo := got.Repositories[0]
+	if repo.Result != resultMirrored {
+		t.Errorf("Expected repository to be %s, got %s", resultMirrored, repo.Result)
+	}
+
+	mirrored := repo.Tags[0]
+	if mirrored.Result != resultMirrored || mirrored.SourceDigest != "sha256:aaa" || mirrored.BytesTransferred != 1024 {
+		t.Errorf("Unexpected mirrored tag %+v", mirrored)
+	}
+
+	if len(mirrored.Targets) != 1 || mirrored.Targets[0].Repository != "hub/redis" || mirrored.Targets[0].Digest != "sha256:aaa" {
+		t.Errorf("Unexpected mirrored tag targets %+v", mirrored.Targets)
+	}
+
+	if skipped := repo.Tags[1]; skipped.Result != resultSkipped {
+		t.Errorf("Expected tag %s to be %s, got %s", skipped.Tag, resultSkipped, skipped.Result)
+	}
+}
+
+func TestTrackFreshness(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	sla := Duration(1 * time.Hour)
+
+	tests := []struct {
+		name      string
+		repo      Repository
+		tag       RepositoryTag
+		lag       float64
+		violation bool
+	}{
+		{name: "within sla", repo: Repository{FreshnessSLA: &sla}, tag: RepositoryTag{LastUpdated: now.Add(-30 * time.Minute)}, lag: 1800},
+		{name: "exceeds sla", repo: Repository{FreshnessSLA: &sla}, tag: RepositoryTag{LastUpdated: now.Add(-2 * time.Hour)}, lag: 7200, violation: true},
+		{name: "quay last modified", repo: Repository{FreshnessSLA: &sla}, tag: RepositoryTag{LastModified: now.Add(-2 * time.Hour)}, lag: 7200, violation: true},
+		{name: "no sla", tag: RepositoryTag{LastUpdated: now.Add(-2 * time.Hour)}, lag: 7200},
+		{name: "unknown update time", repo: Repository{FreshnessSLA: &sla}},
+	}
+
+	for _, tt := range tests {
+		r := newRunReport()
+		m := mirror{
+			report: r.repository("redis", dockerHub),
+			log:    log.WithField("repo", "redis"),
+			repo:   tt.repo,
+		}
+
+		tr := m.report.tag("latest")
+		m.trackFreshness(tr, tt.tag, now)
+
+		if tr.Lag != tt.lag || tr.SLAViolation != tt.violation {
+			t.Errorf("%s: expected lag %v and violation %v, got %v and %v", tt.name, tt.lag, tt.violation, tr.Lag, tr.SLAViolation)
+		}
+
+		if want := map[bool]int{true: 1, false: 0}[tt.violation]; r.SLAViolations != want {
+			t.Errorf("%s: expected %d violations, got %d", tt.name, want, r.SLAViolations)
+		}
+	}
+}
+
+func TestErrorGroups(t *testing.T) {
+	r := newRunReport()
+	outage := errors.New("Error response from daemon: Get \"https://registry-1.docker.io/v2/\": 503 Service Unavailable")
+
+	for _, name := range []string{"redis", "nginx", "postgres"} {
+		rr := r.repository(name, dockerHub)
+		for _, tag := range []string{"1", "2"} {
+			rr.tag(tag).fail(rr, outage)
+		}
+	}
+
+	rr := r.repository("jippi/hashi-ui", dockerHub)
+	tr := rr.tag("v1")
+	tr.Targets = []*targetReport{
+		{Result: resultFailed, Error: "denied: requested access to jippi/hashi-ui:v1 is denied"},
+		{Result: resultMirrored},
+	}
+	tr.fail(rr, errors.New(tr.Targets[0].Error))
+	r.repository("yotpo/resec", dockerHub).fail(errors.New("Get https://registry.hub.docker.com/v2/repositories/yotpo/resec/tags/: 404"))
+
+	groups := r.errors()
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 error groups, got %d: %+v", len(groups), groups)
+	}
+
+	if g := groups[0]; g.Count != 6 || len(g.Occurrences) != 6 || g.Sample != outage.Error() || g.Occurrences[0] != "redis:1" {
+		t.Errorf("Unexpected outage error group %+v", g)
+	}
+
+	if g := groups[1]; g.Count != 1 || g.Message != "denied: requested access to <repo>:<tag> is denied" {
+		t.Errorf("Unexpected push error group %+v", g)
+	}
+
+	if g := groups[2]; g.Message != "Get https://registry.hub.docker.com/v2/repositories/<repo>/tags/: 404" || g.Occurrences[0] != "yotpo/resec" {
+		t.Errorf("Unexpected repository error group %+v", g)
+	}
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	a, fa := errorFingerprint("manifest for redis@sha256:"+strings.Repeat("a", 64)+" not found", "redis", "7")
+	b, fb := errorFingerprint("manifest for nginx@sha256:"+strings.Repeat("b", 64)+" not found", "nginx", "1.25")
+
+	if a != "manifest for <repo>@sha256:<digest> not found" || a != b || fa != fb {
+		t.Errorf("Expected identical fingerprints, got %q (%s) and %q (%s)", a, fa, b, fb)
+	}
+}
+
+func TestReportFilteredTags(t *testing.T) {
+	maxAge := Duration(24 * time.Hour)
+	m := mirror{
+		log:    log.WithField("test", "filtered"),
+		report: newRunReport().repository("redis", dockerHub),
+		repo:   Repository{Name: "redis", MatchTags: []string{"7*"}, MaxTagAge: &maxAge, MaxTags: 1},
+	}
+
+	now := time.Now()
+	m.remoteTags = []RepositoryTag{
+		{Name: "7.2.1", LastUpdated: now},
+		{Name: "7.2.0", LastUpdated: now},
+		{Name: "7.0.0", LastUpdated: now.Add(-48 * time.Hour)},
+		{Name: "6.2.0", LastUpdated: now},
+	}
+	m.filterTags()
+
+	got := make(map[string]string)
+	for _, f := range m.report.Filtered {
+		got[f.Tag] = f.Filter
+	}
+
+	want := map[string]string{"7.2.0": filterMaxTags, "7.0.0": filterAge, "6.2.0": filterGlob}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v filtered, got %v", want, got)
+	}
+	for tag, filter := range want {
+		if got[tag] != filter {
+			t.Errorf("Expected %s to be filtered by %s, got %q", tag, filter, got[tag])
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+	log "github.com/sirupsen/logrus"
+)
+
+// filterRepositories keeps the repositories, of the config and its tenants, matching an
+// `include_repositories` glob (all when empty) and no `exclude_repositories` glob. The
+// INCLUDE_REPOSITORIES and EXCLUDE_REPOSITORIES env vars, comma separated, take precedence,
+// so an environment consumes part of a shared config without editing it
+func filterRepositories(c *Config) {
+	if v := os.Getenv("INCLUDE_REPOSITORIES"); v != "" {
+		c.IncludeRepositories = splitList(v)
+	}
+	if v := os.Getenv("EXCLUDE_REPOSITORIES"); v != "" {
+		c.ExcludeRepositories = splitList(v)
+	}
+
+	if len(c.IncludeRepositories) == 0 && len(c.ExcludeRepositories) == 0 {
+		return
+	}
+
+	filter := func(repos []Repository) []Repository {
+		var res []Repository
+		for _, repo := range repos {
+			if name, _ := repo.pinnedDigests(); c.wantsRepository(name) {
+				res = append(res, repo)
+			} else {
+				log.WithField("full_repo", repo.Name).Debug("Dropping repository, filtered by include_repositories / exclude_repositories")
+			}
+		}
+		return res
+	}
+
+	before := len(c.allRepositories())
+	c.Repositories = filter(c.Repositories)
+	for i := range c.Tenants {
+		c.Tenants[i].Repositories = filter(c.Tenants[i].Repositories)
+	}
+
+	log.Infof("Mirroring %d of the %d repositories of the config, filtered by include_repositories / exclude_repositories", len(c.allRepositories()), before)
+}
+
+// wantsRepository returns true if the repository name passes the include and exclude globs
+func (c *Config) wantsRepository(name string) bool {
+	if len(c.IncludeRepositories) > 0 {
+		keep := false
+		for _, pattern := range c.IncludeRepositories {
+			if glob.Glob(pattern, name) {
+				keep = true
+				break
+			}
+		}
+
+		if !keep {
+			return false
+		}
+	}
+
+	for _, pattern := range c.ExcludeRepositories {
+		if glob.Glob(pattern, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitList splits a comma separated list, without the empty items
+func splitList(v string) []string {
+	var res []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
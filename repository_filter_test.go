@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterRepositories(t *testing.T) {
+	names := func(repos []Repository) []string {
+		var res []string
+		for _, repo := range repos {
+			res = append(res, repo.Name)
+		}
+		return res
+	}
+
+	newConfig := func() Config {
+		return Config{
+			IncludeRepositories: []string{"library/*", "bitnami/*"},
+			ExcludeRepositories: []string{"*/postgres"},
+			Repositories:        []Repository{{Name: "library/redis"}, {Name: "library/postgres"}, {Name: "jippi/hashi-ui"}, {Name: "library/nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31"}},
+			Tenants:             []TenantConfig{{Name: "payments", Repositories: []Repository{{Name: "bitnami/kafka"}, {Name: "bitnami/postgres"}}}},
+		}
+	}
+
+	c := newConfig()
+	filterRepositories(&c)
+	if got := names(c.allRepositories()); !reflect.DeepEqual(got, []string{"library/redis", "library/nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", "bitnami/kafka"}) {
+		t.Errorf("Unexpected repositories %v", got)
+	}
+
+	// the env vars take precedence over the config
+	t.Setenv("INCLUDE_REPOSITORIES", "jippi/*, library/postgres")
+	t.Setenv("EXCLUDE_REPOSITORIES", "library/redis")
+	c = newConfig()
+	filterRepositories(&c)
+	if got := names(c.allRepositories()); !reflect.DeepEqual(got, []string{"library/postgres", "jippi/hashi-ui"}) {
+		t.Errorf("Unexpected repositories with the env vars %v", got)
+	}
+
+	c = Config{Repositories: []Repository{{Name: "library/redis"}}}
+	t.Setenv("INCLUDE_REPOSITORIES", "")
+	t.Setenv("EXCLUDE_REPOSITORIES", "")
+	filterRepositories(&c)
+	if len(c.Repositories) != 1 {
+		t.Errorf("Expected all the repositories without filters, got %v", names(c.Repositories))
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+)
+
+// reasons of the pruned tags of the report
+const (
+	pruneNotUpstream = "not_upstream"
+	pruneMaxTags     = "target_max_tags"
+	pruneMaxTagAge   = "target_max_tag_age"
+)
+
+// pushDateLister is implemented by the target managers knowing when the tags were pushed,
+// the ECR ones
+type pushDateLister interface {
+	listPushDates(name string) (map[string]time.Time, error)
+}
+
+// applyRetention deletes the tags of the targets beyond the `target_max_tags` most recently
+// pushed, or pushed more than `target_max_tag_age` ago, like an ECR lifecycle policy
+func (m *mirror) applyRetention(targets []*target, now time.Time) {
+	if reason := m.stopped(); reason != "" {
+		m.log.Warnf("Not applying the target retention: %s", reason)
+		return
+	}
+
+	for _, t := range targets {
+		// archive snapshots have their own retention
+		if t.config.Archive {
+			continue
+		}
+
+		lister, ok := t.ecrManager.(pushDateLister)
+		if !ok {
+			m.log.Warnf("Not applying the target retention to %s, only ECR registries tell when a tag was pushed", t.registry)
+			continue
+		}
+
+		repository := m.targetRepositoryName(t)
+		pushed, err := lister.listPushDates(repository)
+		if err != nil {
+			m.log.Errorf("Could not list the tags of %s/%s to apply the retention: %s", t.registry, repository, err)
+			continue
+		}
+
+		tooMany, tooOld := m.retainedTags(t, pushed, now)
+		for _, expired := range []struct {
+			reason string
+			tags   []string
+		}{{pruneMaxTags, tooMany}, {pruneMaxTagAge, tooOld}} {
+			if len(expired.tags) == 0 {
+				continue
+			}
+
+			p := &prunedTags{Registry: t.registry, Repository: repository, Tags: expired.tags, Reason: expired.reason, DryRun: m.repo.PruneDryRun}
+			if m.repo.PruneDryRun {
+				m.log.Infof("Would delete %d tags beyond the %s from %s/%s (prune_dry_run): %v", len(expired.tags), expired.reason, t.registry, repository, expired.tags)
+			} else {
+				m.log.Infof("Deleting %d tags beyond the %s from %s/%s: %v", len(expired.tags), expired.reason, t.registry, repository, expired.tags)
+				if err := deletions.deleteTags(t, repository, expired.tags, expired.reason, p); err != nil {
+					m.log.Errorf("Could not apply the retention to %s/%s: %s", t.registry, repository, err)
+					p.Error = err.Error()
+				}
+			}
+			m.report.prune(p)
+		}
+	}
+}
+
+// retainedTags returns the tags beyond the `target_max_tags` most recently pushed, and the
+// other tags pushed more than `target_max_tag_age` ago. The `prune_protect` tags, and the
+// tags the target filters out, are never deleted nor counted
+func (m *mirror) retainedTags(t *target, pushed map[string]time.Time, now time.Time) ([]string, []string) {
+	var tags []string
+	for tag := range pushed {
+		if t.wantsTag(tag) && !m.pruneProtected(tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	// most recently pushed first, the tags of an image share its push date
+	sort.Slice(tags, func(i, j int) bool {
+		if !pushed[tags[i]].Equal(pushed[tags[j]]) {
+			return pushed[tags[i]].After(pushed[tags[j]])
+		}
+		return tags[i] < tags[j]
+	})
+
+	var tooMany, tooOld []string
+	for i, tag := range tags {
+		switch {
+		case m.repo.TargetMaxTags > 0 && i >= m.repo.TargetMaxTags:
+			tooMany = append(tooMany, tag)
+		case m.repo.TargetMaxTagAge != nil && now.Sub(pushed[tag]) > time.Duration(*m.repo.TargetMaxTagAge):
+			tooOld = append(tooOld, tag)
+		}
+	}
+	sort.Strings(tooMany)
+	sort.Strings(tooOld)
+
+	return tooMany, tooOld
+}
+
+// listPushDates returns when the image of every tag of the repository was pushed
+func (e *ecrPrivateManager) listPushDates(name string) (map[string]time.Time, error) {
+	pushed := make(map[string]time.Time)
+	var nextToken *string
+	for {
+		resp, err := e.client.DescribeImages(context.TODO(), &ecr.DescribeImagesInput{
+			RepositoryName: &name,
+			Filter:         &types.DescribeImagesFilter{TagStatus: types.TagStatusTagged},
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, detail := range resp.ImageDetails {
+			for _, tag := range detail.ImageTags {
+				pushed[tag] = aws.ToTime(detail.ImagePushedAt)
+			}
+		}
+
+		if resp.NextToken == nil {
+			return pushed, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+// listPushDates returns when the image of every tag of the repository was pushed
+func (e *ecrPublicManager) listPushDates(name string) (map[string]time.Time, error) {
+	pushed := make(map[string]time.Time)
+	var nextToken *string
+	for {
+		resp, err := e.client.DescribeImageTags(context.TODO(), &ecrpublic.DescribeImageTagsInput{
+			RepositoryName: &name,
+			NextToken:      nextToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, detail := range resp.ImageTagDetails {
+			if detail.ImageTag == nil || detail.ImageDetail == nil {
+				continue
+			}
+			if detail.ImageDetail.ImagePushedAt == nil {
+				return nil, fmt.Errorf("No push date for %s:%s", name, *detail.ImageTag)
+			}
+			pushed[*detail.ImageTag] = *detail.ImageDetail.ImagePushedAt
+		}
+
+		if resp.NextToken == nil {
+			return pushed, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
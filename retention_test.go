@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeECRManager is a fakeManager knowing when the tags were pushed
+type fakeECRManager struct {
+	fakeManager
+	pushed map[string]map[string]time.Time
+}
+
+func (f *fakeECRManager) listPushDates(name string) (map[string]time.Time, error) {
+	return f.pushed[name], nil
+}
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	newTargets := func() (*fakeECRManager, []*target) {
+		manager := &fakeECRManager{pushed: map[string]map[string]time.Time{
+			"hub/redis": {
+				"7": now.Add(-1 * day), "latest": now.Add(-1 * day), "6": now.Add(-10 * day),
+				"5": now.Add(-100 * day), "4": now.Add(-200 * day), "debug": now.Add(-300 * day),
+			},
+		}}
+		ecr := &target{registry: "ecr", config: TargetConfig{Prefix: "hub/", DropTags: []string{"debug"}}, ecrManager: manager}
+		archive := &target{registry: "archive", config: TargetConfig{Prefix: "hub/", Archive: true}, ecrManager: manager}
+		registry := &target{registry: "registry", config: TargetConfig{Prefix: "hub/"}, ecrManager: &fakeManager{}}
+		return manager, []*target{ecr, archive, registry}
+	}
+
+	newMirror := func(repo Repository) mirror {
+		repo.Name, repo.Host = "redis", dockerHub
+		return mirror{
+			log:    log.WithField("test", "retention"),
+			repo:   repo,
+			report: newRunReport().repository("redis", dockerHub),
+		}
+	}
+
+	// the most recently pushed tags are kept, then the old ones are deleted, except the protected ones
+	maxAge := Duration(30 * day)
+	manager, targets := newTargets()
+	m := newMirror(Repository{TargetMaxTags: 3, TargetMaxTagAge: &maxAge, PruneProtect: []string{"latest"}})
+	m.applyRetention(targets, now)
+	if !reflect.DeepEqual(manager.deleted, []string{"hub/redis:4", "hub/redis:5"}) {
+		t.Errorf("Unexpected deleted tags %v", manager.deleted)
+	}
+
+	m = newMirror(Repository{TargetMaxTags: 2})
+	m.applyRetention(targets, now)
+	if len(m.report.Pruned) != 1 || m.report.Pruned[0].Reason != pruneMaxTags || !reflect.DeepEqual(m.report.Pruned[0].Tags, []string{"4", "5", "6"}) {
+		t.Errorf("Unexpected max tags report %+v", m.report.Pruned)
+	}
+
+	m = newMirror(Repository{TargetMaxTagAge: &maxAge})
+	m.applyRetention(targets, now)
+	if len(m.report.Pruned) != 1 || m.report.Pruned[0].Reason != pruneMaxTagAge || !reflect.DeepEqual(m.report.Pruned[0].Tags, []string{"4", "5"}) {
+		t.Errorf("Unexpected max tag age report %+v", m.report.Pruned)
+	}
+
+	// a dry run only reports them
+	manager, targets = newTargets()
+	m = newMirror(Repository{TargetMaxTags: 1, PruneDryRun: true})
+	m.applyRetention(targets, now)
+	if len(manager.deleted) != 0 {
+		t.Errorf("Expected a dry run to delete nothing, got %v", manager.deleted)
+	}
+	if len(m.report.Pruned) != 1 || !m.report.Pruned[0].DryRun || !reflect.DeepEqual(m.report.Pruned[0].Tags, []string{"4", "5", "6", "latest"}) {
+		t.Errorf("Unexpected dry run report %+v", m.report.Pruned)
+	}
+}
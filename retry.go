@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// number of attempts for a registry API request
+	httpRetries = 5
+
+	// exponential backoff between attempts, when the registry doesn't say how long to wait
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 1 * time.Minute
+
+	// upper bound of the wait for a Retry-After, a longer one is capped and the request retried
+	maxRetryAfter = 5 * time.Minute
+
+	// first backoff between the attempts of a pull, tag or push with `retries`
+	defaultRetryBackoff = 30 * time.Second
+)
+
+// sleep waits between attempts, replaced in tests
+var sleep = time.Sleep
+
+// retryable returns true for the responses worth retrying: rate limits and server errors
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryDelay returns how long to wait before the next attempt of a failed request. The
+// Retry-After header (seconds or HTTP date) and the Docker Hub X-RateLimit-Reset are honored,
+// otherwise the delay is a jittered exponential backoff, so the workers don't retry in lockstep.
+// res is nil when the request failed without a response
+func retryDelay(res *http.Response, attempt int, now time.Time) time.Duration {
+	if res != nil {
+		if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), now); ok {
+			if d > maxRetryAfter {
+				return maxRetryAfter
+			}
+			return d
+		}
+
+		if reset := res.Header.Get("X-RateLimit-Reset"); res.StatusCode == http.StatusTooManyRequests && reset != "" {
+			return getSleepTime(reset, now)
+		}
+	}
+
+	delay := retryBaseDelay << uint(attempt)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	// jitter within [delay/2, delay)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+}
+
+// retryStep runs a pull, tag or push of the tag, and retries it up to `retries` times with an
+// exponential backoff from `retry_backoff`, so a network blip doesn't fail the tag for the
+// whole run. The retries are counted in the report, the last error says how many attempts failed
+func (m *mirror) retryStep(tr *tagReport, step string, f func() error) error {
+	backoff := defaultRetryBackoff
+	if config.RetryBackoff != nil {
+		backoff = time.Duration(*config.RetryBackoff)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= config.Retries {
+			if attempt > 0 {
+				return fmt.Errorf("%w (%s failed %d times)", err, step, attempt+1)
+			}
+			return err
+		}
+
+		// jitter within [delay/2, delay), so the tags failing together don't retry in lockstep
+		delay := backoff << uint(attempt)
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+		m.log.Warnf("Failed to %s the tag, retrying in %s (retry %d of %d): %s", step, delay.Round(time.Second), attempt+1, config.Retries, err)
+		tr.Retries++
+		m.report.retried()
+		runState.retrySleep(m.repo.Host, err.Error(), delay)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, a number of seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	if d := at.Sub(now); d > 0 {
+		return d, true
+	}
+
+	return 0, true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := func(status int, headers ...string) *http.Response {
+		res := &http.Response{StatusCode: status, Header: http.Header{}}
+		for i := 0; i+1 < len(headers); i += 2 {
+			res.Header.Set(headers[i], headers[i+1])
+		}
+		return res
+	}
+
+	tests := map[string]struct {
+		res  *http.Response
+		want time.Duration
+	}{
+		"retry-after seconds": {response(503, "Retry-After", "30"), 30 * time.Second},
+		"retry-after date":    {response(503, "Retry-After", now.Add(2*time.Minute).Format(http.TimeFormat)), 2 * time.Minute},
+		"retry-after past":    {response(503, "Retry-After", now.Add(-time.Minute).Format(http.TimeFormat)), 0},
+		"retry-after capped":  {response(429, "Retry-After", "86400"), maxRetryAfter},
+		"rate limit reset":    {response(429, "X-RateLimit-Reset", "1767225610"), 10 * time.Second},
+	}
+
+	for name, tt := range tests {
+		if got := retryDelay(tt.res, 0, now); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", name, tt.want, got)
+		}
+	}
+
+	// without header the backoff grows exponentially, with jitter
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		for i := 0; i < 10; i++ {
+			got := retryDelay(response(503), attempt, now)
+			if got < max/2 || got >= max {
+				t.Errorf("Expected attempt %d to wait within [%s, %s), got %s", attempt, max/2, max, got)
+			}
+		}
+	}
+
+	if got := retryDelay(nil, 30, now); got < retryMaxDelay/2 || got >= retryMaxDelay {
+		t.Errorf("Expected the backoff to be capped at %s, got %s", retryMaxDelay, got)
+	}
+}
+
+func TestGetRemoteTagsRetries(t *testing.T) {
+	var delays []time.Duration
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v2/missing/"):
+			w.WriteHeader(http.StatusNotFound)
+		case requests == 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"name":"redis","tags":["7"]}`))
+		}
+	}))
+	defer server.Close()
+
+	defer func(hosts []HostConfig) { config.Hosts = hosts }(config.Hosts)
+	config.Hosts = []HostConfig{{Name: "artifactory.example.com", Type: hostTypeArtifactory, APIBase: server.URL}}
+
+	m := mirror{log: log.WithField("test", "retries"), repo: Repository{Name: "redis", Host: "artifactory.example.com"}}
+	tags, err := m.getRemoteTags()
+	if err != nil || len(tags) != 1 {
+		t.Fatalf("Expected the 503 to be retried, got %v (%v)", tags, err)
+	}
+
+	if len(delays) != 1 || delays[0] != 7*time.Second {
+		t.Errorf("Expected a single retry honoring Retry-After, got %v", delays)
+	}
+
+	requests, delays = 0, nil
+	m.repo.Name = "missing"
+	if _, err := m.getRemoteTags(); err == nil || requests != 1 || len(delays) != 0 {
+		t.Errorf("Expected a 404 to fail without retries, got %d requests (%v)", requests, err)
+	}
+}
+
+func TestRetryStep(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+
+	backoff := Duration(10 * time.Second)
+	config = Config{Retries: 2, RetryBackoff: &backoff}
+
+	r := newRunReport()
+	m := &mirror{report: r.repository("redis", dockerHub), log: log.WithField("test", "retry")}
+	tr := m.report.tag("7.2")
+
+	calls := 0
+	blip := errors.New("connection reset by peer")
+	err := m.retryStep(tr, "pull", func() error {
+		calls++
+		if calls < 3 {
+			return blip
+		}
+		return nil
+	})
+	if err != nil || calls != 3 || tr.Retries != 2 || r.Retries != 2 {
+		t.Errorf("Expected a success after 2 retries, got %v after %d calls (%d retries)", err, calls, tr.Retries)
+	}
+	if len(delays) != 2 || delays[0] < 5*time.Second || delays[0] > 10*time.Second || delays[1] < 10*time.Second || delays[1] > 20*time.Second {
+		t.Errorf("Expected an exponential backoff from 10s, got %v", delays)
+	}
+
+	calls = 0
+	err = m.retryStep(tr, "push", func() error {
+		calls++
+		return blip
+	})
+	if !errors.Is(err, blip) || calls != 3 || !strings.Contains(err.Error(), "push failed 3 times") {
+		t.Errorf("Expected the last error after 3 attempts, got %v after %d calls", err, calls)
+	}
+
+	// no retries by default
+	config = Config{}
+	calls = 0
+	if err := m.retryStep(tr, "tag", func() error { calls++; return blip }); err != blip || calls != 1 {
+		t.Errorf("Expected a single attempt, got %v after %d calls", err, calls)
+	}
+}
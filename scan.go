@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	scannerTrivy = "trivy"
+	scannerGrype = "grype"
+
+	defaultScanSeverity = "HIGH"
+
+	// findings listed in the error and the logs, the report has them all
+	maxLoggedFindings = 5
+)
+
+// severities from the lowest to the highest, as reported by trivy (grype capitalizes them)
+var severities = []string{"UNKNOWN", "NEGLIGIBLE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// ScanConfig configures the vulnerability scan gating the pushes
+type ScanConfig struct {
+	Scanner       string `yaml:"scanner,omitempty"`        // trivy or grype, the scan is disabled when empty
+	Severity      string `yaml:"severity,omitempty"`       // lowest severity blocking the push (default: HIGH)
+	IgnoreUnfixed bool   `yaml:"ignore_unfixed,omitempty"` // vulnerabilities without a fixed version don't block
+}
+
+// scannerOutput runs the scanner, replaced in the tests
+var scannerOutput = commandOutput
+
+// vulnerability is a finding of the scanner at or above the severity of the scan
+type vulnerability struct {
+	ID           string `json:"id"`
+	Severity     string `json:"severity"`
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+}
+
+// vulnerableImageError blocks the push of an image with vulnerabilities at or above the
+// severity of the scan
+type vulnerableImageError struct {
+	reference       string
+	severity        string
+	vulnerabilities []vulnerability
+}
+
+func (e *vulnerableImageError) Error() string {
+	var ids []string
+	for i, v := range e.vulnerabilities {
+		if i == maxLoggedFindings {
+			ids = append(ids, fmt.Sprintf("and %d more", len(e.vulnerabilities)-i))
+			break
+		}
+		ids = append(ids, fmt.Sprintf("%s (%s in %s %s)", v.ID, v.Severity, v.Package, v.Version))
+	}
+
+	return fmt.Sprintf("%s has %d vulnerabilities of severity %s or higher: %s", e.reference, len(e.vulnerabilities), e.severity, strings.Join(ids, ", "))
+}
+
+// validateScanConfig checks the scanner and the severity of the `scan` config
+func validateScanConfig(c ScanConfig) error {
+	switch c.Scanner {
+	case "", scannerTrivy, scannerGrype:
+	default:
+		return fmt.Errorf("Unknown `scan -> scanner` %q, we support %s and %s", c.Scanner, scannerTrivy, scannerGrype)
+	}
+
+	if c.Severity != "" && severityRank(c.Severity) < 0 {
+		return fmt.Errorf("Unknown `scan -> severity` %q, we support %s", c.Severity, strings.Join(severities, ", "))
+	}
+
+	return nil
+}
+
+// severityRank returns the position of the severity in severities, -1 when unknown
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// scanImage scans the image with the scanner of the `scan` config, and returns a
+// vulnerableImageError when it has vulnerabilities at or above the severity. The image is
+// read from the docker daemon when it was pulled, and from the source registry in the
+// daemonless mode
+func scanImage(c ScanConfig, reference string, daemon bool) error {
+	var command []string
+	switch c.Scanner {
+	case scannerTrivy:
+		source := "remote"
+		if daemon {
+			source = "docker"
+		}
+		command = []string{scannerTrivy, "image", "--quiet", "--format", "json", "--image-src", source}
+		if c.IgnoreUnfixed {
+			command = append(command, "--ignore-unfixed")
+		}
+		command = append(command, reference)
+	case scannerGrype:
+		source := "registry:"
+		if daemon {
+			source = "docker:"
+		}
+		command = []string{scannerGrype, "--quiet", "--output", "json"}
+		if c.IgnoreUnfixed {
+			command = append(command, "--only-fixed")
+		}
+		command = append(command, source+reference)
+	default:
+		return nil
+	}
+
+	out, err := scannerOutput(command...)
+	if err != nil {
+		return fmt.Errorf("Failed to scan %s with %s: %s", reference, c.Scanner, err)
+	}
+
+	var found []vulnerability
+	if c.Scanner == scannerTrivy {
+		found, err = parseTrivyReport(out)
+	} else {
+		found, err = parseGrypeReport(out)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not parse the %s report of %s: %s", c.Scanner, reference, err)
+	}
+
+	severity := strings.ToUpper(c.Severity)
+	if severity == "" {
+		severity = defaultScanSeverity
+	}
+
+	var blocking []vulnerability
+	for _, v := range found {
+		if severityRank(v.Severity) >= severityRank(severity) {
+			blocking = append(blocking, v)
+		}
+	}
+
+	if len(blocking) == 0 {
+		return nil
+	}
+
+	// the most severe first
+	sort.SliceStable(blocking, func(i, j int) bool {
+		return severityRank(blocking[i].Severity) > severityRank(blocking[j].Severity)
+	})
+
+	return &vulnerableImageError{reference: reference, severity: severity, vulnerabilities: blocking}
+}
+
+// parseTrivyReport returns the vulnerabilities of a `trivy image --format json` report
+func parseTrivyReport(out []byte) ([]vulnerability, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string
+				PkgName          string
+				InstalledVersion string
+				FixedVersion     string
+				Severity         string
+			}
+		}
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, err
+	}
+
+	var res []vulnerability
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			res = append(res, vulnerability{ID: v.VulnerabilityID, Severity: strings.ToUpper(v.Severity), Package: v.PkgName, Version: v.InstalledVersion, FixedVersion: v.FixedVersion})
+		}
+	}
+
+	return res, nil
+}
+
+// parseGrypeReport returns the vulnerabilities of a `grype --output json` report
+func parseGrypeReport(out []byte) ([]vulnerability, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+				Fix      struct {
+					Versions []string `json:"versions"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, err
+	}
+
+	var res []vulnerability
+	for _, m := range report.Matches {
+		res = append(res, vulnerability{
+			ID:           m.Vulnerability.ID,
+			Severity:     strings.ToUpper(m.Vulnerability.Severity),
+			Package:      m.Artifact.Name,
+			Version:      m.Artifact.Version,
+			FixedVersion: strings.Join(m.Vulnerability.Fix.Versions, ", "),
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ryanuber/go-glob"
+	log "github.com/sirupsen/logrus"
+)
+
+// podList is the part of `kubectl get pods -o json` listing the container images
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers          []podContainer `json:"containers"`
+			InitContainers      []podContainer `json:"initContainers"`
+			EphemeralContainers []podContainer `json:"ephemeralContainers"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+type podContainer struct {
+	Image string `json:"image"`
+}
+
+// scanClusterCommand mirrors the images of the pods running in a Kubernetes cluster, and
+// prints where each image is mirrored in the target registry
+func scanClusterCommand(args []string) {
+	flags := flag.NewFlagSet("scan-cluster", flag.ExitOnError)
+	configFile := configFlag(flags)
+	kubeconfig := flags.String("kubeconfig", os.Getenv("KUBECONFIG"), "kubeconfig file of the cluster")
+	kubeContext := flags.String("context", "", "kubeconfig context of the cluster (default: current context)")
+	selector := flags.String("selector", "", "only scan the pods matching this label selector, e.g. app=web")
+	var namespaces, excludeNamespaces listFlag
+	flags.Var(&namespaces, "namespace", "only scan the namespaces matching this glob (repeatable)")
+	flags.Var(&excludeNamespaces, "exclude-namespace", "skip the namespaces matching this glob (repeatable), e.g. kube-*")
+	workers := flags.Int("workers", envInt("NUM_WORKERS"), "number of repositories mirrored in parallel (default: workers config or number of CPUs)")
+	reportFile := flags.String("report-file", os.Getenv("REPORT_FILE"), "write a JSON report of the run to this file")
+	dryRun := flags.Bool("dry-run", false, "only print the images and where they would be mirrored")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror scan-cluster [--kubeconfig file] [--namespace glob] [--exclude-namespace glob] [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	setupConfig(*configFile)
+
+	command := []string{"kubectl", "get", "pods", "--all-namespaces", "--output", "json"}
+	if *kubeconfig != "" {
+		command = append(command, "--kubeconfig", *kubeconfig)
+	}
+	if *kubeContext != "" {
+		command = append(command, "--context", *kubeContext)
+	}
+	if *selector != "" {
+		command = append(command, "--selector", *selector)
+	}
+
+	out, err := commandOutput(command...)
+	if err != nil {
+		log.Fatalf("Could not list the pods of the cluster: %s", err)
+	}
+
+	images, err := podImages(out, namespaces, excludeNamespaces)
+	if err != nil {
+		log.Fatalf("Could not parse the pods of the cluster: %s", err)
+	}
+
+	repos := discoveredRepositories(images)
+	log.Infof("Found %d images in %d repositories running in the cluster", len(images), len(repos))
+
+	for _, line := range imageRewrites(images) {
+		fmt.Println(line)
+	}
+
+	if *dryRun {
+		return
+	}
+
+	// only the images of the cluster are mirrored, to the targets of the config
+	config.Repositories = repos
+	config.Tenants = nil
+
+	startRun(runOptions{
+		configFile: *configFile,
+		workers:    *workers,
+		reportFile: *reportFile,
+	})
+}
+
+// podImages returns the unique images of the pods in the namespaces matching the globs
+func podImages(content []byte, namespaces, excludeNamespaces []string) ([]string, error) {
+	var pods podList
+	if err := json.Unmarshal(content, &pods); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var images []string
+	for _, pod := range pods.Items {
+		if !matchNamespace(pod.Metadata.Namespace, namespaces, excludeNamespaces) {
+			continue
+		}
+
+		var containers []podContainer
+		containers = append(containers, pod.Spec.Containers...)
+		containers = append(containers, pod.Spec.InitContainers...)
+		containers = append(containers, pod.Spec.EphemeralContainers...)
+		for _, c := range containers {
+			if c.Image != "" && !seen[c.Image] {
+				seen[c.Image] = true
+				images = append(images, c.Image)
+			}
+		}
+	}
+	sort.Strings(images)
+
+	return images, nil
+}
+
+// matchNamespace returns true if the namespace matches an include glob (any, when none
+// is set) and no exclude glob
+func matchNamespace(namespace string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if glob.Glob(pattern, namespace) {
+			return false
+		}
+	}
+
+	if len(include) == 0 {
+		return true
+	}
+
+	for _, pattern := range include {
+		if glob.Glob(pattern, namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imageRewrites returns a `source => target` line per image, the target image being the
+// image in the primary target, i.e. to rewrite the manifests of an air-gapped copy
+func imageRewrites(images []string) []string {
+	tc := config.Target
+	if tc.Registry == "" && len(config.Targets) > 0 {
+		tc = config.Targets[0]
+	}
+
+	// the name template was validated with the config
+	nameTemplate, _ := parseNameTemplate(tc)
+	t := &target{registry: tc.Registry, config: tc, primary: tc.Registry == config.Target.Registry, nameTemplate: nameTemplate}
+
+	var res []string
+	for _, image := range images {
+		host, name, tag, digest, ok := parseSourceImage(image)
+		if !ok {
+			continue
+		}
+
+		if digest != "" {
+			tag = PinnedDigest{Digest: digest}.targetTag()
+		}
+
+		m := mirror{repo: Repository{Name: name, Host: host}, log: log.WithField("full_repo", name)}
+		res = append(res, fmt.Sprintf("%s => %s/%s:%s", image, t.registry, m.targetRepositoryName(t), tag))
+	}
+
+	return res
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPodImages(t *testing.T) {
+	content := []byte(`{"items": [
+		{"metadata": {"namespace": "web"}, "spec": {"containers": [{"image": "nginx:1.25"}], "initContainers": [{"image": "busybox"}]}},
+		{"metadata": {"namespace": "web"}, "spec": {"containers": [{"image": "nginx:1.25"}]}},
+		{"metadata": {"namespace": "kube-system"}, "spec": {"containers": [{"image": "k8s.gcr.io/coredns:1.8.0"}]}},
+		{"metadata": {"namespace": "data"}, "spec": {"containers": [{"image": "quay.io/coreos/etcd:v3.5.9"}]}}
+	]}`)
+
+	images, err := podImages(content, nil, []string{"kube-*"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if want := []string{"busybox", "nginx:1.25", "quay.io/coreos/etcd:v3.5.9"}; !reflect.DeepEqual(images, want) {
+		t.Errorf("Expected %v, got %v", want, images)
+	}
+
+	images, _ = podImages(content, []string{"data"}, nil)
+	if want := []string{"quay.io/coreos/etcd:v3.5.9"}; !reflect.DeepEqual(images, want) {
+		t.Errorf("Expected %v, got %v", want, images)
+	}
+}
+
+func TestImageRewrites(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{Target: TargetConfig{Registry: "registry.example.com", Prefix: "mirror/"}}
+
+	got := imageRewrites([]string{"nginx:1.25", "quay.io/coreos/etcd@sha256:0000000000000000000000000000000000000000000000000000000000000000", "registry.example.com/mirror/nginx:1.25"})
+	want := []string{
+		"nginx:1.25 => registry.example.com/mirror/nginx:1.25",
+		"quay.io/coreos/etcd@sha256:0000000000000000000000000000000000000000000000000000000000000000 => registry.example.com/mirror/coreos/etcd:sha256-0000000000000000000000000000000000000000000000000000000000000000",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected\n%v\ngot\n%v", want, got)
+	}
+}
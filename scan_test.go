@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const trivyReport = `{"Results": [{"Target": "redis:7 (debian 12.1)", "Vulnerabilities": [
+	{"VulnerabilityID": "CVE-2023-0001", "PkgName": "openssl", "InstalledVersion": "3.0.9", "FixedVersion": "3.0.10", "Severity": "HIGH"},
+	{"VulnerabilityID": "CVE-2023-0002", "PkgName": "zlib", "InstalledVersion": "1.2.13", "Severity": "LOW"},
+	{"VulnerabilityID": "CVE-2023-0003", "PkgName": "glibc", "InstalledVersion": "2.36", "FixedVersion": "2.37", "Severity": "CRITICAL"}
+]}, {"Target": "Java", "Class": "lang-pkgs"}]}`
+
+const grypeReport = `{"matches": [
+	{"vulnerability": {"id": "CVE-2023-0001", "severity": "High", "fix": {"versions": ["3.0.10"]}}, "artifact": {"name": "openssl", "version": "3.0.9"}},
+	{"vulnerability": {"id": "CVE-2023-0002", "severity": "Medium", "fix": {"versions": []}}, "artifact": {"name": "zlib", "version": "1.2.13"}}
+]}`
+
+func TestScanImage(t *testing.T) {
+	defer func(original func(...string) ([]byte, error)) { scannerOutput = original }(scannerOutput)
+
+	var command []string
+	output := trivyReport
+	scannerOutput = func(c ...string) ([]byte, error) {
+		command = c
+		return []byte(output), nil
+	}
+
+	err := scanImage(ScanConfig{Scanner: scannerTrivy}, "redis:7", true)
+	var vulnerable *vulnerableImageError
+	if !errors.As(err, &vulnerable) {
+		t.Fatalf("Expected a vulnerable image, got %v", err)
+	}
+	if want := []string{"trivy", "image", "--quiet", "--format", "json", "--image-src", "docker", "redis:7"}; !reflect.DeepEqual(command, want) {
+		t.Errorf("Expected %v, got %v", want, command)
+	}
+	if len(vulnerable.vulnerabilities) != 2 || vulnerable.vulnerabilities[0].ID != "CVE-2023-0003" || vulnerable.vulnerabilities[1].ID != "CVE-2023-0001" {
+		t.Errorf("Expected the critical and high findings, most severe first, got %+v", vulnerable.vulnerabilities)
+	}
+
+	if err := scanImage(ScanConfig{Scanner: scannerTrivy, Severity: "critical", IgnoreUnfixed: true}, "redis:7", false); !errors.As(err, &vulnerable) || len(vulnerable.vulnerabilities) != 1 {
+		t.Errorf("Expected only the critical finding, got %v", err)
+	}
+	if want := []string{"trivy", "image", "--quiet", "--format", "json", "--image-src", "remote", "--ignore-unfixed", "redis:7"}; !reflect.DeepEqual(command, want) {
+		t.Errorf("Expected %v, got %v", want, command)
+	}
+
+	output = grypeReport
+	if err := scanImage(ScanConfig{Scanner: scannerGrype, Severity: "MEDIUM"}, "redis:7", false); !errors.As(err, &vulnerable) || len(vulnerable.vulnerabilities) != 2 {
+		t.Errorf("Expected the high and medium findings, got %v", err)
+	}
+	if want := []string{"grype", "--quiet", "--output", "json", "registry:redis:7"}; !reflect.DeepEqual(command, want) {
+		t.Errorf("Expected %v, got %v", want, command)
+	}
+	if vulnerable.vulnerabilities[0].FixedVersion != "3.0.10" || vulnerable.vulnerabilities[1].Severity != "MEDIUM" {
+		t.Errorf("Unexpected grype findings %+v", vulnerable.vulnerabilities)
+	}
+
+	if err := scanImage(ScanConfig{Scanner: scannerGrype, Severity: "CRITICAL"}, "redis:7", true); err != nil {
+		t.Errorf("Expected no finding above critical, got %s", err)
+	}
+
+	scannerOutput = func(c ...string) ([]byte, error) { return nil, errors.New("executable file not found") }
+	if err := scanImage(ScanConfig{Scanner: scannerTrivy}, "redis:7", true); err == nil || errors.As(err, &vulnerable) {
+		t.Errorf("Expected a failing scanner to fail the tag, got %v", err)
+	}
+}
+
+func TestValidateScanConfig(t *testing.T) {
+	tests := map[string]struct {
+		config ScanConfig
+		valid  bool
+	}{
+		"disabled":         {ScanConfig{}, true},
+		"trivy":            {ScanConfig{Scanner: scannerTrivy, Severity: "critical"}, true},
+		"unknown scanner":  {ScanConfig{Scanner: "clair"}, false},
+		"unknown severity": {ScanConfig{Scanner: scannerGrype, Severity: "severe"}, false},
+	}
+
+	for name, tt := range tests {
+		err := validateScanConfig(tt.config)
+		if tt.valid != (err == nil) {
+			t.Errorf("%s: unexpected result %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// awsSecretEndpoint returns the endpoint of Secrets Manager or SSM in the region, replaced
+// in the tests
+var awsSecretEndpoint = func(service, region string) string {
+	return fmt.Sprintf("https://%s.%s.amazonaws.com/", service, region)
+}
+
+// secretResolver resolves the secret references of the config. The AWS config is loaded with
+// the first reference of AWS, and every secret of AWS is fetched once
+type secretResolver struct {
+	aws    *aws.Config
+	values map[string]string
+}
+
+// resolve returns the value of a secret reference of the config:
+//   - `env:NAME` is the env var NAME
+//   - `file:/path` is the content of the file, without its trailing newline (i.e. a mounted
+//     Kubernetes secret)
+//   - `secretsmanager:<arn or name>` is a secret of AWS Secrets Manager
+//   - `ssm:<name or arn>` is a parameter of SSM Parameter Store, decrypted
+//
+// A `#key` suffix of an AWS reference picks the key of a JSON secret (i.e. `#password`).
+// Other values are returned as is
+func (r *secretResolver) resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("The env var %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		content, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case strings.HasPrefix(value, "secretsmanager:"), strings.HasPrefix(value, "ssm:"):
+		chunk := strings.SplitN(value, ":", 2)
+		id, key := chunk[1], ""
+		if i := strings.LastIndex(id, "#"); i >= 0 {
+			id, key = id[:i], id[i+1:]
+		}
+
+		secret, ok := r.values[chunk[0]+":"+id]
+		if !ok {
+			var err error
+			if secret, err = r.awsSecret(chunk[0], id); err != nil {
+				return "", fmt.Errorf("Could not get %s from %s: %s", id, chunk[0], err)
+			}
+			r.values[chunk[0]+":"+id] = secret
+		}
+
+		if key == "" {
+			return secret, nil
+		}
+
+		var keys map[string]interface{}
+		if err := json.Unmarshal([]byte(secret), &keys); err != nil {
+			return "", fmt.Errorf("The secret %s is not a JSON object, it has no key %s", id, key)
+		}
+		if v, ok := keys[key].(string); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("The secret %s has no string key %s", id, key)
+	}
+
+	return value, nil
+}
+
+// awsSecret fetches a secret of Secrets Manager or a parameter of SSM, in the region of its
+// ARN or of the AWS config
+func (r *secretResolver) awsSecret(service, id string) (string, error) {
+	if r.aws == nil {
+		cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return "", err
+		}
+		r.aws = &cfg
+	}
+
+	region := r.aws.Region
+	if chunk := strings.Split(id, ":"); len(chunk) > 3 && chunk[0] == "arn" {
+		region = chunk[3]
+	}
+
+	target, in := "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": id}
+	if service == "ssm" {
+		target, in = "AmazonSSM.GetParameter", map[string]interface{}{"Name": id, "WithDecryption": true}
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", awsSecretEndpoint(service, region), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	creds, err := r.aws.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(context.TODO(), creds, req, hex.EncodeToString(sum[:]), service, region, time.Now()); err != nil {
+		return "", err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return "", fmt.Errorf("%s returned %d: %s %s", target, res.StatusCode, e.Type, e.Message)
+	}
+
+	var out struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+		Parameter    struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return "", err
+	}
+
+	switch {
+	case service == "ssm":
+		return out.Parameter.Value, nil
+	case out.SecretBinary != nil:
+		return string(out.SecretBinary), nil
+	}
+	return out.SecretString, nil
+}
+
+// resolveSecrets replaces the secret references of the credentials of the config with their
+// values, so a missing secret fails when the config is loaded: the `username` and `password`
+// of the targets, the hosts and the `auth` of the repositories, the `token` of the
+// `remote_tags_config` and the webhook `token`
+func resolveSecrets(cfg *Config) error {
+	// several targets can share a registry (i.e. an `archive` target), the fields are a list
+	type secretField struct {
+		name  string
+		value *string
+	}
+	fields := []secretField{{"the webhook `token`", &cfg.Webhook.Token}}
+
+	targets := []*TargetConfig{&cfg.Target}
+	for i := range cfg.Targets {
+		targets = append(targets, &cfg.Targets[i])
+	}
+	for _, t := range targets {
+		fields = append(fields, secretField{"the `username` of target " + t.Registry, &t.Username}, secretField{"the `password` of target " + t.Registry, &t.Password})
+	}
+
+	for i := range cfg.Hosts {
+		h := &cfg.Hosts[i]
+		fields = append(fields, secretField{"the `username` of host " + h.Name, &h.Username}, secretField{"the `password` of host " + h.Name, &h.Password})
+	}
+
+	r := &secretResolver{values: make(map[string]string)}
+	for _, field := range fields {
+		secret, err := r.resolve(*field.value)
+		if err != nil {
+			return fmt.Errorf("Could not resolve %s: %s", field.name, err)
+		}
+		*field.value = secret
+	}
+
+	// the repositories are copies, sharing their `auth` and `remote_tags_config`
+	for _, repo := range cfg.allRepositories() {
+		if repo.Auth != nil {
+			for _, field := range []*string{&repo.Auth.Username, &repo.Auth.Password} {
+				secret, err := r.resolve(*field)
+				if err != nil {
+					return fmt.Errorf("Could not resolve the `auth` of repository %s: %s", repo.Name, err)
+				}
+				*field = secret
+			}
+		}
+
+		if token := repo.RemoteTagConfig["token"]; token != "" {
+			secret, err := r.resolve(token)
+			if err != nil {
+				return fmt.Errorf("Could not resolve the `remote_tags_config` token of repository %s: %s", repo.Name, err)
+			}
+			repo.RemoteTagConfig["token"] = secret
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	t.Setenv("QUAY_ROBOT", "acme+mirror")
+	token := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(token, []byte("robot-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": "repositories:\n  - name: acme/app\n    host: quay.io\n    auth:\n      username: env:QUAY_ROBOT\n      password: file:" + token + "\n  - name: redis\ntenants:\n  - name: search\n    repositories:\n      - name: acme/search\n        auth:\n          username: acme\n          password: literal\n",
+	})
+	if err := loadConfig(filepath.Join(dir, "config.yaml")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if auth := config.Repositories[0].Auth; auth.Username != "acme+mirror" || auth.Password != "robot-token" {
+		t.Errorf("Expected the secrets of the env var and the file, got %+v", auth)
+	}
+	if auth := config.Tenants[0].Repositories[0].Auth; auth.Username != "acme" || auth.Password != "literal" {
+		t.Errorf("Expected the values without reference as is, got %+v", auth)
+	}
+
+	for _, value := range []string{"env:MISSING_SECRET", "file:" + filepath.Join(dir, "missing")} {
+		if _, err := (&secretResolver{}).resolve(value); err == nil {
+			t.Errorf("Expected %s to fail", value)
+		}
+	}
+}
+
+func TestResolveAWSSecrets(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var in map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&in)
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "secretsmanager.GetSecretValue":
+			if in["SecretId"] != "arn:aws:secretsmanager:eu-west-1:123456789012:secret:registry-AbCdEf" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "Secrets Manager can't find the specified secret."}`)
+				return
+			}
+			fmt.Fprint(w, `{"SecretString": "{\"username\": \"mirror\", \"password\": \"s3cret\"}"}`)
+		case "AmazonSSM.GetParameter":
+			if in["WithDecryption"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `{"Parameter": {"Name": %q, "Value": "ssm-token"}}`, in["Name"])
+		}
+	}))
+	defer server.Close()
+
+	var regions []string
+	defer func(endpoint func(string, string) string) { awsSecretEndpoint = endpoint }(awsSecretEndpoint)
+	awsSecretEndpoint = func(service, region string) string {
+		regions = append(regions, service+"/"+region)
+		return server.URL
+	}
+
+	cfg := Config{
+		Target: TargetConfig{Registry: "registry.example.com", Username: "secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:registry-AbCdEf#username", Password: "secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:registry-AbCdEf#password"},
+		Hosts:  []HostConfig{{Name: "artifactory.example.com", Username: "mirror", Password: "ssm:/mirror/artifactory"}},
+	}
+
+	r := &secretResolver{values: make(map[string]string), aws: &aws.Config{Region: "us-east-1", Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})}}
+
+	for _, field := range []*string{&cfg.Target.Username, &cfg.Target.Password, &cfg.Hosts[0].Password} {
+		secret, err := r.resolve(*field)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		*field = secret
+	}
+
+	if cfg.Target.Username != "mirror" || cfg.Target.Password != "s3cret" || cfg.Hosts[0].Password != "ssm-token" {
+		t.Errorf("Unexpected resolved credentials %+v %+v", cfg.Target, cfg.Hosts[0])
+	}
+
+	// the secret is fetched once, in the region of its ARN
+	if calls != 2 || !reflect.DeepEqual(regions, []string{"secretsmanager/eu-west-1", "ssm/us-east-1"}) {
+		t.Errorf("Expected a call per secret in their region, got %d %v", calls, regions)
+	}
+
+	for _, value := range []string{"secretsmanager:missing", "secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:registry-AbCdEf#token", "ssm:/mirror/artifactory#password"} {
+		if _, err := r.resolve(value); err == nil {
+			t.Errorf("Expected %s to fail", value)
+		}
+	}
+}
+
+func TestResolveSecretsOfTargetsSharingARegistry(t *testing.T) {
+	t.Setenv("HARBOR_PASSWORD", "harbor-secret")
+	t.Setenv("HARBOR_ARCHIVE_PASSWORD", "archive-secret")
+
+	cfg := Config{
+		Target: TargetConfig{Registry: "harbor.example.com", Username: "mirror", Password: "env:HARBOR_PASSWORD"},
+		Targets: []TargetConfig{
+			{Registry: "harbor.example.com", Prefix: "archive/", Archive: true, Username: "archive", Password: "env:HARBOR_ARCHIVE_PASSWORD"},
+		},
+	}
+
+	if err := resolveSecrets(&cfg); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if cfg.Target.Password != "harbor-secret" || cfg.Targets[0].Password != "archive-secret" {
+		t.Errorf("Expected the password of both targets, got %q and %q", cfg.Target.Password, cfg.Targets[0].Password)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// matches a semantic version, tolerating a leading `v` and a missing minor or patch
+	// version as docker tags often have (e.g. v1.24.3, 1.24 or 7-alpine)
+	semverRE = regexp.MustCompile(`^v?([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+	// matches a single comparison of a constraint, e.g. `>= 1.24`
+	semverComparisonRE = regexp.MustCompile(`^(!=|>=|<=|=|>|<|~|\^)?\s*(\S+)$`)
+)
+
+// semver is a parsed semantic version
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseSemver parses a tag as a semantic version
+func parseSemver(tag string) (semver, bool) {
+	matches := semverRE.FindStringSubmatch(tag)
+	if matches == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	for i, p := range []*int{&v.major, &v.minor, &v.patch} {
+		if matches[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return semver{}, false
+		}
+		*p = n
+	}
+	v.prerelease = matches[4]
+
+	return v, true
+}
+
+// compare returns -1, 0 or 1 when v is lower, equal or greater than o, with the
+// semantic versioning precedence: a prerelease is lower than its release
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	}
+
+	return comparePrerelease(v.prerelease, o.prerelease)
+}
+
+// comparePrerelease compares the dot separated identifiers, numeric identifiers numerically
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil && bErr != nil:
+			return -1
+		case aErr != nil && bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+
+	return 0
+}
+
+// semverComparison is a single comparison of a constraint, e.g. `>= 1.24`
+type semverComparison struct {
+	op      string
+	version semver
+	// the version is partial (e.g. 1.24), used by ~ and ^
+	parts int
+}
+
+// semverConstraint is a parsed constraint, e.g. `>= 1.24, < 2.0 || 3.x`: comparisons
+// separated by commas must all match, groups separated by || are alternatives
+type semverConstraint struct {
+	groups [][]semverComparison
+	// prereleases only match when the constraint mentions one
+	prerelease bool
+}
+
+// parseSemverConstraint parses a semver constraint
+func parseSemverConstraint(constraint string) (*semverConstraint, error) {
+	c := &semverConstraint{}
+
+	for _, group := range strings.Split(constraint, "||") {
+		var comparisons []semverComparison
+		for _, raw := range strings.Split(group, ",") {
+			raw = strings.TrimSpace(raw)
+			matches := semverComparisonRE.FindStringSubmatch(raw)
+			if matches == nil {
+				return nil, fmt.Errorf("Invalid comparison %q", raw)
+			}
+
+			version := strings.TrimSuffix(strings.TrimSuffix(matches[2], ".x"), ".*")
+			v, ok := parseSemver(version)
+			if !ok {
+				return nil, fmt.Errorf("Invalid version %q in %q", matches[2], raw)
+			}
+
+			op := matches[1]
+			parts := strings.Count(strings.SplitN(strings.TrimPrefix(version, "v"), "-", 2)[0], ".") + 1
+			if op == "" || op == "=" {
+				// a partial version (e.g. 1.24 or 1.x) matches all its versions
+				if parts < 3 {
+					op = "~"
+				} else {
+					op = "="
+				}
+			}
+
+			c.prerelease = c.prerelease || v.prerelease != ""
+			comparisons = append(comparisons, semverComparison{op: op, version: v, parts: parts})
+		}
+		c.groups = append(c.groups, comparisons)
+	}
+
+	return c, nil
+}
+
+// matches returns true if the version satisfies the constraint
+func (c *semverConstraint) matches(v semver) bool {
+	if v.prerelease != "" && !c.prerelease {
+		return false
+	}
+
+	for _, group := range c.groups {
+		ok := true
+		for _, comparison := range group {
+			if !comparison.matches(v) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c semverComparison) matches(v semver) bool {
+	cmp := v.compare(c.version)
+
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~":
+		// ~1.2.3 and 1.2 allow patch updates, ~1 and 1.x allow minor updates
+		upper := semver{major: c.version.major + 1}
+		if c.parts >= 2 {
+			upper = semver{major: c.version.major, minor: c.version.minor + 1}
+		}
+		return cmp >= 0 && v.compare(upper) < 0
+	case "^":
+		// ^1.2.3 allows minor updates, ^0.2.3 only patch updates
+		upper := semver{major: c.version.major + 1}
+		if c.version.major == 0 && c.parts >= 2 {
+			upper = semver{minor: c.version.minor + 1}
+		}
+		return cmp >= 0 && v.compare(upper) < 0
+	}
+
+	return false
+}
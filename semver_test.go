@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := map[string]*semver{
+		"1.24.3":        {major: 1, minor: 24, patch: 3},
+		"v1.24.3":       {major: 1, minor: 24, patch: 3},
+		"1.24":          {major: 1, minor: 24},
+		"7":             {major: 7},
+		"1.25.0-rc.1":   {major: 1, minor: 25, prerelease: "rc.1"},
+		"7.2-alpine":    {major: 7, minor: 2, prerelease: "alpine"},
+		"1.0.0+build.5": {major: 1},
+		"latest":        nil,
+		"1.2.3.4":       nil,
+		"v":             nil,
+	}
+
+	for tag, want := range tests {
+		got, ok := parseSemver(tag)
+		if want == nil {
+			if ok {
+				t.Errorf("Expected %s not to parse, got %+v", tag, got)
+			}
+			continue
+		}
+
+		if !ok || got != *want {
+			t.Errorf("Expected %s to parse as %+v, got %+v (%v)", tag, *want, got, ok)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0"}
+
+	for i := 0; i+1 < len(ordered); i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("Expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matching   []string
+		other      []string
+	}{
+		{">= 1.24, < 2.0", []string{"1.24", "1.24.0", "v1.25.3", "1.99.99"}, []string{"1.23.9", "2.0.0", "2.0", "1.25.0-rc.1"}},
+		{">=1.24,<2", []string{"1.24.1"}, []string{"2.1.0"}},
+		{"1.24", []string{"1.24.0", "1.24.9"}, []string{"1.25.0", "1.23.0"}},
+		{"1.x", []string{"1.0.0", "1.99.0"}, []string{"2.0.0", "0.9.0"}},
+		{"= 1.24.3", []string{"1.24.3", "v1.24.3"}, []string{"1.24.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"!= 1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"< 1.0 || >= 3.0", []string{"0.9.0", "3.1.0"}, []string{"1.0.0", "2.5.0"}},
+		{">= 1.25.0-rc.1, < 1.26", []string{"1.25.0-rc.1", "1.25.0-rc.2", "1.25.0"}, []string{"1.25.0-beta.1", "1.24.0"}},
+	}
+
+	for _, tt := range tests {
+		c, err := parseSemverConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.constraint, err)
+			continue
+		}
+
+		for _, tag := range tt.matching {
+			if v, _ := parseSemver(tag); !c.matches(v) {
+				t.Errorf("%s: expected %s to match", tt.constraint, tag)
+			}
+		}
+
+		for _, tag := range tt.other {
+			if v, _ := parseSemver(tag); c.matches(v) {
+				t.Errorf("%s: expected %s not to match", tt.constraint, tag)
+			}
+		}
+	}
+
+	for _, constraint := range []string{"", ">= latest", ">= 1.2,", "=> 1.2"} {
+		if _, err := parseSemverConstraint(constraint); err == nil {
+			t.Errorf("Expected %q to be invalid", constraint)
+		}
+	}
+}
+
+func TestWantsTagSemver(t *testing.T) {
+	m := mirror{log: log.WithField("test", "semver"), repo: Repository{Name: "kubectl", Semver: ">= 1.24, < 2.0"}}
+	if err := m.compileTagFilters(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !m.wantsTag("v1.24.3") || m.wantsTag("1.23.0") || m.wantsTag("latest") {
+		t.Errorf("Expected only the semantic versions matching the constraint")
+	}
+
+	m.repo.SemverKeepOther = true
+	if !m.wantsTag("latest") || m.wantsTag("1.23.0") {
+		t.Errorf("Expected the tags that aren't versions to be kept")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// suffixes of the tags cosign attaches to an image digest: signatures, attestations and SBOMs
+var cosignSuffixes = []string{".sig", ".att", ".sbom"}
+
+// cosignTag returns the tag cosign stores an artifact of the digest under, i.e. sha256-<hex>.sig
+func cosignTag(digest, suffix string) string {
+	return strings.Replace(digest, ":", "-", 1) + suffix
+}
+
+// isCosignTag returns true for the tags of cosignTag, i.e. sha256-<hex>.sig
+func isCosignTag(tag string) bool {
+	for _, suffix := range cosignSuffixes {
+		if strings.HasSuffix(tag, suffix) {
+			return digestTagRE.MatchString(strings.TrimSuffix(tag, suffix))
+		}
+	}
+
+	return false
+}
+
+// copySignatures copies the cosign signatures, attestations and SBOMs of the mirrored digest
+// to the targets with `copy_signatures`, so admission policies verifying them keep working
+// against the mirror. They are copied with the registry API, also when the image itself was
+// pushed by the docker daemon. A signature only verifies when the target has the upstream
+// digest, the targets with another digest are skipped
+func (m *mirror) copySignatures(tr *tagReport) error {
+	if tr.SourceDigest == "" {
+		m.log.Warn("Not copying the signatures, the upstream digest is unknown")
+		return nil
+	}
+
+	srcHost, srcRepository := splitReference(m.sourceRepository())
+	src := registryClientFor(srcHost, m.sourceAuth(), false)
+
+	var artifacts []string
+	for _, suffix := range cosignSuffixes {
+		tag := cosignTag(tr.SourceDigest, suffix)
+		exists, err := src.manifestExists(srcRepository, tag)
+		if err != nil {
+			return fmt.Errorf("Could not look up the cosign %s of %s: %s", tag, tr.SourceDigest, err)
+		}
+		if exists {
+			artifacts = append(artifacts, tag)
+		}
+	}
+
+	if len(artifacts) == 0 {
+		m.log.Debug("No cosign signature upstream")
+		return nil
+	}
+
+	return m.eachSourceDigestTarget(tr, "signatures", func(result *targetReport, dst *registryClient, repository string) error {
+		for _, tag := range artifacts {
+			if _, _, err := copyImage(src, srcRepository, tag, dst, repository, tag); err != nil {
+				return fmt.Errorf("Failed to copy the cosign %s: %s", tag, err)
+			}
+			result.Signatures = append(result.Signatures, tag)
+		}
+		return nil
+	})
+}
+
+// eachSourceDigestTarget calls fn with a registry client of every target the tag was mirrored
+// to with the upstream digest, artifacts referencing the digest don't apply to the others. A
+// failing target is marked failed, the last error is returned
+func (m *mirror) eachSourceDigestTarget(tr *tagReport, what string, fn func(result *targetReport, dst *registryClient, repository string) error) error {
+	var failed error
+	for _, result := range tr.Targets {
+		if result.Result != resultMirrored {
+			continue
+		}
+		if result.Digest != "" && result.Digest != tr.SourceDigest {
+			m.log.Warnf("Not copying the %s to %s, it has digest %s instead of the upstream %s", what, result.Registry, result.Digest, tr.SourceDigest)
+			continue
+		}
+
+		t := result.target
+		creds, err := t.credentials()
+		if err != nil {
+			m.log.Errorf("Failed to get credentials for %s: %s", t.registry, err)
+			failed = err
+			continue
+		}
+
+		host, repository := t.registryPath(result.Repository)
+		if err := fn(result, registryClientFor(host, *creds, t.config.Insecure), repository); err != nil {
+			m.log.Errorf("Failed to copy the %s to %s: %s", what, t.registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+		}
+	}
+
+	return failed
+}
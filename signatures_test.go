@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCopySignatures(t *testing.T) {
+	source := newFakeRegistry()
+	image := source.image("library/redis", "7", []byte("layer"))
+	digest := sha256Digest(image)
+	signature := source.image("library/redis", cosignTag(digest, ".sig"), []byte(`{"critical":{"image":{"docker-manifest-digest":"`+digest+`"}}}`))
+	srcServer := httptest.NewServer(source)
+	defer srcServer.Close()
+
+	dest := newFakeRegistry()
+	dstServer := httptest.NewServer(dest)
+	defer dstServer.Close()
+
+	// the source is served over plain http
+	srcHost := strings.TrimPrefix(srcServer.URL, "http://")
+	registryClientsMu.Lock()
+	registryClients[srcHost+"||false"] = newRegistryClient(srcHost, docker.AuthConfiguration{}, true)
+	registryClientsMu.Unlock()
+	defer func() {
+		registryClientsMu.Lock()
+		delete(registryClients, srcHost+"||false")
+		registryClientsMu.Unlock()
+	}()
+
+	dstHost := strings.TrimPrefix(dstServer.URL, "http://")
+	targets := []*target{
+		{registry: dstHost, config: TargetConfig{Prefix: "hub/", Username: "mirror", Insecure: true}},
+		{registry: "other", config: TargetConfig{Prefix: "hub/"}},
+	}
+
+	m := mirror{log: log.WithField("test", "signatures"), repo: Repository{Name: srcHost + "/library/redis", CopySignatures: true}}
+	tr := &tagReport{Tag: "7", SourceDigest: digest, Targets: []*targetReport{
+		{Registry: dstHost, Repository: "hub/redis", Tag: "7", Result: resultMirrored, Digest: digest, target: targets[0]},
+		{Registry: "other", Repository: "hub/redis", Tag: "7", Result: resultMirrored, Digest: "sha256:other", target: targets[1]},
+	}}
+
+	if err := m.copySignatures(tr); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	sigTag := "sha256-" + strings.TrimPrefix(digest, "sha256:") + ".sig"
+	if string(dest.manifests["hub/redis:"+sigTag]) != string(signature) {
+		t.Errorf("Expected the signature to be copied as %s", sigTag)
+	}
+	if !reflect.DeepEqual(tr.Targets[0].Signatures, []string{sigTag}) {
+		t.Errorf("Unexpected copied signatures %v", tr.Targets[0].Signatures)
+	}
+
+	// the target with another digest can't verify the signature
+	if len(tr.Targets[1].Signatures) != 0 || tr.Targets[1].Result != resultMirrored {
+		t.Errorf("Expected no signature for a target with another digest, got %+v", tr.Targets[1])
+	}
+}
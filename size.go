@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes, configured with a unit, i.e. 10GB
+type ByteSize int64
+
+var byteSizeRE = regexp.MustCompile("^([0-9]+)(B|KB|MB|GB|TB|KiB|MiB|GiB|TiB)?$")
+
+// ParseByteSize parses a size with an optional unit, the units are powers of 1024 as in
+// the sizes docker reports (KB and KiB are the same)
+func ParseByteSize(s string) (ByteSize, error) {
+	matches := byteSizeRE.FindStringSubmatch(strings.TrimSpace(s))
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("not a valid size string: %q", s)
+	}
+
+	n, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a valid size string: %q", s)
+	}
+
+	switch strings.TrimSuffix(strings.Replace(matches[2], "i", "", 1), "B") {
+	case "K":
+		n <<= 10
+	case "M":
+		n <<= 20
+	case "G":
+		n <<= 30
+	case "T":
+		n <<= 40
+	}
+
+	return ByteSize(n), nil
+}
+
+func (b ByteSize) String() string {
+	for i, unit := range []string{"TB", "GB", "MB", "KB"} {
+		if shift := uint(40 - 10*i); b != 0 && b%(1<<shift) == 0 {
+			return fmt.Sprintf("%d%s", b>>shift, unit)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return b.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
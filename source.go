@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registries of docker hub a `source` can name, a source without registry is on docker hub
+var dockerHubSourceRegistries = map[string]bool{
+	dockerHubRegistry: true,
+	"docker.io":       true,
+	"index.docker.io": true,
+}
+
+// resolveSource returns the host of a `source` reference, and the path of the repository in
+// it. A registry that isn't a supported host is pulled from docker hub's host with its full
+// reference as name, as a name with a registry is
+func resolveSource(source string) (string, string) {
+	registry, path := splitReference(source)
+	switch {
+	case dockerHubSourceRegistries[registry]:
+		// the official images are known by their short name, as a `name` of docker hub is
+		return dockerHub, strings.TrimPrefix(path, "library/")
+	case registry == quay || registry == gcr || registry == k8s:
+		return registry, path
+	}
+
+	if h := customHost(registry); h != nil {
+		return registry, strings.TrimPrefix(path, h.PathPrefix)
+	}
+
+	return dockerHub, source
+}
+
+// upstream returns the repository with the host and the path of its `source`, the `name`
+// stays the name in the targets. A source registry that isn't a supported host lists its
+// tags with the registry API
+func (r Repository) upstream() Repository {
+	if r.Source == "" || r.sourceName != "" {
+		return r
+	}
+
+	r.Host, r.sourceName = resolveSource(r.Source)
+	if host, _ := splitReference(r.sourceName); r.Host == dockerHub && host != dockerHubRegistry && r.RemoteTagSource == "" {
+		r.RemoteTagSource = remoteTagSourceRegistry
+	}
+
+	return r
+}
+
+// upstreamName returns the path of the repository in its host, the `name` without a `source`
+func (m *mirror) upstreamName() string {
+	if m.repo.sourceName != "" {
+		return m.repo.sourceName
+	}
+
+	return m.repo.Name
+}
+
+// pullHost returns the host the images are pulled from, whose pull quota they spend. The
+// `pull_host_override` of a docker hub repository (i.e. mirror.gcr.io) serves the pulls, the
+// tags are still listed with the API of docker hub
+func (m *mirror) pullHost() string {
+	if m.repo.Host == dockerHub && m.repo.PullHost != "" {
+		return m.repo.PullHost
+	}
+
+	return m.repo.Host
+}
+
+// validatePullHost checks the `pull_host_override` of a repository, it mirrors the images
+// of docker hub only
+func validatePullHost(repo Repository) error {
+	if repo.PullHost == "" {
+		return nil
+	}
+
+	repo = repo.upstream()
+	if host, _ := splitReference(repo.sourceName); (repo.Host != "" && repo.Host != dockerHub) || repo.PrivateRegistry != "" || (repo.sourceName != "" && host != dockerHubRegistry) {
+		return fmt.Errorf("The `pull_host_override` of repository %s only applies to the images of Docker Hub", repo.Name)
+	}
+	if strings.Contains(repo.PullHost, "/") {
+		return fmt.Errorf("The `pull_host_override` of repository %s must be a registry host, i.e. mirror.gcr.io", repo.Name)
+	}
+	if repo.ContentTrust {
+		return fmt.Errorf("The `pull_host_override` of repository %s can't be used with `content_trust`, the trust data is signed for Docker Hub", repo.Name)
+	}
+
+	return nil
+}
+
+// validateSource checks the `source` of a repository, it replaces the host and the
+// private registry of the name and can't pin a tag or a digest
+func validateSource(repo Repository) error {
+	if repo.Source == "" {
+		return nil
+	}
+
+	if repo.Host != "" || repo.PrivateRegistry != "" {
+		return fmt.Errorf("The `source` of repository %s has the registry, it can't be used with `host` or `private_registry`", repo.Name)
+	}
+
+	_, path := splitReference(repo.Source)
+	if strings.ContainsAny(path, ":@") {
+		return fmt.Errorf("The `source` of repository %s can't have a tag or a digest, use `tags` or `digests`", repo.Name)
+	}
+
+	return nil
+}
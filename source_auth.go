@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// SourceAuth are the credentials the images of a repository are pulled with, i.e. of a
+// Quay robot account
+type SourceAuth struct {
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`        // or the token of a robot account
+	GoogleKeyFile string `yaml:"google_key_file,omitempty"` // a service account JSON key of gcr.io or Artifact Registry
+}
+
+// quayAuth returns the QUAY_USER and QUAY_TOKEN credentials of quay.io (i.e. of a robot
+// account), empty without
+func quayAuth() docker.AuthConfiguration {
+	if os.Getenv("QUAY_USER") == "" || os.Getenv("QUAY_TOKEN") == "" {
+		return docker.AuthConfiguration{}
+	}
+
+	return docker.AuthConfiguration{Username: os.Getenv("QUAY_USER"), Password: os.Getenv("QUAY_TOKEN"), ServerAddress: quay}
+}
+
+// listsWithCredentials returns true when the tags of the repository are listed with the
+// registry API, as the credentials it is pulled with don't work with the API of its host:
+// a Quay robot account, or the `auth` of a Docker Hub repository
+func (m *mirror) listsWithCredentials() bool {
+	switch m.repo.Host {
+	case quay:
+		return m.repo.Auth != nil || quayAuth().Username != ""
+	case gcr:
+		return m.repo.Auth != nil || config.GoogleAuth.enabled()
+	case dockerHub:
+		return m.repo.Auth != nil
+	}
+
+	return false
+}
+
+// validateSourceAuth checks the `auth` of a repository
+func validateSourceAuth(repo Repository) error {
+	if repo.Auth == nil {
+		return nil
+	}
+
+	if repo.Auth.GoogleKeyFile != "" {
+		if repo.Auth.Username != "" || repo.Auth.Password != "" {
+			return fmt.Errorf("The `auth` of repository %s has both a `google_key_file` and a `username`, use one of them", repo.Name)
+		}
+		return nil
+	}
+
+	if repo.Auth.Username == "" || repo.Auth.Password == "" {
+		return fmt.Errorf("The `auth` of repository %s needs a `username` and a `password`, or a `google_key_file`", repo.Name)
+	}
+
+	return nil
+}
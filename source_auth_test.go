@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSourceAuth(t *testing.T) {
+	t.Setenv("QUAY_USER", "acme+mirror")
+	t.Setenv("QUAY_TOKEN", "robot-token")
+	t.Setenv("DOCKERHUB_USER", "")
+
+	tests := []struct {
+		repo     Repository
+		auth     docker.AuthConfiguration
+		registry bool
+	}{
+		// the robot account of the environment, the Quay API doesn't accept it
+		{Repository{Name: "acme/app", Host: quay}, docker.AuthConfiguration{Username: "acme+mirror", Password: "robot-token", ServerAddress: quay}, true},
+		// the `auth` of the repository takes precedence
+		{Repository{Name: "other/app", Host: quay, Auth: &SourceAuth{Username: "other+mirror", Password: "other-token"}}, docker.AuthConfiguration{Username: "other+mirror", Password: "other-token", ServerAddress: quay}, true},
+		{Repository{Name: "acme/private", Host: dockerHub, Auth: &SourceAuth{Username: "acme", Password: "pat"}}, docker.AuthConfiguration{Username: "acme", Password: "pat"}, true},
+		{Repository{Name: "redis", Host: dockerHub}, docker.AuthConfiguration{}, false},
+	}
+
+	for _, tt := range tests {
+		m := &mirror{repo: tt.repo, log: log.WithField("repo", tt.repo.Name)}
+		if auth := m.sourceAuth(); auth != tt.auth {
+			t.Errorf("%s: expected %+v, got %+v", tt.repo.Name, tt.auth, auth)
+		}
+		if m.listsWithCredentials() != tt.registry {
+			t.Errorf("%s: expected the tags to be listed with the registry API %v", tt.repo.Name, tt.registry)
+		}
+	}
+
+	if err := validateSourceAuth(Repository{Name: "acme/app", Auth: &SourceAuth{Username: "acme+mirror"}}); err == nil {
+		t.Error("Expected an `auth` without password to be invalid")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRepositoryUpstream(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{Hosts: []HostConfig{{Name: "artifactory.example.com", Type: hostTypeArtifactory, PathPrefix: "docker-remote/"}}}
+
+	tests := []struct {
+		source     string
+		host       string
+		upstream   string
+		pulled     string
+		tagsSource string
+	}{
+		{"redis", dockerHub, "redis", "redis", ""},
+		{"docker.io/library/redis", dockerHub, "redis", "redis", ""},
+		{"docker.io/bitnami/redis", dockerHub, "bitnami/redis", "bitnami/redis", ""},
+		{"quay.io/prometheus/node-exporter", quay, "prometheus/node-exporter", "quay.io/prometheus/node-exporter", ""},
+		{"artifactory.example.com/docker-remote/acme/app", "artifactory.example.com", "acme/app", "artifactory.example.com/docker-remote/acme/app", ""},
+		{"ghcr.io/acme/app", dockerHub, "ghcr.io/acme/app", "ghcr.io/acme/app", remoteTagSourceRegistry},
+	}
+
+	for _, tt := range tests {
+		repo := Repository{Name: "mirrored/app", Host: dockerHub, Source: tt.source}.upstream()
+		m := &mirror{repo: repo}
+
+		if repo.Host != tt.host || m.upstreamName() != tt.upstream || m.sourceRepository() != tt.pulled || repo.RemoteTagSource != tt.tagsSource {
+			t.Errorf("%s: unexpected host %s, upstream %s, pulled %s and tags source %q", tt.source, repo.Host, m.upstreamName(), m.sourceRepository(), repo.RemoteTagSource)
+		}
+
+		// the targets are named after the name
+		if name := m.targetRepositoryName(&target{config: TargetConfig{Prefix: "hub/"}}); name != "hub/mirrored/app" {
+			t.Errorf("%s: expected hub/mirrored/app in the targets, got %s", tt.source, name)
+		}
+	}
+
+	// without a source, the name is pulled
+	if m := (&mirror{repo: Repository{Name: "redis", Host: dockerHub}.upstream()}); m.sourceRepository() != "redis" {
+		t.Errorf("Expected redis, got %s", m.sourceRepository())
+	}
+}
+
+func TestPullHost(t *testing.T) {
+	tests := []struct {
+		repo   Repository
+		pulled string
+		host   string
+	}{
+		{Repository{Name: "redis", Host: dockerHub, PullHost: "mirror.gcr.io"}, "mirror.gcr.io/library/redis", "mirror.gcr.io"},
+		{Repository{Name: "bitnami/redis", Host: dockerHub, PullHost: "mirror.gcr.io"}, "mirror.gcr.io/bitnami/redis", "mirror.gcr.io"},
+		{Repository{Name: "app", Host: dockerHub, Source: "docker.io/library/redis", PullHost: "mirror.gcr.io"}, "mirror.gcr.io/library/redis", "mirror.gcr.io"},
+		{Repository{Name: "redis", Host: dockerHub}, "redis", dockerHub},
+	}
+
+	for _, tt := range tests {
+		m := &mirror{repo: tt.repo.upstream()}
+		if m.sourceRepository() != tt.pulled || m.pullHost() != tt.host {
+			t.Errorf("%s: expected %s from %s, got %s from %s", tt.repo.Name, tt.pulled, tt.host, m.sourceRepository(), m.pullHost())
+		}
+
+		// the pulls through the override don't use the docker hub credentials
+		if tt.repo.PullHost != "" && m.sourceAuth() != (docker.AuthConfiguration{}) {
+			t.Errorf("%s: expected no credentials for %s", tt.repo.Name, tt.host)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	stateTypeDynamoDB = "dynamodb"
+	stateTypeS3       = "s3"
+)
+
+// state records what was mirrored across runs, nil when no state backend is configured
+var state stateBackend
+
+// mirroredTag is a tag that was mirrored to all its targets
+type mirroredTag struct {
+	SourceDigest string            `json:"source_digest,omitempty"`
+	Targets      map[string]string `json:"targets"` // target image reference -> digest
+	MirroredAt   time.Time         `json:"mirrored_at"`
+	Metadata     *imageMetadata    `json:"metadata,omitempty"` // of the upstream image, when known
+}
+
+// covers returns true if the tag was mirrored to all the target images
+func (t *mirroredTag) covers(targets []string) bool {
+	for _, target := range targets {
+		if _, ok := t.Targets[target]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// upstreamListing is the tags listed upstream for a repository, with `track_deletions`
+type upstreamListing struct {
+	Tags     map[string]string `json:"tags"` // tag -> digest, empty when the host doesn't list digests
+	ListedAt time.Time         `json:"listed_at"`
+}
+
+// stateBackend stores the source -> target digest mappings per tag, shared between
+// runs (e.g. from ephemeral CI runners)
+type stateBackend interface {
+	// load returns the mirrored tag of the source image reference, nil if it was never mirrored
+	load(source string) (*mirroredTag, error)
+	// save records the mirrored tag of the source image reference
+	save(source string, tag *mirroredTag) error
+	// loadUpstream returns the upstream tags of the source repository listed by the previous
+	// run, nil if it was never listed
+	loadUpstream(repository string) (*upstreamListing, error)
+	// saveUpstream records the upstream tags of the source repository
+	saveUpstream(repository string, listing *upstreamListing) error
+}
+
+// newStateBackend creates the configured state backend
+func newStateBackend(sc StateConfig, cfg aws.Config) (stateBackend, error) {
+	if sc.Region != "" {
+		cfg = cfg.Copy()
+		cfg.Region = sc.Region
+	}
+
+	switch sc.Type {
+	case stateTypeDynamoDB:
+		if sc.Table == "" {
+			return nil, fmt.Errorf("Missing `table` for %s state", sc.Type)
+		}
+
+		return &dynamoDBState{client: dynamodb.NewFromConfig(cfg), table: sc.Table}, nil
+	case stateTypeS3:
+		if sc.Bucket == "" {
+			return nil, fmt.Errorf("Missing `bucket` for %s state", sc.Type)
+		}
+
+		return &s3State{client: s3.NewFromConfig(cfg), bucket: sc.Bucket, prefix: sc.Prefix}, nil
+	default:
+		return nil, fmt.Errorf("Unknown state type %q, we support %s and %s", sc.Type, stateTypeDynamoDB, stateTypeS3)
+	}
+}
+
+// dynamoDBClient is the part of the DynamoDB API used by the state
+type dynamoDBClient interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// dynamoDBState stores every tag as an item of a table, with `source` (string) as hash key
+type dynamoDBState struct {
+	client dynamoDBClient
+	table  string
+}
+
+func (d *dynamoDBState) load(source string) (*mirroredTag, error) {
+	resp, err := d.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]dynamodbtypes.AttributeValue{"source": &dynamodbtypes.AttributeValueMemberS{Value: source}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	tag := &mirroredTag{Targets: make(map[string]string)}
+	if v, ok := resp.Item["source_digest"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		tag.SourceDigest = v.Value
+	}
+
+	if v, ok := resp.Item["mirrored_at"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		tag.MirroredAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+
+	if v, ok := resp.Item["targets"].(*dynamodbtypes.AttributeValueMemberM); ok {
+		for target, digest := range v.Value {
+			if s, ok := digest.(*dynamodbtypes.AttributeValueMemberS); ok {
+				tag.Targets[target] = s.Value
+			}
+		}
+	}
+
+	if v, ok := resp.Item["metadata"].(*dynamodbtypes.AttributeValueMemberM); ok {
+		tag.Metadata = &imageMetadata{}
+		if s, ok := v.Value["maintainer"].(*dynamodbtypes.AttributeValueMemberS); ok {
+			tag.Metadata.Maintainer = s.Value
+		}
+
+		if s, ok := v.Value["created"].(*dynamodbtypes.AttributeValueMemberS); ok {
+			if created, err := time.Parse(time.RFC3339Nano, s.Value); err == nil {
+				tag.Metadata.Created = &created
+			}
+		}
+
+		if labels, ok := v.Value["labels"].(*dynamodbtypes.AttributeValueMemberM); ok {
+			tag.Metadata.Labels = make(map[string]string)
+			for name, value := range labels.Value {
+				if s, ok := value.(*dynamodbtypes.AttributeValueMemberS); ok {
+					tag.Metadata.Labels[name] = s.Value
+				}
+			}
+		}
+	}
+
+	return tag, nil
+}
+
+func (d *dynamoDBState) save(source string, tag *mirroredTag) error {
+	targets := make(map[string]dynamodbtypes.AttributeValue)
+	for target, digest := range tag.Targets {
+		targets[target] = &dynamodbtypes.AttributeValueMemberS{Value: digest}
+	}
+
+	item := map[string]dynamodbtypes.AttributeValue{
+		"source":        &dynamodbtypes.AttributeValueMemberS{Value: source},
+		"source_digest": &dynamodbtypes.AttributeValueMemberS{Value: tag.SourceDigest},
+		"mirrored_at":   &dynamodbtypes.AttributeValueMemberS{Value: tag.MirroredAt.UTC().Format(time.RFC3339)},
+		"targets":       &dynamodbtypes.AttributeValueMemberM{Value: targets},
+	}
+
+	// a native map, so the catalog can project single labels
+	if md := tag.Metadata; md != nil {
+		metadata := make(map[string]dynamodbtypes.AttributeValue)
+		if md.Maintainer != "" {
+			metadata["maintainer"] = &dynamodbtypes.AttributeValueMemberS{Value: md.Maintainer}
+		}
+
+		if md.Created != nil {
+			metadata["created"] = &dynamodbtypes.AttributeValueMemberS{Value: md.Created.UTC().Format(time.RFC3339Nano)}
+		}
+
+		if len(md.Labels) > 0 {
+			labels := make(map[string]dynamodbtypes.AttributeValue)
+			for name, value := range md.Labels {
+				labels[name] = &dynamodbtypes.AttributeValueMemberS{Value: value}
+			}
+			metadata["labels"] = &dynamodbtypes.AttributeValueMemberM{Value: labels}
+		}
+
+		item["metadata"] = &dynamodbtypes.AttributeValueMemberM{Value: metadata}
+	}
+
+	_, err := d.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	})
+
+	return err
+}
+
+// loadUpstream reads the item of the repository, its key has no tag so it can't collide
+// with the items of the tags
+func (d *dynamoDBState) loadUpstream(repository string) (*upstreamListing, error) {
+	resp, err := d.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]dynamodbtypes.AttributeValue{"source": &dynamodbtypes.AttributeValueMemberS{Value: repository}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	listing := &upstreamListing{Tags: make(map[string]string)}
+	if v, ok := resp.Item["listed_at"].(*dynamodbtypes.AttributeValueMemberS); ok {
+		listing.ListedAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+
+	if v, ok := resp.Item["upstream_tags"].(*dynamodbtypes.AttributeValueMemberM); ok {
+		for tag, digest := range v.Value {
+			if s, ok := digest.(*dynamodbtypes.AttributeValueMemberS); ok {
+				listing.Tags[tag] = s.Value
+			}
+		}
+	}
+
+	return listing, nil
+}
+
+func (d *dynamoDBState) saveUpstream(repository string, listing *upstreamListing) error {
+	tags := make(map[string]dynamodbtypes.AttributeValue)
+	for tag, digest := range listing.Tags {
+		tags[tag] = &dynamodbtypes.AttributeValueMemberS{Value: digest}
+	}
+
+	_, err := d.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]dynamodbtypes.AttributeValue{
+			"source":        &dynamodbtypes.AttributeValueMemberS{Value: repository},
+			"listed_at":     &dynamodbtypes.AttributeValueMemberS{Value: listing.ListedAt.UTC().Format(time.RFC3339)},
+			"upstream_tags": &dynamodbtypes.AttributeValueMemberM{Value: tags},
+		},
+	})
+
+	return err
+}
+
+// s3Client is the part of the S3 API used by the state
+type s3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3State stores every tag as a JSON object, e.g. <prefix>quay.io/coreos/etcd:v3.json
+type s3State struct {
+	client s3Client
+	bucket string
+	prefix string
+}
+
+func (s *s3State) key(source string) string {
+	return s.prefix + source + ".json"
+}
+
+func (s *s3State) load(source string) (*mirroredTag, error) {
+	var tag mirroredTag
+	found, err := s.get(s.key(source), &tag)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &tag, nil
+}
+
+func (s *s3State) save(source string, tag *mirroredTag) error {
+	return s.put(s.key(source), tag)
+}
+
+// loadUpstream reads <prefix><repository>.json, i.e. quay.io/coreos/etcd.json next to the
+// objects of its tags
+func (s *s3State) loadUpstream(repository string) (*upstreamListing, error) {
+	var listing upstreamListing
+	found, err := s.get(s.key(repository), &listing)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &listing, nil
+}
+
+func (s *s3State) saveUpstream(repository string, listing *upstreamListing) error {
+	return s.put(s.key(repository), listing)
+}
+
+// get decodes the JSON object of the key into v, and returns false when it doesn't exist
+func (s *s3State) get(key string, v interface{}) (bool, error) {
+	resp, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(content, v)
+}
+
+// put stores v as the JSON object of the key
+func (s *s3State) put(key string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/json"),
+	})
+
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/sirupsen/logrus"
+)
+
+type fakeDynamoDB struct {
+	items map[string]map[string]dynamodbtypes.AttributeValue
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	key := params.Key["source"].(*dynamodbtypes.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[*params.TableName+"/"+key]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	key := params.Item["source"].(*dynamodbtypes.AttributeValueMemberS).Value
+	f.items[*params.TableName+"/"+key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+type fakeS3 struct {
+	objects map[string][]byte
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	content, ok := f.objects[*params.Bucket+"/"+*params.Key]
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(content))}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	content, _ := ioutil.ReadAll(params.Body)
+	f.objects[*params.Bucket+"/"+*params.Key] = content
+	return &s3.PutObjectOutput{}, nil
+}
+
+// memoryState is a state backend for tests
+type memoryState map[string]*mirroredTag
+
+func (s memoryState) load(source string) (*mirroredTag, error) {
+	return s[source], nil
+}
+
+func (s memoryState) save(source string, tag *mirroredTag) error {
+	s[source] = tag
+	return nil
+}
+
+// the upstream listings aren't needed by the tests using a memoryState
+func (s memoryState) loadUpstream(repository string) (*upstreamListing, error) {
+	return nil, nil
+}
+
+func (s memoryState) saveUpstream(repository string, listing *upstreamListing) error {
+	return nil
+}
+
+func TestStateBackends(t *testing.T) {
+	s3Backend := &s3State{client: &fakeS3{objects: make(map[string][]byte)}, bucket: "mirror-state", prefix: "prod/"}
+	backends := map[string]stateBackend{
+		stateTypeDynamoDB: &dynamoDBState{client: &fakeDynamoDB{items: make(map[string]map[string]dynamodbtypes.AttributeValue)}, table: "docker-mirror"},
+		stateTypeS3:       s3Backend,
+	}
+
+	want := &mirroredTag{
+		SourceDigest: "sha256:aaa",
+		Targets:      map[string]string{"registry.example.com/hub/redis:7": "sha256:aaa"},
+		MirroredAt:   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		Metadata:     newImageMetadata(map[string]string{"maintainer": "Redis Docker Maintainers"}, time.Date(2020, 12, 31, 12, 0, 0, 0, time.UTC), ""),
+	}
+
+	for name, backend := range backends {
+		if got, err := backend.load("redis:7"); err != nil || got != nil {
+			t.Errorf("%s: expected a missing tag to load as nil, got %+v (%v)", name, got, err)
+		}
+
+		if err := backend.save("redis:7", want); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		got, err := backend.load("redis:7")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, got)
+		}
+
+		listing := &upstreamListing{Tags: map[string]string{"7": "sha256:aaa", "6": ""}, ListedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if err := backend.saveUpstream("redis", listing); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if got, err := backend.loadUpstream("redis"); err != nil || !reflect.DeepEqual(got, listing) {
+			t.Errorf("%s: expected %+v, got %+v (%v)", name, listing, got, err)
+		}
+		if got, _ := backend.load("redis:7"); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected the listing not to overwrite the tags, got %+v", name, got)
+		}
+	}
+
+	if _, ok := s3Backend.client.(*fakeS3).objects["mirror-state/prod/redis:7.json"]; !ok {
+		t.Error("Expected the S3 state to be stored under the prefix")
+	}
+}
+
+func TestStateSkipsMirroredDigests(t *testing.T) {
+	defer func(s stateBackend) { state = s }(state)
+	mem := memoryState{
+		"redis:7": {SourceDigest: "sha256:aaa", Targets: map[string]string{"registry.example.com/hub/redis:7": "sha256:aaa"}},
+		"redis:6": {SourceDigest: "sha256:old", Targets: map[string]string{"registry.example.com/hub/redis:6": "sha256:old"}},
+	}
+	state = mem
+
+	responseContainer := &ResponseContainer{}
+	var client DockerClient
+	client = CreateTestDockerClient(responseContainer)
+	tc := TargetConfig{Registry: "registry.example.com", Prefix: "hub/", Username: "user"}
+
+	m := mirror{
+		dockerClient: &client,
+		targets:      []*target{{registry: tc.Registry, config: tc, ecrManager: newRegistryManager(tc)}},
+		report:       newRunReport().repository("redis", dockerHub),
+		log:          log.WithField("repo", "redis"),
+		repo:         Repository{Name: "redis", Host: dockerHub},
+		remoteTags:   []RepositoryTag{{Name: "7", Digest: "sha256:aaa"}, {Name: "6", Digest: "sha256:new"}},
+	}
+	m.work()
+
+	// tag 7 is unchanged upstream, tag 6 got a new digest and is mirrored again
+	if got := responseContainer.PullImageOptions.Tag; got != "6" {
+		t.Errorf("Expected only tag 6 to be pulled, got %q", got)
+	}
+
+	if got := mem["redis:6"].SourceDigest; got != "sha256:new" {
+		t.Errorf("Expected the new digest of tag 6 to be saved, got %q", got)
+	}
+}
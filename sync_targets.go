@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// syncTargetsCommand copies the tags missing from a target, or with another digest, from
+// another target with the registry API, i.e. to reconcile a primary after a failover to its
+// standby or to seed a new region
+func syncTargetsCommand(args []string) {
+	flags := flag.NewFlagSet("sync-targets", flag.ExitOnError)
+	configFile := configFlag(flags)
+	from := flags.String("from", "", "target registry or ECR region to copy the tags from")
+	to := flags.String("to", "", "target registry or ECR region to copy the tags to")
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "only sync the repositories starting with this prefix")
+	dryRun := flags.Bool("dry-run", false, "only print the tags that would be copied")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror sync-targets --from us-east-1 --to eu-west-1 [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	setupConfig(*configFile)
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
+	}
+
+	all, err := buildTargets(cfg)
+	if err != nil {
+		log.Fatalf("Could not create targets: %s", err)
+	}
+
+	failed := false
+	copied := 0
+	for _, repo := range config.allRepositories() {
+		if *prefix != "" && !strings.HasPrefix(repo.Name, *prefix) {
+			continue
+		}
+
+		targets := tenantTargets(all, repo.tenant)
+		src, err := findTarget(targets, *from)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dst, err := findTarget(targets, *to)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tags, err := syncRepository(repo, src, dst, *dryRun)
+		copied += len(tags)
+		if err != nil {
+			log.Errorf("Failed to sync repository %s: %s", repo.Name, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d tags would be copied from %s to %s\n", copied, *from, *to)
+		return
+	}
+
+	fmt.Printf("Copied %d tags from %s to %s\n", copied, *from, *to)
+}
+
+// findTarget returns the target matching the registry or ECR region
+func findTarget(targets []*target, name string) (*target, error) {
+	var res []*target
+	for _, t := range targets {
+		if name == t.registry || name == targetRegion(t) {
+			res = append(res, t)
+		}
+	}
+
+	switch len(res) {
+	case 0:
+		return nil, fmt.Errorf("No target matches %q", name)
+	case 1:
+		return res[0], nil
+	default:
+		return nil, fmt.Errorf("Several targets match %q, use the registry of the target", name)
+	}
+}
+
+// syncRepository copies the tags of the repository in src that are missing from dst, or
+// have another digest in it, manifest by manifest. Returns the tags copied, or that would be
+func syncRepository(repo Repository, src, dst *target, dryRun bool) ([]string, error) {
+	m, err := targetsMirror(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if !src.wantsRepository(m.repo.Name) || !dst.wantsRepository(m.repo.Name) {
+		return nil, nil
+	}
+
+	srcName, dstName := m.targetRepositoryName(src), m.targetRepositoryName(dst)
+	if !src.ecrManager.exists(srcName) {
+		m.log.Debugf("Repository %s doesn't exist in %s", srcName, src.registry)
+		return nil, nil
+	}
+
+	srcTags, err := src.ecrManager.listTags(srcName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list tags in %s: %s", src.registry, err)
+	}
+
+	dstTags := map[string]string{}
+	if dst.ecrManager.exists(dstName) {
+		if dstTags, err = dst.ecrManager.listTags(dstName); err != nil {
+			return nil, fmt.Errorf("Could not list tags in %s: %s", dst.registry, err)
+		}
+	}
+
+	var missing []string
+	for _, tag := range sortedTags(srcTags) {
+		if m.wantsTag(tag) && dst.wantsTag(tag) && dstTags[tag] != srcTags[tag] {
+			missing = append(missing, tag)
+		}
+	}
+
+	if dryRun {
+		for _, tag := range missing {
+			fmt.Printf("%s:%s would be copied from %s to %s\n", m.repo.Name, tag, src.registry, dst.registry)
+		}
+		return missing, nil
+	}
+
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	if err := dst.ecrManager.ensure(dstName); err != nil {
+		return nil, fmt.Errorf("Could not create %s in %s: %s", dstName, dst.registry, err)
+	}
+
+	srcClient, srcRepository, err := targetRegistryClient(src, srcName)
+	if err != nil {
+		return nil, err
+	}
+	dstClient, dstRepository, err := targetRegistryClient(dst, dstName)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	for _, tag := range missing {
+		if _, _, err := copyImage(srcClient, srcRepository, tag, dstClient, dstRepository, tag); err != nil {
+			return copied, fmt.Errorf("Could not copy %s: %s", tag, err)
+		}
+
+		fmt.Printf("%s:%s copied from %s to %s\n", m.repo.Name, tag, src.registry, dst.registry)
+		copied = append(copied, tag)
+	}
+
+	return copied, nil
+}
+
+// targetRegistryClient returns the registry client of the target with its credentials, and
+// the path of the repository in the registry
+func targetRegistryClient(t *target, name string) (*registryClient, string, error) {
+	creds, err := t.credentials()
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get credentials for %s: %s", t.registry, err)
+	}
+
+	host, repository := t.registryPath(name)
+	return registryClientFor(host, *creds, t.config.Insecure), repository, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindTarget(t *testing.T) {
+	east := &target{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com"}
+	harbor := &target{registry: "harbor.example.com"}
+	targets := []*target{east, harbor}
+
+	if got, err := findTarget(targets, "us-east-1"); err != nil || got != east {
+		t.Errorf("Expected the us-east-1 target, got %v (%v)", got, err)
+	}
+	if got, err := findTarget(targets, "harbor.example.com"); err != nil || got != harbor {
+		t.Errorf("Expected the harbor target, got %v (%v)", got, err)
+	}
+	if _, err := findTarget(targets, "eu-west-1"); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+}
+
+func TestSyncRepository(t *testing.T) {
+	primary := newFakeRegistry()
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+
+	standby := newFakeRegistry()
+	seven := standby.image("hub/redis", "7", []byte("seven"))
+	standby.image("hub/redis", "6", []byte("six"))
+	standbyServer := httptest.NewServer(standby)
+	defer standbyServer.Close()
+
+	primary.image("hub/redis", "6", []byte("six"))
+
+	src := &target{
+		registry:   strings.TrimPrefix(standbyServer.URL, "http://"),
+		config:     TargetConfig{Prefix: "hub/", Username: "mirror", Insecure: true},
+		ecrManager: &fakeManager{tags: map[string]map[string]string{"hub/redis": {"6": "sha256:six", "7": sha256Digest(seven)}}},
+	}
+	dst := &target{
+		registry:   strings.TrimPrefix(primaryServer.URL, "http://"),
+		config:     TargetConfig{Prefix: "hub/", Username: "mirror", Insecure: true},
+		ecrManager: &fakeManager{tags: map[string]map[string]string{"hub/redis": {"6": "sha256:six"}}},
+	}
+
+	got, err := syncRepository(Repository{Name: "redis"}, src, dst, true)
+	if err != nil || !reflect.DeepEqual(got, []string{"7"}) {
+		t.Fatalf("Expected 7 to be copied, got %v (%v)", got, err)
+	}
+	if _, ok := primary.manifests["hub/redis:7"]; ok {
+		t.Errorf("Expected nothing to be copied in a dry run")
+	}
+
+	got, err = syncRepository(Repository{Name: "redis"}, src, dst, false)
+	if err != nil || !reflect.DeepEqual(got, []string{"7"}) {
+		t.Fatalf("Expected 7 to be copied, got %v (%v)", got, err)
+	}
+	if string(primary.manifests["hub/redis:7"]) != string(seven) {
+		t.Errorf("Expected the manifest of 7 to be copied")
+	}
+}
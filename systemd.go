@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a run without any progress for this long is considered hung, the watchdog pings stop
+const defaultWatchdogStall = 30 * time.Minute
+
+// sdNotify sends the state to systemd, when started by a `Type=notify` unit. It returns
+// false outside systemd
+func sdNotify(state string) bool {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false
+	}
+
+	// an abstract socket
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Warnf("Failed to notify systemd: %s", err)
+		return false
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Warnf("Failed to notify systemd: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// watchdogInterval returns the `WatchdogSec=` of the unit, 0 when the watchdog is disabled
+// or meant for another process
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startWatchdog pings the systemd watchdog at half its interval, as long as the scheduler is
+// alive: idle between runs, or making progress during a run. A run without any progress for
+// the stall duration stops the pings, and systemd restarts the service
+func startWatchdog(stall time.Duration) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	if stall == 0 {
+		stall = defaultWatchdogStall
+	}
+
+	log.Infof("Pinging the systemd watchdog every %s, unless the run makes no progress for %s", interval/2, stall)
+	go func() {
+		for range time.Tick(interval / 2) {
+			if idle := runState.stalled(time.Now(), stall); idle > 0 {
+				log.Errorf("No progress for %s, the systemd watchdog is not pinged anymore", idle.Round(time.Second))
+				continue
+			}
+
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
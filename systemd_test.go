@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	if sdNotify("READY=1") {
+		t.Error("Expected no notification outside systemd")
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets unavailable: %s", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	if !sdNotify("READY=1") {
+		t.Fatal("Expected the notification to be sent")
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("Expected READY=1, got %q %v", buf[:n], err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "60000000")
+	if got := watchdogInterval(); got != time.Minute {
+		t.Errorf("Expected 1m, got %s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("Expected no watchdog for another process, got %s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("Expected no watchdog, got %s", got)
+	}
+}
+
+func TestStalled(t *testing.T) {
+	s := newStateTracker()
+	now := time.Now()
+
+	// idle between runs
+	if idle := s.stalled(now.Add(time.Hour), time.Minute); idle != 0 {
+		t.Errorf("Expected an idle scheduler not to be stalled, got %s", idle)
+	}
+
+	s.working(1, "library/redis", dockerHub)
+	if idle := s.stalled(now.Add(30*time.Second), time.Minute); idle != 0 {
+		t.Errorf("Expected a recent progress not to be stalled, got %s", idle)
+	}
+	if idle := s.stalled(now.Add(time.Hour), time.Minute); idle < time.Minute {
+		t.Errorf("Expected the run to be stalled, got %s", idle)
+	}
+
+	// waiting for a rate limit is progress
+	s.waits[&stateWait{Host: dockerHub}] = true
+	if idle := s.stalled(now.Add(time.Hour), time.Minute); idle != 0 {
+		t.Errorf("Expected a wait not to be stalled, got %s", idle)
+	}
+}
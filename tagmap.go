@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// TagMapping renames a source tag in the targets, either an exact tag (`from`) or the
+// part of the tag matched by a regular expression (`regex`), which `to` can refer to
+// with the capture groups (i.e. `$1`)
+type TagMapping struct {
+	From  string `yaml:"from,omitempty"`
+	Regex string `yaml:"regex,omitempty"`
+	To    string `yaml:"to,omitempty"`
+}
+
+// tagMapping is a compiled `tag_map` rule
+type tagMapping struct {
+	from  string
+	regex *regexp.Regexp
+	to    string
+}
+
+// compileTagMap compiles the `tag_map` rules of a repository
+func compileTagMap(mappings []TagMapping) ([]tagMapping, error) {
+	res := make([]tagMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		if (mapping.From == "") == (mapping.Regex == "") {
+			return nil, fmt.Errorf("Each rule needs either `from` or `regex`")
+		}
+
+		if mapping.Regex == "" {
+			if mapping.To == "" {
+				return nil, fmt.Errorf("The rule for %q needs a `to` tag", mapping.From)
+			}
+			res = append(res, tagMapping{from: mapping.From, to: mapping.To})
+			continue
+		}
+
+		re, err := regexp.Compile(mapping.Regex)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, tagMapping{regex: re, to: mapping.To})
+	}
+
+	return res, nil
+}
+
+// mapTag returns the tag the source tag is pushed as, the first matching rule of the
+// `tag_map` applies. Tags matched by no rule keep their name
+func (m *mirror) mapTag(tag string) string {
+	for _, mapping := range m.tagMap {
+		if mapping.regex == nil {
+			if mapping.from == tag {
+				return mapping.to
+			}
+			continue
+		}
+
+		if !mapping.regex.MatchString(tag) {
+			continue
+		}
+
+		if mapped := mapping.regex.ReplaceAllString(tag, mapping.to); mapped != "" {
+			return mapped
+		}
+
+		m.log.Warnf("Not renaming tag %s, the tag_map regex %s maps it to an empty tag", tag, mapping.regex)
+		return tag
+	}
+
+	return tag
+}
+
+// targetTag returns the tag the source tag is pushed as in the target, renamed by
+// the `tag_map` and dated in archive targets
+func (m *mirror) targetTag(t *target, tag string, day time.Time) string {
+	return t.tagName(m.mapTag(tag), day)
+}
+
+// dropTagMapCollisions drops the tags renamed to the same tag as a previous tag, only
+// one image can be pushed as a tag
+func (m *mirror) dropTagMapCollisions(tags []RepositoryTag) []RepositoryTag {
+	if len(m.tagMap) == 0 {
+		return tags
+	}
+
+	seen := make(map[string]string)
+	res := make([]RepositoryTag, 0, len(tags))
+	for _, tag := range tags {
+		mapped := m.mapTag(tag.Name)
+		if other, ok := seen[mapped]; ok {
+			m.log.Warnf("Dropping tag '%s', the tag_map renames it to %s as '%s'", tag.Name, mapped, other)
+			if m.report != nil {
+				m.report.filter(tag.Name, filterTagMap, fmt.Sprintf("the tag_map renames it to %s as '%s'", mapped, other))
+			}
+			continue
+		}
+
+		seen[mapped] = tag.Name
+		res = append(res, tag)
+	}
+
+	return res
+}
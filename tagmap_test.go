@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMapTag(t *testing.T) {
+	m := mirror{log: log.WithField("test", "tag_map"), repo: Repository{Name: "nginx", TagMap: []TagMapping{
+		{From: "stable-alpine", To: "stable"},
+		{Regex: `^([0-9.]+)-alpine$`, To: "$1"},
+		{Regex: `^`, To: "upstream-"},
+	}}}
+	if err := m.compileTagFilters(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for tag, want := range map[string]string{
+		"stable-alpine": "stable",
+		"1.27.3-alpine": "1.27.3",
+		"1.27.3":        "upstream-1.27.3",
+	} {
+		if got := m.mapTag(tag); got != want {
+			t.Errorf("Expected %s to be pushed as %s, got %s", tag, want, got)
+		}
+	}
+
+	archive := &target{config: TargetConfig{Archive: true}}
+	day := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	if got, want := m.targetTag(archive, "1.27.3-alpine", day), "1.27.3-20210601"; got != want {
+		t.Errorf("Expected the archive snapshot of the renamed tag %s, got %s", want, got)
+	}
+
+	for _, mappings := range [][]TagMapping{
+		{{From: "latest"}},
+		{{From: "latest", Regex: "^latest$", To: "stable"}},
+		{{Regex: "([0-9]+", To: "$1"}},
+	} {
+		if _, err := compileTagMap(mappings); err == nil {
+			t.Errorf("Expected %+v to be invalid", mappings)
+		}
+	}
+}
+
+func TestFilterTagsTagMapCollisions(t *testing.T) {
+	m := mirror{log: log.WithField("test", "tag_map"), repo: Repository{Name: "nginx", TagMap: []TagMapping{
+		{Regex: `-alpine$`, To: ""},
+	}}}
+	if err := m.compileTagFilters(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, name := range []string{"1.27.3-alpine", "1.27.3", "1.27.2"} {
+		m.remoteTags = append(m.remoteTags, RepositoryTag{Name: name})
+	}
+	m.filterTags()
+
+	var got []string
+	for _, tag := range m.remoteTags {
+		got = append(got, tag.Name)
+	}
+
+	if strings.Join(got, ",") != "1.27.3-alpine,1.27.2" {
+		t.Errorf("Expected the second tag pushed as 1.27.3 to be dropped, got %v", got)
+	}
+}
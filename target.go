@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/ryanuber/go-glob"
+)
+
+const (
+	targetTypeECR       = "ecr"
+	targetTypeECRPublic = "ecr-public"
+	targetTypeRegistry  = "registry"
+)
+
+// matches ECR private registry hosts, e.g. 123456789012.dkr.ecr.us-east-1.amazonaws.com
+var ecrPrivateRegistryRE = regexp.MustCompile(`^([0-9]+)\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// target is a single registry the repositories are mirrored into
+type target struct {
+	registry   string       // registry host (and optional path) images are pushed to
+	config     TargetConfig // target config, holding the prefix and filters
+	primary    bool         // true for the `target` config, false for the `targets` list
+	ecrManager ecrManager   // ECR manager, used to ensure the ECR repository exist
+
+	nameTemplate *template.Template // parsed `name_template`, nil when not set
+	tenant       string             // tenant the target pushes for, empty for `repositories`
+	tokenAuth    *ecrTokenAuth      // ECR token of the AWS credentials, nil outside ECR
+}
+
+// nameTemplateData is the data the `name_template` of a target is evaluated with
+type nameTemplateData struct {
+	Host   string // source host, i.e. hub.docker.com or quay.io
+	Name   string // repository name, i.e. library/nginx
+	Prefix string // prefix of the target
+}
+
+// functions available in `name_template`, the string is the last argument so they
+// can be piped into, i.e. {{ .Host | replace "." "-" }}
+var nameTemplateFuncs = template.FuncMap{
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+}
+
+// parseNameTemplate parses the `name_template` of a target, and evaluates it once so
+// unknown fields are reported with the config rather than on the first repository
+func parseNameTemplate(tc TargetConfig) (*template.Template, error) {
+	if tc.NameTemplate == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("name_template").Funcs(nameTemplateFuncs).Option("missingkey=error").Parse(tc.NameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid `name_template` for target %s: %s", tc.Registry, err)
+	}
+
+	if _, err := executeNameTemplate(tmpl, nameTemplateData{Host: dockerHub, Name: "library/nginx", Prefix: tc.Prefix}); err != nil {
+		return nil, fmt.Errorf("Invalid `name_template` for target %s: %s", tc.Registry, err)
+	}
+
+	return tmpl, nil
+}
+
+// executeNameTemplate returns the target repository name rendered by the template
+func executeNameTemplate(tmpl *template.Template, data nameTemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	name := strings.Trim(strings.TrimSpace(b.String()), "/")
+	if name == "" {
+		return "", fmt.Errorf("the template renders an empty name for %s/%s", data.Host, data.Name)
+	}
+
+	return name, nil
+}
+
+// credentials returns the docker credentials used to push to the target
+func (t *target) credentials() (*docker.AuthConfiguration, error) {
+	if t.config.Username != "" {
+		return &docker.AuthConfiguration{
+			Username:      t.config.Username,
+			Password:      t.config.Password,
+			ServerAddress: t.registry,
+		}, nil
+	}
+
+	if t.tokenAuth != nil {
+		return t.tokenAuth.credentials()
+	}
+
+	if isPublicECR(t.registry) {
+		return getDockerCredentials(ecrPublicRegistryPrefix)
+	}
+
+	return getDockerCredentials(t.registry)
+}
+
+// wantsRepository returns true if the repository should be mirrored to this target
+func (t *target) wantsRepository(name string) bool {
+	if len(t.config.MatchRepositories) == 0 {
+		return true
+	}
+
+	for _, pattern := range t.config.MatchRepositories {
+		if glob.Glob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wantsTag returns true if the tag passes the target specific tag filters
+func (t *target) wantsTag(tag string) bool {
+	if len(t.config.MatchTags) > 0 {
+		keep := false
+		for _, pattern := range t.config.MatchTags {
+			if glob.Glob(pattern, tag) {
+				keep = true
+				break
+			}
+		}
+
+		if !keep {
+			return false
+		}
+	}
+
+	for _, pattern := range t.config.DropTags {
+		if glob.Glob(pattern, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// preloadCache returns false when `preload_cache: false`, for IAM roles that can't list the
+// repositories: they are then created on first push, an existing repository isn't an error
+func (t *target) preloadCache() bool {
+	return t.config.PreloadCache == nil || *t.config.PreloadCache
+}
+
+// isECR returns true for the ECR and ECR Public targets, which reject some media types
+func (t *target) isECR() bool {
+	tt := targetType(t.config)
+	return tt == targetTypeECR || tt == targetTypeECRPublic
+}
+
+// targetType returns the configured type of the target, or detects it from the registry host
+func targetType(tc TargetConfig) string {
+	if tc.Type != "" {
+		return tc.Type
+	}
+
+	switch {
+	case isPublicECR(tc.Registry):
+		return targetTypeECRPublic
+	case isPrivateECR(tc.Registry):
+		return targetTypeECR
+	default:
+		return targetTypeRegistry
+	}
+}
+
+func isPublicECR(registry string) bool {
+	return strings.HasPrefix(registry, ecrPublicRegistryPrefix)
+}
+
+func isPrivateECR(registry string) bool {
+	return strings.Contains(registry, ".amazonaws.com")
+}
+
+// regionalRegistry returns the ECR private registry host of the given registry in another region
+func regionalRegistry(registry, region string) (string, error) {
+	matches := ecrPrivateRegistryRE.FindStringSubmatch(registry)
+	if matches == nil {
+		return "", fmt.Errorf("%s is not an ECR private registry, can't push to multiple regions", registry)
+	}
+
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com%s", matches[1], region, matches[3]), nil
+}
+
+// buildTargets creates the targets of the `repositories`, and a copy of them for every
+// tenant, under the tenant prefix and with the credentials of the tenant role
+func buildTargets(cfg aws.Config) ([]*target, error) {
+	targets, err := newTargets(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range config.Tenants {
+		tenant := &config.Tenants[i]
+
+		tenantTargets, err := newTargets(tenant.awsConfig(cfg), tenant)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, tenantTargets...)
+	}
+
+	return targets, nil
+}
+
+// tenantTargets returns the targets of the tenant, the targets of `repositories` for
+// an empty tenant
+func tenantTargets(targets []*target, tenant string) []*target {
+	var res []*target
+	for _, t := range targets {
+		if t.tenant == tenant {
+			res = append(res, t)
+		}
+	}
+
+	return res
+}
+
+// newTargets creates a target for each configured registry, or one target per region
+// when `regions` is configured for an ECR private registry
+func newTargets(cfg aws.Config, tenant *TenantConfig) ([]*target, error) {
+	var targets []*target
+
+	configs := config.Targets
+	if config.Target.Registry != "" {
+		configs = append([]TargetConfig{config.Target}, configs...)
+	}
+
+	for i, tc := range configs {
+		primary := i == 0 && config.Target.Registry != ""
+		if tc.Registry == "" {
+			return nil, fmt.Errorf("Missing `registry` for target")
+		}
+
+		if tenant != nil {
+			tc.Prefix = tenant.Prefix
+		}
+
+		nameTemplate, err := parseNameTemplate(tc)
+		if err != nil {
+			return nil, err
+		}
+
+		switch targetType(tc) {
+		case targetTypeECRPublic:
+			// Override the AWS region with the ecrPublicRegion for ECR authentication.
+			publicCfg := cfg.Copy()
+			publicCfg.Region = ecrPublicRegion
+
+			targets = append(targets, &target{
+				registry:     tc.Registry,
+				config:       tc,
+				primary:      primary,
+				ecrManager:   &ecrPublicManager{client: ecrpublic.NewFromConfig(publicCfg)},
+				nameTemplate: nameTemplate,
+			})
+		case targetTypeECR:
+			if len(tc.Regions) == 0 {
+				targets = append(targets, &target{
+					registry:     tc.Registry,
+					config:       tc,
+					primary:      primary,
+					ecrManager:   &ecrPrivateManager{client: ecr.NewFromConfig(cfg)},
+					nameTemplate: nameTemplate,
+				})
+				continue
+			}
+
+			for _, region := range tc.Regions {
+				registry, err := regionalRegistry(tc.Registry, region)
+				if err != nil {
+					return nil, err
+				}
+
+				regionalCfg := cfg.Copy()
+				regionalCfg.Region = region
+
+				targets = append(targets, &target{
+					registry:     registry,
+					config:       tc,
+					primary:      primary,
+					ecrManager:   &ecrPrivateManager{client: ecr.NewFromConfig(regionalCfg)},
+					nameTemplate: nameTemplate,
+				})
+			}
+		case targetTypeRegistry:
+			targets = append(targets, &target{
+				registry:     tc.Registry,
+				config:       tc,
+				primary:      primary,
+				ecrManager:   newRegistryManager(tc),
+				nameTemplate: nameTemplate,
+			})
+		default:
+			return nil, fmt.Errorf("Unknown type %q for target %s, we support %s, %s and %s", tc.Type, tc.Registry, targetTypeECR, targetTypeECRPublic, targetTypeRegistry)
+		}
+	}
+
+	for _, t := range targets {
+		if tenant != nil {
+			t.tenant = tenant.Name
+		}
+		// ECR is authenticated with the AWS credentials (of the tenant role), not the docker config
+		if t.config.Username == "" {
+			t.tokenAuth = newECRTokenAuth(t)
+		}
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// targetTagCache holds the tags of the target repositories for `skip_existing`, listed once
+// per run (a paged ListImages in ECR) instead of looking up every tag, and shared by the
+// repositories mirrored to the same target repository
+type targetTagCache struct {
+	mu      sync.Mutex
+	entries map[string]*targetTags
+}
+
+// targetTags are the tags of a target repository with their digest, listed once
+type targetTags struct {
+	once sync.Once
+	tags map[string]string
+	err  error
+}
+
+var targetTagsCache = &targetTagCache{entries: make(map[string]*targetTags)}
+
+// reset forgets the listings of the previous run
+func (c *targetTagCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*targetTags)
+}
+
+// forget drops the listing of the target repository, i.e. once tags were deleted from it
+func (c *targetTagCache) forget(t *target, repository string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, t.registry+"/"+repository)
+}
+
+// load returns the tags of the target repository, listed on first use. The map is shared
+// and must not be modified
+func (c *targetTagCache) load(t *target, repository string) (map[string]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[t.registry+"/"+repository]
+	if !ok {
+		entry = &targetTags{}
+		c.entries[t.registry+"/"+repository] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		if !t.ecrManager.exists(repository) {
+			entry.tags = map[string]string{}
+			return
+		}
+
+		entry.tags, entry.err = t.ecrManager.listTags(repository)
+		if entry.err != nil {
+			entry.err = fmt.Errorf("Could not list the tags of %s/%s: %s", t.registry, repository, entry.err)
+		}
+	})
+
+	return entry.tags, entry.err
+}
+
+// existsInTargets returns true with `skip_existing` when every target already has the tag
+// up to date, as `plan` decides it: with the upstream digest, or the digest recorded in
+// the state for a multi-platform tag
+func (m *mirror) existsInTargets(remoteTag RepositoryTag, tagTargets []*target, now time.Time) bool {
+	if !config.SkipExisting {
+		return false
+	}
+
+	for _, t := range tagTargets {
+		repository := m.targetRepositoryName(t)
+		tags, err := targetTagsCache.load(t, repository)
+		if err != nil {
+			m.log.Warnf("Not skipping the existing tag: %s", err)
+			return false
+		}
+
+		if m.planTag(t, repository, remoteTag, tags, now).Action != planSkip {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestExistsInTargets(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config.SkipExisting = true
+	targetTagsCache.reset()
+
+	manager := &fakeManager{tags: map[string]map[string]string{"hub/redis": {"7": "sha256:a", "6": "sha256:old"}}}
+	targets := []*target{{registry: "registry.example.com", config: TargetConfig{Prefix: "hub/"}, ecrManager: manager}}
+
+	tests := []struct {
+		tag    RepositoryTag
+		exists bool
+	}{
+		{RepositoryTag{Name: "7", Digest: "sha256:a"}, true},
+		{RepositoryTag{Name: "6", Digest: "sha256:new"}, false},
+		{RepositoryTag{Name: "8", Digest: "sha256:b"}, false},
+		{RepositoryTag{Name: "7"}, false}, // the upstream digest is unknown
+	}
+
+	for _, tt := range tests {
+		// the repositories mirrored to the same target repository share the listing
+		m := &mirror{repo: Repository{Name: "redis", Host: dockerHub}, log: log.WithField("tag", tt.tag.Name)}
+		if exists := m.existsInTargets(tt.tag, targets, time.Now()); exists != tt.exists {
+			t.Errorf("%s: expected %v, got %v", tt.tag.Name, tt.exists, exists)
+		}
+	}
+
+	if manager.listed != 1 {
+		t.Errorf("Expected the target repository to be listed once, got %d", manager.listed)
+	}
+
+	// a deletion lists the repository again
+	targetTagsCache.forget(targets[0], "hub/redis")
+	m := &mirror{repo: Repository{Name: "redis", Host: dockerHub}, log: log.WithField("tag", "7")}
+	m.existsInTargets(RepositoryTag{Name: "7", Digest: "sha256:a"}, targets, time.Now())
+	if manager.listed != 2 {
+		t.Errorf("Expected the target repository to be listed again, got %d", manager.listed)
+	}
+
+	config.SkipExisting = false
+	if m.existsInTargets(RepositoryTag{Name: "7", Digest: "sha256:a"}, targets, time.Now()) {
+		t.Error("Expected no skipping without skip_existing")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRegionalRegistry(t *testing.T) {
+	got, err := regionalRegistry("123456789012.dkr.ecr.us-east-1.amazonaws.com", "eu-west-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := "123456789012.dkr.ecr.eu-west-1.amazonaws.com"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := regionalRegistry("public.ecr.aws/alias", "eu-west-1"); err == nil {
+		t.Errorf("Expected an error for a non ECR private registry")
+	}
+}
+
+func TestTargetFilters(t *testing.T) {
+	tgt := &target{
+		config: TargetConfig{
+			MatchRepositories: []string{"jippi/*"},
+			MatchTags:         []string{"v*"},
+			DropTags:          []string{"*-rc*"},
+		},
+	}
+
+	if !tgt.wantsRepository("jippi/hashi-ui") {
+		t.Errorf("Expected jippi/hashi-ui to be wanted")
+	}
+
+	if tgt.wantsRepository("elasticsearch") {
+		t.Errorf("Expected elasticsearch to not be wanted")
+	}
+
+	for tag, want := range map[string]bool{"v1.0.0": true, "v1.0.0-rc1": false, "latest": false} {
+		if got := tgt.wantsTag(tag); got != want {
+			t.Errorf("Expected wantsTag(%q) to be %v, got %v", tag, want, got)
+		}
+	}
+}
+
+func TestTargetRepositoryName(t *testing.T) {
+	repoPrefix := "library/"
+	m := mirror{repo: Repository{Name: "elasticsearch", TargetPrefix: &repoPrefix}}
+
+	primary := &target{config: TargetConfig{Prefix: "hub/"}, primary: true}
+	if got, want := m.targetRepositoryName(primary), "library/elasticsearch"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	secondary := &target{config: TargetConfig{Prefix: "mirror/"}}
+	if got, want := m.targetRepositoryName(secondary), "mirror/elasticsearch"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	m.repo.TargetPrefix = nil
+	if got, want := m.targetRepositoryName(primary), "hub/elasticsearch"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// an explicit empty prefix disables the prefix of every target
+	noPrefix := ""
+	m.repo.TargetPrefix = &noPrefix
+	for _, tgt := range []*target{primary, secondary} {
+		if got, want := m.targetRepositoryName(tgt), "elasticsearch"; got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestTargetNameTemplate(t *testing.T) {
+	tc := TargetConfig{Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Prefix: "mirror/", NameTemplate: `{{ .Host | replace "." "-" }}/{{ .Name }}`}
+	tmpl, err := parseNameTemplate(tc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	tgt := &target{config: tc, primary: true, nameTemplate: tmpl}
+
+	m := mirror{repo: Repository{Name: "coreos/etcd", Host: quay}}
+	if got, want := m.targetRepositoryName(tgt), "quay-io/coreos/etcd"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// the repository target_prefix still wins on the primary target
+	repoPrefix := "legacy/"
+	m.repo.TargetPrefix = &repoPrefix
+	if got, want := m.targetRepositoryName(tgt), "legacy/coreos/etcd"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	for _, invalid := range []string{"{{ .Host", "{{ .Registry }}/{{ .Name }}", "{{ .Name | shout }}"} {
+		tc.NameTemplate = invalid
+		if _, err := parseNameTemplate(tc); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTargetType(t *testing.T) {
+	tests := map[string]TargetConfig{
+		targetTypeECR:       {Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com"},
+		targetTypeECRPublic: {Registry: "public.ecr.aws/alias"},
+		targetTypeRegistry:  {Registry: "registry.lab.local:5000"},
+	}
+
+	for want, tc := range tests {
+		if got := targetType(tc); got != want {
+			t.Errorf("Expected %s to be detected as %q, got %q", tc.Registry, want, got)
+		}
+	}
+
+	// an explicit type wins over detection
+	tc := TargetConfig{Type: targetTypeRegistry, Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com"}
+	if got := targetType(tc); got != targetTypeRegistry {
+		t.Errorf("Expected explicit type %q, got %q", targetTypeRegistry, got)
+	}
+}
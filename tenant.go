@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// TenantConfig is a team the repositories are mirrored on behalf of, into the targets
+// under its own prefix and with the credentials of its own IAM role
+type TenantConfig struct {
+	Name         string       `yaml:"name,omitempty"`
+	Prefix       string       `yaml:"prefix,omitempty"`
+	RoleARN      string       `yaml:"role_arn,omitempty"`
+	ExternalID   string       `yaml:"external_id,omitempty"`
+	Repositories []Repository `yaml:"repositories,omitempty"`
+}
+
+// allRepositories returns the `repositories`, followed by the repositories of every
+// tenant, marked with their tenant
+func (c Config) allRepositories() []Repository {
+	res := append([]Repository{}, c.Repositories...)
+	for _, tenant := range c.Tenants {
+		for _, repo := range tenant.Repositories {
+			repo.tenant = tenant.Name
+			res = append(res, repo)
+		}
+	}
+
+	return res
+}
+
+// validateTenants checks the `tenants` config
+func validateTenants(tenants []TenantConfig) error {
+	seen := make(map[string]bool)
+	for _, tenant := range tenants {
+		if tenant.Name == "" {
+			return fmt.Errorf("Missing `name` for tenant")
+		}
+
+		if seen[tenant.Name] {
+			return fmt.Errorf("Tenant %s is configured twice", tenant.Name)
+		}
+		seen[tenant.Name] = true
+
+		if tenant.RoleARN != "" && !strings.HasPrefix(tenant.RoleARN, "arn:") {
+			return fmt.Errorf("Invalid `role_arn` %q for tenant %s", tenant.RoleARN, tenant.Name)
+		}
+	}
+
+	return nil
+}
+
+// awsConfig returns the AWS config of the tenant, assuming its role when `role_arn` is set
+func (t TenantConfig) awsConfig(cfg aws.Config) aws.Config {
+	if t.RoleARN == "" {
+		return cfg
+	}
+
+	tenantCfg := cfg.Copy()
+	tenantCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), t.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "docker-mirror-" + t.Name
+		if t.ExternalID != "" {
+			o.ExternalID = aws.String(t.ExternalID)
+		}
+	}))
+
+	return tenantCfg
+}
+
+// ecrTokenAuth returns the docker credentials of an ECR registry from an authorization
+// token of the AWS credentials (the tenant role for a tenant), rather than the docker config
+type ecrTokenAuth struct {
+	mu       sync.Mutex
+	registry string
+	fetch    func() (token string, expires time.Time, err error)
+	auth     *docker.AuthConfiguration
+	expires  time.Time
+}
+
+// newECRTokenAuth returns the token authentication of an ECR target, nil when the target
+// isn't ECR
+func newECRTokenAuth(t *target) *ecrTokenAuth {
+	switch manager := t.ecrManager.(type) {
+	case *ecrPrivateManager:
+		return &ecrTokenAuth{registry: t.registry, fetch: func() (string, time.Time, error) {
+			res, err := manager.client.GetAuthorizationToken(context.Background(), &ecr.GetAuthorizationTokenInput{})
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			if len(res.AuthorizationData) == 0 {
+				return "", time.Time{}, fmt.Errorf("No ECR authorization token returned for %s", t.registry)
+			}
+			return aws.ToString(res.AuthorizationData[0].AuthorizationToken), aws.ToTime(res.AuthorizationData[0].ExpiresAt), nil
+		}}
+	case *ecrPublicManager:
+		return &ecrTokenAuth{registry: ecrPublicRegistryPrefix, fetch: func() (string, time.Time, error) {
+			res, err := manager.client.GetAuthorizationToken(context.Background(), &ecrpublic.GetAuthorizationTokenInput{})
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			if res.AuthorizationData == nil {
+				return "", time.Time{}, fmt.Errorf("No ECR public authorization token returned")
+			}
+			return aws.ToString(res.AuthorizationData.AuthorizationToken), aws.ToTime(res.AuthorizationData.ExpiresAt), nil
+		}}
+	}
+
+	return nil
+}
+
+// credentials returns the cached credentials, a new token is fetched an hour before the
+// current one expires (they last 12 hours), so a push started with it doesn't outlive it
+func (a *ecrTokenAuth) credentials() (*docker.AuthConfiguration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth != nil && time.Now().Add(time.Hour).Before(a.expires) {
+		return a.auth, nil
+	}
+
+	token, expires, err := a.fetch()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get ECR authorization token for %s: %s", a.registry, err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ECR authorization token for %s: %s", a.registry, err)
+	}
+
+	chunk := strings.SplitN(string(decoded), ":", 2)
+	if len(chunk) != 2 {
+		return nil, fmt.Errorf("Invalid ECR authorization token for %s", a.registry)
+	}
+
+	a.auth = &docker.AuthConfiguration{Username: chunk[0], Password: chunk[1], ServerAddress: a.registry}
+	a.expires = expires
+
+	return a.auth, nil
+}
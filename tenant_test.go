@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestTenantTargets(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{
+		Target:       TargetConfig{Registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Prefix: "hub/"},
+		Repositories: []Repository{{Name: "redis"}},
+		Tenants: []TenantConfig{
+			{Name: "payments", Prefix: "payments/", RoleARN: "arn:aws:iam::123456789012:role/mirror-payments", Repositories: []Repository{{Name: "postgres"}}},
+			{Name: "search", Prefix: "search/", Repositories: []Repository{{Name: "elasticsearch"}}},
+		},
+	}
+
+	targets, err := buildTargets(aws.Config{Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(targets) != 3 {
+		t.Fatalf("Expected a target per tenant, got %d targets", len(targets))
+	}
+
+	payments := tenantTargets(targets, "payments")
+	if len(payments) != 1 || payments[0].config.Prefix != "payments/" || payments[0].tokenAuth == nil {
+		t.Errorf("Expected the payments target under its prefix with its role, got %+v", payments)
+	}
+
+	search := tenantTargets(targets, "search")
+	if len(search) != 1 || search[0].config.Prefix != "search/" || search[0].tokenAuth == nil {
+		t.Errorf("Expected the search target under its prefix with the default credentials, got %+v", search)
+	}
+
+	if shared := tenantTargets(targets, ""); len(shared) != 1 || shared[0].config.Prefix != "hub/" || shared[0].tokenAuth == nil {
+		t.Errorf("Expected the shared target, got %+v", shared)
+	}
+
+	repos := config.allRepositories()
+	if len(repos) != 3 || repos[0].tenant != "" || repos[1].tenant != "payments" || repos[2].tenant != "search" {
+		t.Errorf("Expected the tenant repositories after the shared ones, got %+v", repos)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	tests := map[string][]TenantConfig{
+		"Missing `name` for tenant":                       {{Prefix: "payments/"}},
+		"Tenant payments is configured twice":             {{Name: "payments"}, {Name: "payments"}},
+		"Invalid `role_arn` \"mirror\" for tenant search": {{Name: "search", RoleARN: "mirror"}},
+	}
+
+	for want, tenants := range tests {
+		if err := validateTenants(tenants); err == nil || err.Error() != want {
+			t.Errorf("Expected %q, got %v", want, err)
+		}
+	}
+}
+
+func TestECRTokenAuth(t *testing.T) {
+	fetched := 0
+	a := &ecrTokenAuth{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com", fetch: func() (string, time.Time, error) {
+		fetched++
+		return base64.StdEncoding.EncodeToString([]byte("AWS:secret")), time.Now().Add(12 * time.Hour), nil
+	}}
+
+	for i := 0; i < 2; i++ {
+		auth, err := a.credentials()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if auth.Username != "AWS" || auth.Password != "secret" || auth.ServerAddress != a.registry {
+			t.Errorf("Expected the decoded token, got %+v", auth)
+		}
+	}
+
+	if fetched != 1 {
+		t.Errorf("Expected the token to be cached, fetched %d times", fetched)
+	}
+
+	// the token is refreshed before it expires
+	a.expires = time.Now().Add(30 * time.Minute)
+	if _, err := a.credentials(); err != nil || fetched != 2 {
+		t.Errorf("Expected the token to be refreshed an hour before it expires, fetched %d times (%v)", fetched, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// repositoryTimeout returns the `timeout` of the repository, or the `repository_timeout` of
+// the config, 0 without timeout
+func repositoryTimeout(repo Repository) time.Duration {
+	if repo.Timeout != nil {
+		return time.Duration(*repo.Timeout)
+	}
+	if config.RepositoryTimeout != nil {
+		return time.Duration(*config.RepositoryTimeout)
+	}
+
+	return 0
+}
+
+// mirrorRepository mirrors the repository, and gives up on it once its timeout is exceeded:
+// the repository is failed and the worker is freed. The pulls and pushes in progress can't
+// be interrupted, they finish in the background but no other tag of the repository is
+// started, nor are its targets pruned
+func mirrorRepository(repo Repository, rr *repositoryReport, dc *DockerClient, targets []*target, c *cleaner, parent *span) {
+	timeout := repositoryTimeout(repo)
+	if timeout <= 0 {
+		mirrorRepositoryUntil(repo, rr, dc, targets, c, parent, nil)
+		return
+	}
+
+	timedOut := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mirrorRepositoryUntil(repo, rr, dc, targets, c, parent, timedOut)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		close(timedOut)
+		err := fmt.Errorf("Timed out after %s, the tags in progress finish in the background", timeout)
+		log.WithField("full_repo", repo.Name).Error(err)
+		rr.fail(err)
+	}
+}
+
+// stopped returns why the remaining work of the repository is skipped, the kill switch or
+// its timeout, empty to keep going
+func (m *mirror) stopped() string {
+	if reason := killSwitch.stopped(); reason != "" {
+		return reason
+	}
+
+	select {
+	case <-m.timedOut:
+		return "the timeout of the repository was exceeded"
+	default:
+		return ""
+	}
+}
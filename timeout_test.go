@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRepositoryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung tag API
+		<-release
+		w.Write([]byte(`{"name":"redis","tags":["7","6"]}`))
+	}))
+	defer server.Close()
+
+	defer func(c Config) { config = c }(config)
+	config.Hosts = []HostConfig{{Name: "artifactory.example.com", Type: hostTypeArtifactory, APIBase: server.URL}}
+	timeout := Duration(50 * time.Millisecond)
+	config.RepositoryTimeout = &timeout
+
+	rr := newRunReport().repository("redis", "artifactory.example.com")
+	targets := []*target{{registry: "registry.example.com", ecrManager: &fakeManager{}}}
+
+	start := time.Now()
+	mirrorRepository(Repository{Name: "redis", Host: "artifactory.example.com"}, rr, nil, targets, nil, nil)
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected the worker to be freed after the timeout, took %s", time.Since(start))
+	}
+	if !rr.failed() || !strings.HasPrefix(rr.Error, "Timed out after 50ms") {
+		t.Errorf("Expected the repository to time out, got %s %q", rr.Result, rr.Error)
+	}
+
+	// once the tag API answers, the tags of the timed out repository are skipped
+	close(release)
+	skipped := func() bool {
+		rr.mu.Lock()
+		defer rr.mu.Unlock()
+
+		for _, tr := range rr.Tags {
+			if tr.Result != resultSkipped || tr.Reason != "the timeout of the repository was exceeded" {
+				return false
+			}
+		}
+		return len(rr.Tags) == 2
+	}
+	for deadline := time.Now().Add(2 * time.Second); !skipped(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the 2 tags to be skipped, got %+v", rr.Tags)
+		}
+	}
+
+	// the `timeout` of the repository takes precedence
+	short := Duration(time.Second)
+	if d := repositoryTimeout(Repository{Timeout: &short}); d != time.Second {
+		t.Errorf("Expected 1s, got %s", d)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// number of finished spans buffered before they are exported
+	traceBatchSize = 512
+
+	// OTLP span kind and status codes
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+// tracer exports the spans of the run, it is disabled unless an OTLP endpoint is configured
+var tracer = newTracerFromEnv()
+
+// traceExporter exports spans to an OTLP/HTTP endpoint, using the JSON encoding
+type traceExporter struct {
+	mu       sync.Mutex
+	endpoint string            // OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces
+	headers  map[string]string // extra headers sent with every export, e.g. for authentication
+	resource map[string]string // resource attributes, e.g. service.name
+	client   *http.Client
+	spans    []*span // finished spans waiting to be exported
+}
+
+// span is a single timed operation, part of a trace
+type span struct {
+	exporter   *traceExporter
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// newTracerFromEnv configures the exporter from the standard OpenTelemetry env vars
+func newTracerFromEnv() *traceExporter {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+
+	if endpoint == "" {
+		return nil
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	// OTLP/HTTP collectors accept JSON, so it's used without a protocol: the default protocol
+	// of the SDKs (http/protobuf) is only refused when it's asked for explicitly
+	if protocol != "" && protocol != "http/json" {
+		log.Errorf("OTLP protocol %s is not supported, set OTEL_EXPORTER_OTLP_PROTOCOL=http/json to export traces, tracing is disabled", protocol)
+		return nil
+	}
+
+	headers := parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	for k, v := range parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")) {
+		headers[k] = v
+	}
+
+	resource := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		resource["service.name"] = name
+	}
+	if _, ok := resource["service.name"]; !ok {
+		resource["service.name"] = "docker-mirror"
+	}
+
+	return &traceExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		resource: resource,
+		client:   &http.Client{Timeout: 10 * time.Second, Transport: PTransport},
+	}
+}
+
+// parseKeyValues parses the `key1=value1,key2=value2` format of the OpenTelemetry env vars
+func parseKeyValues(s string) map[string]string {
+	res := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		chunk := strings.SplitN(pair, "=", 2)
+		if len(chunk) != 2 {
+			continue
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(chunk[1]))
+		if err != nil {
+			value = strings.TrimSpace(chunk[1])
+		}
+		res[strings.TrimSpace(chunk[0])] = value
+	}
+
+	return res
+}
+
+// start a new span, a nil parent starts a new trace. Returns nil when tracing is disabled,
+// all span methods are safe to call on a nil span
+func (e *traceExporter) start(parent *span, name string, attributes ...string) *span {
+	if e == nil {
+		return nil
+	}
+
+	s := &span{
+		exporter:   e,
+		spanID:     randomID(8),
+		name:       name,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.attributes[attributes[i]] = attributes[i+1]
+	}
+
+	return s
+}
+
+// child starts a new span as a child of this span
+func (s *span) child(name string, attributes ...string) *span {
+	if s == nil {
+		return nil
+	}
+
+	return s.exporter.start(s, name, attributes...)
+}
+
+// set an attribute on the span
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.attributes[key] = value
+}
+
+// finish the span, recording the error (if any) as the span status
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.err = err
+	s.exporter.add(s)
+}
+
+// add a finished span to the batch, exporting the batch once it is full
+func (e *traceExporter) add(s *span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	if len(e.spans) < traceBatchSize {
+		e.mu.Unlock()
+		return
+	}
+
+	batch := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if err := e.export(batch); err != nil {
+		log.Warnf("Failed to export traces: %s", err)
+	}
+}
+
+// flush exports all buffered spans, it must be called before exiting
+func (e *traceExporter) flush() {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	batch := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := e.export(batch); err != nil {
+		log.Warnf("Failed to export traces: %s", err)
+	}
+}
+
+// export sends the spans to the OTLP endpoint
+func (e *traceExporter) export(spans []*span) error {
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Export to %s failed with %d", e.endpoint, res.StatusCode)
+	}
+
+	return nil
+}
+
+// payload builds the OTLP ExportTraceServiceRequest, in its JSON encoding
+func (e *traceExporter) payload(spans []*span) map[string]interface{} {
+	var otlpSpans []map[string]interface{}
+	for _, s := range spans {
+		otlpSpan := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              spanKindInternal,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attributes),
+		}
+
+		if s.parentID != "" {
+			otlpSpan["parentSpanId"] = s.parentID
+		}
+
+		if s.err != nil {
+			otlpSpan["status"] = map[string]interface{}{"code": statusCodeError, "message": s.err.Error()}
+		}
+
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": otlpAttributes(e.resource)},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/seatgeek/docker-mirror"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attributes map[string]string) []interface{} {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := []interface{}{}
+	for _, k := range keys {
+		res = append(res, map[string]interface{}{
+			"key":   k,
+			"value": map[string]string{"stringValue": attributes[k]},
+		})
+	}
+
+	return res
+}
+
+// randomID returns a random hex encoded trace or span id of n bytes
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracerFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if newTracerFromEnv() != nil {
+		t.Fatal("Expected tracing to be disabled without an OTLP endpoint")
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret%3D,x-team=infra")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=production")
+
+	e := newTracerFromEnv()
+	if e == nil {
+		t.Fatal("Expected tracing to be enabled")
+	}
+
+	if e.endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("Unexpected endpoint %q", e.endpoint)
+	}
+
+	if e.headers["x-api-key"] != "secret=" || e.headers["x-team"] != "infra" {
+		t.Errorf("Unexpected headers %v", e.headers)
+	}
+
+	if e.resource["service.name"] != "docker-mirror" || e.resource["deployment.environment"] != "production" {
+		t.Errorf("Unexpected resource attributes %v", e.resource)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	if newTracerFromEnv() != nil {
+		t.Error("Expected tracing to be disabled with an unsupported protocol")
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "http/json")
+	if newTracerFromEnv() == nil {
+		t.Error("Expected the traces protocol to win over OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	if newTracerFromEnv() != nil {
+		t.Error("Expected tracing to be disabled with OTEL_TRACES_EXPORTER=none")
+	}
+}
+
+func TestTracerExport(t *testing.T) {
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Status       struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var apiKey string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("x-api-key")
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", server.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=secret")
+	e := newTracerFromEnv()
+
+	root := e.start(nil, "mirror repository", "repository", "redis")
+	root.child("docker pull").finish(errors.New("manifest unknown"))
+	root.finish(nil)
+	e.flush()
+
+	if apiKey != "secret" {
+		t.Errorf("Expected the OTLP headers to be sent, got %q", apiKey)
+	}
+
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	pull, repo := spans[0], spans[1]
+	if pull.Name != "docker pull" || pull.TraceID != repo.TraceID || pull.ParentSpanID != repo.SpanID {
+		t.Errorf("Expected docker pull to be a child of the repository span, got %+v and %+v", pull, repo)
+	}
+
+	if pull.Status.Code != statusCodeError || pull.Status.Message != "manifest unknown" {
+		t.Errorf("Expected docker pull to have an error status, got %+v", pull.Status)
+	}
+
+	if len(repo.TraceID) != 32 || len(repo.SpanID) != 16 || repo.ParentSpanID != "" {
+		t.Errorf("Unexpected root span ids %+v", repo)
+	}
+}
+
+func TestTracerDisabled(t *testing.T) {
+	var e *traceExporter
+
+	s := e.start(nil, "run")
+	s.child("mirror repository").finish(nil)
+	s.set("result", "mirrored")
+	s.finish(nil)
+	e.flush()
+}
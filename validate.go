@@ -0,0 +1,461 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var (
+	// matches the `line N: ` prefix of the yaml errors
+	yamlLineRE = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+
+	// matches regular expression syntax in a tag glob, only `*` is a wildcard in globs
+	globRegexRE = regexp.MustCompile(`[\\\[\](){}|+?^$]`)
+
+	// matches a TUF key ID of `content_trust_root_keys`
+	hexKeyIDRE = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// config keys holding a Duration, wherever they are in the config
+var durationKeys = map[string]bool{
+	"max_tag_age":        true,
+	"freshness_sla":      true,
+	"archive_max_age":    true,
+	"visibility_timeout": true,
+	"target_max_tag_age": true,
+	"interval":           true,
+	"max_wait":           true,
+	"retry_backoff":      true,
+	"timeout":            true,
+	"repository_timeout": true,
+}
+
+// configError is a config problem, at the given line of the config file (0 when unknown)
+type configError struct {
+	Line    int
+	Message string
+}
+
+func (e configError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// validateCommand checks the config file strictly, listing every problem with its line
+func validateCommand(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := configFlag(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror validate [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	files, err := configFiles(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	contents := make([][]byte, len(files))
+	hosts := make(map[string]bool)
+	for i, file := range files {
+		if contents[i], err = readConfigFile(file); err != nil {
+			log.Fatalf("Could not read config file: %s", err)
+		}
+
+		// repositories can use the hosts of any fragment
+		var fragment Config
+		yaml.Unmarshal(contents[i], &fragment)
+		for _, h := range fragment.Hosts {
+			hosts[h.Name] = true
+		}
+	}
+
+	failed := false
+	for i, file := range files {
+		var errs []configError
+		if len(files) == 1 {
+			errs = lintConfig(contents[i])
+		} else {
+			errs, _ = lintFragment(contents[i], hosts)
+		}
+
+		for _, e := range errs {
+			if e.Line > 0 {
+				fmt.Fprintf(os.Stderr, "%s:%d: %s\n", file, e.Line, e.Message)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, e.Message)
+			}
+		}
+		failed = failed || len(errs) > 0
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	// the fragments are valid on their own, check them merged
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	if err := validateConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	setupConfig(*configFile)
+	fmt.Printf("%s is valid: %d repositories\n", *configFile, len(config.allRepositories()))
+}
+
+// lintConfig decodes the config strictly, unknown keys (e.g. `match_tags`) are errors, and checks
+// the values the yaml decoder can't: required fields, durations, globs and hosts
+func lintConfig(content []byte) []configError {
+	errs, cfg := lintFragment(content, nil)
+	if cfg != nil {
+		if err := validateConfig(*cfg); err != nil {
+			errs = append(errs, configError{Message: err.Error()})
+		}
+	}
+
+	return errs
+}
+
+// lintFragment lints a file of a config split in several files, the checks of the whole config
+// are left out. hosts are the custom hosts of the other files. The decoded fragment is nil
+// when it couldn't be decoded
+func lintFragment(content []byte, hosts map[string]bool) ([]configError, *Config) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(content, &root); err != nil {
+		return []configError{yamlError(err.Error())}, nil
+	}
+
+	var errs []configError
+	walkDurations(&root, &errs)
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			// durations are decoded by their own unmarshaler, which has no line numbers
+			if len(errs) == 0 {
+				errs = append(errs, yamlError(err.Error()))
+			}
+			return errs, nil
+		}
+
+		for _, e := range typeErr.Errors {
+			errs = append(errs, yamlError(e))
+		}
+	}
+
+	if q := cfg.Queue; q.MaxReceives < 0 {
+		errs = append(errs, configError{lineOf(&root, "queue", "max_receives"), "The `max_receives` can't be negative"})
+	} else if q.MaxReceives > 0 && q.DeadLetterURL == "" {
+		errs = append(errs, configError{lineOf(&root, "queue", "max_receives"), "The `max_receives` moves failed jobs to the `dead_letter_url`, which is missing"})
+	}
+	if q := cfg.Queue; q.VisibilityTimeout != nil && (*q.VisibilityTimeout <= 0 || time.Duration(*q.VisibilityTimeout) > 12*time.Hour) {
+		errs = append(errs, configError{lineOf(&root, "queue", "visibility_timeout"), "The `visibility_timeout` must be between 1s and 12h, the SQS limits"})
+	}
+
+	if a := cfg.AdaptiveWorkers; a != nil {
+		if a.Min < 0 || cfg.Workers > 0 && a.Min > cfg.Workers {
+			errs = append(errs, configError{lineOf(&root, "adaptive_workers", "min"), "The `min` of `adaptive_workers` must be between 1 and `workers`"})
+		}
+		if a.Interval != nil && *a.Interval <= 0 {
+			errs = append(errs, configError{lineOf(&root, "adaptive_workers", "interval"), "The `interval` of `adaptive_workers` must be positive"})
+		}
+		if a.MaxErrorRate < 0 {
+			errs = append(errs, configError{lineOf(&root, "adaptive_workers", "max_error_rate"), "The `max_error_rate` of `adaptive_workers` can't be negative"})
+		}
+	}
+
+	for i, tc := range cfg.Targets {
+		if tc.Registry == "" {
+			errs = append(errs, configError{lineOf(&root, "targets", i), "Missing `registry` for target"})
+		}
+
+		switch tc.Type {
+		case "", targetTypeECR, targetTypeECRPublic, targetTypeRegistry:
+		default:
+			errs = append(errs, configError{lineOf(&root, "targets", i, "type"), fmt.Sprintf("Unknown target type %q, we support %s, %s and %s", tc.Type, targetTypeECR, targetTypeECRPublic, targetTypeRegistry)})
+		}
+	}
+
+	if hosts == nil {
+		hosts = make(map[string]bool)
+	}
+	for _, h := range cfg.Hosts {
+		hosts[h.Name] = true
+	}
+
+	names := make(map[string]int)
+	for i, repo := range cfg.Repositories {
+		if repo.Name == "" {
+			errs = append(errs, configError{lineOf(&root, "repositories", i), "Missing `name` for repository"})
+			continue
+		}
+
+		if first, ok := names[repo.Name]; ok {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "name"), fmt.Sprintf("Repository %s is already configured at line %d", repo.Name, first)})
+		} else {
+			names[repo.Name] = lineOf(&root, "repositories", i, "name")
+		}
+
+		switch repo.Host {
+		case "", dockerHub, quay, gcr, k8s:
+		default:
+			if !hosts[repo.Host] {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "host"), fmt.Sprintf("Unsupported host %s, we support %s, %s, %s, %s and the `hosts` in the config", repo.Host, dockerHub, quay, gcr, k8s)})
+			}
+		}
+
+		for _, globs := range []struct {
+			key      string
+			patterns []string
+		}{{"match_tag", repo.MatchTags}, {"ignore_tag", repo.DropTags}} {
+			for j, pattern := range globs.patterns {
+				if globRegexRE.MatchString(pattern) {
+					errs = append(errs, configError{lineOf(&root, "repositories", i, globs.key, j), fmt.Sprintf("Tag glob %s %q looks like a regular expression, only `*` globs are supported, use %s_regex for regular expressions", globs.key, pattern, globs.key)})
+				}
+			}
+		}
+
+		for _, regexes := range []struct {
+			key      string
+			patterns []string
+		}{{"match_tag_regex", repo.MatchTagRegex}, {"ignore_tag_regex", repo.IgnoreTagRegex}} {
+			for j, pattern := range regexes.patterns {
+				if _, err := regexp.Compile(pattern); err != nil {
+					errs = append(errs, configError{lineOf(&root, "repositories", i, regexes.key, j), fmt.Sprintf("Invalid %s %q: %s", regexes.key, pattern, err)})
+				}
+			}
+		}
+
+		if repo.Semver != "" {
+			if _, err := parseSemverConstraint(repo.Semver); err != nil {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "semver"), fmt.Sprintf("Invalid semver %q: %s", repo.Semver, err)})
+			}
+		}
+
+		if _, err := compileTagMap(repo.TagMap); err != nil {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "tag_map"), fmt.Sprintf("Invalid tag_map: %s", err)})
+		}
+
+		if k := repo.Keep; k != nil {
+			if k.Majors < 0 || k.PerMajor < 0 || k.Minors < 0 || k.PerMinor < 0 {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "keep"), "The `keep` rule values can't be negative"})
+			} else if *k == (KeepConfig{}) {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "keep"), "The `keep` rule needs one of majors, per_major, minors or per_minor"})
+			}
+		}
+
+		switch repo.RemoteTagSource {
+		case "":
+		case remoteTagSourceGitHub:
+			switch repo.RemoteTagConfig["mode"] {
+			case "", gitHubModeTags, gitHubModeReleases:
+			default:
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_config", "mode"), fmt.Sprintf("Unknown github mode %q, we support %s and %s", repo.RemoteTagConfig["mode"], gitHubModeTags, gitHubModeReleases)})
+			}
+			if repo.RemoteTagConfig["tag_template"] != "" && repo.RemoteTagConfig["mode"] != gitHubModeReleases {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_config", "tag_template"), "The `tag_template` needs `mode: releases`"})
+			} else if _, err := parseReleaseTagTemplate(repo.RemoteTagConfig["tag_template"]); err != nil {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_config", "tag_template"), err.Error()})
+			}
+		case remoteTagSourceGitLab:
+			if repo.RemoteTagConfig["project"] == "" {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_source"), "The `gitlab` remote_tags_source needs a `project` in remote_tags_config"})
+			}
+			if _, err := strconv.Atoi(repo.RemoteTagConfig["num_releases"]); err != nil {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_source"), "The `gitlab` remote_tags_source needs a `num_releases` number in remote_tags_config"})
+			}
+		case remoteTagSourceRegistry:
+			if repo.MaxTagAge != nil {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "max_tag_age"), "The `max_tag_age` needs the tag timestamps, the registry tags list has none"})
+			}
+		default:
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "remote_tags_source"), fmt.Sprintf("Unknown remote_tags_source %q, we support %s, %s and %s", repo.RemoteTagSource, remoteTagSourceGitHub, remoteTagSourceGitLab, remoteTagSourceRegistry)})
+		}
+
+		name, digests := repo.pinnedDigests()
+		if strings.Contains(repo.Name, "@") && !pinnedDigestRE.MatchString(digests[0].Digest) {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "name"), fmt.Sprintf("Invalid digest %q, we support sha256:<64 hex characters>", digests[0].Digest)})
+		}
+		for j, d := range repo.Digests {
+			if !pinnedDigestRE.MatchString(d.Digest) {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "digests", j, "digest"), fmt.Sprintf("Invalid digest %q, we support sha256:<64 hex characters>", d.Digest)})
+			}
+		}
+		if repo.DigestTag != "" && !strings.Contains(repo.Name, "@") {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "digest_tag"), "The `digest_tag` needs a digest in the repository name, i.e. `name: library/nginx@sha256:...`"})
+		}
+
+		if len(repo.Tags) > 0 || len(digests) > 0 {
+			var filters []string
+			for _, filter := range []struct {
+				key string
+				set bool
+			}{
+				{"match_tag", len(repo.MatchTags) > 0},
+				{"ignore_tag", len(repo.DropTags) > 0},
+				{"match_tag_regex", len(repo.MatchTagRegex) > 0},
+				{"ignore_tag_regex", len(repo.IgnoreTagRegex) > 0},
+				{"semver", repo.Semver != ""},
+				{"keep", repo.Keep != nil},
+				{"max_tags", repo.MaxTags > 0},
+				{"max_tag_age", repo.MaxTagAge != nil},
+				{"remote_tags_source", repo.RemoteTagSource != ""},
+			} {
+				if filter.set {
+					filters = append(filters, filter.key)
+				}
+			}
+
+			if len(filters) > 0 {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, filters[0]), fmt.Sprintf("The `tags` and `digests` are mirrored as is, they can't be combined with %s", strings.Join(filters, ", "))})
+			}
+
+			if strings.Contains(name, ":") {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "name"), "The `tags` and `digests` can't be combined with a tag in the repository name"})
+			}
+		}
+
+		if repo.ContentTrust && len(digests) > 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "content_trust"), "The `content_trust` checks the signature of tags, pinned `digests` are already immutable"})
+		}
+		if repo.ContentTrust && repo.TrustServer == "" && repo.Host != "" && repo.Host != dockerHub {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "content_trust"), fmt.Sprintf("The `content_trust` of host %s needs a `content_trust_server`, only Docker Hub has a default Notary server", repo.Host)})
+		}
+		if len(repo.TrustRootKeys) > 0 && !repo.ContentTrust {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "content_trust_root_keys"), "The `content_trust_root_keys` pin the root of the `content_trust` data, it needs `content_trust: true`"})
+		}
+		for _, id := range repo.TrustRootKeys {
+			if !hexKeyIDRE.MatchString(id) {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "content_trust_root_keys"), fmt.Sprintf("Invalid content_trust_root_keys %q, it must be the 64 hex characters of a key ID", id)})
+			}
+		}
+		if repo.TrustServer != "" && !strings.HasPrefix(repo.TrustServer, "https://") && !strings.HasPrefix(repo.TrustServer, "http://") {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "content_trust_server"), fmt.Sprintf("Invalid content_trust_server %q, it must be an http(s) URL", repo.TrustServer)})
+		}
+
+		if repo.PruneTarget && len(digests) > 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "prune_target"), "The `prune_target` deletes the tags no longer upstream, it can't be combined with pinned `digests`"})
+		}
+		retention := repo.TargetMaxTags > 0 || repo.TargetMaxTagAge != nil
+		if !repo.PruneTarget && !retention && (repo.PruneDryRun || len(repo.PruneProtect) > 0) {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "name"), "The `prune_dry_run` and `prune_protect` need `prune_target: true`, `target_max_tags` or `target_max_tag_age`"})
+		}
+		if repo.TargetMaxTags < 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "target_max_tags"), "The `target_max_tags` can't be negative"})
+		}
+		if retention && len(digests) > 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "name"), "The `target_max_tags` and `target_max_tag_age` delete tags of the targets, they can't be combined with pinned `digests`"})
+		}
+
+		if err := validateForeignLayers(repo.ForeignLayers); err != nil {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "foreign_layers"), err.Error()})
+		}
+
+		for j, p := range repo.Platforms {
+			if _, err := parsePlatform(p); err != nil {
+				errs = append(errs, configError{lineOf(&root, "repositories", i, "platforms", j), err.Error()})
+			}
+		}
+
+		if repo.TrackDeletions && (len(repo.Tags) > 0 || len(digests) > 0) {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "track_deletions"), "The `track_deletions` compares the upstream tags listed by each run, the static `tags` and `digests` aren't listed"})
+		}
+		if repo.Quarantine != "" && !repo.TrackDeletions {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "quarantine_suffix"), "The `quarantine_suffix` needs `track_deletions: true`"})
+		}
+
+		if repo.MaxTags < 0 || repo.TagConcurrency < 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i), "The `max_tags` and `tag_concurrency` can't be negative"})
+		}
+
+		if repo.MinTags != nil && *repo.MinTags < 0 {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "min_tags"), "The `min_tags` can't be negative"})
+		} else if repo.MinTags != nil && repo.MaxTags > 0 && *repo.MinTags > repo.MaxTags {
+			errs = append(errs, configError{lineOf(&root, "repositories", i, "min_tags"), "The `min_tags` can't be above `max_tags`"})
+		}
+	}
+
+	return errs, &cfg
+}
+
+// yamlError converts a yaml error message into a configError, keeping its line number
+func yamlError(message string) configError {
+	matches := yamlLineRE.FindStringSubmatch(message)
+	if matches == nil {
+		return configError{Message: message}
+	}
+
+	line, _ := strconv.Atoi(matches[1])
+	return configError{Line: line, Message: matches[2]}
+}
+
+// walkDurations checks every duration in the config, they support units the yaml decoder doesn't know
+func walkDurations(node *yamlv3.Node, errs *[]configError) {
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if durationKeys[key.Value] && value.Kind == yamlv3.ScalarNode {
+				if _, err := ParseDuration(value.Value); err != nil {
+					*errs = append(*errs, configError{value.Line, fmt.Sprintf("Invalid %s: %s", key.Value, err)})
+				}
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		walkDurations(child, errs)
+	}
+}
+
+// lineOf returns the line of the node at the path of mapping keys and sequence indexes,
+// or of its closest existing parent
+func lineOf(node *yamlv3.Node, path ...interface{}) int {
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, p := range path {
+		var next *yamlv3.Node
+		switch p := p.(type) {
+		case string:
+			if node.Kind == yamlv3.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == p {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yamlv3.SequenceNode && p < len(node.Content) {
+				next = node.Content[p]
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	return node.Line
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestLintConfig(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+targets:
+  - type: harbor
+    registry: harbor.example.com
+repositories:
+  - name: redis
+    match_tags:
+      - "7*"
+  - name: redis
+    host: registry.example.com
+    ignore_tag:
+      - "^rc.*$"
+  - host: quay.io
+`)
+
+	want := []configError{
+		{9, "field match_tags not found in type main.Repository"},
+		{5, `Unknown target type "harbor", we support ecr, ecr-public and registry`},
+		{11, "Repository redis is already configured at line 8"},
+		{12, "Unsupported host registry.example.com, we support hub.docker.com, quay.io, gcr.io, k8s.gcr.io and the `hosts` in the config"},
+		{14, `Tag glob ignore_tag "^rc.*$" looks like a regular expression, only ` + "`*`" + ` globs are supported, use ignore_tag_regex for regular expressions`},
+		{15, "Missing `name` for repository"},
+	}
+
+	if got := lintConfig(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected errors\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestLintConfigDurations(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+repositories:
+  - name: redis
+    max_tag_age: 4weeks
+`)
+
+	got := lintConfig(content)
+	if len(got) != 1 || got[0].Line != 6 {
+		t.Errorf("Expected a single duration error at line 6, got %v", got)
+	}
+
+	if got := lintConfig([]byte("target: [")); len(got) != 1 || got[0].Line == 0 {
+		t.Errorf("Expected a syntax error with its line, got %v", got)
+	}
+}
+
+func TestLintQueue(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+queue:
+  url: https://sqs.us-east-1.amazonaws.com/123456789012/jobs
+  max_receives: 3
+  visibility_timeout: 24h
+`)
+
+	got := lintConfig(content)
+	if len(got) != 2 || got[0].Line != 6 || got[1].Line != 7 {
+		t.Errorf("Expected max_receives and visibility_timeout errors at lines 6 and 7, got %v", got)
+	}
+}
+
+func TestLintContentTrustRootKeys(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+repositories:
+  - name: redis
+    content_trust_root_keys:
+      - 0123
+`)
+
+	got := lintConfig(content)
+	if len(got) != 2 || got[0].Line != 7 || got[1].Line != 7 {
+		t.Errorf("Expected content_trust and key ID errors at line 7, got %v", got)
+	}
+}
+
+func TestLintRemoteTagsSource(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+repositories:
+  - name: redis
+    remote_tags_source: registry
+    match_tag: ["7*"]
+  - name: postgres
+    remote_tags_source: registry
+    max_tag_age: 4w
+  - name: elasticsearch
+    remote_tags_source: hub
+  - name: tools/scanner
+    remote_tags_source: github
+    remote_tags_config:
+      mode: latest
+      tag_template: "{{ .Version }}"
+`)
+
+	want := []configError{
+		{10, "The `max_tag_age` needs the tag timestamps, the registry tags list has none"},
+		{12, `Unknown remote_tags_source "hub", we support github, gitlab and registry`},
+		{16, `Unknown github mode "latest", we support tags and releases`},
+		{17, "The `tag_template` needs `mode: releases`"},
+	}
+
+	if got := lintConfig(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected errors\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestLintStaticTags(t *testing.T) {
+	content := []byte(`
+target:
+  registry: registry.example.com
+repositories:
+  - name: redis
+    tags: ["7.0.11", "7.2.4"]
+  - name: postgres
+    tags: ["15.4"]
+    match_tag: ["15*"]
+    max_tags: 3
+  - name: nginx:stable
+    tags: ["1.25.3"]
+  - name: library/nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31
+    digest_tag: 1.25-pinned
+  - name: library/alpine@sha256:1234
+  - name: library/redis
+    digest_tag: 7-pinned
+    digests:
+      - digest: sha256:12
+`)
+
+	want := []configError{
+		{9, "The `tags` and `digests` are mirrored as is, they can't be combined with match_tag, max_tags"},
+		{11, "The `tags` and `digests` can't be combined with a tag in the repository name"},
+		{15, `Invalid digest "sha256:1234", we support sha256:<64 hex characters>`},
+		{19, `Invalid digest "sha256:12", we support sha256:<64 hex characters>`},
+		{17, "The `digest_tag` needs a digest in the repository name, i.e. `name: library/nginx@sha256:...`"},
+	}
+
+	if got := lintConfig(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected errors\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestLintExampleConfig(t *testing.T) {
+	content, err := ioutil.ReadFile("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := lintConfig(content); len(errs) > 0 {
+		t.Errorf("Expected the example config to be valid, got %v", errs)
+	}
+}
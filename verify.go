@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyCommand cross-checks the digests of the mirrored tags across target registries,
+// it is read-only and exits non-zero when the targets diverge
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	configFile := configFlag(flags)
+	only := flags.String("targets", "", "comma separated target registries or ECR regions to compare (default: all non-archive targets)")
+	prefix := flags.String("prefix", os.Getenv("PREFIX"), "only verify the repositories starting with this prefix")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: docker-mirror verify [--targets us-east-1,eu-west-1] [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	setupConfig(*configFile)
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("Unable to load AWS SDK config, " + err.Error())
+	}
+
+	all, err := buildTargets(cfg)
+	if err != nil {
+		log.Fatalf("Could not create targets: %s", err)
+	}
+
+	targets, err := selectTargets(all, *only)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diverged := false
+	for _, repo := range config.allRepositories() {
+		if *prefix != "" && !strings.HasPrefix(repo.Name, *prefix) {
+			continue
+		}
+
+		divergences, err := verifyRepository(repo, targets)
+		if err != nil {
+			log.Errorf("Failed to verify repository %s: %s", repo.Name, err)
+			diverged = true
+			continue
+		}
+
+		for _, d := range divergences {
+			fmt.Println(d)
+			diverged = true
+		}
+	}
+
+	if diverged {
+		os.Exit(1)
+	}
+
+	fmt.Printf("All repositories are identical across %d targets\n", len(targets))
+}
+
+// selectTargets returns the targets matching the comma separated registries or ECR regions,
+// all non-archive targets when none are given
+func selectTargets(targets []*target, only string) ([]*target, error) {
+	var res []*target
+	for _, t := range targets {
+		if only == "" && !t.config.Archive {
+			res = append(res, t)
+			continue
+		}
+
+		for _, name := range strings.Split(only, ",") {
+			if name = strings.TrimSpace(name); name != "" && (name == t.registry || name == targetRegion(t)) {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+
+	if len(res) < 2 {
+		return nil, fmt.Errorf("Need at least 2 targets to verify, got %d", len(res))
+	}
+
+	return res, nil
+}
+
+// targetRegion returns the region of an ECR private target, empty for other targets
+func targetRegion(t *target) string {
+	if matches := ecrPrivateRegistryRE.FindStringSubmatch(t.registry); matches != nil {
+		return matches[2]
+	}
+
+	return ""
+}
+
+// targetsMirror returns a mirror of the repository comparing the tags of the targets, with
+// the pinned tag of a `name:tag` repository as its only tag
+func targetsMirror(repo Repository) (*mirror, error) {
+	m := &mirror{repo: repo.upstream(), log: log.WithField("full_repo", repo.Name)}
+	m.repo.Name, _ = repo.pinnedDigests()
+	if strings.Contains(m.repo.Name, ":") {
+		chunk := strings.SplitN(m.repo.Name, ":", 2)
+		m.repo.Name = chunk[0]
+		m.repo.MatchTags = []string{chunk[1]}
+	}
+
+	return m, m.compileTagFilters()
+}
+
+// verifyRepository compares the tags of the repository in each target, and returns a line
+// per tag missing from a target or with a different digest
+func verifyRepository(repo Repository, targets []*target) ([]string, error) {
+	m, err := targetsMirror(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	// target -> tag -> digest
+	digests := make(map[*target]map[string]string)
+	tags := make(map[string]string)
+	var wanted []*target
+	for _, t := range tenantTargets(targets, repo.tenant) {
+		if !t.wantsRepository(m.repo.Name) {
+			continue
+		}
+
+		listed, err := t.ecrManager.listTags(m.targetRepositoryName(t))
+		if err != nil {
+			return nil, fmt.Errorf("Could not list tags in %s: %s", t.registry, err)
+		}
+
+		digests[t] = listed
+		wanted = append(wanted, t)
+		for tag := range listed {
+			if m.wantsTag(tag) {
+				tags[tag] = ""
+			}
+		}
+	}
+
+	var res []string
+	for _, tag := range sortedTags(tags) {
+		var found []string
+		var missing []string
+		seen := make(map[string]bool)
+		for _, t := range wanted {
+			if !t.wantsTag(tag) {
+				continue
+			}
+
+			digest, ok := digests[t][tag]
+			if !ok {
+				missing = append(missing, t.registry)
+				continue
+			}
+
+			seen[digest] = true
+			found = append(found, fmt.Sprintf("%s=%s", t.registry, digest))
+		}
+
+		if len(missing) > 0 {
+			res = append(res, fmt.Sprintf("%s:%s is missing in %s", m.repo.Name, tag, strings.Join(missing, ", ")))
+		}
+
+		if len(seen) > 1 {
+			res = append(res, fmt.Sprintf("%s:%s has different digests: %s", m.repo.Name, tag, strings.Join(found, ", ")))
+		}
+	}
+
+	return res, nil
+}
+
+// verifyPushes fetches the manifest of every target the tag was pushed to, and checks it has
+// the digest the push reported (the upstream digest, in the daemonless mode) and all its
+// blobs, with `verify_push`. A target with another digest or a truncated image is failed
+func (m *mirror) verifyPushes(tr *tagReport) error {
+	var failed error
+	for _, result := range tr.Targets {
+		if result.Result != resultMirrored {
+			continue
+		}
+		if result.Digest == "" {
+			m.log.Warnf("Not verifying the push to %s, its digest is unknown", result.Registry)
+			continue
+		}
+
+		client, repository, err := targetRegistryClient(result.target, result.Repository)
+		if err == nil {
+			err = verifyImage(client, repository, result.Tag, result.Digest)
+		}
+		if err != nil {
+			m.log.Errorf("Failed to verify the push to %s: %s", result.Registry, err)
+			result.Result, result.Error, failed = resultFailed, err.Error(), err
+		}
+	}
+
+	return failed
+}
+
+// verifyImage checks the reference has the expected digest in the registry, and that the
+// registry has every platform manifest or blob of it
+func verifyImage(r *registryClient, repository, reference, expected string) error {
+	body, _, digest, err := r.manifest(repository, reference)
+	if err != nil {
+		return err
+	}
+	if digest != expected {
+		return fmt.Errorf("%s:%s has digest %s in %s, the push reported %s", repository, reference, digest, r.host, expected)
+	}
+
+	var image manifest
+	if err := json.Unmarshal(body, &image); err != nil {
+		return fmt.Errorf("Could not parse manifest %s:%s: %s", repository, reference, err)
+	}
+
+	for _, child := range image.Manifests {
+		exists, err := r.manifestExists(repository, child.Digest)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%s:%s is missing its platform manifest %s in %s", repository, reference, child.Digest, r.host)
+		}
+	}
+
+	blobs := image.Layers
+	if image.Config != nil {
+		blobs = append([]descriptor{*image.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		// foreign layers are not distributed by the registry
+		if len(blob.URLs) > 0 {
+			continue
+		}
+
+		exists, err := r.blobExists(repository, blob.Digest)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%s:%s is missing the blob %s in %s, the push was truncated", repository, reference, blob.Digest, r.host)
+		}
+	}
+
+	return nil
+}
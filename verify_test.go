@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cenkalti/backoff"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeManager is an in-memory ecrManager, holding repository -> tag -> digest
+type fakeManager struct {
+	tags    map[string]map[string]string
+	deleted []string
+	listed  int
+}
+
+func (f *fakeManager) exists(name string) bool                                { return f.tags[name] != nil }
+func (f *fakeManager) ensure(name string) error                               { return nil }
+func (f *fakeManager) create(name string) error                               { return nil }
+func (f *fakeManager) buildCache(nextToken *string) error                     { return nil }
+func (f *fakeManager) buildCacheBackoff() backoff.Operation                   { return func() error { return nil } }
+func (f *fakeManager) putCatalogData(name string, catalog *CatalogData) error { return nil }
+
+func (f *fakeManager) listTags(name string) (map[string]string, error) {
+	f.listed++
+	return f.tags[name], nil
+}
+
+func (f *fakeManager) deleteTags(name string, tags []string) error {
+	for _, tag := range tags {
+		delete(f.tags[name], tag)
+		f.deleted = append(f.deleted, name+":"+tag)
+	}
+
+	return nil
+}
+
+func TestSelectTargets(t *testing.T) {
+	east := &target{registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com"}
+	west := &target{registry: "123456789012.dkr.ecr.eu-west-1.amazonaws.com"}
+	harbor := &target{registry: "harbor.example.com"}
+	archive := &target{registry: "123456789012.dkr.ecr.us-east-2.amazonaws.com", config: TargetConfig{Archive: true}}
+	targets := []*target{east, west, harbor, archive}
+
+	got, err := selectTargets(targets, "")
+	if err != nil || !reflect.DeepEqual(got, []*target{east, west, harbor}) {
+		t.Errorf("Expected all non-archive targets, got %v (%v)", got, err)
+	}
+
+	got, err = selectTargets(targets, "us-east-1, harbor.example.com")
+	if err != nil || !reflect.DeepEqual(got, []*target{east, harbor}) {
+		t.Errorf("Expected the us-east-1 and harbor targets, got %v (%v)", got, err)
+	}
+
+	if _, err := selectTargets(targets, "us-east-1"); err == nil {
+		t.Errorf("Expected an error when selecting a single target")
+	}
+}
+
+func TestVerifyRepository(t *testing.T) {
+	east := &target{registry: "east", ecrManager: &fakeManager{tags: map[string]map[string]string{
+		"hub/redis": {"6": "sha256:six", "7": "sha256:seven", "7-alpine": "sha256:alpine"},
+	}}}
+	west := &target{registry: "west", config: TargetConfig{Prefix: "mirror/"}, ecrManager: &fakeManager{tags: map[string]map[string]string{
+		"mirror/redis": {"6": "sha256:six", "7": "sha256:stale"},
+	}}}
+	east.config.Prefix = "hub/"
+
+	got, err := verifyRepository(Repository{Name: "redis", DropTags: []string{"*-alpine"}}, []*target{east, west})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	want := []string{"redis:7 has different digests: east=sha256:seven, west=sha256:stale"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	delete(east.ecrManager.(*fakeManager).tags["hub/redis"], "6")
+	got, _ = verifyRepository(Repository{Name: "redis:6"}, []*target{east, west})
+	if want := []string{"redis:6 is missing in east"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestVerifyPushes(t *testing.T) {
+	dest := newFakeRegistry()
+	image := dest.image("hub/redis", "7", []byte("layer"))
+	dest.image("hub/redis", "6", []byte("truncated"))
+	delete(dest.blobs, sha256Digest([]byte("truncated")))
+	server := httptest.NewServer(dest)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	tg := &target{registry: host, config: TargetConfig{Prefix: "hub/", Username: "mirror", Insecure: true}}
+
+	m := mirror{log: log.WithField("test", "verify")}
+	tr := &tagReport{Tag: "7", Targets: []*targetReport{
+		{Registry: host, Repository: "hub/redis", Tag: "7", Result: resultMirrored, Digest: sha256Digest(image), target: tg},
+	}}
+	if err := m.verifyPushes(tr); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	tr.Targets[0].Digest = "sha256:changed"
+	if err := m.verifyPushes(tr); err == nil || tr.Targets[0].Result != resultFailed {
+		t.Errorf("Expected a digest mismatch to fail the target, got %v", err)
+	}
+
+	truncated := sha256Digest(dest.manifests["hub/redis:6"])
+	tr = &tagReport{Tag: "6", Targets: []*targetReport{
+		{Registry: host, Repository: "hub/redis", Tag: "6", Result: resultMirrored, Digest: truncated, target: tg},
+	}}
+	if err := m.verifyPushes(tr); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("Expected a missing blob to fail the target, got %v", err)
+	}
+}
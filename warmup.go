@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WarmUpConfig configures where the pull or deployment count of the repositories is read
+// from, the most used repositories are mirrored first
+type WarmUpConfig struct {
+	File string `yaml:"file,omitempty"`
+	URL  string `yaml:"url,omitempty"`
+}
+
+// enabled returns true when a ranking file or URL is configured
+func (c WarmUpConfig) enabled() bool {
+	return c.File != "" || c.URL != ""
+}
+
+// warmUpOrder returns the repositories ordered by their count, read again every run. The
+// config order is kept when the counts can't be read
+func warmUpOrder(repos []Repository, cfg WarmUpConfig) []Repository {
+	counts, err := loadWarmUpCounts(cfg)
+	if err != nil {
+		log.Warnf("Could not read the warm-up ranking, mirroring in config order: %s", err)
+		return repos
+	}
+
+	ranked, n := rankRepositories(repos, counts)
+	log.Infof("Mirroring the repositories by warm-up ranking, %d of %d are ranked", n, len(repos))
+	return ranked
+}
+
+// loadWarmUpCounts reads the counts by repository name, from a CSV file or URL of
+// `repository,count` lines, or a URL responding with a JSON object of the counts
+func loadWarmUpCounts(cfg WarmUpConfig) (map[string]int, error) {
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return parseWarmUpCSV(f)
+	}
+
+	res, err := httpClient.Get(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %d", cfg.URL, res.StatusCode)
+	}
+
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		counts := make(map[string]int)
+		if err := json.NewDecoder(res.Body).Decode(&counts); err != nil {
+			return nil, err
+		}
+		return counts, nil
+	}
+
+	return parseWarmUpCSV(res.Body)
+}
+
+// parseWarmUpCSV parses `repository,count` lines, a header line is skipped
+func parseWarmUpCSV(r io.Reader) (map[string]int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for i, record := range records {
+		count, err := strconv.Atoi(record[1])
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("Invalid count %q for %s on line %d", record[1], record[0], i+1)
+		}
+		counts[record[0]] += count
+	}
+
+	return counts, nil
+}
+
+// rankRepositories orders the repositories by count, the hottest first, and returns the
+// number of ranked repositories. A repository is looked up by its config name (i.e.
+// redis:7), then by its name without tag or digest. The repositories without count keep
+// their config order, after the ranked ones
+func rankRepositories(repos []Repository, counts map[string]int) ([]Repository, int) {
+	// count by config name
+	ranked := make(map[string]int)
+	for _, repo := range repos {
+		name, _ := repo.pinnedDigests()
+		name = strings.SplitN(name, ":", 2)[0]
+
+		if count, ok := counts[repo.Name]; ok {
+			ranked[repo.Name] = count
+		} else if count, ok := counts[name]; ok {
+			ranked[repo.Name] = count
+		}
+	}
+
+	res := append([]Repository{}, repos...)
+	sort.SliceStable(res, func(i, j int) bool {
+		ci, iok := ranked[res[i].Name]
+		cj, jok := ranked[res[j].Name]
+		if iok != jok {
+			return iok
+		}
+		return ci > cj
+	})
+
+	return res, len(ranked)
+}
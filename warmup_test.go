@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRankRepositories(t *testing.T) {
+	counts, err := parseWarmUpCSV(strings.NewReader("repository,count\nredis,120\nlibrary/nginx, 4000\npostgres,7\nredis,30\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	repos := []Repository{{Name: "elasticsearch"}, {Name: "postgres"}, {Name: "redis:7"}, {Name: "library/nginx"}, {Name: "kibana"}}
+	ranked, n := rankRepositories(repos, counts)
+
+	var got []string
+	for _, repo := range ranked {
+		got = append(got, repo.Name)
+	}
+
+	if n != 3 || strings.Join(got, ",") != "library/nginx,redis:7,postgres,elasticsearch,kibana" {
+		t.Errorf("Expected the hottest repositories first, then the config order, got %d ranked %v", n, got)
+	}
+
+	if _, err := parseWarmUpCSV(strings.NewReader("redis,120\npostgres,many\n")); err == nil {
+		t.Errorf("Expected an error for an invalid count")
+	}
+}
+
+func TestWarmUpOrderURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"redis": 3, "postgres": 12}`))
+	}))
+	defer server.Close()
+
+	repos := []Repository{{Name: "redis"}, {Name: "postgres"}}
+	if got := warmUpOrder(repos, WarmUpConfig{URL: server.URL}); got[0].Name != "postgres" {
+		t.Errorf("Expected postgres first, got %+v", got)
+	}
+
+	// the config order is kept when the ranking can't be read
+	if got := warmUpOrder(repos, WarmUpConfig{URL: server.URL + "/missing", File: "/nonexistent.csv"}); got[0].Name != "redis" {
+		t.Errorf("Expected the config order, got %+v", got)
+	}
+}
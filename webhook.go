@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// max number of pending webhook jobs, the pushes above it are refused
+const webhookQueueSize = 256
+
+// WebhookConfig enables the `/webhook` endpoint of the admin server
+type WebhookConfig struct {
+	Token         string `yaml:"token,omitempty"`
+	AllowUnlisted bool   `yaml:"allow_unlisted,omitempty"`
+}
+
+// webhooks is the listener of the admin server, nil when the webhooks aren't enabled
+var webhooks *webhookListener
+
+// webhookListener mirrors the tags pushed upstream, as notified by Docker Hub or Harbor
+// webhooks, without waiting for the next run
+type webhookListener struct {
+	token         string
+	allowUnlisted bool
+	jobs          chan queueJob
+
+	mu      sync.Mutex
+	pending map[queueJob]bool // the queued jobs, a push notified twice is mirrored once
+	execute func(queueJob) error
+}
+
+// dockerHubWebhook is the payload of a Docker Hub webhook
+type dockerHubWebhook struct {
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// harborWebhook is the payload of a Harbor webhook, the resource URL of an artifact is
+// i.e. harbor.example.com/library/redis:7.2
+type harborWebhook struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+func newWebhookListener(wc WebhookConfig, execute func(queueJob) error) *webhookListener {
+	return &webhookListener{
+		token:         wc.Token,
+		allowUnlisted: wc.AllowUnlisted,
+		jobs:          make(chan queueJob, webhookQueueSize),
+		pending:       make(map[queueJob]bool),
+		execute:       execute,
+	}
+}
+
+// start mirrors the pushed tags in the background, with the given number of workers
+func (l *webhookListener) start(workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range l.jobs {
+				scheduler.wait(log.WithField("full_repo", job.Name))
+
+				l.mu.Lock()
+				delete(l.pending, job)
+				execute := l.execute
+				l.mu.Unlock()
+
+				logger := log.WithFields(log.Fields{"full_repo": job.Name, "tag": job.Tag})
+				if killSwitch.stopped() != "" {
+					logger.Warn("Skipping the pushed tag, the kill switch is engaged")
+					continue
+				}
+
+				if err := execute(job); err != nil {
+					logger.Errorf("Could not mirror the pushed tag: %s", err)
+					continue
+				}
+				logger.Info("Mirrored the pushed tag")
+			}
+		}()
+	}
+}
+
+// setExecute replaces how the jobs are mirrored, i.e. with the targets of a reloaded config
+func (l *webhookListener) setExecute(execute func(queueJob) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.execute = execute
+}
+
+// enqueue queues the job, unless the same job is already pending
+func (l *webhookListener) enqueue(job queueJob) error {
+	if _, err := jobRepository(job, l.allowUnlisted); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending[job] {
+		return nil
+	}
+
+	select {
+	case l.jobs <- job:
+		l.pending[job] = true
+		return nil
+	default:
+		return fmt.Errorf("Too many pending webhook jobs")
+	}
+}
+
+// ServeHTTP handles the webhook of a push to Docker Hub or Harbor. The token is the `token`
+// query parameter (Docker Hub webhooks can't set headers) or the Authorization header
+func (l *webhookListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(l.token)) != 1 {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := parseWebhook(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, job := range jobs {
+		if err := l.enqueue(job); err != nil {
+			log.WithFields(log.Fields{"full_repo": job.Name, "tag": job.Tag}).Warnf("Ignoring the webhook: %s", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.WithFields(log.Fields{"full_repo": job.Name, "tag": job.Tag}).Info("Queued the pushed tag")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"queued": jobs})
+}
+
+// parseWebhook returns the pushed tags of a Docker Hub or Harbor webhook payload
+func parseWebhook(body []byte) ([]queueJob, error) {
+	var harbor harborWebhook
+	if err := json.Unmarshal(body, &harbor); err != nil {
+		return nil, fmt.Errorf("Invalid webhook payload: %s", err)
+	}
+
+	if harbor.Type != "" {
+		switch harbor.Type {
+		case "PUSH_ARTIFACT", "pushImage":
+		default:
+			return nil, nil
+		}
+
+		var jobs []queueJob
+		for _, res := range harbor.EventData.Resources {
+			host := strings.SplitN(res.ResourceURL, "/", 2)[0]
+			if host == "" || res.Tag == "" {
+				continue
+			}
+			jobs = append(jobs, queueJob{Name: harbor.EventData.Repository.RepoFullName, Host: host, Tag: res.Tag})
+		}
+		return jobs, nil
+	}
+
+	var hub dockerHubWebhook
+	if err := json.Unmarshal(body, &hub); err != nil {
+		return nil, fmt.Errorf("Invalid webhook payload: %s", err)
+	}
+	if hub.Repository.RepoName == "" || hub.PushData.Tag == "" {
+		return nil, fmt.Errorf("Unknown webhook payload, we support Docker Hub and Harbor webhooks")
+	}
+
+	// the official images are named without their library/ namespace
+	name := hub.Repository.RepoName
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return []queueJob{{Name: name, Tag: hub.PushData.Tag}}, nil
+}
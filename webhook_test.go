@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWebhook(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		body     string
+		expected []queueJob
+		err      bool
+	}{
+		{"docker hub", `{"callback_url": "https://registry.hub.docker.com/u/bitnami/redis/hook/1/", "push_data": {"tag": "7.2"}, "repository": {"repo_name": "bitnami/redis"}}`, []queueJob{{Name: "bitnami/redis", Tag: "7.2"}}, false},
+		{"docker hub official image", `{"push_data": {"tag": "7.2"}, "repository": {"repo_name": "redis"}}`, []queueJob{{Name: "library/redis", Tag: "7.2"}}, false},
+		{"harbor", `{"type": "PUSH_ARTIFACT", "event_data": {"resources": [{"tag": "7.2", "resource_url": "harbor.example.com/library/redis:7.2"}], "repository": {"repo_full_name": "library/redis"}}}`, []queueJob{{Name: "library/redis", Host: "harbor.example.com", Tag: "7.2"}}, false},
+		{"harbor delete", `{"type": "DELETE_ARTIFACT", "event_data": {"resources": [{"tag": "7.2", "resource_url": "harbor.example.com/library/redis:7.2"}]}}`, nil, false},
+		{"unknown", `{"ref": "refs/heads/main"}`, nil, true},
+		{"invalid", `redis`, nil, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			jobs, err := parseWebhook([]byte(test.body))
+			if (err != nil) != test.err {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(jobs, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, jobs)
+			}
+		})
+	}
+}
+
+func TestWebhookListener(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+	config = Config{Repositories: []Repository{{Name: "library/redis"}}}
+
+	defer func(l *webhookListener) { webhooks = l }(webhooks)
+	mirrored := make(chan queueJob, 1)
+	webhooks = newWebhookListener(WebhookConfig{Token: "secret"}, func(job queueJob) error {
+		mirrored <- job
+		return nil
+	})
+
+	server := httptest.NewServer(newAdminMux())
+	defer server.Close()
+
+	post := func(query, body string) int {
+		res, err := http.Post(server.URL+"/webhook"+query, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	push := `{"push_data": {"tag": "7.2"}, "repository": {"repo_name": "redis"}}`
+	if code := post("?token=wrong", push); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", code)
+	}
+	if code := post("?token=secret", `{"push_data": {"tag": "1.25"}, "repository": {"repo_name": "nginx"}}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a repository that isn't in the config to be refused, got %d", code)
+	}
+
+	// the same push notified twice is queued once
+	for i := 0; i < 2; i++ {
+		if code := post("?token=secret", push); code != http.StatusAccepted {
+			t.Fatalf("Expected the push to be queued, got %d", code)
+		}
+	}
+	if len(webhooks.jobs) != 1 {
+		t.Errorf("Expected a single pending job, got %d", len(webhooks.jobs))
+	}
+
+	webhooks.start(1)
+	select {
+	case job := <-mirrored:
+		if job != (queueJob{Name: "library/redis", Tag: "7.2"}) {
+			t.Errorf("Unexpected job %+v", job)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The pushed tag wasn't mirrored")
+	}
+
+	webhooks = nil
+	if code := post("?token=secret", push); code != http.StatusNotFound {
+		t.Errorf("Expected the webhook endpoint to be disabled, got %d", code)
+	}
+}